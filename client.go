@@ -2,10 +2,12 @@ package sftp
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"io"
 	"os"
 	"path"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -14,7 +16,9 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
-// MaxPacket sets the maximum size of the payload.
+// MaxPacket sets the maximum size of the payload, at least 32k. It is
+// lowered to what the server accepts if the server reports its limits with
+// the limits@openssh.com extension, which otherwise set the size.
 func MaxPacket(size int) func(*Client) error {
 	return func(c *Client) error {
 		if size < 1<<15 {
@@ -25,9 +29,55 @@ func MaxPacket(size int) func(*Client) error {
 	}
 }
 
+// MaxConcurrentReads sets the most READ requests a File keeps outstanding
+// when reading, which bounds how far ahead of the data consumed it reads.
+// The default is 64, or enough to read 2MB at once when the request size is
+// set by the server's limits; links with a high bandwidth-delay product
+// benefit from more, while 1 reads a request at a time.
+func MaxConcurrentReads(n int) func(*Client) error {
+	return func(c *Client) error {
+		if n < 1 {
+			return errors.Errorf("invalid number of concurrent reads %d", n)
+		}
+		c.maxConcurrentReads = n
+		return nil
+	}
+}
+
+// MaxConcurrentWrites sets the most WRITE requests a File keeps outstanding
+// when writing, the client going on sending data while earlier writes await
+// their acknowledgement. The default is 64, or enough to write 2MB at once
+// when the request size is set by the server's limits; 1 waits for each
+// write to be acknowledged before sending the next.
+func MaxConcurrentWrites(n int) func(*Client) error {
+	return func(c *Client) error {
+		if n < 1 {
+			return errors.Errorf("invalid number of concurrent writes %d", n)
+		}
+		c.maxConcurrentWrites = n
+		return nil
+	}
+}
+
+// MaxConcurrentRequests sets both MaxConcurrentReads and MaxConcurrentWrites
+// to n. With MaxPacket, it sets how much data a File keeps in flight, which
+// must cover the bandwidth-delay product of the link for a transfer to use
+// all of its bandwidth.
+func MaxConcurrentRequests(n int) func(*Client) error {
+	return func(c *Client) error {
+		if n < 1 {
+			return errors.Errorf("invalid number of concurrent requests %d", n)
+		}
+		c.maxConcurrentReads = n
+		c.maxConcurrentWrites = n
+		return nil
+	}
+}
+
 // NewClient creates a new SFTP client on conn, using zero or more option
 // functions.
 func NewClient(conn *ssh.Client, opts ...func(*Client) error) (*Client, error) {
+	opts = append([]func(*Client) error{detectSymlinkOrder(string(conn.ServerVersion()))}, opts...)
 	s, err := conn.NewSession()
 	if err != nil {
 		return nil, err
@@ -58,9 +108,10 @@ func NewClientPipe(rd io.Reader, wr io.WriteCloser, opts ...func(*Client) error)
 				WriteCloser: wr,
 			},
 			inflight: make(map[uint32]chan<- result),
+			closed:   make(chan struct{}),
 		},
-		maxPacket: 1 << 15,
 	}
+	sftp.sess = &sftp.clientConn
 	if err := sftp.applyOptions(opts...); err != nil {
 		wr.Close()
 		return nil, err
@@ -75,6 +126,10 @@ func NewClientPipe(rd io.Reader, wr io.WriteCloser, opts ...func(*Client) error)
 	}
 	sftp.clientConn.wg.Add(1)
 	go sftp.loop()
+	sftp.tuneTransfers()
+	if sftp.keepAlive > 0 {
+		go sftp.sendKeepAlives()
+	}
 	return sftp, nil
 }
 
@@ -82,19 +137,51 @@ func NewClientPipe(rd io.Reader, wr io.WriteCloser, opts ...func(*Client) error)
 // Multiple Clients can be active on a single SSH connection, and a Client
 // may be called concurrently from multiple Goroutines.
 //
+// The methods whose names end in Context stop waiting for the server once
+// their context is done, and return its error. The requests sent are then
+// abandoned: the server may still carry them out, but their responses are
+// dropped, and any handles they open closed.
+//
 // Client implements the github.com/kr/fs.FileSystem interface.
 type Client struct {
 	clientConn
 
-	maxPacket int // max packet size read or written.
-	nextid    uint32
+	maxPacket           int // max packet size read or written.
+	maxConcurrentReads  int // max READ requests outstanding per File
+	maxConcurrentWrites int // max WRITE requests outstanding per File
+	rate                *transferRate
+	requestTimeout      time.Duration
+	keepAlive           time.Duration
+	ext                 map[string]string // extensions supported by the server
+	symlinkOrder        SymlinkOrder
+	dial                func() (io.Reader, io.WriteCloser, error)
+	nextid              uint32
+
+	mu        sync.Mutex  // protects sess, closing and redialing
+	sess      *clientConn // current session, clientConn before any reconnect
+	closing   bool
+	redialing chan struct{} // closed once the dial under way is done
+}
+
+// Close closes the SFTP session.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	c.closing = true
+	s := c.sess
+	c.mu.Unlock()
+	return s.Close()
 }
 
 // Create creates the named file mode 0666 (before umask), truncating it if
 // it already exists. If successful, methods on the returned File can be
 // used for I/O; the associated file descriptor has mode O_RDWR.
 func (c *Client) Create(path string) (*File, error) {
-	return c.open(path, flags(os.O_RDWR|os.O_CREATE|os.O_TRUNC))
+	return c.CreateContext(context.Background(), path)
+}
+
+// CreateContext is Create with a context, as described at Client.
+func (c *Client) CreateContext(ctx context.Context, path string) (*File, error) {
+	return c.open(ctx, path, flags(os.O_RDWR|os.O_CREATE|os.O_TRUNC))
 }
 
 const sftpProtocolVersion = 3 // http://tools.ietf.org/html/draft-ietf-secsh-filexfer-02
@@ -115,16 +202,31 @@ func (c *Client) recvVersion() error {
 	if err != nil {
 		return err
 	}
+	c.ext, err = unmarshalVersion(typ, data)
+	return err
+}
+
+// unmarshalVersion returns the extensions listed in a VERSION packet.
+func unmarshalVersion(typ byte, data []byte) (map[string]string, error) {
 	if typ != ssh_FXP_VERSION {
-		return &unexpectedPacketErr{ssh_FXP_VERSION, typ}
+		return nil, &unexpectedPacketErr{ssh_FXP_VERSION, typ}
 	}
 
-	version, _ := unmarshalUint32(data)
+	version, data := unmarshalUint32(data)
 	if version != sftpProtocolVersion {
-		return &unexpectedVersionErr{sftpProtocolVersion, version}
+		return nil, &unexpectedVersionErr{sftpProtocolVersion, version}
+	}
+	ext := make(map[string]string)
+	for len(data) > 0 {
+		ep, rest, err := unmarshalExtensionPair(data)
+		if err != nil {
+			return nil, err
+		}
+		ext[ep.Name] = ep.Data
+		data = rest
 	}
 
-	return nil
+	return ext, nil
 }
 
 // Walk returns a new Walker rooted at root.
@@ -135,85 +237,111 @@ func (c *Client) Walk(root string) *fs.Walker {
 // ReadDir reads the directory named by dirname and returns a list of
 // directory entries.
 func (c *Client) ReadDir(p string) ([]os.FileInfo, error) {
-	handle, err := c.opendir(p)
+	return c.ReadDirContext(context.Background(), p)
+}
+
+// ReadDirContext is ReadDir with a context, as described at Client.
+func (c *Client) ReadDirContext(ctx context.Context, p string) ([]os.FileInfo, error) {
+	s, handle, err := c.opendir(ctx, p)
 	if err != nil {
 		return nil, err
 	}
-	defer c.close(handle) // this has to defer earlier than the lock below
+	defer c.close(ctx, s, handle) // this has to defer earlier than the lock below
 	var attrs []os.FileInfo
-	var done = false
-	for !done {
-		id := c.nextID()
-		typ, data, err1 := c.sendPacket(sshFxpReaddirPacket{
-			ID:     id,
-			Handle: handle,
-		})
-		if err1 != nil {
-			err = err1
-			done = true
-			break
+	for {
+		batch, err := c.readdir(ctx, s, handle)
+		if err == io.EOF {
+			return attrs, nil
 		}
-		switch typ {
-		case ssh_FXP_NAME:
-			sid, data := unmarshalUint32(data)
-			if sid != id {
-				return nil, &unexpectedIDErr{id, sid}
-			}
-			count, data := unmarshalUint32(data)
-			for i := uint32(0); i < count; i++ {
-				var filename string
-				filename, data = unmarshalString(data)
-				_, data = unmarshalString(data) // discard longname
-				var attr *FileStat
-				attr, data = unmarshalAttrs(data)
-				if filename == "." || filename == ".." {
-					continue
-				}
-				attrs = append(attrs, fileInfoFromStat(attr, path.Base(filename)))
-			}
-		case ssh_FXP_STATUS:
-			// TODO(dfc) scope warning!
-			err = normaliseError(unmarshalStatus(id, data))
-			done = true
-		default:
-			return nil, unimplementedPacketErr(typ)
+		if err != nil {
+			return attrs, err
 		}
+		attrs = append(attrs, batch...)
 	}
-	if err == io.EOF {
-		err = nil
+}
+
+// readdir reads the next batch of entries of the directory open as handle on
+// the session s, other than "." and "..". It returns io.EOF once all have
+// been read.
+func (c *Client) readdir(ctx context.Context, s *clientConn, handle string) ([]os.FileInfo, error) {
+	id := c.nextID()
+	typ, data, err := c.sessionPacket(ctx, s, sshFxpReaddirPacket{
+		ID:     id,
+		Handle: handle,
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case ssh_FXP_NAME:
+		sid, data := unmarshalUint32(data)
+		if sid != id {
+			return nil, &unexpectedIDErr{id, sid}
+		}
+		count, data := unmarshalUint32(data)
+		attrs := make([]os.FileInfo, 0, count)
+		for i := uint32(0); i < count; i++ {
+			var filename string
+			filename, data = unmarshalString(data)
+			_, data = unmarshalString(data) // discard longname
+			var attr *FileStat
+			attr, data = unmarshalAttrs(data)
+			// Entries are named within the directory: a name such as
+			// "x/.." or "/" would have the caller leave it, or list it
+			// again.
+			name := path.Base(filename)
+			if name == "." || name == ".." || name == "/" {
+				continue
+			}
+			attrs = append(attrs, fileInfoFromStat(attr, name))
+		}
+		return attrs, nil
+	case ssh_FXP_STATUS:
+		if err := normaliseError(unmarshalStatus(id, data)); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	default:
+		return nil, unimplementedPacketErr(typ)
 	}
-	return attrs, err
 }
 
-func (c *Client) opendir(path string) (string, error) {
+// opendir opens the directory path, returning its handle and the session it
+// belongs to.
+func (c *Client) opendir(ctx context.Context, path string) (*clientConn, string, error) {
 	id := c.nextID()
-	typ, data, err := c.sendPacket(sshFxpOpendirPacket{
+	s, typ, data, err := c.request(ctx, sshFxpOpendirPacket{
 		ID:   id,
 		Path: path,
 	})
 	if err != nil {
-		return "", err
+		return nil, "", err
 	}
 	switch typ {
 	case ssh_FXP_HANDLE:
 		sid, data := unmarshalUint32(data)
 		if sid != id {
-			return "", &unexpectedIDErr{id, sid}
+			return nil, "", &unexpectedIDErr{id, sid}
 		}
 		handle, _ := unmarshalString(data)
-		return handle, nil
+		return s, handle, nil
 	case ssh_FXP_STATUS:
-		return "", unmarshalStatus(id, data)
+		return nil, "", unmarshalStatus(id, data)
 	default:
-		return "", unimplementedPacketErr(typ)
+		return nil, "", unimplementedPacketErr(typ)
 	}
 }
 
 // Stat returns a FileInfo structure describing the file specified by path 'p'.
 // If 'p' is a symbolic link, the returned FileInfo structure describes the referent file.
 func (c *Client) Stat(p string) (os.FileInfo, error) {
+	return c.StatContext(context.Background(), p)
+}
+
+// StatContext is Stat with a context, as described at Client.
+func (c *Client) StatContext(ctx context.Context, p string) (os.FileInfo, error) {
 	id := c.nextID()
-	typ, data, err := c.sendPacket(sshFxpStatPacket{
+	typ, data, err := c.sendPacketContext(ctx, sshFxpStatPacket{
 		ID:   id,
 		Path: p,
 	})
@@ -238,8 +366,13 @@ func (c *Client) Stat(p string) (os.FileInfo, error) {
 // Lstat returns a FileInfo structure describing the file specified by path 'p'.
 // If 'p' is a symbolic link, the returned FileInfo structure describes the symbolic link.
 func (c *Client) Lstat(p string) (os.FileInfo, error) {
+	return c.LstatContext(context.Background(), p)
+}
+
+// LstatContext is Lstat with a context, as described at Client.
+func (c *Client) LstatContext(ctx context.Context, p string) (os.FileInfo, error) {
 	id := c.nextID()
-	typ, data, err := c.sendPacket(sshFxpLstatPacket{
+	typ, data, err := c.sendPacketContext(ctx, sshFxpLstatPacket{
 		ID:   id,
 		Path: p,
 	})
@@ -263,8 +396,13 @@ func (c *Client) Lstat(p string) (os.FileInfo, error) {
 
 // ReadLink reads the target of a symbolic link.
 func (c *Client) ReadLink(p string) (string, error) {
+	return c.ReadLinkContext(context.Background(), p)
+}
+
+// ReadLinkContext is ReadLink with a context, as described at Client.
+func (c *Client) ReadLinkContext(ctx context.Context, p string) (string, error) {
 	id := c.nextID()
-	typ, data, err := c.sendPacket(sshFxpReadlinkPacket{
+	typ, data, err := c.sendPacketContext(ctx, sshFxpReadlinkPacket{
 		ID:   id,
 		Path: p,
 	})
@@ -290,14 +428,25 @@ func (c *Client) ReadLink(p string) (string, error) {
 	}
 }
 
-// Symlink creates a symbolic link at 'newname', pointing at target 'oldname'
+// Symlink creates a symbolic link at 'newname', pointing at target 'oldname'.
+// The order in which it sends them is set by SymlinkArgs.
 func (c *Client) Symlink(oldname, newname string) error {
+	return c.SymlinkContext(context.Background(), oldname, newname)
+}
+
+// SymlinkContext is Symlink with a context, as described at Client.
+func (c *Client) SymlinkContext(ctx context.Context, oldname, newname string) error {
 	id := c.nextID()
-	typ, data, err := c.sendPacket(sshFxpSymlinkPacket{
+	p := sshFxpSymlinkPacket{
 		ID:         id,
 		Linkpath:   newname,
 		Targetpath: oldname,
-	})
+	}
+	if c.symlinkOrder == SymlinkOrderDraft {
+		// The packet puts its first argument in Targetpath.
+		p.Targetpath, p.Linkpath = newname, oldname
+	}
+	typ, data, err := c.sendPacketContext(ctx, p)
 	if err != nil {
 		return err
 	}
@@ -310,9 +459,9 @@ func (c *Client) Symlink(oldname, newname string) error {
 }
 
 // setstat is a convience wrapper to allow for changing of various parts of the file descriptor.
-func (c *Client) setstat(path string, flags uint32, attrs interface{}) error {
+func (c *Client) setstat(ctx context.Context, path string, flags uint32, attrs interface{}) error {
 	id := c.nextID()
-	typ, data, err := c.sendPacket(sshFxpSetstatPacket{
+	typ, data, err := c.sendPacketContext(ctx, sshFxpSetstatPacket{
 		ID:    id,
 		Path:  path,
 		Flags: flags,
@@ -331,27 +480,42 @@ func (c *Client) setstat(path string, flags uint32, attrs interface{}) error {
 
 // Chtimes changes the access and modification times of the named file.
 func (c *Client) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	return c.ChtimesContext(context.Background(), path, atime, mtime)
+}
+
+// ChtimesContext is Chtimes with a context, as described at Client.
+func (c *Client) ChtimesContext(ctx context.Context, path string, atime time.Time, mtime time.Time) error {
 	type times struct {
 		Atime uint32
 		Mtime uint32
 	}
 	attrs := times{uint32(atime.Unix()), uint32(mtime.Unix())}
-	return c.setstat(path, ssh_FILEXFER_ATTR_ACMODTIME, attrs)
+	return c.setstat(ctx, path, ssh_FILEXFER_ATTR_ACMODTIME, attrs)
 }
 
 // Chown changes the user and group owners of the named file.
 func (c *Client) Chown(path string, uid, gid int) error {
+	return c.ChownContext(context.Background(), path, uid, gid)
+}
+
+// ChownContext is Chown with a context, as described at Client.
+func (c *Client) ChownContext(ctx context.Context, path string, uid, gid int) error {
 	type owner struct {
 		UID uint32
 		GID uint32
 	}
 	attrs := owner{uint32(uid), uint32(gid)}
-	return c.setstat(path, ssh_FILEXFER_ATTR_UIDGID, attrs)
+	return c.setstat(ctx, path, ssh_FILEXFER_ATTR_UIDGID, attrs)
 }
 
 // Chmod changes the permissions of the named file.
 func (c *Client) Chmod(path string, mode os.FileMode) error {
-	return c.setstat(path, ssh_FILEXFER_ATTR_PERMISSIONS, uint32(mode))
+	return c.ChmodContext(context.Background(), path, mode)
+}
+
+// ChmodContext is Chmod with a context, as described at Client.
+func (c *Client) ChmodContext(ctx context.Context, path string, mode os.FileMode) error {
+	return c.setstat(ctx, path, ssh_FILEXFER_ATTR_PERMISSIONS, uint32(mode))
 }
 
 // Truncate sets the size of the named file. Although it may be safely assumed
@@ -359,26 +523,52 @@ func (c *Client) Chmod(path string, mode os.FileMode) error {
 // the SFTP protocol does not specify what behavior the server should do when setting
 // size greater than the current size.
 func (c *Client) Truncate(path string, size int64) error {
-	return c.setstat(path, ssh_FILEXFER_ATTR_SIZE, uint64(size))
+	return c.TruncateContext(context.Background(), path, size)
+}
+
+// TruncateContext is Truncate with a context, as described at Client.
+func (c *Client) TruncateContext(ctx context.Context, path string, size int64) error {
+	return c.setstat(ctx, path, ssh_FILEXFER_ATTR_SIZE, uint64(size))
 }
 
 // Open opens the named file for reading. If successful, methods on the
 // returned file can be used for reading; the associated file descriptor
 // has mode O_RDONLY.
 func (c *Client) Open(path string) (*File, error) {
-	return c.open(path, flags(os.O_RDONLY))
+	return c.OpenContext(context.Background(), path)
+}
+
+// OpenContext is Open with a context, as described at Client.
+func (c *Client) OpenContext(ctx context.Context, path string) (*File, error) {
+	return c.open(ctx, path, flags(os.O_RDONLY))
 }
 
 // OpenFile is the generalized open call; most users will use Open or
 // Create instead. It opens the named file with specified flag (O_RDONLY
 // etc.). If successful, methods on the returned File can be used for I/O.
+//
+// With O_APPEND, servers which honour SSH_FXF_APPEND, as OpenSSH's does,
+// write all the data at the end of the file, whatever the offset; others
+// write it at the offset given, so the File starts at the end of the file
+// rather than overwriting its start, but appends which race with other
+// writers are only safe on the former. Servers which refuse to append, such
+// as this package's Server, fail with a *StatusError with code
+// SSH_FX_OP_UNSUPPORTED, which is ErrOpUnsupported.
 func (c *Client) OpenFile(path string, f int) (*File, error) {
-	return c.open(path, flags(f))
+	return c.OpenFileContext(context.Background(), path, f)
 }
 
-func (c *Client) open(path string, pflags uint32) (*File, error) {
+// OpenFileContext is OpenFile with a context, as described at Client.
+func (c *Client) OpenFileContext(ctx context.Context, path string, f int) (*File, error) {
+	if f&os.O_APPEND != 0 {
+		return c.openAppend(ctx, path, flags(f))
+	}
+	return c.open(ctx, path, flags(f))
+}
+
+func (c *Client) open(ctx context.Context, path string, pflags uint32) (*File, error) {
 	id := c.nextID()
-	typ, data, err := c.sendPacket(sshFxpOpenPacket{
+	s, typ, data, err := c.request(ctx, sshFxpOpenPacket{
 		ID:     id,
 		Path:   path,
 		Pflags: pflags,
@@ -393,7 +583,7 @@ func (c *Client) open(path string, pflags uint32) (*File, error) {
 			return nil, &unexpectedIDErr{id, sid}
 		}
 		handle, _ := unmarshalString(data)
-		return &File{c: c, path: path, handle: handle}, nil
+		return &File{c: c, s: s, path: path, handle: handle}, nil
 	case ssh_FXP_STATUS:
 		return nil, normaliseError(unmarshalStatus(id, data))
 	default:
@@ -401,12 +591,17 @@ func (c *Client) open(path string, pflags uint32) (*File, error) {
 	}
 }
 
-// close closes a handle handle previously returned in the response
-// to SSH_FXP_OPEN or SSH_FXP_OPENDIR. The handle becomes invalid
-// immediately after this request has been sent.
-func (c *Client) close(handle string) error {
+// close closes a handle handle previously returned on the session s in the
+// response to SSH_FXP_OPEN or SSH_FXP_OPENDIR. The handle becomes invalid
+// immediately after this request has been sent. If ctx is done already,
+// the handle is closed without waiting for the server.
+func (c *Client) close(ctx context.Context, s *clientConn, handle string) error {
+	if err := ctx.Err(); err != nil {
+		go c.close(context.Background(), s, handle)
+		return err
+	}
 	id := c.nextID()
-	typ, data, err := c.sendPacket(sshFxpClosePacket{
+	typ, data, err := c.sessionPacket(ctx, s, sshFxpClosePacket{
 		ID:     id,
 		Handle: handle,
 	})
@@ -421,9 +616,9 @@ func (c *Client) close(handle string) error {
 	}
 }
 
-func (c *Client) fstat(handle string) (*FileStat, error) {
+func (c *Client) fstat(ctx context.Context, s *clientConn, handle string) (*FileStat, error) {
 	id := c.nextID()
-	typ, data, err := c.sendPacket(sshFxpFstatPacket{
+	typ, data, err := c.sessionPacket(ctx, s, sshFxpFstatPacket{
 		ID:     id,
 		Handle: handle,
 	})
@@ -445,14 +640,20 @@ func (c *Client) fstat(handle string) (*FileStat, error) {
 	}
 }
 
-// StatVFS retrieves VFS statistics from a remote host.
+// StatVFS retrieves VFS statistics from a remote host, such as the space
+// available to an upload to path.
 //
 // It implements the statvfs@openssh.com SSH_FXP_EXTENDED feature
 // from http://www.opensource.apple.com/source/OpenSSH/OpenSSH-175/openssh/PROTOCOL?txt.
 func (c *Client) StatVFS(path string) (*StatVFS, error) {
+	return c.StatVFSContext(context.Background(), path)
+}
+
+// StatVFSContext is StatVFS with a context, as described at Client.
+func (c *Client) StatVFSContext(ctx context.Context, path string) (*StatVFS, error) {
 	// send the StatVFS packet to the server
 	id := c.nextID()
-	typ, data, err := c.sendPacket(sshFxpStatvfsPacket{
+	typ, data, err := c.sendPacketContext(ctx, sshFxpStatvfsPacket{
 		ID:   id,
 		Path: path,
 	})
@@ -468,12 +669,16 @@ func (c *Client) StatVFS(path string) (*StatVFS, error) {
 		if err != nil {
 			return nil, errors.New("can not parse reply")
 		}
+		if response.ID != id {
+			return nil, &unexpectedIDErr{id, response.ID}
+		}
 
 		return &response, nil
 
-	// the resquest failed
+	// the request failed, with SSH_FX_OP_UNSUPPORTED if the server does not
+	// support it
 	case ssh_FXP_STATUS:
-		return nil, errors.New(fxp(ssh_FXP_STATUS).String())
+		return nil, normaliseError(unmarshalStatus(id, data))
 
 	default:
 		return nil, unimplementedPacketErr(typ)
@@ -489,21 +694,26 @@ func (c *Client) Join(elem ...string) string { return path.Join(elem...) }
 // file or directory with the specified path exists, or if the specified directory
 // is not empty.
 func (c *Client) Remove(path string) error {
-	err := c.removeFile(path)
+	return c.RemoveContext(context.Background(), path)
+}
+
+// RemoveContext is Remove with a context, as described at Client.
+func (c *Client) RemoveContext(ctx context.Context, path string) error {
+	err := c.removeFile(ctx, path)
 	if err, ok := err.(*StatusError); ok {
 		switch err.Code {
 		// some servers, *cough* osx *cough*, return EPERM, not ENODIR.
 		// serv-u returns ssh_FX_FILE_IS_A_DIRECTORY
 		case ssh_FX_PERMISSION_DENIED, ssh_FX_FAILURE, ssh_FX_FILE_IS_A_DIRECTORY:
-			return c.RemoveDirectory(path)
+			return c.RemoveDirectoryContext(ctx, path)
 		}
 	}
 	return err
 }
 
-func (c *Client) removeFile(path string) error {
+func (c *Client) removeFile(ctx context.Context, path string) error {
 	id := c.nextID()
-	typ, data, err := c.sendPacket(sshFxpRemovePacket{
+	typ, data, err := c.sendPacketContext(ctx, sshFxpRemovePacket{
 		ID:       id,
 		Filename: path,
 	})
@@ -520,8 +730,13 @@ func (c *Client) removeFile(path string) error {
 
 // RemoveDirectory removes a directory path.
 func (c *Client) RemoveDirectory(path string) error {
+	return c.RemoveDirectoryContext(context.Background(), path)
+}
+
+// RemoveDirectoryContext is RemoveDirectory with a context, as described at Client.
+func (c *Client) RemoveDirectoryContext(ctx context.Context, path string) error {
 	id := c.nextID()
-	typ, data, err := c.sendPacket(sshFxpRmdirPacket{
+	typ, data, err := c.sendPacketContext(ctx, sshFxpRmdirPacket{
 		ID:   id,
 		Path: path,
 	})
@@ -538,8 +753,13 @@ func (c *Client) RemoveDirectory(path string) error {
 
 // Rename renames a file.
 func (c *Client) Rename(oldname, newname string) error {
+	return c.RenameContext(context.Background(), oldname, newname)
+}
+
+// RenameContext is Rename with a context, as described at Client.
+func (c *Client) RenameContext(ctx context.Context, oldname, newname string) error {
 	id := c.nextID()
-	typ, data, err := c.sendPacket(sshFxpRenamePacket{
+	typ, data, err := c.sendPacketContext(ctx, sshFxpRenamePacket{
 		ID:      id,
 		Oldpath: oldname,
 		Newpath: newname,
@@ -555,9 +775,9 @@ func (c *Client) Rename(oldname, newname string) error {
 	}
 }
 
-func (c *Client) realpath(path string) (string, error) {
+func (c *Client) realpath(ctx context.Context, path string) (string, error) {
 	id := c.nextID()
-	typ, data, err := c.sendPacket(sshFxpRealpathPacket{
+	typ, data, err := c.sendPacketContext(ctx, sshFxpRealpathPacket{
 		ID:   id,
 		Path: path,
 	})
@@ -586,15 +806,25 @@ func (c *Client) realpath(path string) (string, error) {
 // Getwd returns the current working directory of the server. Operations
 // involving relative paths will be based at this location.
 func (c *Client) Getwd() (string, error) {
-	return c.realpath(".")
+	return c.GetwdContext(context.Background())
+}
+
+// GetwdContext is Getwd with a context, as described at Client.
+func (c *Client) GetwdContext(ctx context.Context) (string, error) {
+	return c.realpath(ctx, ".")
 }
 
 // Mkdir creates the specified directory. An error will be returned if a file or
 // directory with the specified path already exists, or if the directory's
 // parent folder does not exist (the method cannot create complete paths).
 func (c *Client) Mkdir(path string) error {
+	return c.MkdirContext(context.Background(), path)
+}
+
+// MkdirContext is Mkdir with a context, as described at Client.
+func (c *Client) MkdirContext(ctx context.Context, path string) error {
 	id := c.nextID()
-	typ, data, err := c.sendPacket(sshFxpMkdirPacket{
+	typ, data, err := c.sendPacketContext(ctx, sshFxpMkdirPacket{
 		ID:   id,
 		Path: path,
 	})
@@ -622,16 +852,29 @@ func (c *Client) applyOptions(opts ...func(*Client) error) error {
 
 // File represents a remote file.
 type File struct {
-	c      *Client
-	path   string
-	handle string
-	offset uint64 // current offset within remote file
+	c        *Client
+	s        *clientConn // session the handle belongs to
+	path     string
+	handle   string
+	offset   uint64 // current offset within remote file
+	progress func(Progress)
+	onClose  func() // called once closed, if set
+	append   bool   // opened with O_APPEND
 }
 
 // Close closes the File, rendering it unusable for I/O. It returns an
 // error, if any.
 func (f *File) Close() error {
-	return f.c.close(f.handle)
+	return f.CloseContext(context.Background())
+}
+
+// CloseContext is Close with a context, as described at Client.
+func (f *File) CloseContext(ctx context.Context) error {
+	err := f.c.close(ctx, f.s, f.handle)
+	if f.onClose != nil {
+		f.onClose()
+	}
+	return err
 }
 
 // Name returns the name of the file as presented to Open or Create.
@@ -646,14 +889,47 @@ const maxConcurrentRequests = 64
 // encounters an error or EOF condition after successfully reading n > 0 bytes,
 // it returns the number of bytes read.
 func (f *File) Read(b []byte) (int, error) {
+	return f.ReadContext(context.Background(), b)
+}
+
+// ReadContext is Read with a context, as described at Client. If ctx is
+// done, it returns 0 and the error of ctx, and the offset is unchanged.
+func (f *File) ReadContext(ctx context.Context, b []byte) (int, error) {
+	n, err := f.readAt(ctx, b, f.offset)
+	f.offset += uint64(n)
+	return n, err
+}
+
+// ReadAt reads len(b) bytes from the File at offset off, as io.ReaderAt
+// does. It does not use or change the offset of Read and Write, and may
+// be called concurrently with other calls of ReadAt and WriteAt, each
+// sending its own requests.
+func (f *File) ReadAt(b []byte, off int64) (int, error) {
+	return f.ReadAtContext(context.Background(), b, off)
+}
+
+// ReadAtContext is ReadAt with a context, as described at Client. If ctx is
+// done, it returns 0 and the error of ctx.
+func (f *File) ReadAtContext(ctx context.Context, b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.Errorf("sftp: negative offset %d", off)
+	}
+	return f.readAt(ctx, b, uint64(off))
+}
+
+// readAt reads len(b) bytes from the File at offset, the error being
+// io.EOF if it reads fewer.
+func (f *File) readAt(ctx context.Context, b []byte, offset uint64) (int, error) {
 	// Split the read into multiple maxPacket sized concurrent reads
-	// bounded by maxConcurrentRequests. This allows reads with a suitably
+	// bounded by MaxConcurrentReads. This allows reads with a suitably
 	// large buffer to transfer data at a much faster rate due to
 	// overlapping round trip times.
 	inFlight := 0
 	desiredInFlight := 1
-	offset := f.offset
-	ch := make(chan result, 1)
+	// chan must have a buffer of max value of (desiredInFlight - inFlight)
+	ch := make(chan result, f.c.maxConcurrentReads)
+	timer := f.c.newRequestTimer()
+	defer timer.stop()
 	type inflightRead struct {
 		b      []byte
 		offset uint64
@@ -666,8 +942,9 @@ func (f *File) Read(b []byte) (int, error) {
 	var firstErr offsetErr
 
 	sendReq := func(b []byte, offset uint64) {
+		f.c.throttle(len(b))
 		reqID := f.c.nextID()
-		f.c.dispatchRequest(ch, sshFxpReadPacket{
+		f.s.dispatchRequest(ch, sshFxpReadPacket{
 			ID:     reqID,
 			Handle: f.handle,
 			Offset: offset,
@@ -690,7 +967,12 @@ func (f *File) Read(b []byte) (int, error) {
 		if inFlight == 0 {
 			break
 		}
+		timer.reset()
 		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-timer.C():
+			return 0, ErrRequestTimeout
 		case res := <-ch:
 			inFlight--
 			if res.err != nil {
@@ -720,7 +1002,7 @@ func (f *File) Read(b []byte) (int, error) {
 				if n < len(req.b) {
 					sendReq(req.b[l:], req.offset+uint64(l))
 				}
-				if desiredInFlight < maxConcurrentRequests {
+				if desiredInFlight < f.c.maxConcurrentReads {
 					desiredInFlight++
 				}
 			default:
@@ -736,7 +1018,6 @@ func (f *File) Read(b []byte) (int, error) {
 	if firstErr.err != nil && firstErr.err != io.EOF {
 		read = 0
 	}
-	f.offset += uint64(read)
 	return read, firstErr.err
 }
 
@@ -752,7 +1033,12 @@ func (f *File) WriteTo(w io.Writer) (int64, error) {
 	offset := f.offset
 	writeOffset := offset
 	fileSize := uint64(fi.Size())
-	ch := make(chan result, 1)
+	progress := f.startProgress(fi.Size() - int64(offset))
+	defer progress.done()
+	// chan must have a buffer of max value of (desiredInFlight - inFlight)
+	ch := make(chan result, f.c.maxConcurrentReads)
+	timer := f.c.newRequestTimer()
+	defer timer.stop()
 	type inflightRead struct {
 		b      []byte
 		offset uint64
@@ -766,8 +1052,9 @@ func (f *File) WriteTo(w io.Writer) (int64, error) {
 	var firstErr offsetErr
 
 	sendReq := func(b []byte, offset uint64) {
+		f.c.throttle(len(b))
 		reqID := f.c.nextID()
-		f.c.dispatchRequest(ch, sshFxpReadPacket{
+		f.s.dispatchRequest(ch, sshFxpReadPacket{
 			ID:     reqID,
 			Handle: f.handle,
 			Offset: offset,
@@ -796,7 +1083,10 @@ func (f *File) WriteTo(w io.Writer) (int64, error) {
 			}
 			break
 		}
+		timer.reset()
 		select {
+		case <-timer.C():
+			return copied, ErrRequestTimeout
 		case res := <-ch:
 			inFlight--
 			if res.err != nil {
@@ -821,6 +1111,7 @@ func (f *File) WriteTo(w io.Writer) (int64, error) {
 				if req.offset == writeOffset {
 					nbytes, err := w.Write(data)
 					copied += int64(nbytes)
+					progress.add(int64(nbytes))
 					if err != nil {
 						// We will never receive another DATA with offset==writeOffset, so
 						// the loop will drain inFlight and then exit.
@@ -834,7 +1125,7 @@ func (f *File) WriteTo(w io.Writer) (int64, error) {
 					switch {
 					case offset > fileSize:
 						desiredInFlight = 1
-					case desiredInFlight < maxConcurrentRequests:
+					case desiredInFlight < f.c.maxConcurrentReads:
 						desiredInFlight++
 					}
 					writeOffset += uint64(nbytes)
@@ -846,6 +1137,7 @@ func (f *File) WriteTo(w io.Writer) (int64, error) {
 						// Give go a chance to free the memory.
 						delete(pendingWrites, writeOffset)
 						nbytes, err := w.Write(pendingData)
+						progress.add(int64(nbytes))
 						// Do not move writeOffset on error so subsequent iterations won't trigger
 						// any writes.
 						if err != nil {
@@ -880,7 +1172,12 @@ func (f *File) WriteTo(w io.Writer) (int64, error) {
 // Stat returns the FileInfo structure describing file. If there is an
 // error.
 func (f *File) Stat() (os.FileInfo, error) {
-	fs, err := f.c.fstat(f.handle)
+	return f.StatContext(context.Background())
+}
+
+// StatContext is Stat with a context, as described at Client.
+func (f *File) StatContext(ctx context.Context) (os.FileInfo, error) {
+	fs, err := f.c.fstat(ctx, f.s, f.handle)
 	if err != nil {
 		return nil, err
 	}
@@ -891,22 +1188,61 @@ func (f *File) Stat() (os.FileInfo, error) {
 // written and an error, if any. Write returns a non-nil error when n !=
 // len(b).
 func (f *File) Write(b []byte) (int, error) {
+	return f.WriteContext(context.Background(), b)
+}
+
+// WriteContext is Write with a context, as described at Client. If ctx is
+// done, it returns 0 and the error of ctx, and the offset is unchanged,
+// though the server may have written some of the data.
+func (f *File) WriteContext(ctx context.Context, b []byte) (int, error) {
+	n, err := f.writeAt(ctx, b, f.offset)
+	f.offset += uint64(n)
+	return n, err
+}
+
+// WriteAt writes len(b) bytes to the File at offset off, as io.WriterAt
+// does. It does not use or change the offset of Read and Write, and may
+// be called concurrently with other calls of WriteAt and ReadAt, each
+// sending its own requests. As with os.File, it fails if the File was
+// opened with O_APPEND, which makes the server ignore the offset.
+func (f *File) WriteAt(b []byte, off int64) (int, error) {
+	return f.WriteAtContext(context.Background(), b, off)
+}
+
+// WriteAtContext is WriteAt with a context, as described at Client. If ctx
+// is done, it returns 0 and the error of ctx, though the server may have
+// written some of the data.
+func (f *File) WriteAtContext(ctx context.Context, b []byte, off int64) (int, error) {
+	if f.append {
+		return 0, errors.New("sftp: WriteAt on file opened with O_APPEND")
+	}
+	if off < 0 {
+		return 0, errors.Errorf("sftp: negative offset %d", off)
+	}
+	return f.writeAt(ctx, b, uint64(off))
+}
+
+// writeAt writes len(b) bytes to the File at offset. If it fails, it
+// returns 0, since the data written may have gaps.
+func (f *File) writeAt(ctx context.Context, b []byte, offset uint64) (int, error) {
 	// Split the write into multiple maxPacket sized concurrent writes
-	// bounded by maxConcurrentRequests. This allows writes with a suitably
+	// bounded by MaxConcurrentWrites. This allows writes with a suitably
 	// large buffer to transfer data at a much faster rate due to
 	// overlapping round trip times.
 	inFlight := 0
 	desiredInFlight := 1
-	offset := f.offset
 	// chan must have a buffer of max value of (desiredInFlight - inFlight)
-	ch := make(chan result, 2)
+	ch := make(chan result, f.c.maxConcurrentWrites)
+	timer := f.c.newRequestTimer()
+	defer timer.stop()
 	var firstErr error
 	written := len(b)
 	for len(b) > 0 || inFlight > 0 {
 		for inFlight < desiredInFlight && len(b) > 0 && firstErr == nil {
 			l := min(len(b), f.c.maxPacket)
 			rb := b[:l]
-			f.c.dispatchRequest(ch, sshFxpWritePacket{
+			f.c.throttle(l)
+			f.s.dispatchRequest(ch, sshFxpWritePacket{
 				ID:     f.c.nextID(),
 				Handle: f.handle,
 				Offset: offset,
@@ -921,7 +1257,12 @@ func (f *File) Write(b []byte) (int, error) {
 		if inFlight == 0 {
 			break
 		}
+		timer.reset()
 		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-timer.C():
+			return 0, ErrRequestTimeout
 		case res := <-ch:
 			inFlight--
 			if res.err != nil {
@@ -936,7 +1277,7 @@ func (f *File) Write(b []byte) (int, error) {
 					firstErr = err
 					break
 				}
-				if desiredInFlight < maxConcurrentRequests {
+				if desiredInFlight < f.c.maxConcurrentWrites {
 					desiredInFlight++
 				}
 			default:
@@ -951,7 +1292,6 @@ func (f *File) Write(b []byte) (int, error) {
 	if firstErr != nil {
 		written = 0
 	}
-	f.offset += uint64(written)
 	return written, firstErr
 }
 
@@ -963,18 +1303,29 @@ func (f *File) ReadFrom(r io.Reader) (int64, error) {
 	desiredInFlight := 1
 	offset := f.offset
 	// chan must have a buffer of max value of (desiredInFlight - inFlight)
-	ch := make(chan result, 2)
+	ch := make(chan result, f.c.maxConcurrentWrites)
+	timer := f.c.newRequestTimer()
+	defer timer.stop()
 	var firstErr error
 	read := int64(0)
 	b := make([]byte, f.c.maxPacket)
+	var progress *progressReport
+	if f.progress != nil {
+		progress = f.startProgress(remaining(r))
+		defer progress.done()
+	}
+	sent := map[uint32]int{} // lengths of the writes in flight
 	for inFlight > 0 || firstErr == nil {
 		for inFlight < desiredInFlight && firstErr == nil {
 			n, err := r.Read(b)
 			if err != nil {
 				firstErr = err
 			}
-			f.c.dispatchRequest(ch, sshFxpWritePacket{
-				ID:     f.c.nextID(),
+			f.c.throttle(n)
+			id := f.c.nextID()
+			sent[id] = n
+			f.s.dispatchRequest(ch, sshFxpWritePacket{
+				ID:     id,
 				Handle: f.handle,
 				Offset: offset,
 				Length: uint32(n),
@@ -988,7 +1339,10 @@ func (f *File) ReadFrom(r io.Reader) (int64, error) {
 		if inFlight == 0 {
 			break
 		}
+		timer.reset()
 		select {
+		case <-timer.C():
+			return 0, ErrRequestTimeout
 		case res := <-ch:
 			inFlight--
 			if res.err != nil {
@@ -1003,7 +1357,9 @@ func (f *File) ReadFrom(r io.Reader) (int64, error) {
 					firstErr = err
 					break
 				}
-				if desiredInFlight < maxConcurrentRequests {
+				progress.add(int64(sent[id]))
+				delete(sent, id)
+				if desiredInFlight < f.c.maxConcurrentWrites {
 					desiredInFlight++
 				}
 			default:
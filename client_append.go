@@ -0,0 +1,29 @@
+package sftp
+
+// Opening files to append
+
+import "context"
+
+// openAppend opens path with pflags, which include SSH_FXF_APPEND, at the
+// end of the file as the server reports it once open, for OpenFile.
+func (c *Client) openAppend(ctx context.Context, path string, pflags uint32) (*File, error) {
+	f, err := c.open(ctx, path, pflags)
+	if err, ok := err.(*StatusError); ok && err.Code == ssh_FX_OP_UNSUPPORTED {
+		return nil, &StatusError{
+			Code: err.Code,
+			msg:  "open with O_APPEND not supported: " + err.msg,
+			lang: err.lang,
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	fs, err := c.fstat(ctx, f.s, f.handle)
+	if err != nil {
+		f.Close()
+		return nil, normaliseError(err)
+	}
+	f.offset = fs.Size
+	f.append = true
+	return f, nil
+}
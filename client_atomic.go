@@ -0,0 +1,49 @@
+package sftp
+
+// Atomic uploads
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path"
+)
+
+// PutAtomic uploads the local file localPath to remotePath so that other
+// clients never see a partial upload under its name: the file is written to
+// a new temporary file in the same remote directory, which is then renamed
+// over remotePath with PosixRename. The temporary file is removed if the
+// upload fails. PutAtomic fails with a *StatusError with code
+// SSH_FX_OP_UNSUPPORTED, before uploading anything, if the server does not
+// support posix-rename@openssh.com.
+func (c *Client) PutAtomic(localPath, remotePath string) error {
+	if !c.hasExtension("posix-rename@openssh.com", "1") {
+		return unsupportedExtension("posix-rename@openssh.com")
+	}
+	src, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	dir, base := path.Split(remotePath)
+	tmp := dir + "." + base + "." + hex.EncodeToString(suffix)
+	dst, err := c.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return err
+	}
+	_, err = dst.ReadFrom(src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = c.PosixRename(tmp, remotePath)
+	}
+	if err != nil {
+		c.Remove(tmp)
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package sftp
+
+// Checksums computed by the server
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// CheckFile asks the server for the hash of length bytes of the named file
+// from offset, or of the rest of the file if length is 0, so that a transfer
+// can be verified without reading the file back. The server hashes with the
+// first of algorithms, a comma separated list such as "sha256,md5", which it
+// supports, or with an algorithm of its choice if algorithms is empty, and
+// CheckFile returns the algorithm used with the hash. It uses the check-file
+// extension, which servers such as OpenSSH's do not support, failing with a
+// *StatusError with code SSH_FX_OP_UNSUPPORTED. This package's Server only
+// supports File.CheckFile.
+func (c *Client) CheckFile(path, algorithms string, offset, length int64) (string, []byte, error) {
+	return c.CheckFileContext(context.Background(), path, algorithms, offset, length)
+}
+
+// CheckFileContext is CheckFile with a context, as described at Client.
+func (c *Client) CheckFileContext(ctx context.Context, path, algorithms string, offset, length int64) (string, []byte, error) {
+	if offset < 0 || length < 0 {
+		return "", nil, errors.Errorf("sftp: invalid range %d+%d", offset, length)
+	}
+	return checkFile(ctx, c.sendPacketContext, sshFxpExtendedPacketCheckFileName{
+		ID:         c.nextID(),
+		Path:       path,
+		Algorithms: algorithms,
+		Offset:     uint64(offset),
+		Length:     uint64(length),
+	})
+}
+
+// CheckFile is Client.CheckFile on the open file, which this package's
+// Server supports during an upload when configured WithDigests.
+func (f *File) CheckFile(algorithms string, offset, length int64) (string, []byte, error) {
+	return f.CheckFileContext(context.Background(), algorithms, offset, length)
+}
+
+// CheckFileContext is CheckFile with a context, as described at Client.
+func (f *File) CheckFileContext(ctx context.Context, algorithms string, offset, length int64) (string, []byte, error) {
+	if offset < 0 || length < 0 {
+		return "", nil, errors.Errorf("sftp: invalid range %d+%d", offset, length)
+	}
+	return checkFile(ctx, f.sendPacketContext, sshFxpExtendedPacketCheckFileHandle{
+		ID:         f.c.nextID(),
+		Handle:     f.handle,
+		Algorithms: algorithms,
+		Offset:     uint64(offset),
+		Length:     uint64(length),
+	})
+}
+
+// checkFile sends the check-file request p with send, and returns the
+// algorithm and hash of the reply.
+func checkFile(ctx context.Context, send func(context.Context, idmarshaler) (byte, []byte, error), p idmarshaler) (string, []byte, error) {
+	id := p.id()
+	typ, data, err := send(ctx, p)
+	if err != nil {
+		return "", nil, err
+	}
+	switch typ {
+	case ssh_FXP_EXTENDED_REPLY:
+		sid, data := unmarshalUint32(data)
+		if sid != id {
+			return "", nil, &unexpectedIDErr{id, sid}
+		}
+		reply, data, err := unmarshalStringSafe(data)
+		if err != nil {
+			return "", nil, err
+		}
+		if reply != "check-file" {
+			return "", nil, errors.Errorf("sftp: unexpected reply %q to check-file", reply)
+		}
+		algorithm, hash, err := unmarshalStringSafe(data)
+		if err != nil {
+			return "", nil, err
+		}
+		return algorithm, hash, nil
+	case ssh_FXP_STATUS:
+		return "", nil, normaliseError(unmarshalStatus(id, data))
+	default:
+		return "", nil, unimplementedPacketErr(typ)
+	}
+}
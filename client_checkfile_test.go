@@ -0,0 +1,41 @@
+package sftp
+
+import (
+	"bytes"
+	"crypto/md5"
+	"testing"
+)
+
+func TestFileCheckFile(t *testing.T) {
+	client, _, _, cleanup := uploadServerPair(t, WithDigests(testDigests...))
+	defer cleanup()
+
+	f, err := client.Create(testUploadPath + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data := bytes.Repeat([]byte("contents"), 1000)
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	alg, hash, err := f.CheckFile("sha1,md5", 100, 1000)
+	want := md5.Sum(data[100:1100])
+	if err != nil || alg != "md5" || !bytes.Equal(hash, want[:]) {
+		t.Errorf("want md5 %x, got %q %x, %v", want, alg, hash, err)
+	}
+	alg, hash, err = f.CheckFile("md5", 100, 0)
+	want = md5.Sum(data[100:])
+	if err != nil || alg != "md5" || !bytes.Equal(hash, want[:]) {
+		t.Errorf("want md5 %x of the rest, got %q %x, %v", want, alg, hash, err)
+	}
+	if _, _, err := f.CheckFile("sha1", 0, 0); err == nil {
+		t.Error("unsupported algorithm accepted")
+	} else if serr, ok := err.(*StatusError); !ok || serr.Code != ssh_FX_OP_UNSUPPORTED {
+		t.Errorf("want SSH_FX_OP_UNSUPPORTED for an unsupported algorithm, got %v", err)
+	}
+	if _, _, err := f.CheckFile("md5", -1, 0); err == nil {
+		t.Error("negative offset accepted")
+	}
+}
@@ -0,0 +1,55 @@
+package sftp
+
+// Cancellation of requests
+
+import "context"
+
+// sendPacketContext is sendPacket on the current session, but returns the
+// error of ctx if it is done before the response arrives, or
+// ErrRequestTimeout once the request timeout has passed, abandoning the
+// request. With Reconnect, the request may be sent on a new session.
+func (c *Client) sendPacketContext(ctx context.Context, p idmarshaler) (byte, []byte, error) {
+	_, typ, data, err := c.request(ctx, p)
+	return typ, data, err
+}
+
+// sendPacketContext is sendPacketContext on the session the handle of f
+// belongs to.
+func (f *File) sendPacketContext(ctx context.Context, p idmarshaler) (byte, []byte, error) {
+	return f.c.sessionPacket(ctx, f.s, p)
+}
+
+// sessionPacket is sendPacketContext on the session s.
+func (c *Client) sessionPacket(ctx context.Context, s *clientConn, p idmarshaler) (byte, []byte, error) {
+	if ctx.Done() == nil && c.requestTimeout == 0 {
+		return s.sendPacket(p)
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, nil, err
+	}
+	timer := c.newRequestTimer()
+	defer timer.stop()
+	ch := make(chan result, 1)
+	s.dispatchRequest(ch, p)
+	select {
+	case r := <-ch:
+		return r.typ, r.data, r.err
+	case <-ctx.Done():
+		go c.abandon(s, ch)
+		return 0, nil, ctx.Err()
+	case <-timer.C():
+		go c.abandon(s, ch)
+		return 0, nil, ErrRequestTimeout
+	}
+}
+
+// abandon drops the response to an abandoned request on the session s when
+// it arrives on ch, closing the handle it opened, if any.
+func (c *Client) abandon(s *clientConn, ch <-chan result) {
+	r := <-ch
+	if r.err == nil && r.typ == ssh_FXP_HANDLE {
+		_, data := unmarshalUint32(r.data)
+		handle, _ := unmarshalString(data)
+		c.close(context.Background(), s, handle)
+	}
+}
@@ -0,0 +1,48 @@
+package sftp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClientContext(t *testing.T) {
+	pending := make(chan *PendingUpload, 1)
+	client, _, dir, cleanup := uploadServerPair(t,
+		WithApproval(func(u *PendingUpload) { pending <- u }, time.Minute),
+	)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.CreateContext(ctx, testUploadPath+"/file"); err != context.Canceled {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+
+	f, err := client.Create(testUploadPath + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("contents")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := f.CloseContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("want context.DeadlineExceeded, got %v", err)
+	}
+
+	// The response to the abandoned CLOSE is dropped, and the session goes on.
+	(<-pending).Approve()
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "file")); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := client.Stat(testUploadPath); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,87 @@
+package sftp
+
+// Listing directories a batch at a time
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// A DirStream lists the entries of a remote directory, fetching them from
+// the server a batch at a time as they are read, however many there are.
+// It is not safe for concurrent use.
+//
+//	d, err := client.ReadDirStream(dir)
+//	if err != nil {
+//		return err
+//	}
+//	defer d.Close()
+//	for d.Next() {
+//		fmt.Println(d.FileInfo().Name())
+//	}
+//	return d.Err()
+type DirStream struct {
+	c      *Client
+	ctx    context.Context
+	s      *clientConn
+	handle string // "" once closed
+	batch  []os.FileInfo
+	info   os.FileInfo
+	err    error
+}
+
+// ReadDirStream opens the directory named by path for listing with a
+// DirStream. Its entries, other than "." and "..", are in the order the
+// server lists them, which is not sorted.
+func (c *Client) ReadDirStream(path string) (*DirStream, error) {
+	return c.ReadDirStreamContext(context.Background(), path)
+}
+
+// ReadDirStreamContext is ReadDirStream with a context, as described at
+// Client, which applies to the whole listing.
+func (c *Client) ReadDirStreamContext(ctx context.Context, path string) (*DirStream, error) {
+	s, handle, err := c.opendir(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &DirStream{c: c, ctx: ctx, s: s, handle: handle}, nil
+}
+
+// Next advances to the next entry, which FileInfo then returns, fetching a
+// batch from the server if need be. It returns false once all entries have
+// been read, closing the directory, or on failure, which Err then reports.
+func (d *DirStream) Next() bool {
+	for len(d.batch) == 0 {
+		if d.err != nil || d.handle == "" {
+			d.info = nil
+			return false
+		}
+		d.batch, d.err = d.c.readdir(d.ctx, d.s, d.handle)
+		if d.err == io.EOF {
+			d.err = d.Close()
+		}
+	}
+	d.info, d.batch = d.batch[0], d.batch[1:]
+	return true
+}
+
+// FileInfo returns the entry reached by the last call to Next.
+func (d *DirStream) FileInfo() os.FileInfo {
+	return d.info
+}
+
+// Err returns the error which ended the listing, if any.
+func (d *DirStream) Err() error {
+	return d.err
+}
+
+// Close closes the directory. It may be called more than once.
+func (d *DirStream) Close() error {
+	if d.handle == "" {
+		return nil
+	}
+	handle := d.handle
+	d.handle = ""
+	return d.c.close(d.ctx, d.s, handle)
+}
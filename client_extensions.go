@@ -0,0 +1,175 @@
+package sftp
+
+// OpenSSH protocol extensions
+
+import (
+	"context"
+	"os"
+)
+
+// HasExtension reports whether the server advertised support for the
+// extension name, such as "posix-rename@openssh.com", in its VERSION packet.
+func (c *Client) HasExtension(name string) bool {
+	_, ok := c.ext[name]
+	return ok
+}
+
+// Extensions returns the extensions the server advertised in its VERSION
+// packet, by name, with their data, usually a version number such as "1".
+func (c *Client) Extensions() map[string]string {
+	ext := make(map[string]string, len(c.ext))
+	for name, data := range c.ext {
+		ext[name] = data
+	}
+	return ext
+}
+
+// hasExtension reports whether the server advertised support for the
+// extension name, in version ver.
+func (c *Client) hasExtension(name, ver string) bool {
+	v, ok := c.ext[name]
+	return ok && v == ver
+}
+
+// unsupportedExtension returns the error for a request using the extension
+// name, which the server does not support.
+func unsupportedExtension(name string) error {
+	return &StatusError{Code: ssh_FX_OP_UNSUPPORTED, msg: name + " not supported"}
+}
+
+// Sync commits the contents of the file to stable storage on the server,
+// like os.File.Sync, so that its local copy can safely be deleted. It uses
+// the fsync@openssh.com extension, and fails with a *StatusError with code
+// SSH_FX_OP_UNSUPPORTED if the server does not support it.
+func (f *File) Sync() error {
+	return f.SyncContext(context.Background())
+}
+
+// SyncContext is Sync with a context, as described at Client.
+func (f *File) SyncContext(ctx context.Context) error {
+	if !f.c.hasExtension("fsync@openssh.com", "1") {
+		return unsupportedExtension("fsync@openssh.com")
+	}
+	id := f.c.nextID()
+	typ, data, err := f.sendPacketContext(ctx, sshFxpFsyncPacket{
+		ID:     id,
+		Handle: f.handle,
+	})
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case ssh_FXP_STATUS:
+		return normaliseError(unmarshalStatus(id, data))
+	default:
+		return unimplementedPacketErr(typ)
+	}
+}
+
+// Link creates newname as a hard link to the file oldname, like os.Link. It
+// uses the hardlink@openssh.com extension, and fails with a *StatusError with
+// code SSH_FX_OP_UNSUPPORTED if the server does not support it.
+func (c *Client) Link(oldname, newname string) error {
+	return c.LinkContext(context.Background(), oldname, newname)
+}
+
+// LinkContext is Link with a context, as described at Client.
+func (c *Client) LinkContext(ctx context.Context, oldname, newname string) error {
+	if !c.hasExtension("hardlink@openssh.com", "1") {
+		return unsupportedExtension("hardlink@openssh.com")
+	}
+	id := c.nextID()
+	typ, data, err := c.sendPacketContext(ctx, sshFxpHardlinkPacket{
+		ID:      id,
+		Oldpath: oldname,
+		Newpath: newname,
+	})
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case ssh_FXP_STATUS:
+		return normaliseError(unmarshalStatus(id, data))
+	default:
+		return unimplementedPacketErr(typ)
+	}
+}
+
+// PosixRename renames oldname to newname, replacing newname if it exists,
+// like os.Rename, which Rename does not do on most servers. It uses the
+// posix-rename@openssh.com extension, and fails with a *StatusError with code
+// SSH_FX_OP_UNSUPPORTED if the server does not support it.
+func (c *Client) PosixRename(oldname, newname string) error {
+	return c.PosixRenameContext(context.Background(), oldname, newname)
+}
+
+// PosixRenameContext is PosixRename with a context, as described at Client.
+func (c *Client) PosixRenameContext(ctx context.Context, oldname, newname string) error {
+	if !c.hasExtension("posix-rename@openssh.com", "1") {
+		return unsupportedExtension("posix-rename@openssh.com")
+	}
+	id := c.nextID()
+	typ, data, err := c.sendPacketContext(ctx, sshFxpPosixRenamePacket{
+		ID:      id,
+		Oldpath: oldname,
+		Newpath: newname,
+	})
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case ssh_FXP_STATUS:
+		return normaliseError(unmarshalStatus(id, data))
+	default:
+		return unimplementedPacketErr(typ)
+	}
+}
+
+// CopyData copies the contents of the file srcPath to dstPath on the
+// server, creating or truncating dstPath, without the data passing through
+// the client. It uses the copy-data extension, and fails with a
+// *StatusError with code SSH_FX_OP_UNSUPPORTED, before opening either file,
+// if the server does not support it. If the copy fails, dstPath may hold
+// part of the data.
+func (c *Client) CopyData(srcPath, dstPath string) error {
+	return c.CopyDataContext(context.Background(), srcPath, dstPath)
+}
+
+// CopyDataContext is CopyData with a context, as described at Client.
+func (c *Client) CopyDataContext(ctx context.Context, srcPath, dstPath string) error {
+	if !c.hasExtension("copy-data", "1") {
+		return unsupportedExtension("copy-data")
+	}
+	src, err := c.OpenFileContext(ctx, srcPath, os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := c.OpenFileContext(ctx, dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if src.s != dst.s {
+		// A reconnect came between the opens.
+		dst.Close()
+		return ErrConnectionLost
+	}
+	id := c.nextID()
+	typ, data, err := dst.sendPacketContext(ctx, sshFxpCopyDataPacket{
+		ID:          id,
+		ReadHandle:  src.handle,
+		WriteHandle: dst.handle,
+	})
+	if err == nil {
+		switch typ {
+		case ssh_FXP_STATUS:
+			err = normaliseError(unmarshalStatus(id, data))
+		default:
+			err = unimplementedPacketErr(typ)
+		}
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
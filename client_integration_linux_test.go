@@ -39,4 +39,8 @@ func TestClientStatVFS(t *testing.T) {
 	if vfs.Namemax != uint64(s.Namelen) {
 		t.Fatalf("f_namemax does not match, expected: %v, got: %v", s.Namelen, vfs.Namemax)
 	}
+
+	if vfs.AvailableSpace() > vfs.FreeSpace() {
+		t.Fatalf("available space %d exceeds free space %d", vfs.AvailableSpace(), vfs.FreeSpace())
+	}
 }
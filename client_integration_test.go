@@ -21,6 +21,7 @@ import (
 	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 	"testing"
 	"testing/quick"
 	"time"
@@ -1068,6 +1069,44 @@ func TestClientRead(t *testing.T) {
 	}
 }
 
+func TestClientMaxConcurrentReads(t *testing.T) {
+	sftp, cmd := testClient(t, READONLY, NO_DELAY)
+	defer cmd.Wait()
+	defer sftp.Close()
+
+	f, err := ioutil.TempFile("", "sftptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	const size = 1<<20 + 123
+	hash := writeN(t, f, size)
+
+	for _, n := range []int{1, 4, 256} {
+		if err := sftp.applyOptions(MaxConcurrentReads(n)); err != nil {
+			t.Fatal(err)
+		}
+		f2, err := sftp.Open(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// io.Copy uses WriteTo, readHash a Read of its buffer at a time.
+		var buf bytes.Buffer
+		if got, err := io.Copy(&buf, f2); err != nil || got != size {
+			t.Errorf("%d: WriteTo: %d bytes, %v", n, got, err)
+		}
+		f2.Seek(0, io.SeekStart)
+		if hash2, got := readHash(t, struct{ io.Reader }{f2}); hash2 != hash || got != size {
+			t.Errorf("%d: Read: hash: want: %q, got %q, read: want: %v, got %v", n, hash, hash2, size, got)
+		}
+		f2.Close()
+	}
+	if err := sftp.applyOptions(MaxConcurrentReads(0)); err == nil {
+		t.Error("zero concurrent reads accepted")
+	}
+}
+
 // readHash reads r until EOF returning the number of bytes read
 // and the hash of the contents.
 func readHash(t *testing.T, r io.Reader) (string, int64) {
@@ -1204,6 +1243,48 @@ func TestClientReadFrom(t *testing.T) {
 // Deadlock would occur anytime desiredInFlight-inFlight==2 and 2 errors
 // occured in a row. The channel to report the errors only had a buffer
 // of 1 and 2 would be sent.
+func TestClientMaxConcurrentWrites(t *testing.T) {
+	sftp, cmd := testClient(t, READWRITE, NO_DELAY)
+	defer cmd.Wait()
+	defer sftp.Close()
+
+	d, err := ioutil.TempDir("", "sftptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	data := make([]byte, 1<<20+123)
+	rand.Read(data)
+
+	for _, n := range []int{1, 4, 256} {
+		if err := sftp.applyOptions(MaxConcurrentWrites(n)); err != nil {
+			t.Fatal(err)
+		}
+		for _, readFrom := range []bool{false, true} {
+			f := path.Join(d, "writeTest")
+			w, err := sftp.Create(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if readFrom {
+				_, err = w.ReadFrom(bytes.NewReader(data))
+			} else {
+				_, err = w.Write(data)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Close()
+			if got, err := ioutil.ReadFile(f); err != nil || !bytes.Equal(got, data) {
+				t.Errorf("%d: ReadFrom %v: wrong contents: %v", n, readFrom, err)
+			}
+		}
+	}
+	if err := sftp.applyOptions(MaxConcurrentWrites(0)); err == nil {
+		t.Error("zero concurrent writes accepted")
+	}
+}
+
 func TestClientReadFromDeadlock(t *testing.T) {
 	clientDeadlock(t, func(f *File) {
 		b := make([]byte, 32768*4)
@@ -1798,3 +1879,630 @@ func BenchmarkCopyUp10MiBDelay50Msec(b *testing.B) {
 func BenchmarkCopyUp10MiBDelay150Msec(b *testing.B) {
 	benchmarkCopyUp(b, 10*1024*1024, 150*time.Millisecond)
 }
+
+func TestClientReadDirStream(t *testing.T) {
+	sftp, cmd := testClient(t, READONLY, NO_DELAY)
+	defer cmd.Wait()
+	defer sftp.Close()
+
+	dir, err := ioutil.TempDir("", "sftptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	want := make(map[string]bool)
+	for i := 0; i < 500; i++ {
+		name := "file" + strconv.Itoa(i)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		want[name] = true
+	}
+
+	d, err := sftp.ReadDirStream(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	for d.Next() {
+		name := d.FileInfo().Name()
+		if !want[name] {
+			t.Errorf("unexpected or repeated entry %q", name)
+		}
+		delete(want, name)
+	}
+	if err := d.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(want) != 0 {
+		t.Errorf("%d entries missing", len(want))
+	}
+	if d.Next() || d.Close() != nil {
+		t.Error("listing not ended")
+	}
+
+	if _, err := sftp.ReadDirStream(filepath.Join(dir, "nonexistent")); err == nil {
+		t.Error("listed a nonexistent directory")
+	}
+}
+
+func TestClientWalkDir(t *testing.T) {
+	sftp, cmd := testClient(t, READONLY, NO_DELAY)
+	defer cmd.Wait()
+	defer sftp.Close()
+
+	makeTree(t)
+	defer os.RemoveAll(tree.name)
+	var errors []error
+	err := sftp.WalkDir(tree.name, func(path string, info os.FileInfo, err error) error {
+		return mark(path, info, err, &errors, false)
+	})
+	if err != nil || len(errors) != 0 {
+		t.Fatalf("unexpected errors: %v, %s", err, errors)
+	}
+	checkMarks(t, true)
+
+	// Skipping d or z skips u and v.
+	for _, skip := range []string{"d", "z"} {
+		var visited []string
+		err := sftp.WalkDir(tree.name, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			visited = append(visited, info.Name())
+			if info.Name() == skip {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range visited {
+			if name == "u" || name == "v" {
+				t.Errorf("skipping %s: visited %s", skip, name)
+			}
+		}
+	}
+
+	if err := sftp.WalkDir("/nonexistent", func(path string, info os.FileInfo, err error) error {
+		if info != nil || !os.IsNotExist(err) {
+			t.Errorf("want not found error, got %v, %v", info, err)
+		}
+		return err
+	}); !os.IsNotExist(err) {
+		t.Errorf("want not found error, got %v", err)
+	}
+}
+
+func TestClientUploadDir(t *testing.T) {
+	sftp, cmd := testClient(t, READWRITE, NO_DELAY)
+	defer cmd.Wait()
+	defer sftp.Close()
+
+	makeTree(t)
+	defer os.RemoveAll(tree.name)
+	if err := ioutil.WriteFile(filepath.Join(tree.name, "d", "x"), []byte("contents"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "sftptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var uploaded []string
+	err = sftp.UploadDir(tree.name, dir, UploadDirOptions{
+		Concurrency: 3,
+		OnFile: func(local, remote string, err error) error {
+			uploaded = append(uploaded, remote)
+			return err
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploaded) != 5 {
+		t.Errorf("want 5 files uploaded, got %q", uploaded)
+	}
+	walkTree(tree, dir, func(path string, n *Node) {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Error(err)
+		} else if info.IsDir() != (n.entries != nil) {
+			t.Errorf("%s: wrong type %v", path, info.Mode())
+		}
+	})
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "d", "x")); err != nil || string(b) != "contents" {
+		t.Errorf("want contents, got %q, %v", b, err)
+	}
+
+	// Uploading again overwrites the files in the existing directories.
+	if err := sftp.UploadDir(tree.name, dir, UploadDirOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientDownloadDir(t *testing.T) {
+	sftp, cmd := testClient(t, READONLY, NO_DELAY)
+	defer cmd.Wait()
+	defer sftp.Close()
+
+	makeTree(t)
+	defer os.RemoveAll(tree.name)
+	x := filepath.Join(tree.name, "d", "x")
+	if err := ioutil.WriteFile(x, []byte("contents"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(1500000000, 0)
+	if err := os.Chtimes(x, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "sftptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = sftp.DownloadDir(tree.name, dir, DownloadDirOptions{
+		Concurrency: 3,
+		Filter: func(remote string, info os.FileInfo) bool {
+			return info.Name() != "z"
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	walkTree(tree, dir, func(path string, n *Node) {
+		_, err := os.Stat(path)
+		if skipped := strings.HasPrefix(path, filepath.Join(dir, "d", "z")); skipped != os.IsNotExist(err) {
+			t.Errorf("%s: want skipped %v, got %v", path, skipped, err)
+		}
+	})
+	info, err := os.Stat(filepath.Join(dir, "d", "x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 || !info.ModTime().Equal(mtime) {
+		t.Errorf("want mode 0640 and mtime %v, got %v and %v", mtime, info.Mode(), info.ModTime())
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "d", "x")); err != nil || string(b) != "contents" {
+		t.Errorf("want contents, got %q, %v", b, err)
+	}
+}
+
+func TestClientSyncDir(t *testing.T) {
+	sftp, cmd := testClient(t, READWRITE, NO_DELAY)
+	defer cmd.Wait()
+	defer sftp.Close()
+
+	local, err := ioutil.TempDir("", "sftptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(local)
+	remote, err := ioutil.TempDir("", "sftptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(remote)
+	for name, contents := range map[string]string{"a": "aaa", "d/b": "bbb"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(local, name)), 0700)
+		if err := ioutil.WriteFile(filepath.Join(local, name), []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(remote, "stale"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var uploaded []string
+	opts := SyncDirOptions{
+		Delete: true,
+		OnFile: func(local, remote string, err error) error {
+			if local != "" {
+				uploaded = append(uploaded, remote)
+			}
+			return err
+		},
+	}
+	if err := sftp.SyncDir(local, remote, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(uploaded) != 2 {
+		t.Errorf("want 2 files uploaded, got %q", uploaded)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(remote, "d", "b")); err != nil || string(b) != "bbb" {
+		t.Errorf("want bbb, got %q, %v", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(remote, "stale")); !os.IsNotExist(err) {
+		t.Errorf("stale file not deleted: %v", err)
+	}
+
+	// Unchanged files are not uploaded again.
+	uploaded = nil
+	if err := sftp.SyncDir(local, remote, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(uploaded) != 0 {
+		t.Errorf("want no files uploaded, got %q", uploaded)
+	}
+}
+
+func TestClientResume(t *testing.T) {
+	sftp, cmd := testClient(t, READWRITE, NO_DELAY)
+	defer cmd.Wait()
+	defer sftp.Close()
+
+	dir, err := ioutil.TempDir("", "sftptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	data := bytes.Repeat([]byte("contents"), 1<<14)
+
+	for _, tt := range []struct {
+		name   string
+		resume func(src, dst string) (int64, error)
+	}{
+		{"upload", sftp.ResumeUpload},
+		{"download", sftp.ResumeDownload},
+	} {
+		for _, partial := range []int{0, 1000, len(data), len(data) + 1} {
+			prefix := append([]byte(nil), data...)
+			if partial <= len(data) {
+				prefix = prefix[:partial]
+			} else {
+				prefix = append(prefix, 'x')
+			}
+			if err := ioutil.WriteFile(src, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(dst, prefix, 0600); err != nil {
+				t.Fatal(err)
+			}
+			want := int64(len(data) - partial)
+			if partial > len(data) {
+				want = int64(len(data))
+			}
+			n, err := tt.resume(src, dst)
+			if err != nil || n != want {
+				t.Errorf("%s from %d: want %d bytes, got %d, %v", tt.name, partial, want, n, err)
+			}
+			if b, err := ioutil.ReadFile(dst); err != nil || !bytes.Equal(b, data) {
+				t.Errorf("%s from %d: wrong contents, %v", tt.name, partial, err)
+			}
+		}
+	}
+}
+
+func TestClientMaxTransferRate(t *testing.T) {
+	sftp, cmd := testClient(t, READWRITE, NO_DELAY)
+	defer cmd.Wait()
+	defer sftp.Close()
+	if err := sftp.applyOptions(MaxTransferRate(1 << 20)); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := ioutil.TempDir("", "sftptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	f, err := sftp.Create(filepath.Join(d, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// The first packet is sent at once, the other 15 at 32KB every 1/32s.
+	start := time.Now()
+	if _, err := f.Write(make([]byte, 1<<19)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 450*time.Millisecond {
+		t.Errorf("wrote 512KB at 1MB/s in %v", elapsed)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	start = time.Now()
+	if _, err := io.ReadFull(f, make([]byte, 1<<19)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 450*time.Millisecond {
+		t.Errorf("read 512KB at 1MB/s in %v", elapsed)
+	}
+}
+
+func TestClientProgress(t *testing.T) {
+	sftp, cmd := testClient(t, READWRITE, NO_DELAY)
+	defer cmd.Wait()
+	defer sftp.Close()
+
+	d, err := ioutil.TempDir("", "sftptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	f, err := sftp.Create(filepath.Join(d, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var reports []Progress
+	f.OnProgress(func(p Progress) { reports = append(reports, p) })
+
+	data := bytes.Repeat([]byte("contents"), 1<<15)
+	for _, tt := range []struct {
+		name     string
+		transfer func() (int64, error)
+		total    int64
+	}{
+		{"ReadFrom", func() (int64, error) { return f.ReadFrom(bytes.NewReader(data)) }, int64(len(data))},
+		{"ReadFrom unknown size", func() (int64, error) { return f.ReadFrom(struct{ io.Reader }{bytes.NewReader(data)}) }, -1},
+		{"WriteTo", func() (int64, error) { return f.WriteTo(ioutil.Discard) }, int64(len(data))},
+	} {
+		reports = nil
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tt.transfer(); err != nil {
+			t.Fatal(err)
+		}
+		if len(reports) == 0 {
+			t.Errorf("%s: no progress reported", tt.name)
+			continue
+		}
+		last := reports[len(reports)-1]
+		if last.Name != f.Name() || last.Done != int64(len(data)) || last.Total != tt.total {
+			t.Errorf("%s: wrong final progress %+v", tt.name, last)
+		}
+	}
+}
+
+func TestClientSync(t *testing.T) {
+	if *testServerImpl {
+		t.Skipf("go server does not support fsync@openssh.com")
+	}
+	sftp, cmd := testClient(t, READWRITE, NO_DELAY)
+	defer cmd.Wait()
+	defer sftp.Close()
+
+	d, err := ioutil.TempDir("", "sftptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	f, err := sftp.Create(filepath.Join(d, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write([]byte("contents")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Sync(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientLink(t *testing.T) {
+	if *testServerImpl {
+		t.Skipf("go server does not support hardlink@openssh.com")
+	}
+	sftp, cmd := testClient(t, READWRITE, NO_DELAY)
+	defer cmd.Wait()
+	defer sftp.Close()
+
+	d, err := ioutil.TempDir("", "sftptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	old, link := filepath.Join(d, "old"), filepath.Join(d, "link")
+	if err := ioutil.WriteFile(old, []byte("contents"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := sftp.Link(old, link); err != nil {
+		t.Fatal(err)
+	}
+	oldInfo, err := os.Stat(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	linkInfo, err := os.Stat(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(oldInfo, linkInfo) {
+		t.Errorf("%s is not a link to %s", link, old)
+	}
+	if err := sftp.Link(old, link); err == nil {
+		t.Error("linked over an existing file")
+	}
+}
+
+func TestClientPutAtomic(t *testing.T) {
+	if *testServerImpl {
+		t.Skipf("go server does not support posix-rename@openssh.com")
+	}
+	sftp, cmd := testClient(t, READWRITE, NO_DELAY)
+	defer cmd.Wait()
+	defer sftp.Close()
+
+	d, err := ioutil.TempDir("", "sftptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	local, remote := filepath.Join(d, "local"), filepath.Join(d, "remote")
+	if err := ioutil.WriteFile(local, []byte("new"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(remote, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := sftp.PutAtomic(local, remote); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(remote); err != nil || string(b) != "new" {
+		t.Errorf("want new, got %q, %v", b, err)
+	}
+
+	// A failed rename leaves no temporary file behind.
+	if err := os.MkdirAll(filepath.Join(d, "dir", "x"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := sftp.PutAtomic(local, filepath.Join(d, "dir")); err == nil {
+		t.Error("replaced a non-empty directory")
+	}
+	if fis, err := ioutil.ReadDir(d); err != nil || len(fis) != 3 {
+		t.Errorf("want local, remote and dir, got %d files, %v", len(fis), err)
+	}
+}
+
+func TestClientReadAt(t *testing.T) {
+	sftp, cmd := testClient(t, READONLY, NO_DELAY)
+	defer cmd.Wait()
+	defer sftp.Close()
+
+	d, err := ioutil.TempDir("", "sftptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	const chunk = 100 << 10
+	want := make([]byte, 8*chunk)
+	rand.Read(want)
+	name := filepath.Join(d, "file")
+	if err := ioutil.WriteFile(name, want, 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := sftp.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	got := make([]byte, len(want))
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		go func(i int) {
+			_, err := f.ReadAt(got[i*chunk:(i+1)*chunk], int64(i*chunk))
+			errs <- err
+		}(i)
+	}
+	for i := 0; i < 8; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("wrong data read")
+	}
+	if n, err := f.ReadAt(got, chunk); n != 7*chunk || err != io.EOF {
+		t.Errorf("want %d bytes and io.EOF, got %d, %v", 7*chunk, n, err)
+	}
+}
+
+func TestClientCopyData(t *testing.T) {
+	sftp, cmd := testClient(t, READWRITE, NO_DELAY)
+	defer cmd.Wait()
+	defer sftp.Close()
+	if !sftp.HasExtension("copy-data") {
+		t.Skip("server does not support copy-data")
+	}
+
+	d, err := ioutil.TempDir("", "sftptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	src, dst := filepath.Join(d, "src"), filepath.Join(d, "dst")
+	if err := ioutil.WriteFile(src, []byte("contents"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dst, []byte("longer old contents"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := sftp.CopyData(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(dst); err != nil || string(b) != "contents" {
+		t.Errorf("want contents, got %q, %v", b, err)
+	}
+	if err := sftp.CopyData(filepath.Join(d, "missing"), dst); err != os.ErrNotExist {
+		t.Errorf("want os.ErrNotExist, got %v", err)
+	}
+}
+
+func TestClientOpenFileAppend(t *testing.T) {
+	sftp, cmd := testClient(t, READWRITE, NO_DELAY)
+	defer cmd.Wait()
+	defer sftp.Close()
+
+	d, err := ioutil.TempDir("", "sftptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	name := filepath.Join(d, "file")
+	if err := ioutil.WriteFile(name, []byte("abc"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := sftp.OpenFile(name, os.O_WRONLY|os.O_APPEND)
+	if *testServerImpl {
+		if !errors.Is(err, ErrOpUnsupported) {
+			t.Errorf("want ErrOpUnsupported, got %v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("def")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(name); err != nil || string(b) != "abcdef" {
+		t.Errorf("want abcdef, got %q, %v", b, err)
+	}
+}
+
+func TestClientTransferManagerDownload(t *testing.T) {
+	sftp, cmd := testClient(t, READONLY, NO_DELAY)
+	defer cmd.Wait()
+	defer sftp.Close()
+
+	remote, err := ioutil.TempDir("", "sftptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(remote)
+	local, err := ioutil.TempDir("", "sftptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(local)
+	if err := ioutil.WriteFile(filepath.Join(remote, "file"), []byte("contents"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewTransferManager(TransferManagerOptions{Concurrency: 2}, sftp)
+	m.Add(Transfer{Download: true, Remote: filepath.Join(remote, "file"), Local: filepath.Join(local, "file")})
+	m.Add(Transfer{Download: true, Remote: filepath.Join(remote, "missing"), Local: filepath.Join(local, "missing")})
+	if err := m.Close(); err == nil {
+		t.Error("failed download not reported")
+	}
+	got, err := ioutil.ReadFile(filepath.Join(local, "file"))
+	if err != nil || string(got) != "contents" {
+		t.Errorf("wrong download %q, %v", got, err)
+	}
+	if stats := m.Stats(); stats.Succeeded != 1 || stats.Failed != 1 {
+		t.Errorf("want 1 succeeded and 1 failed, got %+v", stats)
+	}
+}
@@ -0,0 +1,66 @@
+package sftp
+
+// Detection of lost connections
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrConnectionLost is returned by the operations of a Client with KeepAlive
+// once the server has stopped responding, and with Reconnect, by those on
+// the Files of a lost session.
+var ErrConnectionLost = errors.New("sftp: connection lost")
+
+// KeepAlive makes the Client send an SSH_FXP_REALPATH request for "." once
+// it has received nothing for interval, so that NAT mappings stay open. If
+// the server does not respond within interval, the Client closes the
+// session, and the operations under way and any later ones fail with
+// ErrConnectionLost, rather than hanging until TCP gives up; with Reconnect,
+// a new session is started instead.
+func KeepAlive(interval time.Duration) func(*Client) error {
+	return func(c *Client) error {
+		if interval <= 0 {
+			return errors.Errorf("invalid keepalive interval %v", interval)
+		}
+		c.keepAlive = interval
+		return nil
+	}
+}
+
+// sendKeepAlives sends keepalive requests until the session is closed, or
+// with Reconnect, the Client, replacing lost sessions.
+func (c *Client) sendKeepAlives() {
+	atomic.StoreInt64(&c.lastRecv, time.Now().UnixNano())
+	t := time.NewTicker(c.keepAlive / 2)
+	defer t.Stop()
+	for {
+		s := c.session()
+		select {
+		case <-s.closed:
+			if c.dial == nil || c.isClosed() {
+				return
+			}
+			<-t.C // reconnect with the next keepalive
+		case <-t.C:
+			if c.isClosed() {
+				return
+			}
+			if time.Since(time.Unix(0, atomic.LoadInt64(&s.lastRecv))) < c.keepAlive {
+				continue
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), c.keepAlive)
+		_, err := c.realpath(ctx, ".")
+		cancel()
+		if err == context.DeadlineExceeded || err == ErrRequestTimeout {
+			c.session().closeWith(ErrConnectionLost)
+			if c.dial == nil {
+				return
+			}
+		}
+	}
+}
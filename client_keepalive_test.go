@@ -0,0 +1,43 @@
+package sftp
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestClientKeepAlive(t *testing.T) {
+	// The server answers INIT, then nothing.
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	defer sw.Close()
+	go func() {
+		if _, _, err := recvPacket(sr); err != nil {
+			return
+		}
+		sendPacket(sw, sshFxVersionPacket{Version: sftpProtocolVersion})
+		io.Copy(ioutil.Discard, sr)
+	}()
+	client, err := NewClientPipe(cr, cw, KeepAlive(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Stat("/")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != ErrConnectionLost {
+			t.Errorf("want ErrConnectionLost, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("lost connection not detected")
+	}
+	if _, err := client.Stat("/"); err != ErrConnectionLost {
+		t.Errorf("want ErrConnectionLost after the loss, got %v", err)
+	}
+}
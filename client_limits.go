@@ -0,0 +1,135 @@
+package sftp
+
+// Tuning transfers to the limits@openssh.com extension
+
+import "context"
+
+// transferWindow is the number of bytes a File keeps in flight by default:
+// maxConcurrentRequests requests of the default 32k.
+const transferWindow = maxConcurrentRequests << 15
+
+// serverLimits are the limits a server reports with the limits@openssh.com
+// extension. Zero means no limit.
+type serverLimits struct {
+	MaxPacketLength uint64 // largest packet accepted
+	MaxReadLength   uint64 // largest length of a READ request
+	MaxWriteLength  uint64 // largest length of data in a WRITE request
+	MaxOpenHandles  uint64 // most handles open at once
+}
+
+// sshFxpLimitsPacket is a limits@openssh.com request.
+type sshFxpLimitsPacket struct {
+	ID uint32
+}
+
+func (p sshFxpLimitsPacket) id() uint32 { return p.ID }
+
+func (p sshFxpLimitsPacket) MarshalBinary() ([]byte, error) {
+	l := 1 + 4 + // type(byte) + uint32
+		4 + len("limits@openssh.com")
+
+	b := make([]byte, 0, l)
+	b = append(b, ssh_FXP_EXTENDED)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, "limits@openssh.com")
+	return b, nil
+}
+
+func unmarshalLimits(b []byte) (*serverLimits, error) {
+	if len(b) < 4*8 {
+		return nil, errShortPacket
+	}
+	var l serverLimits
+	l.MaxPacketLength, b = unmarshalUint64(b)
+	l.MaxReadLength, b = unmarshalUint64(b)
+	l.MaxWriteLength, b = unmarshalUint64(b)
+	l.MaxOpenHandles, _ = unmarshalUint64(b)
+	return &l, nil
+}
+
+// tuneTransfers sizes the READ and WRITE requests of Files, unless set by
+// MaxPacket, to the largest the server accepts, and the number of them
+// outstanding, unless set by MaxConcurrentReads or MaxConcurrentWrites, so
+// as to keep transferWindow bytes in flight. A size set by MaxPacket is
+// lowered to what the server accepts. The defaults are kept if the server
+// reports no limits.
+func (c *Client) tuneTransfers() {
+	packet := 0
+	if l, err := c.limits(context.Background()); err == nil {
+		packet = l.packetLength()
+	}
+	switch {
+	case packet == 0:
+	case c.maxPacket == 0:
+		c.maxPacket = packet
+		n := transferWindow / packet
+		if n < 1 {
+			n = 1
+		}
+		if c.maxConcurrentReads == 0 {
+			c.maxConcurrentReads = n
+		}
+		if c.maxConcurrentWrites == 0 {
+			c.maxConcurrentWrites = n
+		}
+	case c.maxPacket > packet:
+		c.maxPacket = packet
+	}
+	if c.maxPacket == 0 {
+		c.maxPacket = 1 << 15
+	}
+	if c.maxConcurrentReads == 0 {
+		c.maxConcurrentReads = maxConcurrentRequests
+	}
+	if c.maxConcurrentWrites == 0 {
+		c.maxConcurrentWrites = maxConcurrentRequests
+	}
+}
+
+// packetLength returns the largest length of both READ and WRITE requests
+// within l, at most that the OpenSSH client accepts, or 0 if there is no
+// limit.
+func (l *serverLimits) packetLength() int {
+	var lengths []uint64
+	switch {
+	case l.MaxReadLength > 0 || l.MaxWriteLength > 0:
+		lengths = []uint64{l.MaxReadLength, l.MaxWriteLength}
+	case l.MaxPacketLength > 2*rxPacketOverhead:
+		// Leave room for the header of a WRITE.
+		lengths = []uint64{l.MaxPacketLength - rxPacketOverhead}
+	default:
+		return 0
+	}
+	n := uint64(maxMsgLength - rxPacketOverhead)
+	for _, m := range lengths {
+		if m > 0 && m < n {
+			n = m
+		}
+	}
+	return int(n)
+}
+
+// limits queries the limits of the server with the limits@openssh.com
+// extension.
+func (c *Client) limits(ctx context.Context) (*serverLimits, error) {
+	if !c.hasExtension("limits@openssh.com", "1") {
+		return nil, unsupportedExtension("limits@openssh.com")
+	}
+	id := c.nextID()
+	typ, data, err := c.sendPacketContext(ctx, sshFxpLimitsPacket{ID: id})
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case ssh_FXP_EXTENDED_REPLY:
+		sid, data := unmarshalUint32(data)
+		if sid != id {
+			return nil, &unexpectedIDErr{id, sid}
+		}
+		return unmarshalLimits(data)
+	case ssh_FXP_STATUS:
+		return nil, normaliseError(unmarshalStatus(id, data))
+	default:
+		return nil, unimplementedPacketErr(typ)
+	}
+}
@@ -0,0 +1,88 @@
+package sftp
+
+import (
+	"io"
+	"testing"
+)
+
+// limitsReply is the reply of a server to a limits@openssh.com request.
+type limitsReply struct {
+	ID     uint32
+	Limits serverLimits
+}
+
+func (p limitsReply) MarshalBinary() ([]byte, error) {
+	b := []byte{ssh_FXP_EXTENDED_REPLY}
+	b = marshalUint32(b, p.ID)
+	b = marshalUint64(b, p.Limits.MaxPacketLength)
+	b = marshalUint64(b, p.Limits.MaxReadLength)
+	b = marshalUint64(b, p.Limits.MaxWriteLength)
+	return marshalUint64(b, p.Limits.MaxOpenHandles), nil
+}
+
+// limitsClient returns a Client of a server which reports limits, or does
+// not support the extension if limits is nil.
+func limitsClient(t *testing.T, limits *serverLimits, opts ...func(*Client) error) *Client {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	go func() {
+		defer sw.Close()
+		for {
+			typ, data, err := recvPacket(sr)
+			if err != nil {
+				return
+			}
+			switch typ {
+			case ssh_FXP_INIT:
+				version := sshFxVersionPacket{Version: sftpProtocolVersion}
+				if limits != nil {
+					version.Extensions = append(version.Extensions, struct{ Name, Data string }{"limits@openssh.com", "1"})
+				}
+				sendPacket(sw, version)
+			case ssh_FXP_EXTENDED:
+				id, _ := unmarshalUint32(data)
+				sendPacket(sw, limitsReply{id, *limits})
+			}
+		}
+	}()
+	client, err := NewClientPipe(cr, cw, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestClientLimits(t *testing.T) {
+	openssh := &serverLimits{256 * 1024, 256*1024 - 1024, 256*1024 - 1024, 0}
+	for _, tt := range []struct {
+		limits                *serverLimits
+		opts                  []func(*Client) error
+		packet, reads, writes int
+	}{
+		{nil, nil, 1 << 15, 64, 64},
+		{openssh, nil, 261120, 8, 8},
+		{openssh, []func(*Client) error{MaxPacket(1 << 20)}, 261120, 64, 64},
+		{openssh, []func(*Client) error{MaxPacket(1 << 16)}, 1 << 16, 64, 64},
+		{openssh, []func(*Client) error{MaxConcurrentReads(2)}, 261120, 2, 8},
+		{openssh, []func(*Client) error{MaxConcurrentRequests(4)}, 261120, 4, 4},
+		{nil, []func(*Client) error{MaxPacket(1 << 16), MaxConcurrentRequests(128)}, 1 << 16, 128, 128},
+		{&serverLimits{MaxReadLength: 1 << 14}, nil, 1 << 14, 128, 128},
+		{&serverLimits{MaxPacketLength: 1 << 16}, nil, 1<<16 - 1024, 32, 32},
+		{&serverLimits{MaxPacketLength: 1 << 30}, nil, 261120, 8, 8},
+		{&serverLimits{MaxOpenHandles: 10}, nil, 1 << 15, 64, 64},
+	} {
+		client := limitsClient(t, tt.limits, tt.opts...)
+		if client.maxPacket != tt.packet || client.maxConcurrentReads != tt.reads || client.maxConcurrentWrites != tt.writes {
+			t.Errorf("%+v: got packet %d, %d reads, %d writes, want %d, %d, %d", tt.limits,
+				client.maxPacket, client.maxConcurrentReads, client.maxConcurrentWrites,
+				tt.packet, tt.reads, tt.writes)
+		}
+		client.Close()
+	}
+
+	for _, opt := range []func(*Client) error{MaxPacket(1 << 14), MaxConcurrentRequests(0)} {
+		if err := opt(&Client{}); err == nil {
+			t.Error("invalid option accepted")
+		}
+	}
+}
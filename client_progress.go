@@ -0,0 +1,103 @@
+package sftp
+
+// Transfer progress reporting
+
+import (
+	"io"
+	"time"
+)
+
+// Progress describes how far a transfer by File.ReadFrom or File.WriteTo
+// has got.
+type Progress struct {
+	Name  string  // of the remote file
+	Done  int64   // bytes transferred so far
+	Total int64   // bytes to transfer, or -1 if not known
+	Rate  float64 // bytes a second since the previous report
+}
+
+// progressInterval is the least time between reports of a transfer, other
+// than the last.
+const progressInterval = 100 * time.Millisecond
+
+// OnProgress sets fn to be called as ReadFrom and WriteTo transfer data, at
+// most every 100ms and once they are done, so that progress can be shown
+// without wrapping the reader or writer. A nil fn stops the reports.
+func (f *File) OnProgress(fn func(Progress)) {
+	f.progress = fn
+}
+
+// A progressReport reports the progress of a transfer to fn.
+type progressReport struct {
+	fn       func(Progress)
+	p        Progress
+	last     time.Time
+	lastDone int64
+}
+
+// startProgress starts reporting a transfer of total bytes, or of an
+// unknown number if total is negative. It returns nil, on which the methods
+// do nothing, if f has no OnProgress function.
+func (f *File) startProgress(total int64) *progressReport {
+	if f.progress == nil {
+		return nil
+	}
+	if total < 0 {
+		total = -1
+	}
+	return &progressReport{
+		fn:   f.progress,
+		p:    Progress{Name: f.path, Total: total},
+		last: time.Now(),
+	}
+}
+
+// add counts n more bytes transferred.
+func (r *progressReport) add(n int64) {
+	if r == nil {
+		return
+	}
+	r.p.Done += n
+	if time.Since(r.last) >= progressInterval {
+		r.report()
+	}
+}
+
+// done reports the end of the transfer.
+func (r *progressReport) done() {
+	if r != nil {
+		r.report()
+	}
+}
+
+func (r *progressReport) report() {
+	now := time.Now()
+	if elapsed := now.Sub(r.last); elapsed > 0 {
+		r.p.Rate = float64(r.p.Done-r.lastDone) / elapsed.Seconds()
+	}
+	r.last, r.lastDone = now, r.p.Done
+	r.fn(r.p)
+}
+
+// remaining returns the number of bytes left to read from r, or -1 if it
+// cannot tell.
+func remaining(r io.Reader) int64 {
+	switch r := r.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len())
+	case io.Seeker:
+		cur, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		end, err := r.Seek(0, io.SeekEnd)
+		if err != nil {
+			return -1
+		}
+		if _, err := r.Seek(cur, io.SeekStart); err != nil {
+			return -1
+		}
+		return end - cur
+	}
+	return -1
+}
@@ -0,0 +1,53 @@
+package sftp
+
+// Transfer rate limiting
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MaxTransferRate limits the data read and written by all the Files of the
+// Client to bytesPerSecond, so that transfers leave room for other traffic
+// on a shared link. Requests are spaced out as they are sent, each reading
+// or writing up to MaxPacket bytes, so the rate is exceeded by at most a
+// packet.
+func MaxTransferRate(bytesPerSecond int64) func(*Client) error {
+	return func(c *Client) error {
+		if bytesPerSecond < 1 {
+			return errors.Errorf("invalid transfer rate %d", bytesPerSecond)
+		}
+		c.rate = &transferRate{perByte: float64(time.Second) / float64(bytesPerSecond)}
+		return nil
+	}
+}
+
+// transferRate spaces out the READ and WRITE requests of a Client.
+type transferRate struct {
+	perByte float64 // nanoseconds to transfer a byte at the rate
+
+	mu  sync.Mutex
+	tat time.Time // when the data requested so far is transferred at the rate
+}
+
+// throttle waits until a request for n bytes may be sent under the transfer
+// rate, if any, and counts it.
+func (c *Client) throttle(n int) {
+	r := c.rate
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	now := time.Now()
+	if r.tat.Before(now) {
+		r.tat = now
+	}
+	d := r.tat.Sub(now)
+	r.tat = r.tat.Add(time.Duration(float64(n) * r.perByte))
+	r.mu.Unlock()
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
@@ -0,0 +1,194 @@
+package sftp
+
+// Re-establishing lost sessions
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Reconnect makes the Client start a new session once the connection is
+// lost, rather than failing all later operations. dial returns the ends of
+// a new session to the same server, such as the pipes of an ssh.Session
+// running the sftp subsystem, on a new SSH connection; the server is
+// assumed to support the same extensions.
+//
+// The first request made once the connection is lost, which KeepAlive
+// detects early, dials; if that fails it fails with the error, and the next
+// request dials again. It gives up waiting for the new session, as for a
+// response, once its context is done or the request timeout passes. A
+// request under way when the connection is lost is sent again on the new
+// session if that is safe whether or not the server carried it out: if it
+// only reads, sets attributes, or opens a file other than with O_EXCL.
+// Others, such as Remove and Rename, fail. Files and directories opened
+// before are not reopened: their handles belong to the lost session, and
+// their operations fail with ErrConnectionLost.
+func Reconnect(dial func() (io.Reader, io.WriteCloser, error)) func(*Client) error {
+	return func(c *Client) error {
+		c.dial = dial
+		return nil
+	}
+}
+
+// session returns the current session.
+func (c *Client) session() *clientConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sess
+}
+
+// request sends p on the current session, and returns the session with the
+// response. With Reconnect, a lost session is replaced first, and p is sent
+// again on a new one if the connection is lost before its response arrives
+// and p is retryable.
+func (c *Client) request(ctx context.Context, p idmarshaler) (*clientConn, byte, []byte, error) {
+	s := c.session()
+	if c.dial != nil && s.lost() {
+		var err error
+		if s, err = c.reconnect(ctx, s); err != nil {
+			return nil, 0, nil, err
+		}
+	}
+	typ, data, err := c.sessionPacket(ctx, s, p)
+	if c.dial == nil || err == nil || err == ctx.Err() || err == ErrRequestTimeout {
+		return s, typ, data, err
+	}
+	// The connection was lost.
+	ns, rerr := c.reconnect(ctx, s)
+	if rerr != nil || ns == s || !retryable(p) {
+		return nil, 0, nil, err
+	}
+	typ, data, err = c.sessionPacket(ctx, ns, p)
+	return ns, typ, data, err
+}
+
+// reconnect replaces s, the lost session, with a new one, unless that has
+// been done already or the Client is closed. It returns the current
+// session. Only one request dials at a time, without holding c.mu; the
+// others wait for it, and dial in turn if it fails.
+func (c *Client) reconnect(ctx context.Context, s *clientConn) (*clientConn, error) {
+	c.mu.Lock()
+	for c.sess == s && !c.closing && c.redialing != nil {
+		redialing := c.redialing
+		c.mu.Unlock()
+		select {
+		case <-redialing:
+		case <-ctx.Done():
+			return s, ctx.Err()
+		}
+		c.mu.Lock()
+	}
+	if c.sess != s || c.closing {
+		defer c.mu.Unlock()
+		return c.sess, nil
+	}
+	redialing := make(chan struct{})
+	c.redialing = redialing
+	c.mu.Unlock()
+
+	ns, err := c.dialSession(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.redialing = nil
+	close(redialing)
+	if err != nil {
+		return s, err
+	}
+	if c.closing {
+		ns.WriteCloser.Close()
+		return c.sess, nil
+	}
+	atomic.StoreInt64(&ns.lastRecv, time.Now().UnixNano())
+	ns.wg.Add(1)
+	go ns.loop()
+	s.closeWith(ErrConnectionLost)
+	c.sess = ns
+	return ns, nil
+}
+
+// dialSession dials a new session and initializes it, giving up if ctx is
+// done or the request timeout passes first; the session is then closed
+// once dialed.
+func (c *Client) dialSession(ctx context.Context) (*clientConn, error) {
+	type dialed struct {
+		s   *clientConn
+		err error
+	}
+	ch := make(chan dialed, 1)
+	go func() {
+		s, err := c.newSession()
+		ch <- dialed{s, err}
+	}()
+	timer := c.newRequestTimer()
+	defer timer.stop()
+	var err error
+	select {
+	case d := <-ch:
+		return d.s, d.err
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C():
+		err = ErrRequestTimeout
+	}
+	go func() {
+		if d := <-ch; d.err == nil {
+			d.s.WriteCloser.Close()
+		}
+	}()
+	return nil, err
+}
+
+// newSession dials a new session and exchanges INIT and VERSION on it.
+func (c *Client) newSession() (*clientConn, error) {
+	rd, wr, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	ns := &clientConn{
+		conn: conn{
+			Reader:      rd,
+			WriteCloser: wr,
+		},
+		inflight: make(map[uint32]chan<- result),
+		closed:   make(chan struct{}),
+	}
+	if err := ns.conn.sendPacket(sshFxInitPacket{Version: sftpProtocolVersion}); err != nil {
+		wr.Close()
+		return nil, err
+	}
+	typ, data, err := ns.recvPacket()
+	if err == nil {
+		_, err = unmarshalVersion(typ, data)
+	}
+	if err != nil {
+		wr.Close()
+		return nil, err
+	}
+	return ns, nil
+}
+
+// isClosed reports whether Close has been called.
+func (c *Client) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closing
+}
+
+// retryable reports whether p can be sent again without knowing whether
+// the server carried it out.
+func retryable(p idmarshaler) bool {
+	switch p := p.(type) {
+	case sshFxpStatPacket, sshFxpLstatPacket, sshFxpRealpathPacket,
+		sshFxpReadlinkPacket, sshFxpOpendirPacket, sshFxpSetstatPacket,
+		sshFxpStatvfsPacket, sshFxpLimitsPacket,
+		sshFxpExtendedPacketCheckFileName, sshFxpGetxattrPacket,
+		sshFxpSetxattrPacket, sshFxpListxattrPacket:
+		return true
+	case sshFxpOpenPacket:
+		return p.Pflags&ssh_FXF_EXCL == 0
+	}
+	return false
+}
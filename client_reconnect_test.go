@@ -0,0 +1,198 @@
+package sftp
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// reconnectServers dials the sessions of a Client with Reconnect: upload
+// servers on dir, unless the next of servers is "drop", a server which drops
+// the connection once it gets a request, "silent", one which never
+// responds, or "mute", one which does not even answer INIT.
+type reconnectServers struct {
+	t   *testing.T
+	dir string
+
+	mu      sync.Mutex
+	servers []string
+	dials   int
+	kill    func() // drops the connection of the last upload server
+}
+
+func (r *reconnectServers) dial() (io.Reader, io.WriteCloser, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dials++
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	end := pipeEnd{sr, sw}
+	kind := "upload"
+	if len(r.servers) > 0 {
+		kind, r.servers = r.servers[0], r.servers[1:]
+	}
+	if kind != "upload" {
+		go func() {
+			defer end.Close()
+			if _, _, err := recvPacket(sr); err != nil {
+				return
+			}
+			if kind == "mute" {
+				io.Copy(ioutil.Discard, sr)
+				return
+			}
+			sendPacket(sw, sshFxVersionPacket{Version: sftpProtocolVersion})
+			if kind == "silent" {
+				io.Copy(ioutil.Discard, sr)
+			}
+			recvPacket(sr)
+		}()
+		return cr, cw, nil
+	}
+	server, err := NewServer(end, UploadPath(testUploadPath), FileNameMapper(func(name string) (string, bool, error) {
+		return r.dir + "/" + name, true, nil
+	}))
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	go func() {
+		server.Serve()
+		end.Close()
+	}()
+	r.kill = func() { end.Close() }
+	return cr, cw, nil
+}
+
+// drop drops the connection of the current session of client, once it has
+// noticed.
+func (r *reconnectServers) drop(client *Client) {
+	r.mu.Lock()
+	r.kill()
+	r.mu.Unlock()
+	<-client.session().closed
+}
+
+func TestClientReconnect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sftp_reconnect_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r := &reconnectServers{t: t, dir: dir, servers: []string{"upload", "drop", "upload", "drop", "upload"}}
+	rd, wr, _ := r.dial()
+	client, err := NewClientPipe(rd, wr, Reconnect(r.dial))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	f, err := client.Create(testUploadPath + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("contents")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The lost session is replaced, and the Stat, lost with the next
+	// connection, sent again.
+	r.drop(client)
+	if _, err := client.Stat(testUploadPath); err != nil {
+		t.Errorf("stat after reconnecting: %v", err)
+	}
+	if r.dials != 3 {
+		t.Errorf("want 3 sessions, got %d", r.dials)
+	}
+	if _, err := f.Write([]byte("more")); err != ErrConnectionLost {
+		t.Errorf("want ErrConnectionLost writing a file of the lost session, got %v", err)
+	}
+	if err := f.Close(); err != ErrConnectionLost {
+		t.Errorf("want ErrConnectionLost closing a file of the lost session, got %v", err)
+	}
+
+	// A Remove is not sent again, but the session is replaced.
+	r.drop(client)
+	if err := client.Remove(testUploadPath + "/file"); err == nil {
+		t.Error("remove on a lost connection succeeded")
+	}
+	if _, err := client.Stat(testUploadPath); err != nil {
+		t.Errorf("stat after failed remove: %v", err)
+	}
+	if r.dials != 5 {
+		t.Errorf("want 5 sessions, got %d", r.dials)
+	}
+}
+
+func TestClientReconnectKeepAlive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sftp_reconnect_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r := &reconnectServers{t: t, dir: dir, servers: []string{"silent", "upload"}}
+	rd, wr, _ := r.dial()
+	client, err := NewClientPipe(rd, wr, Reconnect(r.dial), KeepAlive(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// The Stat is sent again once KeepAlive finds the connection lost.
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Stat(testUploadPath)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("stat after reconnecting: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("lost connection not replaced")
+	}
+}
+
+func TestClientReconnectHandshakeTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sftp_reconnect_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r := &reconnectServers{t: t, dir: dir, servers: []string{"upload", "mute", "upload"}}
+	rd, wr, _ := r.dial()
+	client, err := NewClientPipe(rd, wr, Reconnect(r.dial))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	r.drop(client)
+
+	// The new session never answers INIT: the request gives up with its
+	// context, and the next one dials again.
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := client.StatContext(ctx, testUploadPath)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != context.DeadlineExceeded {
+			t.Errorf("stat during the handshake: got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handshake not abandoned")
+	}
+	if _, err := client.Stat(testUploadPath); err != nil {
+		t.Errorf("stat after reconnecting: %v", err)
+	}
+	if r.dials != 3 {
+		t.Errorf("want 3 sessions, got %d", r.dials)
+	}
+}
@@ -0,0 +1,84 @@
+package sftp
+
+// Resumption of interrupted transfers
+
+import (
+	"io"
+	"os"
+)
+
+// ResumeUpload copies the local file local to remote, continuing from the
+// end of remote if a previous copy was interrupted. A remote file at least
+// as long as local is assumed to be a copy of it, and shorter ones to be
+// partial copies, whose contents are not checked. A longer remote file is
+// overwritten. Servers which truncate files opened for writing, as this
+// package's Server does, receive the whole file. ResumeUpload returns the
+// number of bytes sent.
+func (c *Client) ResumeUpload(local, remote string) (int64, error) {
+	src, err := os.Open(local)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return 0, err
+	}
+	dst, err := c.OpenFile(remote, os.O_WRONLY|os.O_CREATE)
+	if err != nil {
+		return 0, err
+	}
+	done, err := dst.Seek(0, io.SeekEnd)
+	if err == nil && done > info.Size() {
+		done, err = 0, dst.Truncate(0)
+		dst.Seek(0, io.SeekStart)
+	}
+	if err == nil {
+		_, err = src.Seek(done, io.SeekStart)
+	}
+	var n int64
+	if err == nil {
+		n, err = dst.ReadFrom(src)
+	}
+	if err != nil {
+		dst.Close()
+		return n, err
+	}
+	return n, dst.Close()
+}
+
+// ResumeDownload copies remote to the local file local, continuing from the
+// end of local if a previous copy was interrupted, on the same assumptions
+// as ResumeUpload. It returns the number of bytes received.
+func (c *Client) ResumeDownload(remote, local string) (int64, error) {
+	src, err := c.Open(remote)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return 0, err
+	}
+	dst, err := os.OpenFile(local, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	done, err := dst.Seek(0, io.SeekEnd)
+	if err == nil && done > info.Size() {
+		done, err = 0, dst.Truncate(0)
+		dst.Seek(0, io.SeekStart)
+	}
+	if err == nil {
+		_, err = src.Seek(done, io.SeekStart)
+	}
+	var n int64
+	if err == nil {
+		n, err = src.WriteTo(dst)
+	}
+	if err != nil {
+		dst.Close()
+		return n, err
+	}
+	return n, dst.Close()
+}
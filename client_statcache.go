@@ -0,0 +1,243 @@
+package sftp
+
+// Caching the attributes of remote files
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A StatCache wraps a Client, remembering the results of Stat, Lstat and
+// ReadDir, failures included, for a time, so that tools which look at the
+// same paths again and again, such as those syncing trees, do not ask the
+// server each time. The entries a ReadDir lists are remembered as by Lstat.
+//
+// Changes made through the StatCache forget what they may have changed:
+// the path, what lies below it, and the listing of its directory. Changes
+// made otherwise, including through the wrapped Client, are only seen once
+// the results expire, or after Invalidate. A StatCache is safe for
+// concurrent use.
+type StatCache struct {
+	c   *Client
+	ttl time.Duration
+
+	mu      sync.Mutex
+	stat    map[string]statEntry
+	lstat   map[string]statEntry
+	readdir map[string]readdirEntry
+}
+
+type statEntry struct {
+	info    os.FileInfo
+	err     error
+	expires time.Time
+}
+
+type readdirEntry struct {
+	infos   []os.FileInfo
+	expires time.Time
+}
+
+// NewStatCache returns a StatCache of c, which remembers results for ttl.
+func NewStatCache(c *Client, ttl time.Duration) *StatCache {
+	return &StatCache{
+		c:       c,
+		ttl:     ttl,
+		stat:    make(map[string]statEntry),
+		lstat:   make(map[string]statEntry),
+		readdir: make(map[string]readdirEntry),
+	}
+}
+
+// Client returns the wrapped Client.
+func (sc *StatCache) Client() *Client {
+	return sc.c
+}
+
+// Stat is Client.Stat, remembered.
+func (sc *StatCache) Stat(p string) (os.FileInfo, error) {
+	return sc.StatContext(context.Background(), p)
+}
+
+// StatContext is Stat with a context, as described at Client. Failures
+// due to ctx are not remembered.
+func (sc *StatCache) StatContext(ctx context.Context, p string) (os.FileInfo, error) {
+	return sc.cached(ctx, sc.stat, p, sc.c.StatContext)
+}
+
+// Lstat is Client.Lstat, remembered.
+func (sc *StatCache) Lstat(p string) (os.FileInfo, error) {
+	return sc.LstatContext(context.Background(), p)
+}
+
+// LstatContext is Lstat with a context, as described at Client. Failures
+// due to ctx are not remembered.
+func (sc *StatCache) LstatContext(ctx context.Context, p string) (os.FileInfo, error) {
+	return sc.cached(ctx, sc.lstat, p, sc.c.LstatContext)
+}
+
+// cached returns the entry for p in m, or else the result of stat, which
+// it remembers.
+func (sc *StatCache) cached(ctx context.Context, m map[string]statEntry, p string, stat func(context.Context, string) (os.FileInfo, error)) (os.FileInfo, error) {
+	p = path.Clean(p)
+	now := time.Now()
+	sc.mu.Lock()
+	e, ok := m[p]
+	sc.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.info, e.err
+	}
+	info, err := stat(ctx, p)
+	if ctx.Err() == nil && (err == nil || err == os.ErrNotExist || isStatus(err)) {
+		sc.mu.Lock()
+		m[p] = statEntry{info, err, now.Add(sc.ttl)}
+		sc.mu.Unlock()
+	}
+	return info, err
+}
+
+// isStatus reports whether err is a failure reported by the server, rather
+// than one of the connection.
+func isStatus(err error) bool {
+	_, ok := err.(*StatusError)
+	return ok
+}
+
+// ReadDir is Client.ReadDir, remembered. Failures are not remembered.
+func (sc *StatCache) ReadDir(p string) ([]os.FileInfo, error) {
+	return sc.ReadDirContext(context.Background(), p)
+}
+
+// ReadDirContext is ReadDir with a context, as described at Client.
+func (sc *StatCache) ReadDirContext(ctx context.Context, p string) ([]os.FileInfo, error) {
+	p = path.Clean(p)
+	now := time.Now()
+	sc.mu.Lock()
+	e, ok := sc.readdir[p]
+	sc.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return append([]os.FileInfo(nil), e.infos...), nil
+	}
+	infos, err := sc.c.ReadDirContext(ctx, p)
+	if err != nil {
+		return infos, err
+	}
+	expires := now.Add(sc.ttl)
+	sc.mu.Lock()
+	sc.readdir[p] = readdirEntry{append([]os.FileInfo(nil), infos...), expires}
+	for _, info := range infos {
+		sc.lstat[path.Join(p, info.Name())] = statEntry{info: info, expires: expires}
+	}
+	sc.mu.Unlock()
+	return infos, nil
+}
+
+// Invalidate forgets what is remembered of p, of what lies below it, and
+// of the listing of its directory, for changes made other than through
+// the StatCache.
+func (sc *StatCache) Invalidate(p string) {
+	p = path.Clean(p)
+	below := strings.TrimSuffix(p, "/") + "/"
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for _, m := range []map[string]statEntry{sc.stat, sc.lstat} {
+		for name := range m {
+			if name == p || strings.HasPrefix(name, below) {
+				delete(m, name)
+			}
+		}
+	}
+	for name := range sc.readdir {
+		if name == p || strings.HasPrefix(name, below) {
+			delete(sc.readdir, name)
+		}
+	}
+	delete(sc.readdir, path.Dir(p))
+}
+
+// invalidating returns err, having invalidated paths.
+func (sc *StatCache) invalidating(err error, paths ...string) error {
+	for _, p := range paths {
+		sc.Invalidate(p)
+	}
+	return err
+}
+
+// Create is Client.Create, invalidating path, as the File does again when
+// closed.
+func (sc *StatCache) Create(path string) (*File, error) {
+	return sc.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+}
+
+// OpenFile is Client.OpenFile, invalidating path unless the file is opened
+// read only, as the File does again when closed.
+func (sc *StatCache) OpenFile(path string, f int) (*File, error) {
+	file, err := sc.c.OpenFile(path, f)
+	if f&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) == 0 {
+		return file, err
+	}
+	sc.Invalidate(path)
+	if file != nil {
+		file.onClose = func() { sc.Invalidate(path) }
+	}
+	return file, err
+}
+
+// PutAtomic is Client.PutAtomic, invalidating remotePath.
+func (sc *StatCache) PutAtomic(localPath, remotePath string) error {
+	return sc.invalidating(sc.c.PutAtomic(localPath, remotePath), remotePath)
+}
+
+// Rename is Client.Rename, invalidating both paths.
+func (sc *StatCache) Rename(oldname, newname string) error {
+	return sc.invalidating(sc.c.Rename(oldname, newname), oldname, newname)
+}
+
+// PosixRename is Client.PosixRename, invalidating both paths.
+func (sc *StatCache) PosixRename(oldname, newname string) error {
+	return sc.invalidating(sc.c.PosixRename(oldname, newname), oldname, newname)
+}
+
+// Remove is Client.Remove, invalidating path.
+func (sc *StatCache) Remove(path string) error {
+	return sc.invalidating(sc.c.Remove(path), path)
+}
+
+// RemoveDirectory is Client.RemoveDirectory, invalidating path.
+func (sc *StatCache) RemoveDirectory(path string) error {
+	return sc.invalidating(sc.c.RemoveDirectory(path), path)
+}
+
+// Mkdir is Client.Mkdir, invalidating path.
+func (sc *StatCache) Mkdir(path string) error {
+	return sc.invalidating(sc.c.Mkdir(path), path)
+}
+
+// Symlink is Client.Symlink, invalidating newname.
+func (sc *StatCache) Symlink(oldname, newname string) error {
+	return sc.invalidating(sc.c.Symlink(oldname, newname), newname)
+}
+
+// Chmod is Client.Chmod, invalidating path.
+func (sc *StatCache) Chmod(path string, mode os.FileMode) error {
+	return sc.invalidating(sc.c.Chmod(path, mode), path)
+}
+
+// Chown is Client.Chown, invalidating path.
+func (sc *StatCache) Chown(path string, uid, gid int) error {
+	return sc.invalidating(sc.c.Chown(path, uid, gid), path)
+}
+
+// Chtimes is Client.Chtimes, invalidating path.
+func (sc *StatCache) Chtimes(path string, atime, mtime time.Time) error {
+	return sc.invalidating(sc.c.Chtimes(path, atime, mtime), path)
+}
+
+// Truncate is Client.Truncate, invalidating path.
+func (sc *StatCache) Truncate(path string, size int64) error {
+	return sc.invalidating(sc.c.Truncate(path, size), path)
+}
@@ -0,0 +1,135 @@
+package sftp
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// statClient returns a Client of a server which holds files of the sizes in
+// files, by path, and counts the STAT requests it gets in stats. It
+// supports STAT, LSTAT, RENAME, and OPEN, WRITE and CLOSE of one file at
+// a time.
+func statClient(t *testing.T, files map[string]int64, stats *int) *Client {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	go func() {
+		defer sw.Close()
+		var open string
+		for {
+			typ, data, err := recvPacket(sr)
+			if err != nil {
+				return
+			}
+			if typ == ssh_FXP_INIT {
+				sendPacket(sw, sshFxVersionPacket{Version: sftpProtocolVersion})
+				continue
+			}
+			id, data := unmarshalUint32(data)
+			name, data := unmarshalString(data)
+			status := StatusError{Code: ssh_FX_OK}
+			switch typ {
+			case ssh_FXP_STAT, ssh_FXP_LSTAT:
+				if typ == ssh_FXP_STAT {
+					*stats++
+				}
+				if size, ok := files[name]; ok {
+					sendPacket(sw, sshFxpStatResponse{id, &fileInfo{name: name, size: size}})
+					continue
+				}
+				status.Code = ssh_FX_NO_SUCH_FILE
+			case ssh_FXP_RENAME:
+				newname, _ := unmarshalString(data)
+				files[newname] = files[name]
+				delete(files, name)
+			case ssh_FXP_OPEN:
+				open = name
+				files[open] = 0
+				sendPacket(sw, sshFxpHandlePacket{id, "h"})
+				continue
+			case ssh_FXP_WRITE:
+				_, data = unmarshalUint64(data)
+				length, _ := unmarshalUint32(data)
+				files[open] += int64(length)
+			case ssh_FXP_CLOSE:
+			default:
+				status.Code = ssh_FX_OP_UNSUPPORTED
+			}
+			sendPacket(sw, sshFxpStatusPacket{id, status})
+		}
+	}()
+	client, err := NewClientPipe(cr, cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestStatCache(t *testing.T) {
+	files := map[string]int64{"/a": 3}
+	var stats int
+	client := statClient(t, files, &stats)
+	defer client.Close()
+	sc := NewStatCache(client, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if info, err := sc.Stat("/a"); err != nil || info.Size() != 3 {
+			t.Fatalf("want size 3, got %v, %v", info, err)
+		}
+		if _, err := sc.Stat("/b"); err != os.ErrNotExist {
+			t.Fatalf("want os.ErrNotExist, got %v", err)
+		}
+	}
+	if stats != 2 {
+		t.Errorf("want 2 STAT requests, got %d", stats)
+	}
+
+	// Changes made otherwise are not seen until Invalidate.
+	files["/a"] = 6
+	if info, err := sc.Stat("/a"); err != nil || info.Size() != 3 {
+		t.Errorf("want remembered size 3, got %v, %v", info, err)
+	}
+	sc.Invalidate("/")
+	if info, err := sc.Stat("/a"); err != nil || info.Size() != 6 {
+		t.Errorf("want size 6 after Invalidate, got %v, %v", info, err)
+	}
+
+	// Changes made through the StatCache are.
+	if err := sc.Rename("/a", "/b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sc.Stat("/a"); err != os.ErrNotExist {
+		t.Errorf("want os.ErrNotExist after rename, got %v", err)
+	}
+	if info, err := sc.Stat("/b"); err != nil || info.Size() != 6 {
+		t.Errorf("want size 6 after rename, got %v, %v", info, err)
+	}
+	f, err := sc.Create("/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sc.Stat("/b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := sc.Stat("/b"); err != nil || info.Size() != 1 {
+		t.Errorf("want size 1 after upload, got %v, %v", info, err)
+	}
+
+	// Results expire.
+	sc = NewStatCache(client, 10*time.Millisecond)
+	if _, err := sc.Stat("/b"); err != nil {
+		t.Fatal(err)
+	}
+	delete(files, "/b")
+	time.Sleep(20 * time.Millisecond)
+	if _, err := sc.Stat("/b"); err != os.ErrNotExist {
+		t.Errorf("want os.ErrNotExist once expired, got %v", err)
+	}
+}
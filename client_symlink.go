@@ -0,0 +1,64 @@
+package sftp
+
+// Order of the SSH_FXP_SYMLINK arguments
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// A SymlinkOrder is the order in which Client.Symlink sends its arguments.
+// The draft protocol sends the link's path, then its target, but OpenSSH
+// has always done the reverse, and many servers follow it.
+type SymlinkOrder int
+
+const (
+	// SymlinkOrderAuto, the default, uses SymlinkOrderOpenSSH, unless the
+	// Client is made by NewClient and the server's SSH version string
+	// names an implementation not known to follow OpenSSH.
+	SymlinkOrderAuto SymlinkOrder = iota
+	// SymlinkOrderOpenSSH sends the target, then the link's path.
+	SymlinkOrderOpenSSH
+	// SymlinkOrderDraft sends the link's path, then the target.
+	SymlinkOrderDraft
+)
+
+// openSSHSymlinkServers are the SSH version strings, or prefixes of them,
+// of the servers known to take the OpenSSH order. golang.org/x/crypto/ssh
+// servers, such as those serving this package's Server, identify as Go.
+var openSSHSymlinkServers = []string{"SSH-2.0-OpenSSH", "SSH-2.0-Go"}
+
+// SymlinkArgs sets the order in which Symlink sends its arguments, for
+// servers which SymlinkOrderAuto gets wrong.
+func SymlinkArgs(order SymlinkOrder) func(*Client) error {
+	return func(c *Client) error {
+		if order < SymlinkOrderAuto || order > SymlinkOrderDraft {
+			return errors.Errorf("invalid symlink order %d", order)
+		}
+		if order != SymlinkOrderAuto {
+			c.symlinkOrder = order
+		}
+		return nil
+	}
+}
+
+// detectSymlinkOrder sets the order for the server with the SSH version
+// string version, for SymlinkOrderAuto.
+func detectSymlinkOrder(version string) func(*Client) error {
+	return func(c *Client) error {
+		c.symlinkOrder = symlinkOrderFor(version)
+		return nil
+	}
+}
+
+// symlinkOrderFor returns the order taken by the server with the SSH
+// version string version.
+func symlinkOrderFor(version string) SymlinkOrder {
+	for _, prefix := range openSSHSymlinkServers {
+		if strings.HasPrefix(version, prefix) {
+			return SymlinkOrderOpenSSH
+		}
+	}
+	return SymlinkOrderDraft
+}
@@ -0,0 +1,47 @@
+package sftp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSymlinkOrderFor(t *testing.T) {
+	for _, tt := range []struct {
+		version string
+		want    SymlinkOrder
+	}{
+		{"SSH-2.0-OpenSSH_8.9p1 Ubuntu-3", SymlinkOrderOpenSSH},
+		{"SSH-2.0-Go", SymlinkOrderOpenSSH},
+		{"SSH-2.0-Conformant_1.0", SymlinkOrderDraft},
+	} {
+		if got := symlinkOrderFor(tt.version); got != tt.want {
+			t.Errorf("%s: want %d, got %d", tt.version, tt.want, got)
+		}
+	}
+}
+
+func TestClientSymlinkArgs(t *testing.T) {
+	client, _, dir, cleanup := uploadServerPair(t, WithSymlinkPolicy(SymlinkAllow))
+	defer cleanup()
+
+	// The Server takes the OpenSSH order, so the draft order swaps the
+	// link and its target.
+	if err := SymlinkArgs(SymlinkOrderDraft)(client); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Symlink(testUploadPath+"/target", testUploadPath+"/link"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.Readlink(filepath.Join(dir, "target")); err != nil || got != testUploadPath+"/link" {
+		t.Errorf("target links to %q %v", got, err)
+	}
+
+	// SymlinkOrderAuto keeps the order detected.
+	if err := SymlinkArgs(SymlinkOrderAuto)(client); err != nil || client.symlinkOrder != SymlinkOrderDraft {
+		t.Errorf("want draft order kept, got %d %v", client.symlinkOrder, err)
+	}
+	if err := SymlinkArgs(SymlinkOrderDraft + 1)(client); err == nil {
+		t.Error("invalid order accepted")
+	}
+}
@@ -0,0 +1,209 @@
+package sftp
+
+// Mirroring directory trees
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// checksumHashes are the check-file algorithms SyncDir can compute locally.
+var checksumHashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha224": sha256.New224,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// SyncDirOptions configures Client.SyncDir.
+type SyncDirOptions struct {
+	// Concurrency is the number of files uploaded at once, 1 if zero.
+	Concurrency int
+
+	// Checksums, if set, are the comma separated algorithms, such as
+	// "sha256,md5", with which files of the same size are compared
+	// using Client.CheckFile, whatever their modification times. If the
+	// server does not support check-file, they are compared by
+	// modification time. The algorithms supported are md5, sha1, sha224,
+	// sha256, sha384 and sha512.
+	Checksums string
+
+	// Delete removes the remote files and directories which are not in
+	// localDir, and those of a different type, such as a directory in
+	// place of a file, before uploading anything.
+	Delete bool
+
+	// OnFile, if set, is called once each changed file has been uploaded,
+	// or has failed to, with the error, and once each remote file has been
+	// removed, with local empty. The sync stops if it returns an error,
+	// which SyncDir returns. If nil, the sync stops at the first error.
+	// Calls are not concurrent.
+	OnFile func(local, remote string, err error) error
+
+	// OnProgress, if set, is called with the progress of each file, as by
+	// File.OnProgress. Calls are not concurrent.
+	OnProgress func(Progress)
+}
+
+// SyncDir makes the tree rooted at remoteDir a copy of that rooted at
+// localDir, as rsync does, uploading only the regular files which are
+// missing or have changed: whose size or modification time differs, or
+// with Checksums, whose contents do. The uploaded files are given the
+// modification times of the local ones, so that a later SyncDir finds them
+// unchanged. Other local files, such as symbolic links, are skipped.
+func (c *Client) SyncDir(localDir, remoteDir string, opts SyncDirOptions) error {
+	for _, alg := range strings.Split(opts.Checksums, ",") {
+		if opts.Checksums != "" && checksumHashes[alg] == nil {
+			return errors.Errorf("sftp: unsupported checksum algorithm %q", alg)
+		}
+	}
+	onFile := opts.OnFile
+	if onFile == nil {
+		onFile = func(local, remote string, err error) error { return err }
+	}
+
+	// The remote tree, by path relative to remoteDir, parents first.
+	remoteDir = path.Clean(remoteDir)
+	remote := make(map[string]os.FileInfo)
+	var remoteNames []string
+	err := c.WalkDir(remoteDir, func(p string, info os.FileInfo, err error) error {
+		if p == remoteDir && info == nil && os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, remoteDir), "/")
+		remote[rel] = info
+		remoteNames = append(remoteNames, rel)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// The local tree.
+	local := make(map[string]os.FileInfo)
+	var localNames []string
+	err = filepath.Walk(localDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			rel = ""
+		}
+		if info.IsDir() || info.Mode().IsRegular() {
+			local[rel] = info
+			localNames = append(localNames, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if opts.Delete {
+		// Children first.
+		for i := len(remoteNames) - 1; i >= 0; i-- {
+			rel := remoteNames[i]
+			if l, ok := local[rel]; ok && sameType(l, remote[rel]) {
+				continue
+			}
+			p := path.Join(remoteDir, rel)
+			if remote[rel].IsDir() {
+				err = c.RemoveDirectory(p)
+			} else {
+				err = c.Remove(p)
+			}
+			if err := onFile("", p, err); err != nil {
+				return err
+			}
+			delete(remote, rel)
+		}
+	}
+
+	checksums := opts.Checksums
+	changed := func(lp, rp string, l, r os.FileInfo) bool {
+		if l.Size() != r.Size() {
+			return true
+		}
+		if checksums != "" {
+			alg, sum, err := c.CheckFile(rp, checksums, 0, 0)
+			if h := checksumHashes[alg]; err == nil && h != nil {
+				return !bytes.Equal(sum, localChecksum(lp, h()))
+			}
+			if serr, ok := err.(*StatusError); ok && serr.Code == ssh_FX_OP_UNSUPPORTED {
+				checksums = "" // so not asking again
+			}
+		}
+		return l.ModTime().Unix() != r.ModTime().Unix()
+	}
+	t := newDirTransfer(opts.Concurrency, opts.OnFile)
+	progress := t.progress(opts.OnProgress)
+	for _, rel := range localNames {
+		l, r := local[rel], remote[rel]
+		lp, rp := filepath.Join(localDir, filepath.FromSlash(rel)), path.Join(remoteDir, rel)
+		if l.IsDir() {
+			if r == nil || !r.IsDir() {
+				if err = c.mkdirExisting(rp); err != nil {
+					break
+				}
+			}
+			continue
+		}
+		if err = t.error(); err != nil {
+			break
+		}
+		if r != nil && r.Mode().IsRegular() && !changed(lp, rp, l, r) {
+			continue
+		}
+		err = t.do(lp, rp, func() error {
+			if err := c.uploadFile(lp, rp, progress); err != nil {
+				return err
+			}
+			return c.Chtimes(rp, l.ModTime(), l.ModTime())
+		})
+		if err != nil {
+			break
+		}
+	}
+	return t.wait(err)
+}
+
+// sameType reports whether a and b are both directories, both regular
+// files, or both neither.
+func sameType(a, b os.FileInfo) bool {
+	return a.IsDir() == b.IsDir() && a.Mode().IsRegular() == b.Mode().IsRegular()
+}
+
+// localChecksum returns the hash of the file name computed with h, or nil
+// if it cannot be read.
+func localChecksum(name string, h hash.Hash) []byte {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil
+	}
+	return h.Sum(nil)
+}
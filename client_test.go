@@ -1,11 +1,18 @@
 package sftp
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/kr/fs"
 )
@@ -145,3 +152,248 @@ func TestUnmarshalStatus(t *testing.T) {
 		}
 	}
 }
+
+// Many writes in flight over a pipe must not deadlock the client: it must
+// take responses from the server while it is sending requests.
+func TestClientPipelinedWrites(t *testing.T) {
+	client, _, dir, cleanup := uploadServerPair(t)
+	defer cleanup()
+
+	data := bytes.Repeat([]byte("pipelined"), 1<<20)
+	for _, tt := range []struct {
+		name  string
+		write func(*File) error
+	}{
+		{"write", func(f *File) error { _, err := f.Write(data); return err }},
+		{"readfrom", func(f *File) error { _, err := f.ReadFrom(bytes.NewReader(data)); return err }},
+	} {
+		done := make(chan error, 1)
+		go func() {
+			f, err := client.Create(testUploadPath + "/" + tt.name)
+			if err == nil {
+				if err = tt.write(f); err == nil {
+					err = f.Close()
+				}
+			}
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s: deadlocked", tt.name)
+		}
+		if b, err := ioutil.ReadFile(dir + "/" + tt.name); err != nil || !bytes.Equal(b, data) {
+			t.Errorf("%s: got %d bytes, %v", tt.name, len(b), err)
+		}
+	}
+}
+
+// Requests in flight when the connection drops must each fail once, however
+// the failure of the session and of sending them interleave.
+func TestClientConnectionDrop(t *testing.T) {
+	data := make([]byte, 256*1024)
+	for i := 0; i < 50; i++ {
+		client, _, _, cleanup := uploadServerPair(t)
+		var wg sync.WaitGroup
+		for j := 0; j < 8; j++ {
+			wg.Add(1)
+			go func(j int) {
+				defer wg.Done()
+				f, err := client.Create(fmt.Sprintf("%s/file%d", testUploadPath, j))
+				for err == nil {
+					_, err = f.Write(data)
+				}
+			}(j)
+		}
+		time.Sleep(time.Millisecond)
+		cleanup()
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("iteration %d: requests left waiting", i)
+		}
+	}
+}
+
+func TestClientStatVFSUnsupported(t *testing.T) {
+	client, _, _, cleanup := uploadServerPair(t)
+	defer cleanup()
+
+	_, err := client.StatVFS(testUploadPath)
+	if err, ok := err.(*StatusError); !ok || err.Code != ssh_FX_OP_UNSUPPORTED {
+		t.Errorf("want SSH_FX_OP_UNSUPPORTED, got %v", err)
+	}
+}
+
+func TestFileSyncUnsupported(t *testing.T) {
+	client, _, _, cleanup := uploadServerPair(t)
+	defer cleanup()
+
+	f, err := client.Create(testUploadPath + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err, ok := f.Sync().(*StatusError); !ok || err.Code != ssh_FX_OP_UNSUPPORTED {
+		t.Errorf("want SSH_FX_OP_UNSUPPORTED, got %v", err)
+	}
+}
+
+func TestFileWriteAt(t *testing.T) {
+	client, _, dir, cleanup := uploadServerPair(t)
+	defer cleanup()
+
+	f, err := client.Create(testUploadPath + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Chunks of several requests each, written at once out of order.
+	const chunk = 100 << 10
+	want := make([]byte, 8*chunk)
+	for i := range want {
+		want[i] = byte(i / chunk)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 7; i >= 0; i-- {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			n, err := f.WriteAt(want[i*chunk:(i+1)*chunk], int64(i*chunk))
+			if err == nil && n != chunk {
+				err = fmt.Errorf("chunk %d: wrote %d bytes", i, n)
+			}
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := f.WriteAt([]byte("x"), -1); err == nil {
+		t.Error("negative offset accepted")
+	}
+	if off, _ := f.Seek(0, io.SeekCurrent); off != 0 {
+		t.Errorf("WriteAt moved the offset to %d", off)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(dir, "file")); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("wrong contents: %d bytes, %v", len(got), err)
+	}
+}
+
+func TestClientExtensions(t *testing.T) {
+	client := limitsClient(t, &serverLimits{})
+	defer client.Close()
+
+	if !client.HasExtension("limits@openssh.com") || client.HasExtension("posix-rename@openssh.com") {
+		t.Error("wrong extensions reported")
+	}
+	ext := client.Extensions()
+	if !reflect.DeepEqual(ext, map[string]string{"limits@openssh.com": "1"}) {
+		t.Errorf("got extensions %v", ext)
+	}
+	delete(ext, "limits@openssh.com")
+	if !client.HasExtension("limits@openssh.com") {
+		t.Error("Extensions returned the client's map")
+	}
+}
+
+func TestStatusErrorIs(t *testing.T) {
+	for _, tt := range []struct {
+		code   uint32
+		target error
+	}{
+		{ssh_FX_NO_SUCH_FILE, ErrNotExist},
+		{ssh_FX_PERMISSION_DENIED, ErrPermission},
+		{ssh_FX_OP_UNSUPPORTED, ErrOpUnsupported},
+	} {
+		err := error(&StatusError{Code: tt.code, msg: "message"})
+		for _, target := range []error{ErrNotExist, ErrPermission, ErrOpUnsupported} {
+			if got := errors.Is(err, target); got != (target == tt.target) {
+				t.Errorf("%v: errors.Is(%v) = %v", err, target, got)
+			}
+		}
+		if !errors.Is(fmt.Errorf("wrapped: %w", err), tt.target) {
+			t.Errorf("%v: wrapped error is not %v", err, tt.target)
+		}
+	}
+	if err := (&StatusError{Code: ssh_FX_FAILURE, msg: "disk full"}); err.Message() != "disk full" {
+		t.Errorf("got message %q", err.Message())
+	}
+
+	client, _, _, cleanup := uploadServerPair(t)
+	defer cleanup()
+	if err := client.Link(testUploadPath+"/old", testUploadPath+"/new"); !errors.Is(err, ErrOpUnsupported) {
+		t.Errorf("want ErrOpUnsupported, got %v", err)
+	}
+	if _, err := client.Stat(testUploadPath + "/missing"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("want ErrNotExist, got %v", err)
+	}
+}
+
+func TestClientOpenAppendUnsupported(t *testing.T) {
+	client, _, dir, cleanup := uploadServerPair(t)
+	defer cleanup()
+
+	_, err := client.OpenFile(testUploadPath+"/file", os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+	if !errors.Is(err, ErrOpUnsupported) || !strings.Contains(err.Error(), "O_APPEND") {
+		t.Errorf("want O_APPEND unsupported, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "file")); !os.IsNotExist(err) {
+		t.Errorf("file created: %v", err)
+	}
+}
+
+func TestClientLinkUnsupported(t *testing.T) {
+	client, _, _, cleanup := uploadServerPair(t)
+	defer cleanup()
+
+	err := client.Link(testUploadPath+"/old", testUploadPath+"/new")
+	if err, ok := err.(*StatusError); !ok || err.Code != ssh_FX_OP_UNSUPPORTED {
+		t.Errorf("want SSH_FX_OP_UNSUPPORTED, got %v", err)
+	}
+}
+
+func TestClientCopyDataUnsupported(t *testing.T) {
+	client, _, dir, cleanup := uploadServerPair(t)
+	defer cleanup()
+
+	err := client.CopyData(testUploadPath+"/src", testUploadPath+"/dst")
+	if err, ok := err.(*StatusError); !ok || err.Code != ssh_FX_OP_UNSUPPORTED {
+		t.Errorf("want SSH_FX_OP_UNSUPPORTED, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "dst")); !os.IsNotExist(err) {
+		t.Errorf("dst created: %v", err)
+	}
+}
+
+func TestClientPutAtomicUnsupported(t *testing.T) {
+	client, _, dir, cleanup := uploadServerPair(t)
+	defer cleanup()
+
+	local := filepath.Join(dir, "local")
+	if err := ioutil.WriteFile(local, []byte("contents"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err := client.PutAtomic(local, testUploadPath+"/file")
+	if err, ok := err.(*StatusError); !ok || err.Code != ssh_FX_OP_UNSUPPORTED {
+		t.Errorf("want SSH_FX_OP_UNSUPPORTED, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "file")); !os.IsNotExist(err) {
+		t.Errorf("file uploaded: %v", err)
+	}
+}
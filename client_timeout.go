@@ -0,0 +1,73 @@
+package sftp
+
+// Request timeouts
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrRequestTimeout is returned by the operations of a Client with a
+// RequestTimeout when the server has not responded in time.
+var ErrRequestTimeout = errors.New("sftp: request timed out")
+
+// RequestTimeout limits how long the Client waits for the response to each
+// request to d, after which the request is abandoned, as described at
+// Client, and the operation fails with ErrRequestTimeout. This keeps a
+// response lost by the server from blocking the operation forever, whatever
+// its context. Files reading or writing with several requests outstanding
+// give up once none of them has been answered for d.
+func RequestTimeout(d time.Duration) func(*Client) error {
+	return func(c *Client) error {
+		if d <= 0 {
+			return errors.Errorf("invalid request timeout %v", d)
+		}
+		c.requestTimeout = d
+		return nil
+	}
+}
+
+// A requestTimer fires once the request timeout has passed since it was
+// last reset. A nil *requestTimer never fires.
+type requestTimer struct {
+	t *time.Timer
+	d time.Duration
+}
+
+// newRequestTimer returns a running requestTimer, or nil if c has no request
+// timeout.
+func (c *Client) newRequestTimer() *requestTimer {
+	if c.requestTimeout == 0 {
+		return nil
+	}
+	return &requestTimer{time.NewTimer(c.requestTimeout), c.requestTimeout}
+}
+
+// C returns the channel on which the time is sent when the timer fires.
+func (t *requestTimer) C() <-chan time.Time {
+	if t == nil {
+		return nil
+	}
+	return t.t.C
+}
+
+// reset restarts the timer, before waiting for a response.
+func (t *requestTimer) reset() {
+	if t == nil {
+		return
+	}
+	if !t.t.Stop() {
+		select {
+		case <-t.t.C:
+		default:
+		}
+	}
+	t.t.Reset(t.d)
+}
+
+func (t *requestTimer) stop() {
+	if t != nil {
+		t.t.Stop()
+	}
+}
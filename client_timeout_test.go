@@ -0,0 +1,45 @@
+package sftp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClientRequestTimeout(t *testing.T) {
+	pending := make(chan *PendingUpload, 1)
+	client, _, dir, cleanup := uploadServerPair(t,
+		WithApproval(func(u *PendingUpload) { pending <- u }, time.Minute),
+	)
+	defer cleanup()
+	if err := client.applyOptions(RequestTimeout(20 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := client.Create(testUploadPath + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("contents")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != ErrRequestTimeout {
+		t.Errorf("want ErrRequestTimeout, got %v", err)
+	}
+
+	(<-pending).Approve()
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "file")); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := client.Stat(testUploadPath); err != nil {
+		t.Error(err)
+	}
+
+	if err := client.applyOptions(RequestTimeout(0)); err == nil {
+		t.Error("zero request timeout accepted")
+	}
+}
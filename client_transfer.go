@@ -0,0 +1,312 @@
+package sftp
+
+// Transfers of directory trees
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// UploadDirOptions configures Client.UploadDir.
+type UploadDirOptions struct {
+	// Concurrency is the number of files uploaded at once, 1 if zero.
+	Concurrency int
+
+	// Mkdir is called to create each remote directory, parents first,
+	// remoteDir included. If nil, the directory is created with
+	// Client.Mkdir unless it exists already.
+	Mkdir func(remote string) error
+
+	// OnFile, if set, is called once each file has been uploaded, or has
+	// failed to, with the error. The upload stops if it returns an error,
+	// which UploadDir returns. If nil, the upload stops at the first error.
+	// Calls are not concurrent.
+	OnFile func(local, remote string, err error) error
+
+	// OnProgress, if set, is called with the progress of each file, as by
+	// File.OnProgress. Calls are not concurrent.
+	OnProgress func(Progress)
+}
+
+// UploadDir copies the regular files in the tree rooted at localDir to the
+// tree rooted at remoteDir, creating its directories as it goes. Existing
+// files are overwritten. Other local files, such as symbolic links, are
+// skipped.
+func (c *Client) UploadDir(localDir, remoteDir string, opts UploadDirOptions) error {
+	mkdir := opts.Mkdir
+	if mkdir == nil {
+		mkdir = c.mkdirExisting
+	}
+	t := newDirTransfer(opts.Concurrency, opts.OnFile)
+	progress := t.progress(opts.OnProgress)
+	err := filepath.Walk(localDir, func(local string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localDir, local)
+		if err != nil {
+			return err
+		}
+		remote := path.Join(remoteDir, filepath.ToSlash(rel))
+		switch {
+		case info.IsDir():
+			return mkdir(remote)
+		case info.Mode().IsRegular():
+			return t.do(local, remote, func() error { return c.uploadFile(local, remote, progress) })
+		}
+		return nil
+	})
+	return t.wait(err)
+}
+
+// mkdirExisting creates the directory p unless it exists already.
+func (c *Client) mkdirExisting(p string) error {
+	err := c.Mkdir(p)
+	if err != nil {
+		if info, serr := c.Stat(p); serr == nil && info.IsDir() {
+			return nil
+		}
+	}
+	return err
+}
+
+// uploadFile copies the local file local to remote, reporting its progress
+// to progress.
+func (c *Client) uploadFile(local, remote string, progress func(Progress)) error {
+	src, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := c.OpenFile(remote, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	dst.OnProgress(progress)
+	if _, err := dst.ReadFrom(src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// DownloadDirOptions configures Client.DownloadDir.
+type DownloadDirOptions struct {
+	// Concurrency is the number of files downloaded at once, 1 if zero.
+	Concurrency int
+
+	// Filter, if set, is called for each remote file and directory below
+	// remoteDir, which is skipped, with all it contains, if it returns
+	// false.
+	Filter func(remote string, info os.FileInfo) bool
+
+	// OnFile, if set, is called once each file has been downloaded, or has
+	// failed to, with the error. The download stops if it returns an error,
+	// which DownloadDir returns. If nil, the download stops at the first
+	// error. Calls are not concurrent.
+	OnFile func(remote, local string, err error) error
+
+	// OnProgress, if set, is called with the progress of each file, as by
+	// File.OnProgress. Calls are not concurrent.
+	OnProgress func(Progress)
+}
+
+// DownloadDir copies the regular files in the tree rooted at remoteDir to the
+// tree rooted at localDir, creating its directories as it goes. Existing
+// files are overwritten. The files and directories get the permissions and
+// times of the remote ones. Other remote files, such as symbolic links, are
+// skipped. Errors listing remote directories stop the download.
+func (c *Client) DownloadDir(remoteDir, localDir string, opts DownloadDirOptions) error {
+	type dir struct {
+		local string
+		info  os.FileInfo
+	}
+	var dirs []dir
+	remoteDir = path.Clean(remoteDir)
+	t := newDirTransfer(opts.Concurrency, opts.OnFile)
+	progress := t.progress(opts.OnProgress)
+	err := c.WalkDir(remoteDir, func(remote string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if remote != remoteDir && opts.Filter != nil && !opts.Filter(remote, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, ok := relPath(remoteDir, remote)
+		if !ok {
+			return errors.Errorf("sftp: %s is not in %s", remote, remoteDir)
+		}
+		local := filepath.Join(localDir, filepath.FromSlash(rel))
+		switch {
+		case info.IsDir():
+			// Until the download is done, the directory must be writable.
+			if err := os.MkdirAll(local, 0700); err != nil {
+				return err
+			}
+			dirs = append(dirs, dir{local, info})
+		case info.Mode().IsRegular():
+			return t.do(remote, local, func() error { return c.downloadFile(remote, local, info, progress) })
+		}
+		return nil
+	})
+	if err = t.wait(err); err != nil {
+		return err
+	}
+	// Children first, so that setting their times does not change those of
+	// their parents.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := setFileInfo(dirs[i].local, dirs[i].info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// relPath returns the path of p relative to dir, or false if p, which the
+// server named, is not in dir or below it.
+func relPath(dir, p string) (string, bool) {
+	dir, p = path.Clean(dir), path.Clean(p)
+	rel := p
+	switch {
+	case p == dir:
+		return ".", true
+	case dir == ".":
+	case dir == "/" && strings.HasPrefix(p, "/"):
+		rel = p[1:]
+	case strings.HasPrefix(p, dir+"/"):
+		rel = p[len(dir)+1:]
+	default:
+		return "", false
+	}
+	if rel == ".." || strings.HasPrefix(rel, "../") || path.IsAbs(rel) {
+		return "", false
+	}
+	return rel, true
+}
+
+// downloadFile copies remote, whose attributes are info, to the local file
+// local, reporting its progress to progress.
+func (c *Client) downloadFile(remote, local string, info os.FileInfo, progress func(Progress)) error {
+	src, err := c.Open(remote)
+	if err != nil {
+		return err
+	}
+	src.OnProgress(progress)
+	defer src.Close()
+	dst, err := os.OpenFile(local, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := src.WriteTo(dst); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return setFileInfo(local, info)
+}
+
+// setFileInfo gives the local file name the permissions and times of info.
+func setFileInfo(name string, info os.FileInfo) error {
+	if err := os.Chmod(name, info.Mode().Perm()); err != nil {
+		return err
+	}
+	atime := info.ModTime()
+	if st, ok := info.Sys().(*FileStat); ok {
+		atime = time.Unix(int64(st.Atime), 0)
+	}
+	return os.Chtimes(name, atime, info.ModTime())
+}
+
+// A dirTransfer runs the file copies of a tree transfer on a pool of
+// goroutines, stopping at the first error.
+type dirTransfer struct {
+	jobs   chan func()
+	wg     sync.WaitGroup
+	onFile func(src, dst string, err error) error
+
+	mu  sync.Mutex
+	err error
+}
+
+func newDirTransfer(n int, onFile func(src, dst string, err error) error) *dirTransfer {
+	if n < 1 {
+		n = 1
+	}
+	t := &dirTransfer{
+		jobs:   make(chan func()),
+		onFile: onFile,
+	}
+	t.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer t.wg.Done()
+			for job := range t.jobs {
+				job()
+			}
+		}()
+	}
+	return t
+}
+
+// do runs f, the copy of src to dst, once a goroutine is free. It
+// returns the error which stopped the transfer, if any.
+func (t *dirTransfer) do(src, dst string, f func() error) error {
+	if err := t.error(); err != nil {
+		return err
+	}
+	t.jobs <- func() { t.done(src, dst, f()) }
+	return nil
+}
+
+func (t *dirTransfer) done(src, dst string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return
+	}
+	if t.onFile != nil {
+		err = t.onFile(src, dst, err)
+	}
+	t.err = err
+}
+
+// progress returns fn, called one at a time, or nil if fn is nil.
+func (t *dirTransfer) progress(fn func(Progress)) func(Progress) {
+	if fn == nil {
+		return nil
+	}
+	var mu sync.Mutex
+	return func(p Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+		fn(p)
+	}
+}
+
+func (t *dirTransfer) error() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// wait waits for the copies under way, and returns the error which stopped
+// the transfer, or else err.
+func (t *dirTransfer) wait(err error) error {
+	close(t.jobs)
+	t.wg.Wait()
+	if terr := t.error(); terr != nil {
+		return terr
+	}
+	return err
+}
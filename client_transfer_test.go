@@ -0,0 +1,56 @@
+package sftp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRelPath(t *testing.T) {
+	for _, tt := range []struct {
+		dir, p, rel string
+		ok          bool
+	}{
+		{"/a/b", "/a/b", ".", true},
+		{"/a/b", "/a/b/c", "c", true},
+		{"/a/b", "/a/b/c/d", "c/d", true},
+		{"/a/b", "/a/bc", "", false},
+		{"/a/b", "/a", "", false},
+		{"/a/b", "/a/b/c/../..", "", false},
+		{"/", "/c", "c", true},
+		{"data", "data/x", "x", true},
+		{"data", "..", "", false},
+		{".", "x", "x", true},
+		{".", "..", "", false},
+		{".", "../x", "", false},
+		{".", "/x", "", false},
+	} {
+		if rel, ok := relPath(tt.dir, tt.p); rel != tt.rel || ok != tt.ok {
+			t.Errorf("relPath(%q, %q) = %q, %v, want %q, %v", tt.dir, tt.p, rel, ok, tt.rel, tt.ok)
+		}
+	}
+}
+
+// A server listing names which lead out of the directory must not have
+// files written outside localDir.
+func TestClientDownloadDirHostileNames(t *testing.T) {
+	client := listingClient(t, "data", []string{"x/..", "..", "/"})
+	defer client.Close()
+	parent, err := ioutil.TempDir("", "sftp_download_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(parent)
+	local := filepath.Join(parent, "local")
+
+	if err := client.DownloadDir("data", local, DownloadDirOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(local, "f")); err != nil || string(b) != "contents" {
+		t.Errorf("got %q, %v", b, err)
+	}
+	if fis, _ := ioutil.ReadDir(parent); len(fis) != 1 {
+		t.Errorf("%d files beside localDir", len(fis)-1)
+	}
+}
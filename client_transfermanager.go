@@ -0,0 +1,193 @@
+package sftp
+
+// Queues of file transfers
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// A Transfer is a copy of a file between the local file system and that of
+// a server, run by a TransferManager.
+type Transfer struct {
+	Download bool // copy Remote to Local, rather than Local to Remote
+	Local    string
+	Remote   string
+}
+
+// TransferManagerOptions configures a TransferManager.
+type TransferManagerOptions struct {
+	// Concurrency is the number of files transferred at once, 1 if zero.
+	Concurrency int
+
+	// OnDone, if set, is called once each transfer has finished, with its
+	// error, before Wait returns. Calls are not concurrent.
+	OnDone func(t Transfer, err error)
+
+	// OnProgress, if set, is called with the progress of each file, as by
+	// File.OnProgress. Calls are not concurrent.
+	OnProgress func(Progress)
+}
+
+// TransferStats describe the transfers of a TransferManager.
+type TransferStats struct {
+	Queued    int   // waiting to start
+	Running   int   // under way
+	Succeeded int   // finished successfully
+	Failed    int   // finished with an error
+	Bytes     int64 // copied by the transfers, finished or under way
+}
+
+// A TransferManager runs a queue of transfers, a number of them at once,
+// spreading them across one or more Clients. A failed transfer does not
+// stop the others. Its methods may be called concurrently.
+type TransferManager struct {
+	clients []*Client
+	onDone  func(Transfer, error)
+	onProg  func(Progress)
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex
+	cond    *sync.Cond // signalled when the queue or stats change
+	queue   []Transfer
+	stats   TransferStats
+	err     error // of the first transfer failed since Wait
+	closing bool
+
+	cbMu sync.Mutex // serialises the calls to onDone and onProg
+}
+
+// NewTransferManager returns a TransferManager running transfers on
+// clients, each of the Concurrency transfers at once using the next client
+// in turn. Downloads, like those of Client.DownloadDir, give the local files
+// the permissions and times of the remote ones.
+func NewTransferManager(opts TransferManagerOptions, clients ...*Client) *TransferManager {
+	if len(clients) == 0 {
+		panic("sftp: NewTransferManager without a Client")
+	}
+	n := opts.Concurrency
+	if n < 1 {
+		n = 1
+	}
+	m := &TransferManager{
+		clients: clients,
+		onDone:  opts.OnDone,
+		onProg:  opts.OnProgress,
+	}
+	m.cond = sync.NewCond(&m.mu)
+	m.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go m.work(clients[i%len(clients)])
+	}
+	return m
+}
+
+// Add queues t. It does not wait for t to start, and must not be called
+// after Close.
+func (m *TransferManager) Add(t Transfer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closing {
+		panic("sftp: TransferManager.Add after Close")
+	}
+	m.queue = append(m.queue, t)
+	m.stats.Queued++
+	m.cond.Broadcast()
+}
+
+// Stats returns the state of the transfers added so far.
+func (m *TransferManager) Stats() TransferStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats
+}
+
+// Wait waits for the transfers added so far to finish. It returns the error
+// of the first to fail since the previous call to Wait, if any.
+func (m *TransferManager) Wait() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for m.stats.Queued > 0 || m.stats.Running > 0 {
+		m.cond.Wait()
+	}
+	err := m.err
+	m.err = nil
+	return err
+}
+
+// Close waits for the transfers added so far to finish, as Wait does, then
+// stops the TransferManager.
+func (m *TransferManager) Close() error {
+	err := m.Wait()
+	m.mu.Lock()
+	m.closing = true
+	m.cond.Broadcast()
+	m.mu.Unlock()
+	m.wg.Wait()
+	return err
+}
+
+// work runs queued transfers on c until the TransferManager is closed.
+func (m *TransferManager) work(c *Client) {
+	defer m.wg.Done()
+	for {
+		m.mu.Lock()
+		for len(m.queue) == 0 && !m.closing {
+			m.cond.Wait()
+		}
+		if len(m.queue) == 0 {
+			m.mu.Unlock()
+			return
+		}
+		t := m.queue[0]
+		m.queue = m.queue[1:]
+		m.stats.Queued--
+		m.stats.Running++
+		m.mu.Unlock()
+
+		err := m.run(c, t)
+		if m.onDone != nil {
+			m.cbMu.Lock()
+			m.onDone(t, errors.Cause(err))
+			m.cbMu.Unlock()
+		}
+
+		m.mu.Lock()
+		m.stats.Running--
+		if err != nil {
+			m.stats.Failed++
+			if m.err == nil {
+				m.err = err
+			}
+		} else {
+			m.stats.Succeeded++
+		}
+		m.cond.Broadcast()
+		m.mu.Unlock()
+	}
+}
+
+// run copies t on c, counting the bytes copied.
+func (m *TransferManager) run(c *Client, t Transfer) error {
+	var done int64
+	progress := func(p Progress) {
+		m.mu.Lock()
+		m.stats.Bytes += p.Done - done
+		m.mu.Unlock()
+		done = p.Done
+		if m.onProg != nil {
+			m.cbMu.Lock()
+			m.onProg(p)
+			m.cbMu.Unlock()
+		}
+	}
+	if !t.Download {
+		return errors.Wrapf(c.uploadFile(t.Local, t.Remote, progress), "upload %s", t.Local)
+	}
+	info, err := c.Stat(t.Remote)
+	if err == nil {
+		err = c.downloadFile(t.Remote, t.Local, info, progress)
+	}
+	return errors.Wrapf(err, "download %s", t.Remote)
+}
@@ -0,0 +1,80 @@
+package sftp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestTransferManager(t *testing.T) {
+	client1, _, dir1, cleanup1 := uploadServerPair(t)
+	defer cleanup1()
+	client2, _, dir2, cleanup2 := uploadServerPair(t)
+	defer cleanup2()
+	local, err := ioutil.TempDir("", "sftp_transfer_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(local)
+
+	var mu sync.Mutex
+	done := make(map[string]error)
+	var progressed int64
+	m := NewTransferManager(TransferManagerOptions{
+		Concurrency: 3,
+		OnDone: func(tr Transfer, err error) {
+			mu.Lock()
+			done[tr.Local] = err
+			mu.Unlock()
+		},
+		OnProgress: func(p Progress) { progressed = p.Done },
+	}, client1, client2)
+
+	data := bytes.Repeat([]byte("contents"), 1000)
+	const n = 10
+	for i := 0; i < n; i++ {
+		name := "file" + strconv.Itoa(i)
+		if err := ioutil.WriteFile(filepath.Join(local, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		m.Add(Transfer{Local: filepath.Join(local, name), Remote: testUploadPath + "/" + name})
+	}
+	if err := m.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		name := "file" + strconv.Itoa(i)
+		got, err := ioutil.ReadFile(filepath.Join(dir1, name))
+		if os.IsNotExist(err) {
+			got, err = ioutil.ReadFile(filepath.Join(dir2, name))
+		}
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s: wrong upload, %v", name, err)
+		}
+		if err, ok := done[filepath.Join(local, name)]; !ok || err != nil {
+			t.Errorf("%s: want success reported, got %v, %v", name, ok, err)
+		}
+	}
+	want := TransferStats{Succeeded: n, Bytes: n * int64(len(data))}
+	if got := m.Stats(); got != want || progressed != int64(len(data)) {
+		t.Errorf("want %+v, got %+v after progress %d", want, got, progressed)
+	}
+
+	// A failure is reported, and does not stop the other transfers.
+	missing := filepath.Join(local, "missing")
+	m.Add(Transfer{Local: missing, Remote: testUploadPath + "/missing"})
+	m.Add(Transfer{Local: filepath.Join(local, "file0"), Remote: testUploadPath + "/again"})
+	if err := m.Close(); err == nil {
+		t.Error("failed transfer not reported")
+	}
+	if !os.IsNotExist(done[missing]) {
+		t.Errorf("want not exist error reported, got %v", done[missing])
+	}
+	if got := m.Stats(); got.Succeeded != n+1 || got.Failed != 1 {
+		t.Errorf("want %d succeeded and 1 failed, got %+v", n+1, got)
+	}
+}
@@ -0,0 +1,80 @@
+package sftp
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// WalkDirFunc is the type of the function called by Client.WalkDir for each
+// file or directory. As for filepath.WalkFunc, err is non-nil if path could
+// not be read: if it is root and could not be stat'd, info is nil; if it is
+// a directory which could not be listed, fn is called a second time with
+// the error. Returning filepath.SkipDir for a directory skips it, and for a
+// file skips the remaining entries of its directory. Any other error stops
+// the walk and is returned by WalkDir.
+type WalkDirFunc func(path string, info os.FileInfo, err error) error
+
+// WalkDir walks the tree rooted at root, calling fn for each file or
+// directory in it, root included. Unlike Walk, it lists each directory a
+// batch of entries at a time as it goes, so that only the batches of the
+// directories being walked are held in memory, however large the tree.
+// Entries are visited in the order the server lists them, which is not
+// sorted. Symbolic links are not followed, other than root.
+func (c *Client) WalkDir(root string, fn WalkDirFunc) error {
+	return c.WalkDirContext(context.Background(), root, fn)
+}
+
+// WalkDirContext is WalkDir with a context, as described at Client. Once
+// ctx is done, fn is called with its error.
+func (c *Client) WalkDirContext(ctx context.Context, root string, fn WalkDirFunc) error {
+	info, err := c.StatContext(ctx, root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = c.walkDir(ctx, root, info, fn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func (c *Client) walkDir(ctx context.Context, p string, info os.FileInfo, fn WalkDirFunc) error {
+	if err := fn(p, info, nil); err != nil || !info.IsDir() {
+		if err == filepath.SkipDir && info.IsDir() {
+			return nil
+		}
+		return err
+	}
+	s, handle, err := c.opendir(ctx, p)
+	if err != nil {
+		return skipDir(fn(p, info, err))
+	}
+	defer c.close(ctx, s, handle)
+	for {
+		batch, err := c.readdir(ctx, s, handle)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return skipDir(fn(p, info, err))
+		}
+		for _, fi := range batch {
+			if err := c.walkDir(ctx, path.Join(p, fi.Name()), fi, fn); err != nil {
+				return skipDir(err)
+			}
+		}
+	}
+}
+
+// skipDir returns nil for filepath.SkipDir, which ends the walk of the
+// directory, and err otherwise.
+func skipDir(err error) error {
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
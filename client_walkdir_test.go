@@ -0,0 +1,102 @@
+package sftp
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+// listingClient returns a Client of a server whose every path is a
+// directory, listing names in root and nothing elsewhere, apart from
+// root/f, a file holding "contents". It supports STAT, LSTAT, FSTAT,
+// OPENDIR, READDIR, OPEN, READ and CLOSE.
+func listingClient(t *testing.T, root string, names []string) *Client {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	go func() {
+		defer sw.Close()
+		listed := make(map[string]bool)
+		for {
+			typ, data, err := recvPacket(sr)
+			if err != nil {
+				return
+			}
+			if typ == ssh_FXP_INIT {
+				sendPacket(sw, sshFxVersionPacket{Version: sftpProtocolVersion})
+				continue
+			}
+			id, data := unmarshalUint32(data)
+			name, data := unmarshalString(data)
+			dir := &fileInfo{name: name, mode: os.ModeDir | 0755}
+			file := &fileInfo{name: "f", size: 8, mode: 0644}
+			switch typ {
+			case ssh_FXP_STAT, ssh_FXP_LSTAT, ssh_FXP_FSTAT:
+				info := dir
+				if name == root+"/f" {
+					info = file
+				}
+				sendPacket(sw, sshFxpStatResponse{id, info})
+			case ssh_FXP_OPENDIR, ssh_FXP_OPEN:
+				delete(listed, name)
+				sendPacket(sw, sshFxpHandlePacket{id, name})
+			case ssh_FXP_READDIR:
+				if name != root || listed[name] {
+					sendPacket(sw, sshFxpStatusPacket{id, StatusError{Code: ssh_FX_EOF}})
+					continue
+				}
+				listed[name] = true
+				p := sshFxpNamePacket{ID: id, NameAttrs: []sshFxpNameAttr{
+					{Name: "f", LongName: "f", Attrs: []interface{}{file}},
+				}}
+				for _, n := range names {
+					p.NameAttrs = append(p.NameAttrs, sshFxpNameAttr{Name: n, LongName: n, Attrs: []interface{}{dir}})
+				}
+				sendPacket(sw, p)
+			case ssh_FXP_READ:
+				offset, _ := unmarshalUint64(data)
+				if offset > 0 {
+					sendPacket(sw, sshFxpStatusPacket{id, StatusError{Code: ssh_FX_EOF}})
+					continue
+				}
+				sendPacket(sw, sshFxpDataPacket{ID: id, Length: 8, Data: []byte("contents")})
+			case ssh_FXP_CLOSE:
+				sendPacket(sw, sshFxpStatusPacket{id, StatusError{Code: ssh_FX_OK}})
+			default:
+				sendPacket(sw, sshFxpStatusPacket{id, StatusError{Code: ssh_FX_OP_UNSUPPORTED}})
+			}
+		}
+	}()
+	client, err := NewClientPipe(cr, cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// Names which are not those of entries of the directory listed must not
+// lead the walk out of it, or back into it.
+func TestClientWalkDirHostileNames(t *testing.T) {
+	client := listingClient(t, "/data", []string{"x/..", "/", "..", ".", "y/."})
+	defer client.Close()
+
+	var visited []string
+	err := client.WalkDir("/data", func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if visited = append(visited, p); len(visited) > 10 {
+			return errors.New("walking in circles")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("%v after %q", err, visited)
+	}
+	if len(visited) != 2 || visited[0] != "/data" || visited[1] != "/data/f" {
+		t.Errorf("visited %q", visited)
+	}
+	if fis, err := client.ReadDir("/data"); err != nil || len(fis) != 1 || fis[0].Name() != "f" {
+		t.Errorf("listed %v, %v", fis, err)
+	}
+}
@@ -0,0 +1,169 @@
+package sftp
+
+// Extended attributes, with the xattr@retailnext.com extension
+
+import "context"
+
+// xattrExtension is advertised by servers supporting the getxattr, setxattr
+// and listxattr@retailnext.com requests.
+const xattrExtension = "xattr@retailnext.com"
+
+// sshFxpGetxattrPacket asks for the value of the extended attribute Name
+// of the file Path. The reply is an SSH_FXP_EXTENDED_REPLY holding the
+// value as a string.
+type sshFxpGetxattrPacket struct {
+	ID   uint32
+	Path string
+	Name string
+}
+
+func (p sshFxpGetxattrPacket) id() uint32 { return p.ID }
+
+func (p sshFxpGetxattrPacket) MarshalBinary() ([]byte, error) {
+	l := 1 + 4 + 4 + len("getxattr@retailnext.com") + 4 + len(p.Path) + 4 + len(p.Name)
+	b := make([]byte, 0, l)
+	b = append(b, ssh_FXP_EXTENDED)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, "getxattr@retailnext.com")
+	b = marshalString(b, p.Path)
+	return marshalString(b, p.Name), nil
+}
+
+// sshFxpSetxattrPacket sets the extended attribute Name of the file Path
+// to Value.
+type sshFxpSetxattrPacket struct {
+	ID    uint32
+	Path  string
+	Name  string
+	Value []byte
+}
+
+func (p sshFxpSetxattrPacket) id() uint32 { return p.ID }
+
+func (p sshFxpSetxattrPacket) MarshalBinary() ([]byte, error) {
+	l := 1 + 4 + 4 + len("setxattr@retailnext.com") + 4 + len(p.Path) + 4 + len(p.Name) +
+		4 + len(p.Value)
+	b := make([]byte, 0, l)
+	b = append(b, ssh_FXP_EXTENDED)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, "setxattr@retailnext.com")
+	b = marshalString(b, p.Path)
+	b = marshalString(b, p.Name)
+	return marshalString(b, string(p.Value)), nil
+}
+
+// sshFxpListxattrPacket asks for the names of the extended attributes of
+// the file Path. The reply is an SSH_FXP_EXTENDED_REPLY holding their
+// number, as a uint32, then each name as a string.
+type sshFxpListxattrPacket struct {
+	ID   uint32
+	Path string
+}
+
+func (p sshFxpListxattrPacket) id() uint32 { return p.ID }
+
+func (p sshFxpListxattrPacket) MarshalBinary() ([]byte, error) {
+	l := 1 + 4 + 4 + len("listxattr@retailnext.com") + 4 + len(p.Path)
+	b := make([]byte, 0, l)
+	b = append(b, ssh_FXP_EXTENDED)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, "listxattr@retailnext.com")
+	return marshalString(b, p.Path), nil
+}
+
+// Getxattr returns the value of the extended attribute name of the file
+// path, such as one set by the server when it received the file. It uses
+// the xattr@retailnext.com extension, and fails with a *StatusError with
+// code SSH_FX_OP_UNSUPPORTED if the server does not support it.
+func (c *Client) Getxattr(path, name string) ([]byte, error) {
+	return c.GetxattrContext(context.Background(), path, name)
+}
+
+// GetxattrContext is Getxattr with a context, as described at Client.
+func (c *Client) GetxattrContext(ctx context.Context, path, name string) ([]byte, error) {
+	data, err := c.xattrRequest(ctx, sshFxpGetxattrPacket{
+		ID:   c.nextID(),
+		Path: path,
+		Name: name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	value, _, err := unmarshalStringSafe(data)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(value), nil
+}
+
+// Setxattr sets the extended attribute name of the file path to value, as
+// Getxattr describes.
+func (c *Client) Setxattr(path, name string, value []byte) error {
+	return c.SetxattrContext(context.Background(), path, name, value)
+}
+
+// SetxattrContext is Setxattr with a context, as described at Client.
+func (c *Client) SetxattrContext(ctx context.Context, path, name string, value []byte) error {
+	_, err := c.xattrRequest(ctx, sshFxpSetxattrPacket{
+		ID:    c.nextID(),
+		Path:  path,
+		Name:  name,
+		Value: value,
+	})
+	return err
+}
+
+// Listxattr returns the names of the extended attributes of the file path,
+// as Getxattr describes.
+func (c *Client) Listxattr(path string) ([]string, error) {
+	return c.ListxattrContext(context.Background(), path)
+}
+
+// ListxattrContext is Listxattr with a context, as described at Client.
+func (c *Client) ListxattrContext(ctx context.Context, path string) ([]string, error) {
+	data, err := c.xattrRequest(ctx, sshFxpListxattrPacket{
+		ID:   c.nextID(),
+		Path: path,
+	})
+	if err != nil {
+		return nil, err
+	}
+	count, data, err := unmarshalUint32Safe(data)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for i := uint32(0); i < count; i++ {
+		var name string
+		if name, data, err = unmarshalStringSafe(data); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// xattrRequest sends p, one of the xattr requests, and returns the data of
+// its SSH_FXP_EXTENDED_REPLY, which is nil if the reply is a status.
+func (c *Client) xattrRequest(ctx context.Context, p idmarshaler) ([]byte, error) {
+	if !c.hasExtension(xattrExtension, "1") {
+		return nil, unsupportedExtension(xattrExtension)
+	}
+	id := p.id()
+	typ, data, err := c.sendPacketContext(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case ssh_FXP_EXTENDED_REPLY:
+		sid, data := unmarshalUint32(data)
+		if sid != id {
+			return nil, &unexpectedIDErr{id, sid}
+		}
+		return data, nil
+	case ssh_FXP_STATUS:
+		return nil, normaliseError(unmarshalStatus(id, data))
+	default:
+		return nil, unimplementedPacketErr(typ)
+	}
+}
@@ -0,0 +1,109 @@
+package sftp
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// xattrReply is the encoding of a reply to an xattr request.
+type xattrReply []byte
+
+func (p xattrReply) MarshalBinary() ([]byte, error) { return p, nil }
+
+// xattrClient returns a Client of a server which keeps the extended
+// attributes of files, by path and name, in attrs.
+func xattrClient(t *testing.T, attrs map[string]map[string]string) *Client {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	go func() {
+		defer sw.Close()
+		for {
+			typ, data, err := recvPacket(sr)
+			if err != nil {
+				return
+			}
+			if typ == ssh_FXP_INIT {
+				sendPacket(sw, sshFxVersionPacket{
+					Version:    sftpProtocolVersion,
+					Extensions: []struct{ Name, Data string }{{xattrExtension, "1"}},
+				})
+				continue
+			}
+			id, data := unmarshalUint32(data)
+			request, data := unmarshalString(data)
+			path, data := unmarshalString(data)
+			reply := []byte{ssh_FXP_EXTENDED_REPLY}
+			reply = marshalUint32(reply, id)
+			switch request {
+			case "getxattr@retailnext.com":
+				name, _ := unmarshalString(data)
+				value, ok := attrs[path][name]
+				if !ok {
+					sendPacket(sw, sshFxpStatusPacket{id, StatusError{Code: ssh_FX_NO_SUCH_FILE}})
+					continue
+				}
+				reply = marshalString(reply, value)
+			case "setxattr@retailnext.com":
+				name, data := unmarshalString(data)
+				value, _ := unmarshalString(data)
+				if attrs[path] == nil {
+					attrs[path] = make(map[string]string)
+				}
+				attrs[path][name] = value
+				sendPacket(sw, sshFxpStatusPacket{id, StatusError{Code: ssh_FX_OK}})
+				continue
+			case "listxattr@retailnext.com":
+				reply = marshalUint32(reply, uint32(len(attrs[path])))
+				for name := range attrs[path] {
+					reply = marshalString(reply, name)
+				}
+			}
+			sendPacket(sw, xattrReply(reply))
+		}
+	}()
+	client, err := NewClientPipe(cr, cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestClientXattr(t *testing.T) {
+	attrs := map[string]map[string]string{"/f": {"user.digest": "abc"}}
+	client := xattrClient(t, attrs)
+	defer client.Close()
+
+	if v, err := client.Getxattr("/f", "user.digest"); err != nil || string(v) != "abc" {
+		t.Errorf("want abc, got %q, %v", v, err)
+	}
+	if _, err := client.Getxattr("/f", "user.missing"); err != os.ErrNotExist {
+		t.Errorf("want os.ErrNotExist, got %v", err)
+	}
+	if err := client.Setxattr("/f", "user.source", []byte("partner")); err != nil {
+		t.Fatal(err)
+	}
+	if attrs["/f"]["user.source"] != "partner" {
+		t.Errorf("attribute not set: %v", attrs)
+	}
+	names, err := client.Listxattr("/f")
+	sort.Strings(names)
+	if err != nil || !reflect.DeepEqual(names, []string{"user.digest", "user.source"}) {
+		t.Errorf("got names %q, %v", names, err)
+	}
+	if names, err := client.Listxattr("/g"); err != nil || len(names) != 0 {
+		t.Errorf("want no names, got %q, %v", names, err)
+	}
+}
+
+func TestClientXattrUnsupported(t *testing.T) {
+	client, _, _, cleanup := uploadServerPair(t)
+	defer cleanup()
+
+	_, err := client.Getxattr(testUploadPath+"/f", "user.digest")
+	if err, ok := err.(*StatusError); !ok || err.Code != ssh_FX_OP_UNSUPPORTED {
+		t.Errorf("want SSH_FX_OP_UNSUPPORTED, got %v", err)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/retailnext/sftp"
+)
+
+// commands run the gosftp commands, by name, with their arguments.
+var commands = map[string]func(s *session, args []string) error{
+	"put":    put,
+	"get":    get,
+	"ls":     ls,
+	"rm":     rm,
+	"mirror": mirror,
+}
+
+// usages are the arguments each command takes.
+var usages = map[string]string{
+	"put":    "put [-resume] local... remote",
+	"get":    "get [-resume] remote... local",
+	"ls":     "ls [-l] [remote...]",
+	"rm":     "rm remote...",
+	"mirror": "mirror [-delete] [-checksums algs] localdir remotedir",
+}
+
+// A session holds what commands run with.
+type session struct {
+	clients     []*sftp.Client // one per SFTP session, for transfers
+	out, errs   io.Writer      // for output, and errors with single files
+	concurrency int            // files transferred at once
+	verbose     bool           // report each file
+}
+
+func (s *session) client() *sftp.Client {
+	return s.clients[0]
+}
+
+// flags returns a FlagSet for the command name, which prints its usage to
+// s.errs.
+func flags(s *session, name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(s.errs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: gosftp [flags] host %s\n", usages[name])
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// errUsage is returned by commands given the wrong arguments.
+var errUsage = fmt.Errorf("wrong arguments")
+
+func put(s *session, args []string) error {
+	fs := flags(s, "put")
+	resume := fs.Bool("resume", false, "continue interrupted uploads")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return errUsage
+	}
+	locals, remote := fs.Args()[:fs.NArg()-1], fs.Arg(fs.NArg()-1)
+	intoDir := len(locals) > 1 || strings.HasSuffix(remote, "/")
+	if info, err := s.client().Stat(remote); err == nil && info.IsDir() {
+		intoDir = true
+	}
+	var ts []sftp.Transfer
+	for _, local := range locals {
+		t := sftp.Transfer{Local: local, Remote: remote}
+		if intoDir {
+			t.Remote = path.Join(remote, filepath.Base(local))
+		}
+		ts = append(ts, t)
+	}
+	if *resume {
+		return s.resume(ts)
+	}
+	return s.transfer(ts)
+}
+
+func get(s *session, args []string) error {
+	fs := flags(s, "get")
+	resume := fs.Bool("resume", false, "continue interrupted downloads")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return errUsage
+	}
+	remotes, local := fs.Args()[:fs.NArg()-1], fs.Arg(fs.NArg()-1)
+	intoDir := len(remotes) > 1
+	if info, err := os.Stat(local); err == nil && info.IsDir() {
+		intoDir = true
+	}
+	var ts []sftp.Transfer
+	for _, remote := range remotes {
+		t := sftp.Transfer{Download: true, Local: local, Remote: remote}
+		if intoDir {
+			t.Local = filepath.Join(local, path.Base(remote))
+		}
+		ts = append(ts, t)
+	}
+	if *resume {
+		return s.resume(ts)
+	}
+	return s.transfer(ts)
+}
+
+// transfer runs ts with a TransferManager, spread across the clients.
+func (s *session) transfer(ts []sftp.Transfer) error {
+	start := time.Now()
+	opts := sftp.TransferManagerOptions{
+		Concurrency: s.concurrency,
+		OnDone: func(t sftp.Transfer, err error) {
+			if err != nil {
+				fmt.Fprintf(s.errs, "%s: %v\n", describe(t), err)
+			} else if s.verbose {
+				fmt.Fprintf(s.out, "%s\n", describe(t))
+			}
+		},
+	}
+	m := sftp.NewTransferManager(opts, s.clients...)
+	for _, t := range ts {
+		m.Add(t)
+	}
+	err := m.Close()
+	st := m.Stats()
+	if s.verbose {
+		fmt.Fprintf(s.out, "%d files, %d bytes in %v\n", st.Succeeded, st.Bytes, time.Since(start).Round(time.Millisecond))
+	}
+	if st.Failed > 0 {
+		return fmt.Errorf("%d of %d transfers failed", st.Failed, len(ts))
+	}
+	return err
+}
+
+// resume runs ts one after the other with ResumeUpload and ResumeDownload.
+func (s *session) resume(ts []sftp.Transfer) error {
+	var failed int
+	for _, t := range ts {
+		var n int64
+		var err error
+		if t.Download {
+			n, err = s.client().ResumeDownload(t.Remote, t.Local)
+		} else {
+			n, err = s.client().ResumeUpload(t.Local, t.Remote)
+		}
+		if err != nil {
+			fmt.Fprintf(s.errs, "%s: %v\n", describe(t), err)
+			failed++
+		} else if s.verbose {
+			fmt.Fprintf(s.out, "%s (%d bytes sent)\n", describe(t), n)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d transfers failed", failed, len(ts))
+	}
+	return nil
+}
+
+func describe(t sftp.Transfer) string {
+	if t.Download {
+		return t.Remote + " -> " + t.Local
+	}
+	return t.Local + " -> " + t.Remote
+}
+
+func ls(s *session, args []string) error {
+	fs := flags(s, "ls")
+	long := fs.Bool("l", false, "list modes, sizes and times")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	names := fs.Args()
+	if len(names) == 0 {
+		names = []string{"."}
+	}
+	w := tabwriter.NewWriter(s.out, 0, 8, 1, ' ', tabwriter.AlignRight)
+	show := func(info os.FileInfo) {
+		if *long {
+			fmt.Fprintf(w, "%s\t%d\t %s\t %s\t\n", info.Mode(), info.Size(), info.ModTime().Format("Jan _2 15:04 2006"), info.Name())
+		} else {
+			fmt.Fprintln(w, info.Name())
+		}
+	}
+	var err error
+	for _, name := range names {
+		info, serr := s.client().Stat(name)
+		if serr != nil {
+			fmt.Fprintf(s.errs, "%s: %v\n", name, serr)
+			err = serr
+			continue
+		}
+		if !info.IsDir() {
+			show(info)
+			continue
+		}
+		infos, rerr := s.client().ReadDir(name)
+		if rerr != nil {
+			fmt.Fprintf(s.errs, "%s: %v\n", name, rerr)
+			err = rerr
+			continue
+		}
+		if len(names) > 1 {
+			w.Flush()
+			fmt.Fprintf(s.out, "%s:\n", name)
+		}
+		for _, info := range infos {
+			show(info)
+		}
+	}
+	w.Flush()
+	return err
+}
+
+func rm(s *session, args []string) error {
+	fs := flags(s, "rm")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errUsage
+	}
+	var err error
+	for _, name := range fs.Args() {
+		if rerr := s.client().Remove(name); rerr != nil {
+			fmt.Fprintf(s.errs, "%s: %v\n", name, rerr)
+			err = rerr
+		} else if s.verbose {
+			fmt.Fprintf(s.out, "removed %s\n", name)
+		}
+	}
+	return err
+}
+
+func mirror(s *session, args []string) error {
+	fs := flags(s, "mirror")
+	del := fs.Bool("delete", false, "remove remote files missing locally")
+	checksums := fs.String("checksums", "", "compare files by these check-file algorithms, such as sha256")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errUsage
+	}
+	return s.client().SyncDir(fs.Arg(0), fs.Arg(1), sftp.SyncDirOptions{
+		Concurrency: s.concurrency,
+		Checksums:   *checksums,
+		Delete:      *del,
+		OnFile: func(local, remote string, err error) error {
+			switch {
+			case err != nil:
+				return err
+			case !s.verbose:
+			case local == "":
+				fmt.Fprintf(s.out, "removed %s\n", remote)
+			default:
+				fmt.Fprintf(s.out, "%s -> %s\n", local, remote)
+			}
+			return nil
+		},
+	})
+}
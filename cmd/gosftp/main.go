@@ -0,0 +1,210 @@
+// Command gosftp copies files to and from SFTP servers with the sftp
+// package's Client, and so doubles as an end to end test of its pipelining,
+// transfer queues and resumption against a real server.
+//
+// Usage:
+//
+//	gosftp [flags] [user@]host[:port] command [args...]
+//
+// The commands are:
+//
+//	put [-resume] local... remote       upload files
+//	get [-resume] remote... local       download files
+//	ls [-l] [remote...]                 list directories
+//	rm remote...                        remove files
+//	mirror [-delete] [-checksums algs] localdir remotedir
+//	                                    make remotedir a copy of localdir
+//
+// put and get copy into the directory named last if there are several
+// files, or it is one. Transfers run -j at a time, across -sessions SFTP
+// sessions on the SSH connection; with -resume, they run one at a time,
+// continuing interrupted copies. gosftp authenticates with the keys of
+// ssh-agent, if running, and the -i key, and checks the host key against
+// -known-hosts.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/retailnext/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func main() {
+	var (
+		identity   string
+		knownHosts string
+		insecure   bool
+		sessions   int
+		requests   int
+		rate       int64
+		keepAlive  time.Duration
+		reconnect  bool
+		s          = session{out: os.Stdout, errs: os.Stderr}
+	)
+	home, _ := os.UserHomeDir()
+	flag.StringVar(&identity, "i", "", "private key file")
+	flag.StringVar(&knownHosts, "known-hosts", filepath.Join(home, ".ssh", "known_hosts"), "known hosts file")
+	flag.BoolVar(&insecure, "insecure", false, "accept any host key, for test servers")
+	flag.IntVar(&s.concurrency, "j", 4, "files transferred at once")
+	flag.IntVar(&sessions, "sessions", 1, "SFTP sessions to spread transfers across")
+	flag.IntVar(&requests, "requests", 0, "requests kept in flight per file, if not the default")
+	flag.Int64Var(&rate, "rate", 0, "bytes a second to limit each session to, if not 0")
+	flag.DurationVar(&keepAlive, "keepalive", 0, "interval of keepalive requests, if not 0")
+	flag.BoolVar(&reconnect, "reconnect", false, "start new sessions when the connection is lost")
+	flag.BoolVar(&s.verbose, "v", false, "report each file")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: gosftp [flags] [user@]host[:port] command [args...]")
+		for _, name := range []string{"put", "get", "ls", "rm", "mirror"} {
+			fmt.Fprintf(flag.CommandLine.Output(), "\t%s\n", usages[name])
+		}
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 2 || commands[flag.Arg(1)] == nil {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	config, addr, err := sshConfig(flag.Arg(0), identity, knownHosts, insecure)
+	if err != nil {
+		fatal(err)
+	}
+	conn, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		fatal(err)
+	}
+	defer conn.Close()
+
+	var opts []func(*sftp.Client) error
+	if requests > 0 {
+		opts = append(opts, sftp.MaxConcurrentRequests(requests))
+	}
+	if rate > 0 {
+		opts = append(opts, sftp.MaxTransferRate(rate))
+	}
+	if keepAlive > 0 {
+		opts = append(opts, sftp.KeepAlive(keepAlive))
+	}
+	if reconnect {
+		opts = append(opts, sftp.Reconnect(func() (io.Reader, io.WriteCloser, error) {
+			return dialSubsystem(addr, config)
+		}))
+	}
+	if sessions < 1 {
+		sessions = 1
+	}
+	for i := 0; i < sessions; i++ {
+		c, err := sftp.NewClient(conn, opts...)
+		if err != nil {
+			fatal(err)
+		}
+		defer c.Close()
+		s.clients = append(s.clients, c)
+	}
+	if err := commands[flag.Arg(1)](&s, flag.Args()[2:]); err != nil {
+		if err != errUsage {
+			fmt.Fprintf(os.Stderr, "gosftp: %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "gosftp: %v\n", err)
+	os.Exit(1)
+}
+
+// sshConfig returns the configuration for connecting to target, of the
+// form [user@]host[:port], and the address to dial.
+func sshConfig(target, identity, knownHosts string, insecure bool) (*ssh.ClientConfig, string, error) {
+	config := &ssh.ClientConfig{Timeout: 30 * time.Second}
+	if i := strings.LastIndexByte(target, '@'); i >= 0 {
+		config.User, target = target[:i], target[i+1:]
+	} else if u, err := user.Current(); err == nil {
+		config.User = u.Username
+	}
+	addr := target
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		addr = net.JoinHostPort(target, "22")
+	}
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if c, err := net.Dial("unix", sock); err == nil {
+			config.Auth = append(config.Auth, ssh.PublicKeysCallback(agent.NewClient(c).Signers))
+		}
+	}
+	if identity != "" {
+		b, err := ioutil.ReadFile(identity)
+		if err != nil {
+			return nil, "", err
+		}
+		key, err := ssh.ParsePrivateKey(b)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: %v", identity, err)
+		}
+		config.Auth = append(config.Auth, ssh.PublicKeys(key))
+	}
+
+	if insecure {
+		config.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	} else {
+		cb, err := knownhosts.New(knownHosts)
+		if err != nil {
+			return nil, "", err
+		}
+		config.HostKeyCallback = cb
+	}
+	return config, addr, nil
+}
+
+// dialSubsystem opens a new SSH connection to addr and starts the sftp
+// subsystem on it, returning its pipes, for Reconnect.
+func dialSubsystem(addr string, config *ssh.ClientConfig) (io.Reader, io.WriteCloser, error) {
+	conn, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	sess, err := conn.NewSession()
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	r, err := sess.StdoutPipe()
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	w, err := sess.StdinPipe()
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if err := sess.RequestSubsystem("sftp"); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return r, connCloser{w, conn}, nil
+}
+
+// A connCloser is the stdin of a session, closing its connection too.
+type connCloser struct {
+	io.WriteCloser
+	conn *ssh.Client
+}
+
+func (c connCloser) Close() error {
+	c.WriteCloser.Close()
+	return c.conn.Close()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/retailnext/sftp"
+	"github.com/retailnext/sftp/sftptest"
+)
+
+// testSession returns a session on the Client of a new sftptest.Pair, and
+// a directory of local files a, b and c.
+func testSession(t *testing.T, options ...sftp.ServerOption) (*session, *sftptest.Pair, string, func()) {
+	p := sftptest.NewPair(t, options...)
+	local, err := ioutil.TempDir("", "gosftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if err := ioutil.WriteFile(filepath.Join(local, name), []byte(strings.Repeat(name, 100000)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out bytes.Buffer
+	s := &session{
+		clients:     []*sftp.Client{p.Client},
+		out:         &out,
+		errs:        &out,
+		concurrency: 2,
+		verbose:     true,
+	}
+	return s, p, local, func() {
+		if t.Failed() {
+			t.Logf("output:\n%s", &out)
+		}
+		p.Close()
+		os.RemoveAll(local)
+	}
+}
+
+func TestPut(t *testing.T) {
+	s, p, local, cleanup := testSession(t)
+	defer cleanup()
+	a, b := filepath.Join(local, "a"), filepath.Join(local, "b")
+	if err := put(s, []string{a, b, sftptest.UploadPath}); err != nil {
+		t.Fatal(err)
+	}
+	p.AssertFile("a", bytes.Repeat([]byte("a"), 100000))
+	p.AssertFile("b", bytes.Repeat([]byte("b"), 100000))
+	if out := s.out.(*bytes.Buffer).String(); !strings.Contains(out, "2 files, 200000 bytes") {
+		t.Errorf("got output %q", out)
+	}
+}
+
+func TestPutFails(t *testing.T) {
+	s, _, local, cleanup := testSession(t)
+	defer cleanup()
+	err := put(s, []string{filepath.Join(local, "a"), filepath.Join(local, "missing"), sftptest.UploadPath})
+	if err == nil || err.Error() != "1 of 2 transfers failed" {
+		t.Errorf("got %v", err)
+	}
+	if err := put(s, []string{"a"}); err != errUsage {
+		t.Errorf("got %v, want errUsage", err)
+	}
+}
+
+func TestMirror(t *testing.T) {
+	// The Server lists nothing, so that every file is uploaded.
+	s, p, local, cleanup := testSession(t, sftp.ReaddirHook(func() ([]os.FileInfo, error) {
+		return nil, io.EOF
+	}))
+	defer cleanup()
+	if err := mirror(s, []string{local, sftptest.UploadPath}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		p.AssertFile(name, bytes.Repeat([]byte(name), 100000))
+	}
+}
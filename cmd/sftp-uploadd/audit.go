@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/retailnext/sftp"
+)
+
+// An auditLog writes a JSON line for each session started and ended, each
+// upload opened and closed, and each request refused.
+type auditLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newAuditLog(w io.Writer) *auditLog {
+	return &auditLog{enc: json.NewEncoder(w)}
+}
+
+// auditRecord is a line of the audit log. Fields which do not apply to the
+// event are left out.
+type auditRecord struct {
+	Time       time.Time         `json:"time"`
+	Event      string            `json:"event"`
+	User       string            `json:"user"`
+	RemoteAddr string            `json:"remote_addr"`
+	Client     string            `json:"client,omitempty"`
+	Op         string            `json:"op,omitempty"`
+	RemotePath string            `json:"remote_path,omitempty"`
+	Path       string            `json:"path,omitempty"`
+	Files      uint64            `json:"files,omitempty"`
+	Bytes      int64             `json:"bytes,omitempty"`
+	Duration   string            `json:"duration,omitempty"`
+	Digests    map[string]string `json:"digests,omitempty"`
+	Reason     string            `json:"reason,omitempty"`
+	Code       uint32            `json:"code,omitempty"`
+	Err        string            `json:"err,omitempty"`
+}
+
+// record writes the line for e, if it is audited.
+func (a *auditLog) record(e sftp.Event) error {
+	var r auditRecord
+	var sess sftp.SessionInfo
+	switch e := e.(type) {
+	case sftp.SessionStarted:
+		r.Event, sess = "session_started", e.Session
+		r.Client = sess.ClientVersion
+	case sftp.SessionEnded:
+		r.Event, sess = "session_ended", e.Summary.SessionInfo
+		r.Files = e.Summary.Stats.Uploads
+		r.Bytes = int64(e.Summary.Stats.UploadBytes)
+		r.Duration = e.Summary.Ended.Sub(e.Summary.Started).String()
+		if e.Summary.Err != nil && e.Summary.Err != io.EOF {
+			r.Err = e.Summary.Err.Error()
+		}
+	case sftp.FileOpened:
+		r.Event, sess = "file_opened", e.Session
+		r.RemotePath, r.Path = e.RemotePath, e.Path
+	case sftp.FileClosed:
+		r.Event, sess = "file_closed", e.Session
+		r.RemotePath, r.Path = e.RemotePath, e.Path
+		r.Bytes, r.Duration = e.Written, e.Duration.String()
+		for name, sum := range e.Digests {
+			if r.Digests == nil {
+				r.Digests = make(map[string]string)
+			}
+			r.Digests[name] = hex.EncodeToString(sum)
+		}
+		if e.Err != nil {
+			r.Err = e.Err.Error()
+		}
+	case sftp.OperationDenied:
+		r.Event, sess = "denied", e.Session
+		r.Op, r.Path, r.Code, r.Reason = e.Op, e.Path, e.Code, e.Reason.String()
+	default:
+		return nil
+	}
+	r.Time = time.Now()
+	r.User, r.RemoteAddr = sess.User, sess.RemoteAddr
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.enc.Encode(r)
+}
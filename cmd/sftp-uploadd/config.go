@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the configuration of sftp-uploadd, read from a file in the
+// subset of TOML described at parseTOML. The toml tag of each field is its
+// key, prefixed by its table; sftp-uploadd.toml documents them all.
+type Config struct {
+	Listen         string   `toml:"listen"`
+	HostKeys       []string `toml:"host_keys"`
+	AuthorizedKeys string   `toml:"authorized_keys"`
+
+	UploadPath    string      `toml:"uploads.path"`
+	Dir           string      `toml:"uploads.dir"`
+	PerUserDirs   bool        `toml:"uploads.per_user_dirs"`
+	Profile       string      `toml:"uploads.profile"`
+	FileSizeLimit int64       `toml:"uploads.file_size_limit"`
+	FileMode      os.FileMode `toml:"uploads.file_mode"`
+	NoOverwrite   bool        `toml:"uploads.no_overwrite"`
+	StagingDir    string      `toml:"uploads.staging_dir"`
+	Digests       []string    `toml:"uploads.digests"`
+
+	HandshakeTimeout time.Duration `toml:"sessions.handshake_timeout"`
+	Workers          int           `toml:"sessions.workers"`
+	IdleTimeout      time.Duration `toml:"sessions.idle_timeout"`
+	MaxDuration      time.Duration `toml:"sessions.max_duration"`
+	MaxMessageSize   uint32        `toml:"sessions.max_message_size"`
+	RequestRate      float64       `toml:"sessions.request_rate"`
+	RequestBurst     int           `toml:"sessions.request_burst"`
+	RefusedClients   []string      `toml:"sessions.refused_clients"`
+	MaxPerAddress    int           `toml:"sessions.max_per_address"`
+	MaxPerUser       int           `toml:"sessions.max_per_user"`
+
+	Nagle       bool          `toml:"socket.nagle"`
+	ReadBuffer  int           `toml:"socket.read_buffer"`
+	WriteBuffer int           `toml:"socket.write_buffer"`
+	KeepAlive   time.Duration `toml:"socket.keepalive"`
+
+	ProjectQuota bool `toml:"quota.project"`
+
+	AuditLog string `toml:"audit.log"`
+}
+
+// defaultConfig holds the values of keys a config file leaves out.
+var defaultConfig = Config{
+	Listen:           "0.0.0.0:2022",
+	UploadPath:       "/upload",
+	Profile:          "upload-only",
+	HandshakeTimeout: 30 * time.Second,
+}
+
+// loadConfig reads the config file name.
+func loadConfig(name string) (*Config, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, err := parseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return c, nil
+}
+
+// parseConfig reads a config file from r and checks the result.
+func parseConfig(r io.Reader) (*Config, error) {
+	values, err := parseTOML(r)
+	if err != nil {
+		return nil, err
+	}
+	c := defaultConfig
+	fields := make(map[string]reflect.Value)
+	v := reflect.ValueOf(&c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		fields[v.Type().Field(i).Tag.Get("toml")] = v.Field(i)
+	}
+	for _, kv := range values {
+		field, ok := fields[kv.key]
+		if !ok {
+			return nil, fmt.Errorf("line %d: unknown key %s", kv.line, kv.key)
+		}
+		if err := setField(field, kv.value); err != nil {
+			return nil, fmt.Errorf("line %d: %s: %v", kv.line, kv.key, err)
+		}
+	}
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// check reports the first problem with c which the sftp package would not.
+func (c *Config) check() error {
+	switch {
+	case len(c.HostKeys) == 0:
+		return fmt.Errorf("no host_keys")
+	case c.AuthorizedKeys == "":
+		return fmt.Errorf("no authorized_keys")
+	case c.Dir == "":
+		return fmt.Errorf("no uploads.dir")
+	}
+	if _, ok := profiles[c.Profile]; !ok {
+		return fmt.Errorf("unknown uploads.profile %q", c.Profile)
+	}
+	for _, name := range c.Digests {
+		if _, ok := digests[name]; !ok {
+			return fmt.Errorf("unknown digest %q in uploads.digests", name)
+		}
+	}
+	return nil
+}
+
+// setField sets field to value, as parsed by parseTOML.
+func setField(field reflect.Value, value interface{}) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("want a duration such as \"10m\", got %v", value)
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+	bad := fmt.Errorf("want %s, got %T", field.Kind(), value)
+	switch field.Kind() {
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			return bad
+		}
+		field.SetString(s)
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return bad
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, ok := value.(int64)
+		if !ok {
+			return bad
+		}
+		field.SetInt(n)
+	case reflect.Uint32:
+		n, ok := value.(int64)
+		if !ok {
+			return bad
+		}
+		if n < 0 || n > 1<<32-1 {
+			return fmt.Errorf("%d out of range", n)
+		}
+		field.SetUint(uint64(n))
+	case reflect.Float64:
+		switch n := value.(type) {
+		case float64:
+			field.SetFloat(n)
+		case int64:
+			field.SetFloat(float64(n))
+		default:
+			return bad
+		}
+	case reflect.Slice:
+		ss, ok := value.([]string)
+		if !ok {
+			return fmt.Errorf("want an array of strings, got %T", value)
+		}
+		field.Set(reflect.ValueOf(ss))
+	}
+	return nil
+}
+
+// A keyValue is a key set in a config file, prefixed by its table.
+type keyValue struct {
+	line  int
+	key   string
+	value interface{} // string, int64, float64, bool or []string
+}
+
+// parseTOML parses the subset of TOML needed by config files: comments,
+// [table] headers, and bare keys set to basic or literal strings, integers,
+// floats, booleans, or arrays of strings on one line.
+func parseTOML(r io.Reader) ([]keyValue, error) {
+	var kvs []keyValue
+	seen := make(map[string]bool)
+	table := ""
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		s := strings.TrimSpace(sc.Text())
+		if s == "" || s[0] == '#' {
+			continue
+		}
+		if s[0] == '[' {
+			end := strings.IndexByte(s, ']')
+			if end < 0 || !isComment(s[end+1:]) || !isBareKey(s[1:end]) {
+				return nil, fmt.Errorf("line %d: bad table header", line)
+			}
+			table = s[1:end] + "."
+			continue
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: want key = value", line)
+		}
+		key := strings.TrimSpace(s[:eq])
+		if !isBareKey(key) {
+			return nil, fmt.Errorf("line %d: bad key %q", line, key)
+		}
+		value, rest, err := parseValue(strings.TrimSpace(s[eq+1:]))
+		if err == nil && !isComment(rest) {
+			err = fmt.Errorf("unexpected %q", rest)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		key = table + key
+		if seen[key] {
+			return nil, fmt.Errorf("line %d: %s set twice", line, key)
+		}
+		seen[key] = true
+		kvs = append(kvs, keyValue{line, key, value})
+	}
+	return kvs, sc.Err()
+}
+
+// isComment reports whether s, the rest of a line, is blank or a comment.
+func isComment(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "" || s[0] == '#'
+}
+
+func isBareKey(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseValue parses the value at the start of s, returning the rest.
+func parseValue(s string) (interface{}, string, error) {
+	switch {
+	case s == "":
+		return nil, "", fmt.Errorf("missing value")
+	case s[0] == '"' || s[0] == '\'':
+		return parseString(s)
+	case s[0] == '[':
+		var ss []string
+		s = strings.TrimSpace(s[1:])
+		for {
+			if s != "" && s[0] == ']' {
+				return ss, s[1:], nil
+			}
+			v, rest, err := parseString(s)
+			if err != nil {
+				return nil, "", err
+			}
+			ss = append(ss, v)
+			s = strings.TrimSpace(rest)
+			if s != "" && s[0] == ',' {
+				s = strings.TrimSpace(s[1:])
+			} else if s == "" || s[0] != ']' {
+				return nil, "", fmt.Errorf("unterminated array")
+			}
+		}
+	}
+	end := strings.IndexAny(s, " \t#")
+	if end < 0 {
+		end = len(s)
+	}
+	word, rest := s[:end], s[end:]
+	switch word {
+	case "true":
+		return true, rest, nil
+	case "false":
+		return false, rest, nil
+	}
+	if n, err := strconv.ParseInt(word, 0, 64); err == nil {
+		return n, rest, nil
+	}
+	if f, err := strconv.ParseFloat(strings.Replace(word, "_", "", -1), 64); err == nil {
+		return f, rest, nil
+	}
+	return nil, "", fmt.Errorf("bad value %q", word)
+}
+
+// parseString parses the basic or literal string at the start of s,
+// returning the rest.
+func parseString(s string) (string, string, error) {
+	if s == "" || (s[0] != '"' && s[0] != '\'') {
+		return "", "", fmt.Errorf("want a string")
+	}
+	if s[0] == '\'' {
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated string")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			v, err := strconv.Unquote(s[:i+1])
+			return v, s[i+1:], err
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string")
+}
@@ -0,0 +1,280 @@
+// Command sftp-uploadd is an SSH server accepting uploads over SFTP, and
+// nothing else, configured by a file. It is both a deployable server and an
+// example of putting the sftp package's Server options together: the
+// annotated sftp-uploadd.toml lists every setting and the option it maps
+// to.
+//
+// Usage:
+//
+//	sftp-uploadd [-config file] [-check]
+//
+// Clients authenticate with public keys listed in the file named by
+// authorized_keys, in the format of OpenSSH's, in which %u stands for the
+// user name. Each session is written to the audit log, if configured, as
+// JSON lines.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/retailnext/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+func main() {
+	var (
+		configFile string
+		check      bool
+	)
+	flag.StringVar(&configFile, "config", "/etc/sftp-uploadd.toml", "config file")
+	flag.BoolVar(&check, "check", false, "check the config file and exit")
+	flag.Parse()
+
+	c, err := loadConfig(configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sshConfig, err := c.sshConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if check {
+		return
+	}
+
+	var audit *auditLog
+	switch c.AuditLog {
+	case "":
+	case "-":
+		audit = newAuditLog(os.Stdout)
+	default:
+		f, err := os.OpenFile(c.AuditLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		audit = newAuditLog(f)
+	}
+
+	limits := c.sessionLimits()
+	l, err := net.Listen("tcp", c.Listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("listening on %v", l.Addr())
+	err = acceptLoop(l, func(nConn net.Conn) {
+		if err := c.serveConn(nConn, sshConfig, limits, audit); err != nil {
+			log.Printf("%v: %v", nConn.RemoteAddr(), err)
+		}
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// acceptLoop runs handle in a goroutine of its own on each connection l
+// accepts, until l is closed. As net/http's Server does, it logs temporary
+// errors, and running out of file descriptors, and backs off before
+// accepting again, rather than exiting while connections already open
+// could free some; it returns any other error.
+func acceptLoop(l net.Listener, handle func(net.Conn)) error {
+	const maxDelay = time.Second
+	var delay time.Duration
+	for {
+		nConn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			if !retryableAccept(err) {
+				return err
+			}
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay *= 2; delay > maxDelay {
+				delay = maxDelay
+			}
+			log.Printf("accept: %v; retrying in %v", err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		go handle(nConn)
+	}
+}
+
+// retryableAccept reports whether Accept may succeed again after failing
+// with err.
+func retryableAccept(err error) bool {
+	if errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Temporary()
+}
+
+// sshConfig returns the configuration of the SSH server, with the host keys
+// loaded and clients authenticated by public key.
+func (c *Config) sshConfig() (*ssh.ServerConfig, error) {
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if c.authorized(conn.User(), key) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("key rejected for %q", conn.User())
+		},
+	}
+	for _, name := range c.HostKeys {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ssh.ParsePrivateKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		config.AddHostKey(key)
+	}
+	return config, nil
+}
+
+// authorized reports whether key is in the authorized keys of user. The
+// file is read each time, so that changes to it apply to new sessions.
+func (c *Config) authorized(user string, key ssh.PublicKey) bool {
+	if !validUser(user) {
+		return false
+	}
+	b, err := ioutil.ReadFile(strings.Replace(c.AuthorizedKeys, "%u", user, -1))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Print(err)
+		}
+		return false
+	}
+	want := key.Marshal()
+	for len(b) > 0 {
+		k, _, _, rest, err := ssh.ParseAuthorizedKey(b)
+		if err != nil {
+			return false
+		}
+		if bytes.Equal(k.Marshal(), want) {
+			return true
+		}
+		b = rest
+	}
+	return false
+}
+
+// validUser reports whether user may name a file or directory.
+func validUser(user string) bool {
+	return user != "" && user != "." && user != ".." && !strings.ContainsAny(user, "/\\\x00")
+}
+
+// serveConn serves the SSH connection nConn, running an upload Server in
+// each session which asks for the sftp subsystem, as far as limits allow.
+// A client has sessions.handshake_timeout to complete the SSH handshake.
+func (c *Config) serveConn(nConn net.Conn, config *ssh.ServerConfig, limits *sftp.SessionLimits, audit *auditLog) error {
+	defer nConn.Close()
+	if err := c.socketOptions().Apply(nConn); err != nil {
+		return err
+	}
+	if c.HandshakeTimeout > 0 {
+		if err := nConn.SetDeadline(time.Now().Add(c.HandshakeTimeout)); err != nil {
+			return err
+		}
+	}
+	sConn, chans, reqs, err := ssh.NewServerConn(nConn, config)
+	if err != nil {
+		return err
+	}
+	if c.HandshakeTimeout > 0 {
+		if err := nConn.SetDeadline(time.Time{}); err != nil {
+			sConn.Close()
+			return err
+		}
+	}
+	defer sConn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	dir, err := c.userDir(sConn.User())
+	if err != nil {
+		return err
+	}
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer channel.Close()
+			for req := range requests {
+				ok := req.Type == "subsystem" && len(req.Payload) >= 4 && string(req.Payload[4:]) == "sftp"
+				if !ok {
+					req.Reply(false, nil)
+					continue
+				}
+				info := sftp.SessionInfoFromConn(sConn)
+				release, err := limits.Acquire(info)
+				if err != nil {
+					req.Reply(false, nil)
+					log.Printf("%s@%v: %v", sConn.User(), sConn.RemoteAddr(), err)
+					sConn.Close()
+					return
+				}
+				defer release()
+				req.Reply(true, nil)
+				go ssh.DiscardRequests(requests)
+				opts := append(c.serverOptions(dir), sftp.WithSessionInfo(info))
+				if err := serve(channel, opts, audit); err != nil {
+					log.Printf("%s@%v: %v", sConn.User(), sConn.RemoteAddr(), err)
+				}
+				return
+			}
+		}()
+	}
+	return nil
+}
+
+// serve runs a Server with opts on channel, writing its events to audit if
+// not nil.
+func serve(channel io.ReadWriteCloser, opts []sftp.ServerOption, audit *auditLog) error {
+	server, err := sftp.NewServer(channel, opts...)
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	if audit != nil {
+		events := server.Events()
+		go func() {
+			defer close(done)
+			for e := range events {
+				if err := audit.record(e); err != nil {
+					log.Printf("audit log: %v", err)
+				}
+			}
+		}()
+	} else {
+		close(done)
+	}
+	err = server.Serve()
+	<-done
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/retailnext/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestExampleConfig(t *testing.T) {
+	b, err := ioutil.ReadFile("sftp-uploadd.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := parseConfig(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Dir != "/srv/uploads" || c.UploadPath != "/upload" || len(c.HostKeys) != 1 {
+		t.Errorf("got %+v", c)
+	}
+
+	// Every optional setting, once uncommented, must be valid.
+	all := regexp.MustCompile(`(?m)^#([a-z_]+ = )`).ReplaceAll(b, []byte("$1"))
+	c, err = parseConfig(bytes.NewReader(all))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.FileMode != 0640 || len(c.Digests) != 1 || c.RequestBurst != 1 {
+		t.Errorf("got %+v", c)
+	}
+	c.ProjectQuota = false // not supported everywhere
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	if _, err := sftp.NewServer(nopConn{}, c.serverOptions(dir)...); err != nil {
+		t.Error(err)
+	}
+}
+
+type nopConn struct{}
+
+func (nopConn) Read([]byte) (int, error)    { return 0, nil }
+func (nopConn) Write(b []byte) (int, error) { return len(b), nil }
+func (nopConn) Close() error                { return nil }
+
+func TestParseConfig(t *testing.T) {
+	const required = "host_keys = ['k']\nauthorized_keys = \"a\"\n[uploads]\ndir = \"/d\"\n"
+	c, err := parseConfig(strings.NewReader(required + `
+profile = "drop-box" # comment
+digests = [ "sha256", 'md5', ]
+file_size_limit = 1_000_000
+[sessions]
+idle_timeout = "90s"
+request_rate = 2.5
+max_message_size = 0x8000
+max_per_user = 2
+[socket]
+read_buffer = 1_048_576
+keepalive = "-1s"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Profile != "drop-box" || len(c.Digests) != 2 || c.Digests[1] != "md5" ||
+		c.FileSizeLimit != 1000000 || c.IdleTimeout != 90*time.Second ||
+		c.RequestRate != 2.5 || c.MaxMessageSize != 0x8000 || c.Listen != defaultConfig.Listen ||
+		c.MaxPerUser != 2 || c.ReadBuffer != 1<<20 || c.KeepAlive != -time.Second {
+		t.Errorf("got %+v", c)
+	}
+
+	for _, bad := range []string{
+		"listen = \"x\"",                     // missing required keys
+		required + "unknown = 1",             // unknown key
+		required + "[uploads]\ndir = \"/e\"", // set twice
+		required + "profile = \"any\"",       // unknown profile
+		required + "digests = [\"crc\"]",     // unknown digest
+		required + "no_overwrite = 1",        // wrong type
+		required + "path = \"/x",             // unterminated string
+		required + "digests = [\"a\" \"b\"]", // missing comma
+		required + "[sessions]\nidle_timeout = 5",
+		required + "[bad table]",
+		required + "path",
+	} {
+		if _, err := parseConfig(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q: want error", bad)
+		}
+	}
+}
+
+func TestValidUser(t *testing.T) {
+	for user, want := range map[string]bool{
+		"alice": true, "": false, "..": false, "a/b": false, `a\b`: false,
+	} {
+		if got := validUser(user); got != want {
+			t.Errorf("validUser(%q) = %v, want %v", user, got, want)
+		}
+	}
+}
+
+func TestServeAudit(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	c, err := parseConfig(strings.NewReader(`
+host_keys = ["k"]
+authorized_keys = "a"
+[uploads]
+dir = "` + dir + `"
+digests = ["sha256"]
+[sessions]
+max_message_size = 100000
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	audit := newAuditLog(&buf)
+	opts := append(c.serverOptions(c.Dir), sftp.WithSessionInfo(sftp.SessionInfo{User: "alice"}))
+	cconn, sconn := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- serve(sconn, opts, audit) }()
+
+	client, err := sftp.NewClientPipe(cconn, cconn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := client.Create(c.UploadPath + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("contents"))
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	client.Remove(c.UploadPath + "/file")
+	client.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(c.Dir + "/file"); err != nil || string(b) != "contents" {
+		t.Errorf("upload: got %q, %v", b, err)
+	}
+
+	var events []string
+	var closed auditRecord
+	sc := bufio.NewScanner(&buf)
+	for sc.Scan() {
+		var r auditRecord
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			t.Fatalf("%s: %v", sc.Bytes(), err)
+		}
+		if r.User != "alice" {
+			t.Errorf("%s: want user alice", sc.Bytes())
+		}
+		events = append(events, r.Event)
+		if r.Event == "file_closed" {
+			closed = r
+		}
+	}
+	want := "session_started file_opened file_closed denied session_ended"
+	if got := strings.Join(events, " "); got != want {
+		t.Errorf("got events %s, want %s", got, want)
+	}
+	if closed.Bytes != 8 || closed.Digests["sha256"] == "" || closed.Path != c.Dir+"/file" {
+		t.Errorf("got file_closed %+v", closed)
+	}
+}
+
+// errListener is a net.Listener whose Accept returns each of errs in turn,
+// a connection on nil, and net.ErrClosed once they run out.
+type errListener struct {
+	net.Listener
+	errs []error
+}
+
+func (l *errListener) Accept() (net.Conn, error) {
+	if len(l.errs) == 0 {
+		return nil, net.ErrClosed
+	}
+	err := l.errs[0]
+	l.errs = l.errs[1:]
+	if err != nil {
+		return nil, err
+	}
+	conn, _ := net.Pipe()
+	return conn, nil
+}
+
+func TestAcceptLoop(t *testing.T) {
+	emfile := &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept4", syscall.EMFILE)}
+	handled := make(chan net.Conn, 2)
+	l := &errListener{errs: []error{emfile, syscall.ENFILE, nil, emfile, nil}}
+	if err := acceptLoop(l, func(c net.Conn) { handled <- c }); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case c := <-handled:
+			c.Close()
+		case <-time.After(5 * time.Second):
+			t.Fatalf("handled %d connections", i)
+		}
+	}
+
+	permanent := &net.OpError{Op: "accept", Net: "tcp", Err: syscall.EINVAL}
+	l = &errListener{errs: []error{nil, permanent}}
+	if err := acceptLoop(l, func(c net.Conn) { c.Close() }); err != permanent {
+		t.Errorf("got %v, want %v", err, permanent)
+	}
+}
+
+// A client which never completes the SSH handshake is disconnected once
+// sessions.handshake_timeout has passed.
+func TestServeConnHandshakeTimeout(t *testing.T) {
+	c, err := parseConfig(strings.NewReader(`
+host_keys = ["k"]
+authorized_keys = "a"
+[uploads]
+dir = "/d"
+[sessions]
+handshake_timeout = "50ms"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	cconn, sconn := net.Pipe()
+	defer cconn.Close()
+	go ioutil.ReadAll(cconn)
+	done := make(chan error, 1)
+	go func() { done <- c.serveConn(sconn, config, nil, nil) }()
+	select {
+	case err := <-done:
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			t.Errorf("got %v, want a timeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handshake did not time out")
+	}
+}
+
+// tempDir returns a new temporary directory, and a function removing it.
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "sftp-uploadd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"os"
+	"path/filepath"
+
+	"github.com/retailnext/sftp"
+)
+
+// profiles are the values of uploads.profile, by the permission profile
+// they configure for the upload path.
+var profiles = map[string]func(dir string) sftp.ServerOption{
+	"upload-only": sftp.UploadOnly,
+	"drop-box":    sftp.DropBox,
+	"none":        sftp.UploadPath,
+}
+
+// digests are the values of uploads.digests.
+var digests = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// serverOptions returns the options of the Server for a session whose
+// uploads are stored in dir.
+func (c *Config) serverOptions(dir string) []sftp.ServerOption {
+	opts := []sftp.ServerOption{
+		profiles[c.Profile](c.UploadPath),
+		sftp.FileNameMapper(func(name string) (string, bool, error) {
+			return filepath.Join(dir, filepath.FromSlash(name)), true, nil
+		}),
+	}
+	if c.FileSizeLimit > 0 {
+		opts = append(opts, sftp.WithFileSizeLimit(c.FileSizeLimit))
+	}
+	if c.FileMode != 0 {
+		opts = append(opts, sftp.WithFileMode(c.FileMode))
+	}
+	if c.NoOverwrite {
+		opts = append(opts, sftp.NoOverwrite())
+	}
+	if c.StagingDir != "" {
+		opts = append(opts, sftp.WithStagingDir(c.StagingDir))
+	}
+	if len(c.Digests) > 0 {
+		var ds []sftp.Digest
+		for _, name := range c.Digests {
+			ds = append(ds, sftp.Digest{Name: name, New: digests[name]})
+		}
+		opts = append(opts, sftp.WithDigests(ds...))
+	}
+	if c.Workers > 0 {
+		opts = append(opts, sftp.WithWorkers(c.Workers))
+	}
+	if c.IdleTimeout > 0 {
+		opts = append(opts, sftp.WithIdleTimeout(c.IdleTimeout))
+	}
+	if c.MaxDuration > 0 {
+		opts = append(opts, sftp.WithMaxSessionDuration(c.MaxDuration))
+	}
+	if c.MaxMessageSize > 0 {
+		opts = append(opts, sftp.WithMaxMessageSize(c.MaxMessageSize))
+	}
+	if c.RequestRate > 0 {
+		burst := c.RequestBurst
+		if burst <= 0 {
+			burst = 1
+		}
+		opts = append(opts, sftp.WithRequestRate(c.RequestRate, burst))
+	}
+	if len(c.RefusedClients) > 0 {
+		opts = append(opts, sftp.WithRefusedClients(c.RefusedClients...))
+	}
+	if c.ProjectQuota {
+		opts = append(opts, sftp.WithQuota(sftp.ProjectQuota{}))
+	}
+	return opts
+}
+
+// sessionLimits returns the limits on the sessions of each client, shared
+// by all connections.
+func (c *Config) sessionLimits() *sftp.SessionLimits {
+	return sftp.NewSessionLimits(c.MaxPerAddress, c.MaxPerUser)
+}
+
+// socketOptions returns the options of the sockets of SSH connections.
+func (c *Config) socketOptions() sftp.SocketOptions {
+	return sftp.SocketOptions{
+		Nagle:       c.Nagle,
+		ReadBuffer:  c.ReadBuffer,
+		WriteBuffer: c.WriteBuffer,
+		KeepAlive:   c.KeepAlive,
+	}
+}
+
+// userDir returns the directory storing the uploads of user, creating it
+// if it is per user.
+func (c *Config) userDir(user string) (string, error) {
+	if !c.PerUserDirs {
+		return c.Dir, nil
+	}
+	dir := filepath.Join(c.Dir, user)
+	return dir, os.MkdirAll(dir, 0750)
+}
@@ -1,9 +1,12 @@
 package sftp
 
 import (
+	"bufio"
 	"encoding"
 	"io"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -16,6 +19,8 @@ type conn struct {
 	sync.Mutex // used to serialise writes to sendPacket
 	// sendPacketTest is needed to replicate packet issues in testing
 	sendPacketTest func(w io.Writer, m encoding.BinaryMarshaler) error
+	// bw, if not nil, buffers packets until flush is called.
+	bw *bufio.Writer
 }
 
 func (c *conn) recvPacket() (uint8, []byte, error) {
@@ -25,17 +30,50 @@ func (c *conn) recvPacket() (uint8, []byte, error) {
 func (c *conn) sendPacket(m encoding.BinaryMarshaler) error {
 	c.Lock()
 	defer c.Unlock()
+	var w io.Writer = c
+	if c.bw != nil {
+		w = c.bw
+	}
+	if c.sendPacketTest != nil {
+		return c.sendPacketTest(w, m)
+	}
+	return sendPacket(w, m)
+}
+
+// sendPacketBuf is sendPacket for pa, encoding it into buf. It returns the
+// buffer, which may have grown, for reuse.
+func (c *conn) sendPacketBuf(buf []byte, pa packetAppender) ([]byte, error) {
+	c.Lock()
+	defer c.Unlock()
+	var w io.Writer = c
+	if c.bw != nil {
+		w = c.bw
+	}
 	if c.sendPacketTest != nil {
-		return c.sendPacketTest(c, m)
+		return buf, c.sendPacketTest(w, pa.(encoding.BinaryMarshaler))
 	}
-	return sendPacket(c, m)
+	return sendAppendedPacketBuf(w, buf, pa)
+}
+
+// flush writes any buffered packets.
+func (c *conn) flush() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.bw == nil {
+		return nil
+	}
+	return c.bw.Flush()
 }
 
 type clientConn struct {
 	conn
 	wg         sync.WaitGroup
-	sync.Mutex                          // protects inflight
+	sync.Mutex                          // protects inflight and closeErr
 	inflight   map[uint32]chan<- result // outstanding requests
+	closeErr   error                    // if set, why the session was closed
+	closed     chan struct{}            // closed once recv returns
+
+	lastRecv int64 // UnixNano of the last response, accessed atomically
 }
 
 // Close closes the SFTP session.
@@ -46,12 +84,49 @@ func (c *clientConn) Close() error {
 
 func (c *clientConn) loop() {
 	defer c.wg.Done()
+	defer close(c.closed)
 	err := c.recv()
 	if err != nil {
-		c.broadcastErr(err)
+		c.broadcastErr(c.sessionErr(err))
 	}
 }
 
+// closeWith closes the session, failing the outstanding and later requests
+// with err.
+func (c *clientConn) closeWith(err error) {
+	c.Lock()
+	c.closeErr = err
+	listeners := c.inflight
+	c.inflight = make(map[uint32]chan<- result)
+	c.Unlock()
+	c.conn.Close()
+	for _, ch := range listeners {
+		ch <- result{err: err}
+	}
+}
+
+// lost reports whether the session has ended.
+func (c *clientConn) lost() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+	}
+	c.Lock()
+	defer c.Unlock()
+	return c.closeErr != nil
+}
+
+// sessionErr returns the error given to closeWith, if any, or else err.
+func (c *clientConn) sessionErr(err error) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closeErr != nil {
+		return c.closeErr
+	}
+	return err
+}
+
 // recv continuously reads from the server and forwards responses to the
 // appropriate channel.
 func (c *clientConn) recv() error {
@@ -65,6 +140,7 @@ func (c *clientConn) recv() error {
 		if err != nil {
 			return err
 		}
+		atomic.StoreInt64(&c.lastRecv, time.Now().UnixNano())
 		sid, _ := unmarshalUint32(data)
 		c.Lock()
 		ch, ok := c.inflight[sid]
@@ -101,21 +177,38 @@ func (c *clientConn) sendPacket(p idmarshaler) (byte, []byte, error) {
 
 func (c *clientConn) dispatchRequest(ch chan<- result, p idmarshaler) {
 	c.Lock()
+	if err := c.closeErr; err != nil {
+		c.Unlock()
+		ch <- result{err: err}
+		return
+	}
 	c.inflight[p.id()] = ch
+	c.Unlock()
+	// Sending without holding the lock lets recv hand out responses
+	// meanwhile; otherwise a server blocked writing them would never read
+	// this request.
 	if err := c.conn.sendPacket(p); err != nil {
+		// Unless the session failed the request meanwhile, and so sent on
+		// ch already.
+		c.Lock()
+		_, ok := c.inflight[p.id()]
 		delete(c.inflight, p.id())
-		ch <- result{err: err}
+		c.Unlock()
+		if ok {
+			ch <- result{err: c.sessionErr(err)}
+		}
 	}
-	c.Unlock()
 }
 
-// broadcastErr sends an error to all goroutines waiting for a response.
+// broadcastErr sends an error to all goroutines waiting for a response,
+// and fails later requests with it, as no response will come.
 func (c *clientConn) broadcastErr(err error) {
 	c.Lock()
-	listeners := make([]chan<- result, 0, len(c.inflight))
-	for _, ch := range c.inflight {
-		listeners = append(listeners, ch)
+	if c.closeErr == nil {
+		c.closeErr = err
 	}
+	listeners := c.inflight
+	c.inflight = make(map[uint32]chan<- result)
 	c.Unlock()
 	for _, ch := range listeners {
 		ch <- result{err: err}
@@ -125,17 +218,3 @@ func (c *clientConn) broadcastErr(err error) {
 type serverConn struct {
 	conn
 }
-
-func (s *serverConn) sendError(p id, err error) error {
-	return s.sendPacket(statusFromError(p, err))
-}
-
-func (s *serverConn) sendErrorCode(p id, code uint32) error {
-	pkt := sshFxpStatusPacket{
-		ID: p.id(),
-		StatusError: StatusError{
-			Code: code,
-		},
-	}
-	return s.sendPacket(pkt)
-}
@@ -73,7 +73,7 @@ func main() {
 
 	// Before use, a handshake must be performed on the incoming
 	// net.Conn.
-	_, chans, reqs, err := ssh.NewServerConn(nConn, config)
+	sConn, chans, reqs, err := ssh.NewServerConn(nConn, config)
 	if err != nil {
 		log.Fatal("failed to handshake", err)
 	}
@@ -120,6 +120,7 @@ func main() {
 
 		serverOptions := []sftp.ServerOption{
 			sftp.WithDebug(debugStream),
+			sftp.WithSessionInfo(sftp.SessionInfoFromConn(sConn)),
 		}
 
 		if readOnly {
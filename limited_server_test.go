@@ -43,7 +43,7 @@ func TestLimitedServer(t *testing.T) {
 	}
 	var fileListPos int
 
-	notifyChan := make(chan string)
+	notifyChan := make(chan string, 1)
 	uploadNotifier := func(name string) {
 		notifyChan <- name
 	}
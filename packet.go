@@ -6,8 +6,12 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
 
 	"github.com/pkg/errors"
 )
@@ -114,8 +118,31 @@ func unmarshalStringSafe(b []byte) (string, []byte, error) {
 	return string(b[:n]), b[n:], nil
 }
 
+// A packetAppender is a packet which can marshal itself onto the end of b.
+// sendPacket encodes such packets into a pooled buffer, rather than one
+// allocated by MarshalBinary.
+type packetAppender interface {
+	appendPacket(b []byte) []byte
+}
+
+// maxPooledTxBuf is the capacity of the largest buffer kept in txBufPool.
+// One grown by a rare large packet, such as a long listing, is left to the
+// garbage collector rather than held for good.
+const maxPooledTxBuf = 64 * 1024
+
+// txBufPool holds buffers for encoding packetAppenders.
+var txBufPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 256)
+		return &b
+	},
+}
+
 // sendPacket marshals p according to RFC 4234.
 func sendPacket(w io.Writer, m encoding.BinaryMarshaler) error {
+	if pa, ok := m.(packetAppender); ok {
+		return sendAppendedPacket(w, pa)
+	}
 	bb, err := m.MarshalBinary()
 	if err != nil {
 		return errors.Errorf("binary marshaller failed: %v", err)
@@ -127,24 +154,74 @@ func sendPacket(w io.Writer, m encoding.BinaryMarshaler) error {
 	}
 	l := uint32(len(bb))
 	hdr := []byte{byte(l >> 24), byte(l >> 16), byte(l >> 8), byte(l)}
-	_, err = w.Write(hdr)
-	if err != nil {
-		return errors.Errorf("failed to send packet header: %v", err)
-	}
-	_, err = w.Write(bb)
-	if err != nil {
-		return errors.Errorf("failed to send packet body: %v", err)
+	// net.Buffers sends header and body with a single writev where w is
+	// a net.Conn, and with a Write each otherwise, as to an ssh.Channel.
+	bufs := net.Buffers{hdr, bb}
+	if _, err = bufs.WriteTo(w); err != nil {
+		return errors.Errorf("failed to send packet: %v", err)
 	}
 	return nil
 }
 
+func sendAppendedPacket(w io.Writer, pa packetAppender) error {
+	bp := txBufPool.Get().(*[]byte)
+	b, err := sendAppendedPacketBuf(w, *bp, pa)
+	if cap(b) <= maxPooledTxBuf {
+		*bp = b
+		txBufPool.Put(bp)
+	}
+	return err
+}
+
+// sendAppendedPacketBuf encodes pa into buf, which it may grow, and sends it
+// with a single Write. It returns the buffer for reuse.
+func sendAppendedPacketBuf(w io.Writer, buf []byte, pa packetAppender) ([]byte, error) {
+	b := pa.appendPacket(append(buf[:0], 0, 0, 0, 0))
+	l := uint32(len(b) - 4)
+	b[0], b[1], b[2], b[3] = byte(l>>24), byte(l>>16), byte(l>>8), byte(l)
+	if debugDumpTxPacketBytes {
+		debug("send packet: %s %d bytes %x", fxp(b[4]), l, b[5:])
+	} else if debugDumpTxPacket {
+		debug("send packet: %s %d bytes", fxp(b[4]), l)
+	}
+	if _, err := w.Write(b); err != nil {
+		return b, errors.Errorf("failed to send packet: %v", err)
+	}
+	return b, nil
+}
+
 func recvPacket(r io.Reader) (uint8, []byte, error) {
+	return recvPacketBuf(r, nil)
+}
+
+// recvPacketBuf is like recvPacket, but reads the packet into buf if it is
+// large enough. The returned data then aliases buf.
+func recvPacketBuf(r io.Reader, buf []byte) (uint8, []byte, error) {
+	l, err := recvPacketLength(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	return recvPacketBody(r, l, buf)
+}
+
+// recvPacketLength reads the length which precedes every packet.
+func recvPacketLength(r io.Reader) (uint32, error) {
 	var b = []byte{0, 0, 0, 0}
 	if _, err := io.ReadFull(r, b); err != nil {
-		return 0, nil, err
+		return 0, err
 	}
 	l, _ := unmarshalUint32(b)
-	b = make([]byte, l)
+	return l, nil
+}
+
+// recvPacketBody reads a packet of length l, into buf if it is large enough.
+func recvPacketBody(r io.Reader, l uint32, buf []byte) (uint8, []byte, error) {
+	var b []byte
+	if uint32(cap(buf)) >= l {
+		b = buf[:l]
+	} else {
+		b = make([]byte, l)
+	}
 	if _, err := io.ReadFull(r, b); err != nil {
 		debug("recv packet %d bytes: err %v", l, err)
 		return 0, nil, err
@@ -452,13 +529,23 @@ type sshFxpNameAttr struct {
 }
 
 func (p sshFxpNameAttr) MarshalBinary() ([]byte, error) {
-	b := []byte{}
+	return p.appendTo(make([]byte, 0, p.sizeHint())), nil
+}
+
+// sizeHint estimates the marshaled length of p, assuming its attributes are
+// a single os.FileInfo.
+func (p sshFxpNameAttr) sizeHint() int {
+	const fileInfoLen = 4 + 8 + 4 + 4 + 4 + 4 + 4 // flags, size, uid, gid, mode, atime, mtime
+	return 4 + len(p.Name) + 4 + len(p.LongName) + fileInfoLen
+}
+
+func (p sshFxpNameAttr) appendTo(b []byte) []byte {
 	b = marshalString(b, p.Name)
 	b = marshalString(b, p.LongName)
 	for _, attr := range p.Attrs {
 		b = marshal(b, attr)
 	}
-	return b, nil
+	return b
 }
 
 type sshFxpNamePacket struct {
@@ -467,19 +554,108 @@ type sshFxpNamePacket struct {
 }
 
 func (p sshFxpNamePacket) MarshalBinary() ([]byte, error) {
-	b := []byte{}
+	return p.appendPacket(nil), nil
+}
+
+func (p sshFxpNamePacket) appendPacket(b []byte) []byte {
+	if len(p.NameAttrs) > nameChunkSize && runtime.GOMAXPROCS(0) > 1 {
+		return p.appendPacketParallel(b)
+	}
+
+	// Size the buffer up front so that large listings are appended in
+	// place rather than reallocated as they grow.
+	l := 1 + 4 + 4
+	for _, na := range p.NameAttrs {
+		l += na.sizeHint()
+	}
+	if cap(b)-len(b) < l {
+		b = append(make([]byte, 0, len(b)+l), b...)
+	}
+
 	b = append(b, ssh_FXP_NAME)
 	b = marshalUint32(b, p.ID)
 	b = marshalUint32(b, uint32(len(p.NameAttrs)))
 	for _, na := range p.NameAttrs {
-		ab, err := na.MarshalBinary()
-		if err != nil {
-			return nil, err
+		b = na.appendTo(b)
+	}
+	return b
+}
+
+// nameChunkSize is the number of entries of a large listing marshaled by
+// each goroutine.
+const nameChunkSize = 1024
+
+// appendPacketParallel is appendPacket for huge listings, which marshals
+// chunks of the entries concurrently before joining them.
+func (p sshFxpNamePacket) appendPacketParallel(b []byte) []byte {
+	chunks := make([][]byte, (len(p.NameAttrs)+nameChunkSize-1)/nameChunkSize)
+	forEachChunk(len(p.NameAttrs), nameChunkSize, func(c, lo, hi int) {
+		l := 0
+		for _, na := range p.NameAttrs[lo:hi] {
+			l += na.sizeHint()
+		}
+		cb := make([]byte, 0, l)
+		for _, na := range p.NameAttrs[lo:hi] {
+			cb = na.appendTo(cb)
 		}
+		chunks[c] = cb
+	})
 
-		b = append(b, ab...)
+	l := 1 + 4 + 4
+	for _, cb := range chunks {
+		l += len(cb)
 	}
-	return b, nil
+	if cap(b)-len(b) < l {
+		b = append(make([]byte, 0, len(b)+l), b...)
+	}
+	b = append(b, ssh_FXP_NAME)
+	b = marshalUint32(b, p.ID)
+	b = marshalUint32(b, uint32(len(p.NameAttrs)))
+	for _, cb := range chunks {
+		b = append(b, cb...)
+	}
+	return b
+}
+
+// forEachChunk calls fn for each chunk [lo, hi) of at most size of [0, n),
+// numbered c from 0. Chunks are handled on up to GOMAXPROCS goroutines at
+// once; forEachChunk returns when all are done.
+func forEachChunk(n, size int, fn func(c, lo, hi int)) {
+	chunks := (n + size - 1) / size
+	workers := runtime.GOMAXPROCS(0)
+	if workers > chunks {
+		workers = chunks
+	}
+	if workers <= 1 {
+		for c := 0; c < chunks; c++ {
+			lo, hi := c*size, (c+1)*size
+			if hi > n {
+				hi = n
+			}
+			fn(c, lo, hi)
+		}
+		return
+	}
+	next := int32(-1)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				c := int(atomic.AddInt32(&next, 1))
+				if c >= chunks {
+					return
+				}
+				lo, hi := c*size, (c+1)*size
+				if hi > n {
+					hi = n
+				}
+				fn(c, lo, hi)
+			}
+		}()
+	}
+	wg.Wait()
 }
 
 type sshFxpOpenPacket struct {
@@ -595,6 +771,10 @@ type sshFxpWritePacket struct {
 	Offset uint64
 	Length uint32
 	Data   []byte
+
+	// stream, if not nil, is used in place of Data by the server to read
+	// the data of a write too large to buffer.
+	stream io.Reader
 }
 
 func (p sshFxpWritePacket) id() uint32 { return p.ID }
@@ -616,23 +796,35 @@ func (p sshFxpWritePacket) MarshalBinary() ([]byte, error) {
 }
 
 func (p *sshFxpWritePacket) UnmarshalBinary(b []byte) error {
-	var err error
-	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
-		return err
-	} else if p.Handle, b, err = unmarshalStringSafe(b); err != nil {
-		return err
-	} else if p.Offset, b, err = unmarshalUint64Safe(b); err != nil {
-		return err
-	} else if p.Length, b, err = unmarshalUint32Safe(b); err != nil {
+	b, err := p.unmarshalHeader(b)
+	if err != nil {
 		return err
 	} else if uint32(len(b)) < p.Length {
 		return errShortPacket
 	}
 
-	p.Data = append([]byte{}, b[:p.Length]...)
+	// Data aliases the receive buffer, which the server keeps until the
+	// write has been handled.
+	p.Data = b[:p.Length]
 	return nil
 }
 
+// unmarshalHeader decodes the fields preceding the data of a write, returning
+// the rest of b.
+func (p *sshFxpWritePacket) unmarshalHeader(b []byte) ([]byte, error) {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return nil, err
+	} else if p.Handle, b, err = unmarshalStringSafe(b); err != nil {
+		return nil, err
+	} else if p.Offset, b, err = unmarshalUint64Safe(b); err != nil {
+		return nil, err
+	} else if p.Length, b, err = unmarshalUint32Safe(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
 type sshFxpMkdirPacket struct {
 	ID    uint32
 	Path  string
@@ -743,10 +935,13 @@ type sshFxpHandlePacket struct {
 }
 
 func (p sshFxpHandlePacket) MarshalBinary() ([]byte, error) {
-	b := []byte{ssh_FXP_HANDLE}
+	return p.appendPacket(make([]byte, 0, 1+4+4+len(p.Handle))), nil
+}
+
+func (p sshFxpHandlePacket) appendPacket(b []byte) []byte {
+	b = append(b, ssh_FXP_HANDLE)
 	b = marshalUint32(b, p.ID)
-	b = marshalString(b, p.Handle)
-	return b, nil
+	return marshalString(b, p.Handle)
 }
 
 type sshFxpStatusPacket struct {
@@ -755,10 +950,21 @@ type sshFxpStatusPacket struct {
 }
 
 func (p sshFxpStatusPacket) MarshalBinary() ([]byte, error) {
-	b := []byte{ssh_FXP_STATUS}
+	l := 1 + 4 + 4 + 4 + len(p.msg) + 4 + len(p.lang)
+	return p.appendPacket(make([]byte, 0, l)), nil
+}
+
+// statusOK is the encoding of SSH_FX_OK with no message, which follows the
+// ID in most status replies.
+var statusOK = marshalStatus(nil, StatusError{Code: ssh_FX_OK})
+
+func (p sshFxpStatusPacket) appendPacket(b []byte) []byte {
+	b = append(b, ssh_FXP_STATUS)
 	b = marshalUint32(b, p.ID)
-	b = marshalStatus(b, p.StatusError)
-	return b, nil
+	if p.StatusError == (StatusError{Code: ssh_FX_OK}) {
+		return append(b, statusOK...)
+	}
+	return marshalStatus(b, p.StatusError)
 }
 
 type sshFxpDataPacket struct {
@@ -810,6 +1016,107 @@ func (p sshFxpStatvfsPacket) MarshalBinary() ([]byte, error) {
 	return b, nil
 }
 
+// sshFxpFsyncPacket is an fsync@openssh.com request.
+type sshFxpFsyncPacket struct {
+	ID     uint32
+	Handle string
+}
+
+func (p sshFxpFsyncPacket) id() uint32 { return p.ID }
+
+func (p sshFxpFsyncPacket) MarshalBinary() ([]byte, error) {
+	l := 1 + 4 + // type(byte) + uint32
+		4 + len("fsync@openssh.com") +
+		4 + len(p.Handle)
+
+	b := make([]byte, 0, l)
+	b = append(b, ssh_FXP_EXTENDED)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, "fsync@openssh.com")
+	b = marshalString(b, p.Handle)
+	return b, nil
+}
+
+// sshFxpHardlinkPacket is a hardlink@openssh.com request.
+type sshFxpHardlinkPacket struct {
+	ID      uint32
+	Oldpath string
+	Newpath string
+}
+
+func (p sshFxpHardlinkPacket) id() uint32 { return p.ID }
+
+func (p sshFxpHardlinkPacket) MarshalBinary() ([]byte, error) {
+	l := 1 + 4 + // type(byte) + uint32
+		4 + len("hardlink@openssh.com") +
+		4 + len(p.Oldpath) +
+		4 + len(p.Newpath)
+
+	b := make([]byte, 0, l)
+	b = append(b, ssh_FXP_EXTENDED)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, "hardlink@openssh.com")
+	b = marshalString(b, p.Oldpath)
+	b = marshalString(b, p.Newpath)
+	return b, nil
+}
+
+// sshFxpPosixRenamePacket is a posix-rename@openssh.com request.
+type sshFxpPosixRenamePacket struct {
+	ID      uint32
+	Oldpath string
+	Newpath string
+}
+
+func (p sshFxpPosixRenamePacket) id() uint32 { return p.ID }
+
+func (p sshFxpPosixRenamePacket) MarshalBinary() ([]byte, error) {
+	l := 1 + 4 + // type(byte) + uint32
+		4 + len("posix-rename@openssh.com") +
+		4 + len(p.Oldpath) +
+		4 + len(p.Newpath)
+
+	b := make([]byte, 0, l)
+	b = append(b, ssh_FXP_EXTENDED)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, "posix-rename@openssh.com")
+	b = marshalString(b, p.Oldpath)
+	b = marshalString(b, p.Newpath)
+	return b, nil
+}
+
+// sshFxpCopyDataPacket is a copy-data request, which copies Length bytes,
+// or up to the end of the file if zero, from ReadHandle at ReadOffset to
+// WriteHandle at WriteOffset.
+type sshFxpCopyDataPacket struct {
+	ID          uint32
+	ReadHandle  string
+	ReadOffset  uint64
+	Length      uint64
+	WriteHandle string
+	WriteOffset uint64
+}
+
+func (p sshFxpCopyDataPacket) id() uint32 { return p.ID }
+
+func (p sshFxpCopyDataPacket) MarshalBinary() ([]byte, error) {
+	l := 1 + 4 + // type(byte) + uint32
+		4 + len("copy-data") +
+		4 + len(p.ReadHandle) + 8 + 8 +
+		4 + len(p.WriteHandle) + 8
+
+	b := make([]byte, 0, l)
+	b = append(b, ssh_FXP_EXTENDED)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, "copy-data")
+	b = marshalString(b, p.ReadHandle)
+	b = marshalUint64(b, p.ReadOffset)
+	b = marshalUint64(b, p.Length)
+	b = marshalString(b, p.WriteHandle)
+	b = marshalUint64(b, p.WriteOffset)
+	return b, nil
+}
+
 // A StatVFS contains statistics about a filesystem.
 type StatVFS struct {
 	ID      uint32
@@ -836,6 +1143,13 @@ func (p *StatVFS) FreeSpace() uint64 {
 	return p.Frsize * p.Bfree
 }
 
+// AvailableSpace calculates the amount of free space in a filesystem
+// available to unprivileged users, which is less than FreeSpace by any
+// blocks reserved to root.
+func (p *StatVFS) AvailableSpace() uint64 {
+	return p.Frsize * p.Bavail
+}
+
 // Convert to ssh_FXP_EXTENDED_REPLY packet binary format
 func (p *StatVFS) MarshalBinary() ([]byte, error) {
 	var buf bytes.Buffer
@@ -873,6 +1187,10 @@ func (p *sshFxpExtendedPacket) UnmarshalBinary(b []byte) error {
 	switch p.ExtendedRequest {
 	case "statvfs@openssh.com":
 		p.SpecificPacket = &sshFxpExtendedPacketStatVFS{}
+	case "check-file-handle":
+		p.SpecificPacket = &sshFxpExtendedPacketCheckFileHandle{}
+	case receiptExtension:
+		p.SpecificPacket = &sshFxpExtendedPacketReceipt{}
 	default:
 		return errUnknownExtendedPacket
 	}
@@ -899,3 +1217,146 @@ func (p *sshFxpExtendedPacketStatVFS) UnmarshalBinary(b []byte) error {
 	}
 	return nil
 }
+
+// sshFxpExtendedPacketCheckFileHandle is a check-file-handle request, from
+// draft-ietf-secsh-filexfer-extensions.
+type sshFxpExtendedPacketCheckFileHandle struct {
+	ID              uint32
+	ExtendedRequest string
+	Handle          string
+	Algorithms      string // comma separated, in order of preference
+	Offset          uint64
+	Length          uint64 // 0 for the rest of the file
+	BlockSize       uint32 // 0 for a single hash of the whole range
+}
+
+func (p sshFxpExtendedPacketCheckFileHandle) id() uint32     { return p.ID }
+func (p sshFxpExtendedPacketCheckFileHandle) readonly() bool { return true }
+
+func (p sshFxpExtendedPacketCheckFileHandle) MarshalBinary() ([]byte, error) {
+	l := 1 + 4 + 4 + len("check-file-handle") + 4 + len(p.Handle) +
+		4 + len(p.Algorithms) + 8 + 8 + 4
+	b := make([]byte, 0, l)
+	b = append(b, ssh_FXP_EXTENDED)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, "check-file-handle")
+	b = marshalString(b, p.Handle)
+	b = marshalString(b, p.Algorithms)
+	b = marshalUint64(b, p.Offset)
+	b = marshalUint64(b, p.Length)
+	b = marshalUint32(b, p.BlockSize)
+	return b, nil
+}
+
+func (p *sshFxpExtendedPacketCheckFileHandle) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Handle, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Algorithms, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Offset, b, err = unmarshalUint64Safe(b); err != nil {
+		return err
+	} else if p.Length, b, err = unmarshalUint64Safe(b); err != nil {
+		return err
+	} else if p.BlockSize, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+// sshFxpExtendedPacketCheckFileName is a check-file-name request, from
+// draft-ietf-secsh-filexfer-extensions.
+type sshFxpExtendedPacketCheckFileName struct {
+	ID         uint32
+	Path       string
+	Algorithms string // comma separated, in order of preference
+	Offset     uint64
+	Length     uint64 // 0 for the rest of the file
+	BlockSize  uint32 // 0 for a single hash of the whole range
+}
+
+func (p sshFxpExtendedPacketCheckFileName) id() uint32 { return p.ID }
+
+func (p sshFxpExtendedPacketCheckFileName) MarshalBinary() ([]byte, error) {
+	l := 1 + 4 + 4 + len("check-file-name") + 4 + len(p.Path) +
+		4 + len(p.Algorithms) + 8 + 8 + 4
+	b := make([]byte, 0, l)
+	b = append(b, ssh_FXP_EXTENDED)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, "check-file-name")
+	b = marshalString(b, p.Path)
+	b = marshalString(b, p.Algorithms)
+	b = marshalUint64(b, p.Offset)
+	b = marshalUint64(b, p.Length)
+	b = marshalUint32(b, p.BlockSize)
+	return b, nil
+}
+
+// sshFxpExtendedPacketReceipt asks for the Receipt of the upload which was
+// closed with Handle.
+type sshFxpExtendedPacketReceipt struct {
+	ID              uint32
+	ExtendedRequest string
+	Handle          string
+}
+
+func (p sshFxpExtendedPacketReceipt) id() uint32     { return p.ID }
+func (p sshFxpExtendedPacketReceipt) readonly() bool { return true }
+
+func (p sshFxpExtendedPacketReceipt) MarshalBinary() ([]byte, error) {
+	l := 1 + 4 + 4 + len(receiptExtension) + 4 + len(p.Handle)
+	b := make([]byte, 0, l)
+	b = append(b, ssh_FXP_EXTENDED)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, receiptExtension)
+	return marshalString(b, p.Handle), nil
+}
+
+func (p *sshFxpExtendedPacketReceipt) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Handle, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+// sshFxpReceiptReply is the reply to a receipt request.
+type sshFxpReceiptReply struct {
+	ID      uint32
+	Receipt *Receipt
+}
+
+func (p sshFxpReceiptReply) MarshalBinary() ([]byte, error) {
+	signed := p.Receipt.signed()
+	b := make([]byte, 0, 1+4+len(signed)+4+len(p.Receipt.MAC))
+	b = append(b, ssh_FXP_EXTENDED_REPLY)
+	b = marshalUint32(b, p.ID)
+	b = append(b, signed...)
+	return marshalString(b, string(p.Receipt.MAC)), nil
+}
+
+// sshFxpCheckFileReply is the reply to a check-file-handle request: the
+// hashes of each block, concatenated.
+type sshFxpCheckFileReply struct {
+	ID        uint32
+	Algorithm string
+	Hashes    []byte
+}
+
+func (p sshFxpCheckFileReply) MarshalBinary() ([]byte, error) {
+	l := 1 + 4 + 4 + len("check-file") + 4 + len(p.Algorithm) + len(p.Hashes)
+	b := make([]byte, 0, l)
+	b = append(b, ssh_FXP_EXTENDED_REPLY)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, "check-file")
+	b = marshalString(b, p.Algorithm)
+	return append(b, p.Hashes...), nil
+}
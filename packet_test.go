@@ -3,8 +3,12 @@ package sftp
 import (
 	"bytes"
 	"encoding"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var marshalUint32Tests = []struct {
@@ -172,6 +176,44 @@ var sendPacketTests = []struct {
 			GID uint32
 		}{1000, 100},
 	}, []byte{0x0, 0x0, 0x0, 0x19, 0x9, 0x0, 0x0, 0x0, 0x1f, 0x0, 0x0, 0x0, 0x4, 0x2f, 0x62, 0x61, 0x72, 0x0, 0x0, 0x0, 0x2, 0x0, 0x0, 0x3, 0xe8, 0x0, 0x0, 0x0, 0x64}},
+
+	{sshFxpFsyncPacket{
+		ID:     7,
+		Handle: "foo",
+	}, []byte{0x0, 0x0, 0x0, 0x21, 0xc8, 0x0, 0x0, 0x0, 0x7, 0x0, 0x0, 0x0, 0x11, 0x66, 0x73, 0x79, 0x6e, 0x63, 0x40, 0x6f, 0x70, 0x65, 0x6e, 0x73, 0x73, 0x68, 0x2e, 0x63, 0x6f, 0x6d, 0x0, 0x0, 0x0, 0x3, 0x66, 0x6f, 0x6f}},
+
+	{sshFxpHardlinkPacket{
+		ID:      8,
+		Oldpath: "/foo",
+		Newpath: "/bar",
+	}, []byte{0x0, 0x0, 0x0, 0x2d, 0xc8, 0x0, 0x0, 0x0, 0x8, 0x0, 0x0, 0x0, 0x14, 0x68, 0x61, 0x72, 0x64, 0x6c, 0x69, 0x6e, 0x6b, 0x40, 0x6f, 0x70, 0x65, 0x6e, 0x73, 0x73, 0x68, 0x2e, 0x63, 0x6f, 0x6d, 0x0, 0x0, 0x0, 0x4, 0x2f, 0x66, 0x6f, 0x6f, 0x0, 0x0, 0x0, 0x4, 0x2f, 0x62, 0x61, 0x72}},
+
+	{sshFxpLimitsPacket{
+		ID: 9,
+	}, []byte{0x0, 0x0, 0x0, 0x1b, 0xc8, 0x0, 0x0, 0x0, 0x9, 0x0, 0x0, 0x0, 0x12, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x73, 0x40, 0x6f, 0x70, 0x65, 0x6e, 0x73, 0x73, 0x68, 0x2e, 0x63, 0x6f, 0x6d}},
+
+	{sshFxpExtendedPacketCheckFileName{
+		ID:         10,
+		Path:       "/foo",
+		Algorithms: "sha256",
+		Offset:     5,
+		Length:     100,
+	}, []byte{0x0, 0x0, 0x0, 0x3e, 0xc8, 0x0, 0x0, 0x0, 0xa, 0x0, 0x0, 0x0, 0xf, 0x63, 0x68, 0x65, 0x63, 0x6b, 0x2d, 0x66, 0x69, 0x6c, 0x65, 0x2d, 0x6e, 0x61, 0x6d, 0x65, 0x0, 0x0, 0x0, 0x4, 0x2f, 0x66, 0x6f, 0x6f, 0x0, 0x0, 0x0, 0x6, 0x73, 0x68, 0x61, 0x32, 0x35, 0x36, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x5, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x64, 0x0, 0x0, 0x0, 0x0}},
+
+	{sshFxpPosixRenamePacket{
+		ID:      11,
+		Oldpath: "/foo",
+		Newpath: "/bar",
+	}, []byte{0x0, 0x0, 0x0, 0x31, 0xc8, 0x0, 0x0, 0x0, 0xb, 0x0, 0x0, 0x0, 0x18, 0x70, 0x6f, 0x73, 0x69, 0x78, 0x2d, 0x72, 0x65, 0x6e, 0x61, 0x6d, 0x65, 0x40, 0x6f, 0x70, 0x65, 0x6e, 0x73, 0x73, 0x68, 0x2e, 0x63, 0x6f, 0x6d, 0x0, 0x0, 0x0, 0x4, 0x2f, 0x66, 0x6f, 0x6f, 0x0, 0x0, 0x0, 0x4, 0x2f, 0x62, 0x61, 0x72}},
+
+	{sshFxpCopyDataPacket{
+		ID:          12,
+		ReadHandle:  "r",
+		ReadOffset:  1,
+		Length:      2,
+		WriteHandle: "w",
+		WriteOffset: 3,
+	}, []byte{0x0, 0x0, 0x0, 0x34, 0xc8, 0x0, 0x0, 0x0, 0xc, 0x0, 0x0, 0x0, 0x9, 0x63, 0x6f, 0x70, 0x79, 0x2d, 0x64, 0x61, 0x74, 0x61, 0x0, 0x0, 0x0, 0x1, 0x72, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x2, 0x0, 0x0, 0x0, 0x1, 0x77, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x3}},
 }
 
 func TestSendPacket(t *testing.T) {
@@ -213,6 +255,21 @@ func TestRecvPacket(t *testing.T) {
 	}
 }
 
+func TestRecvPacketBuf(t *testing.T) {
+	for _, tt := range recvPacketTests {
+		for _, size := range []int{0, len(tt.b)} {
+			buf := make([]byte, size)
+			got, rest, _ := recvPacketBuf(bytes.NewReader(tt.b), buf)
+			if got != tt.want || !bytes.Equal(rest, tt.rest) {
+				t.Errorf("recvPacketBuf(%#v, %d): want %v, %#v, got %v, %#v", tt.b, size, tt.want, tt.rest, got, rest)
+			}
+			if size > 0 && &rest[0] != &buf[1] {
+				t.Errorf("recvPacketBuf(%#v, %d): buffer not used", tt.b, size)
+			}
+		}
+	}
+}
+
 func TestSSHFxpOpenPacketreadonly(t *testing.T) {
 	var tests = []struct {
 		pflags uint32
@@ -343,3 +400,81 @@ func BenchmarkMarshalWrite1k(b *testing.B) {
 		})
 	}
 }
+
+func TestMarshalNameParallel(t *testing.T) {
+	p := sshFxpNamePacket{ID: 1, NameAttrs: make([]sshFxpNameAttr, 3*nameChunkSize+7)}
+	var want []byte
+	for i := range p.NameAttrs {
+		fi := &fileInfo{name: fmt.Sprintf("file%d", i), size: int64(i), mode: 0644, mtime: time.Unix(1500000000, 0)}
+		p.NameAttrs[i] = sshFxpNameAttr{Name: fi.name, LongName: fi.name, Attrs: []interface{}{fi}}
+		want = p.NameAttrs[i].appendTo(want)
+	}
+	got := p.appendPacketParallel(nil)
+	if !bytes.Equal(got[9:], want) {
+		t.Error("entries marshaled in parallel differ from serial encoding")
+	}
+}
+
+// A buffer grown past maxPooledTxBuf by a large packet is not pooled.
+func TestSendPacketLargeBufferNotPooled(t *testing.T) {
+	name := string(bytes.Repeat([]byte("n"), 2*maxPooledTxBuf))
+	p := sshFxpNamePacket{ID: 1, NameAttrs: []sshFxpNameAttr{{Name: name, LongName: name, Attrs: []interface{}{&fileInfo{name: name}}}}}
+	if err := sendPacket(ioutil.Discard, p); err != nil {
+		t.Fatal(err)
+	}
+	bp := txBufPool.Get().(*[]byte)
+	defer txBufPool.Put(bp)
+	if cap(*bp) > maxPooledTxBuf {
+		t.Errorf("pooled a buffer of %d bytes", cap(*bp))
+	}
+}
+
+func TestForEachChunk(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 11, 95} {
+		seen := make([]int32, n)
+		forEachChunk(n, 10, func(c, lo, hi int) {
+			if lo != c*10 || hi-lo > 10 || hi > n {
+				t.Errorf("n=%d: bad chunk %d [%d, %d)", n, c, lo, hi)
+			}
+			for i := lo; i < hi; i++ {
+				atomic.AddInt32(&seen[i], 1)
+			}
+		})
+		for i, k := range seen {
+			if k != 1 {
+				t.Errorf("n=%d: item %d seen %d times", n, i, k)
+			}
+		}
+	}
+}
+
+func BenchmarkMarshalName100k(b *testing.B) {
+	p := sshFxpNamePacket{ID: 1, NameAttrs: make([]sshFxpNameAttr, 100000)}
+	for i := range p.NameAttrs {
+		fi := &fileInfo{name: fmt.Sprintf("file%06d", i), size: int64(i), mode: 0644, mtime: time.Unix(1500000000, 0)}
+		p.NameAttrs[i] = sshFxpNameAttr{
+			Name:     fi.name,
+			LongName: "-rw-r--r--    1 0        0        " + fi.name,
+			Attrs:    []interface{}{fi},
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.MarshalBinary()
+	}
+}
+
+func BenchmarkSendStatusOK(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		sendPacket(ioutil.Discard, statusFromError(&sshFxpWritePacket{ID: uint32(i)}, nil))
+	}
+}
+
+func BenchmarkSendStatusError(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		sendPacket(ioutil.Discard, statusFromError(&sshFxpWritePacket{ID: uint32(i)}, os.ErrNotExist))
+	}
+}
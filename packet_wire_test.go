@@ -0,0 +1,212 @@
+package sftp
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/retailnext/sftp/sftpwire"
+)
+
+// The packets of sftpwire must encode as this package's own do: there is a
+// case here for every packet this package encodes.
+func TestWireEncodings(t *testing.T) {
+	attrs := sftpwire.Attrs{Flags: sftpwire.AttrSize, Size: 10}
+	mtime := time.Unix(1000000000, 0)
+	info := &fileInfo{name: "f", size: 5, mode: 0644, mtime: mtime}
+	infoAttrs := sftpwire.Attrs{
+		Flags:       sftpwire.AttrSize | sftpwire.AttrPermissions | sftpwire.AttrACModTime,
+		Size:        5,
+		Permissions: fromFileMode(0644),
+		Atime:       uint32(mtime.Unix()),
+		Mtime:       uint32(mtime.Unix()),
+	}
+	checked := make(map[string]bool)
+	defer func() {
+		for _, name := range marshaledTypes(t) {
+			if !checked[name] {
+				t.Errorf("no case for %s", name)
+			}
+		}
+	}()
+	for _, tt := range []struct {
+		p    interface{ MarshalBinary() ([]byte, error) }
+		wire sftpwire.Packet
+	}{
+		{sshFxInitPacket{Version: 3, Extensions: []extensionPair{{"a", "b"}}},
+			&sftpwire.Init{Version: 3, Extensions: []sftpwire.Extension{{Name: "a", Data: "b"}}}},
+		{sshFxVersionPacket{Version: 3, Extensions: []struct{ Name, Data string }{{"a", "b"}}},
+			&sftpwire.Version{Version: 3, Extensions: []sftpwire.Extension{{Name: "a", Data: "b"}}}},
+		{sshFxpOpenPacket{ID: 1, Path: "/f", Pflags: ssh_FXF_WRITE | ssh_FXF_CREAT},
+			&sftpwire.Open{ID: 1, Path: "/f", Pflags: sftpwire.OpenWrite | sftpwire.OpenCreate}},
+		{sshFxpClosePacket{ID: 2, Handle: "h"}, &sftpwire.Close{ID: 2, Handle: "h"}},
+		{sshFxpReadPacket{ID: 3, Handle: "h", Offset: 1 << 40, Len: 100},
+			&sftpwire.Read{ID: 3, Handle: "h", Offset: 1 << 40, Len: 100}},
+		{sshFxpWritePacket{ID: 4, Handle: "h", Offset: 7, Length: 4, Data: []byte("data")},
+			&sftpwire.Write{ID: 4, Handle: "h", Offset: 7, Data: []byte("data")}},
+		{sshFxpLstatPacket{ID: 5, Path: "/l"}, &sftpwire.Lstat{ID: 5, Path: "/l"}},
+		{sshFxpFstatPacket{ID: 6, Handle: "h"}, &sftpwire.Fstat{ID: 6, Handle: "h"}},
+		{sshFxpSetstatPacket{ID: 7, Path: "/s", Flags: ssh_FILEXFER_ATTR_SIZE, Attrs: uint64(10)},
+			&sftpwire.Setstat{ID: 7, Path: "/s", Attrs: attrs}},
+		{sshFxpFsetstatPacket{ID: 8, Handle: "h", Flags: ssh_FILEXFER_ATTR_SIZE, Attrs: uint64(10)},
+			&sftpwire.Fsetstat{ID: 8, Handle: "h", Attrs: attrs}},
+		{sshFxpOpendirPacket{ID: 9, Path: "/d"}, &sftpwire.Opendir{ID: 9, Path: "/d"}},
+		{sshFxpReaddirPacket{ID: 10, Handle: "h"}, &sftpwire.Readdir{ID: 10, Handle: "h"}},
+		{sshFxpRemovePacket{ID: 11, Filename: "/r"}, &sftpwire.Remove{ID: 11, Filename: "/r"}},
+		{sshFxpMkdirPacket{ID: 12, Path: "/m"}, &sftpwire.Mkdir{ID: 12, Path: "/m"}},
+		{sshFxpRmdirPacket{ID: 13, Path: "/m"}, &sftpwire.Rmdir{ID: 13, Path: "/m"}},
+		{sshFxpRealpathPacket{ID: 14, Path: "."}, &sftpwire.Realpath{ID: 14, Path: "."}},
+		{sshFxpStatPacket{ID: 15, Path: "/s"}, &sftpwire.Stat{ID: 15, Path: "/s"}},
+		{sshFxpRenamePacket{ID: 16, Oldpath: "/a", Newpath: "/b"},
+			&sftpwire.Rename{ID: 16, Oldpath: "/a", Newpath: "/b"}},
+		{sshFxpReadlinkPacket{ID: 17, Path: "/l"}, &sftpwire.Readlink{ID: 17, Path: "/l"}},
+		{sshFxpSymlinkPacket{ID: 18, Targetpath: "/t", Linkpath: "/l"},
+			&sftpwire.Symlink{ID: 18, Targetpath: "/t", Linkpath: "/l"}},
+		{sshFxpStatusPacket{ID: 19, StatusError: StatusError{Code: ssh_FX_FAILURE, msg: "m", lang: "en"}},
+			&sftpwire.Status{ID: 19, Code: sftpwire.StatusFailure, Message: "m", Lang: "en"}},
+		{sshFxpStatusPacket{ID: 19, StatusError: StatusError{Code: ssh_FX_OK}},
+			&sftpwire.Status{ID: 19, Code: sftpwire.StatusOK}},
+		{sshFxpHandlePacket{ID: 20, Handle: "0"}, &sftpwire.Handle{ID: 20, Handle: "0"}},
+		{sshFxpDataPacket{ID: 21, Length: 4, Data: []byte("data")}, &sftpwire.Data{ID: 21, Data: []byte("data")}},
+		{sshFxpStatvfsPacket{ID: 22, Path: "/"},
+			&sftpwire.Extended{ID: 22, Request: "statvfs@openssh.com", Data: []byte("\x00\x00\x00\x01/")}},
+		{sshFxpNamePacket{ID: 23, NameAttrs: []sshFxpNameAttr{{Name: "f", LongName: "l", Attrs: []interface{}{info}}}},
+			&sftpwire.Name{ID: 23, Entries: []sftpwire.NameEntry{{Filename: "f", Longname: "l", Attrs: infoAttrs}}}},
+		{sshFxpNamePacket{ID: 23}, &sftpwire.Name{ID: 23, Entries: []sftpwire.NameEntry{}}},
+		{sshFxpStatResponse{ID: 24, info: info}, &sftpwire.AttrsPacket{ID: 24, Attrs: infoAttrs}},
+		{&StatVFS{ID: 25, Bsize: 1, Frsize: 2, Blocks: 3, Bfree: 4, Bavail: 5, Files: 6, Ffree: 7, Favail: 8, Fsid: 9, Flag: 10, Namemax: 11},
+			&sftpwire.ExtendedReply{ID: 25, Data: wireUint64s(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11)}},
+		{sshFxpFsyncPacket{ID: 26, Handle: "h"},
+			&sftpwire.Extended{ID: 26, Request: "fsync@openssh.com", Data: wireStrings("h")}},
+		{sshFxpHardlinkPacket{ID: 27, Oldpath: "/a", Newpath: "/b"},
+			&sftpwire.Extended{ID: 27, Request: "hardlink@openssh.com", Data: wireStrings("/a", "/b")}},
+		{sshFxpPosixRenamePacket{ID: 28, Oldpath: "/a", Newpath: "/b"},
+			&sftpwire.Extended{ID: 28, Request: "posix-rename@openssh.com", Data: wireStrings("/a", "/b")}},
+		{sshFxpCopyDataPacket{ID: 29, ReadHandle: "r", ReadOffset: 1, Length: 2, WriteHandle: "w", WriteOffset: 3},
+			&sftpwire.Extended{ID: 29, Request: "copy-data",
+				Data: append(append(wireStrings("r"), wireUint64s(1, 2)...), append(wireStrings("w"), wireUint64s(3)...)...)}},
+		{sshFxpLimitsPacket{ID: 30}, &sftpwire.Extended{ID: 30, Request: "limits@openssh.com"}},
+		{sshFxpGetxattrPacket{ID: 31, Path: "/f", Name: "user.a"},
+			&sftpwire.Extended{ID: 31, Request: "getxattr@retailnext.com", Data: wireStrings("/f", "user.a")}},
+		{sshFxpSetxattrPacket{ID: 32, Path: "/f", Name: "user.a", Value: []byte("v")},
+			&sftpwire.Extended{ID: 32, Request: "setxattr@retailnext.com", Data: wireStrings("/f", "user.a", "v")}},
+		{sshFxpListxattrPacket{ID: 33, Path: "/f"},
+			&sftpwire.Extended{ID: 33, Request: "listxattr@retailnext.com", Data: wireStrings("/f")}},
+		{sshFxpExtendedPacketCheckFileHandle{ID: 34, Handle: "h", Algorithms: "sha256,md5", Offset: 1, Length: 2, BlockSize: 3},
+			&sftpwire.Extended{ID: 34, Request: "check-file-handle",
+				Data: append(append(wireStrings("h", "sha256,md5"), wireUint64s(1, 2)...), 0, 0, 0, 3)}},
+		{sshFxpExtendedPacketCheckFileName{ID: 35, Path: "/f", Algorithms: "sha256", Offset: 1, Length: 2, BlockSize: 3},
+			&sftpwire.Extended{ID: 35, Request: "check-file-name",
+				Data: append(append(wireStrings("/f", "sha256"), wireUint64s(1, 2)...), 0, 0, 0, 3)}},
+		{sshFxpCheckFileReply{ID: 36, Algorithm: "sha256", Hashes: []byte("hash")},
+			&sftpwire.ExtendedReply{ID: 36, Data: append(wireStrings("check-file", "sha256"), "hash"...)}},
+		{sshFxpExtendedPacketReceipt{ID: 37, Handle: "h"},
+			&sftpwire.Extended{ID: 37, Request: receiptExtension, Data: wireStrings("h")}},
+		{sshFxpReceiptReply{ID: 38, Receipt: &Receipt{Name: "/f", Size: 1, Algorithm: "sha256", Digest: []byte("d"), Time: time.Unix(0, 2), MAC: []byte("m")}},
+			&sftpwire.ExtendedReply{ID: 38,
+				Data: append(append(wireStrings("/f"), wireUint64s(1)...), append(append(wireStrings("sha256", "d"), wireUint64s(2)...), wireStrings("m")...)...)}},
+	} {
+		checked[reflect.Indirect(reflect.ValueOf(tt.p)).Type().Name()] = true
+		want, err := tt.p.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := tt.wire.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("%T: sftpwire encodes %x, want %x", tt.p, got, want)
+		}
+		p, err := sftpwire.Decode(want)
+		if err != nil {
+			t.Errorf("%T: %v", tt.p, err)
+		} else if !reflect.DeepEqual(p, tt.wire) {
+			t.Errorf("%T: decoded %#v, want %#v", tt.p, p, tt.wire)
+		}
+	}
+}
+
+// marshaledTypes returns the names of the types of this package, other than
+// sshFxpNameAttr, the entries of sshFxpNamePacket, with a MarshalBinary
+// method: the packets it encodes.
+func marshaledTypes(t *testing.T) []string {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range pkgs["sftp"].Files {
+		for _, d := range f.Decls {
+			fn, ok := d.(*ast.FuncDecl)
+			if !ok || fn.Recv == nil || fn.Name.Name != "MarshalBinary" {
+				continue
+			}
+			recv := fn.Recv.List[0].Type
+			if star, ok := recv.(*ast.StarExpr); ok {
+				recv = star.X
+			}
+			if name := recv.(*ast.Ident).Name; name != "sshFxpNameAttr" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// wireStrings returns the encoding of ss, one after the other.
+func wireStrings(ss ...string) []byte {
+	b := []byte{}
+	for _, s := range ss {
+		b = marshalString(b, s)
+	}
+	return b
+}
+
+// wireUint64s returns the encoding of vs, one after the other.
+func wireUint64s(vs ...uint64) []byte {
+	b := []byte{}
+	for _, v := range vs {
+		b = marshalUint64(b, v)
+	}
+	return b
+}
+
+// The listings and attributes the Server sends must decode with sftpwire.
+func TestWireDecodesServerResponses(t *testing.T) {
+	mtime := time.Unix(1000000000, 0)
+	info := &fileInfo{name: "f", size: 5, mode: 0644, mtime: mtime}
+	name := sshFxpNamePacket{ID: 1, NameAttrs: []sshFxpNameAttr{
+		{Name: "f", LongName: runLs("/", info), Attrs: []interface{}{info}},
+	}}
+	b, _ := name.MarshalBinary()
+	p, err := sftpwire.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := p.(*sftpwire.Name)
+	if len(n.Entries) != 1 || n.Entries[0].Filename != "f" || n.Entries[0].Longname != runLs("/", info) {
+		t.Fatalf("got %#v", n)
+	}
+	a := n.Entries[0].Attrs
+	if a.Size != 5 || a.Mtime != uint32(mtime.Unix()) || os.FileMode(a.Permissions).Perm() != 0644 {
+		t.Errorf("got attributes %#v", a)
+	}
+
+	b, _ = sshFxpStatResponse{ID: 2, info: info}.MarshalBinary()
+	p, err = sftpwire.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := p.(*sftpwire.AttrsPacket); a.ID != 2 || a.Attrs.Size != 5 {
+		t.Errorf("got %#v", a)
+	}
+}
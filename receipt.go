@@ -0,0 +1,97 @@
+package sftp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"time"
+)
+
+// receiptExtension names the extended request for the receipt of an upload.
+const receiptExtension = "upload-receipt@retailnext.com"
+
+// A Receipt is a Server's signed acknowledgement of an upload, which a
+// client can keep as proof of delivery. It is signed with the key given to
+// WithReceipts, with which the server operator can check it.
+type Receipt struct {
+	Name      string // path requested by the client
+	Size      int64
+	Algorithm string // name of the Digest
+	Digest    []byte
+	Time      time.Time // when the upload was accepted
+	MAC       []byte    // HMAC-SHA256 of the other fields
+}
+
+// signed returns the encoding of the fields of r covered by its MAC.
+func (r *Receipt) signed() []byte {
+	b := make([]byte, 0, 4+len(r.Name)+8+4+len(r.Algorithm)+4+len(r.Digest)+8)
+	b = marshalString(b, r.Name)
+	b = marshalUint64(b, uint64(r.Size))
+	b = marshalString(b, r.Algorithm)
+	b = marshalString(b, string(r.Digest))
+	return marshalUint64(b, uint64(r.Time.UnixNano()))
+}
+
+func (r *Receipt) sign(key []byte) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(r.signed())
+	r.MAC = mac.Sum(nil)
+}
+
+// Verify reports whether r was signed with key.
+func (r *Receipt) Verify(key []byte) bool {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(r.signed())
+	return hmac.Equal(r.MAC, mac.Sum(nil))
+}
+
+func unmarshalReceipt(b []byte) (*Receipt, error) {
+	var r Receipt
+	var err error
+	var size, t uint64
+	var digest, mac string
+	if r.Name, b, err = unmarshalStringSafe(b); err != nil {
+		return nil, err
+	} else if size, b, err = unmarshalUint64Safe(b); err != nil {
+		return nil, err
+	} else if r.Algorithm, b, err = unmarshalStringSafe(b); err != nil {
+		return nil, err
+	} else if digest, b, err = unmarshalStringSafe(b); err != nil {
+		return nil, err
+	} else if t, b, err = unmarshalUint64Safe(b); err != nil {
+		return nil, err
+	} else if mac, _, err = unmarshalStringSafe(b); err != nil {
+		return nil, err
+	}
+	r.Size = int64(size)
+	r.Digest = []byte(digest)
+	r.Time = time.Unix(0, int64(t))
+	r.MAC = []byte(mac)
+	return &r, nil
+}
+
+// Receipt returns the server's receipt for the upload of f, which must have
+// been closed. The server must support the upload-receipt@retailnext.com
+// extension, as a Server with WithReceipts does.
+func (f *File) Receipt() (*Receipt, error) {
+	id := f.c.nextID()
+	typ, data, err := f.sendPacketContext(context.Background(), sshFxpExtendedPacketReceipt{
+		ID:     id,
+		Handle: f.handle,
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case ssh_FXP_EXTENDED_REPLY:
+		sid, data := unmarshalUint32(data)
+		if sid != id {
+			return nil, &unexpectedIDErr{id, sid}
+		}
+		return unmarshalReceipt(data)
+	case ssh_FXP_STATUS:
+		return nil, normaliseError(unmarshalStatus(id, data))
+	default:
+		return nil, unimplementedPacketErr(typ)
+	}
+}
@@ -3,15 +3,17 @@ package sftp
 // sftp server counterpart
 
 import (
+	"bufio"
 	"encoding"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"path"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -27,6 +29,25 @@ type openDirInfo struct {
 	read bool
 }
 
+// openFile is a file opened by a client, along with its transfer state.
+type openFile struct {
+	*os.File
+	remotePath string // path requested by the client
+	finalPath  string // path the file is moved to once closed, if staged
+	quotaLimit int64  // size the file may reach under the quota, or -1
+	opened     time.Time
+	written    int64             // bytes written; accessed atomically
+	dir        *openDirInfo      // nil unless opened as a directory
+	wb         writeBackend      // nil to write with WriteAt
+	digests    *fileDigests      // nil unless WithDigests
+	sums       map[string][]byte // digests, once closed
+	discard    bool              // writes are discarded, in honeypot mode
+	coalesce   *writeBuffer      // nil unless WithWriteCoalescing
+
+	sampleLock sync.Mutex
+	sample     *payloadSample // nil unless sampling is enabled
+}
+
 // Server is an SSH File Transfer Protocol (sftp) server.
 // This is intended to provide the sftp subsystem to an ssh server daemon.
 // This implementation currently supports most of sftp server protocol version 3,
@@ -34,65 +55,312 @@ type openDirInfo struct {
 type Server struct {
 	serverConn
 	debugStream    io.Writer
+	debugFormatter DebugFormatter
+	capture        *capture // nil unless WithCapture
+	clock          Clock
 	readOnly       bool
-	pktChan        chan rxPacket
-	openFiles      map[string]*os.File
-	openDirs       map[string]*openDirInfo
-	openFilesLock  sync.RWMutex
-	handleCount    int
+	readOnlyPaths  []string // cleaned, under which writes are refused
+	minVersion     uint32   // 0 for any SFTP version
+	refusedClients []string
+	noOverwrite    bool
+	workerCount    int
+	queueDepth     int // -1 for the worker count
+	overloadPolicy OverloadPolicy
+	handles        *handleTable
 	maxTxPacket    uint32
+	maxMessageSize uint32    // 0 for no limit
+	rxBufs         sync.Pool // of *[]byte receive buffers
 	uploadPath     string
+	stagingDir     string // "" to write uploads in place
 	fileSizeLimit  int64
+	fileMode       os.FileMode // 0 for the default
+	fileOwner      *fileOwner  // nil to leave uploads owned by the server
+	quota          Quota
+	digests        []Digest
+	receipts       *receiptStore // nil unless WithReceipts
+	fileNamePolicy FileNamePolicy
+	errorMessages  ErrorMessages
+	idleTimeout    time.Duration
+	maxDuration    time.Duration
 	fileNameMapper func(string) (string, bool, error)
 	uploadNotifier func(string)
+	finalizeHook   func(FinalizingUpload) error
+	approve        func(*PendingUpload)
+	approveWithin  time.Duration
+	held           sync.WaitGroup // uploads held for approval
+	leakNotifier   func(LeakedHandle)
+	denyNotifier   func(OperationDenied)
+	sampleSize     int
+	sampleNotifier func(UploadSample)
 	opendirHook    func()
 	readdirHook    func() ([]os.FileInfo, error)
+	noLongNames    bool
+	arenas         *arenaTable // nil unless WithRequestArenas
+	uringEntries   uint32
+	writeBackend   writeBackend // nil for os.File.WriteAt
+	coalesceWindow int          // 0 to write each request as it arrives
+	memoryBudget   *MemoryBudget
+	symlinkPolicy  SymlinkPolicy
+	authorizer     func(Operation, string, SessionInfo) error
+	honeypot       func(HoneypotAction)
+	window         chan struct{} // a token per unanswered request, if limited
+	requestRate    *requestRate
+	stats          *serverStats
+	sharedStats    *StatsCollector
+
+	sessionLock      sync.Mutex // protects session against Status
+	session          SessionInfo
+	sessionStartHook func(SessionInfo)
+	sessionEndHook   func(SessionSummary)
+
+	eventsLock   sync.Mutex
+	eventChans   []chan Event
+	eventsClosed bool
+
+	faults *faultInjector
+
+	clientVersion  uint32 // from SSH_FXP_INIT; accessed atomically
+	inFlightBytes  int64  // accessed atomically
+	pendingPackets int64  // queued or being handled; accessed atomically
+	lastActive     int64  // UnixNano, for idleTimeout; accessed atomically
+	idleExpired    uint32 // accessed atomically
+	sessionExpired uint32 // accessed atomically
 }
 
-func (svr *Server) nextHandle(f *os.File, dirName string) string {
-	svr.openFilesLock.Lock()
-	defer svr.openFilesLock.Unlock()
-	svr.handleCount++
-	handle := strconv.Itoa(svr.handleCount)
-	svr.openFiles[handle] = f
+// nextHandle adds of, a file just opened as dirName if that is not "", to
+// the open handles, and returns its handle.
+func (svr *Server) nextHandle(of *openFile, dirName string) string {
+	of.opened = svr.clock.Now()
+	of.wb = svr.writeBackend
 	if dirName != "" {
-		svr.openDirs[handle] = &openDirInfo{name: dirName}
+		of.dir = &openDirInfo{name: dirName}
+	} else {
+		if svr.sampleSize > 0 {
+			of.sample = &payloadSample{buf: make([]byte, svr.sampleSize)}
+		}
+		of.digests = svr.newFileDigests()
+		if svr.coalesceWindow > 0 {
+			of.coalesce = &writeBuffer{window: svr.coalesceWindow}
+		}
 	}
-	return handle
+	return svr.handles.add(of)
 }
 
-func (svr *Server) closeHandle(handle string) error {
-	svr.openFilesLock.Lock()
-	defer svr.openFilesLock.Unlock()
-	if f, ok := svr.openFiles[handle]; ok {
-		delete(svr.openFiles, handle)
-		_, isDir := svr.openDirs[handle]
-		if isDir {
-			delete(svr.openDirs, handle)
+// closeHandle closes handle and finishes the upload, if it is one, calling
+// reply with the result. An upload held for approval is finished, and
+// reply called, by whoever decides on it, so that the worker is free to
+// handle other requests meanwhile.
+func (svr *Server) closeHandle(handle string, reply func(error) error) error {
+	f, ok := svr.handles.remove(handle)
+	if !ok {
+		return reply(svr.handleError(handle))
+	}
+	err := f.flush()
+	if f.digests != nil {
+		var derr error
+		if f.sums, derr = svr.digestSums(f); err == nil {
+			err = derr
 		}
-		fileName := f.Name()
-		err := f.Close()
-		if svr.uploadNotifier != nil && !isDir {
-			svr.uploadNotifier(fileName)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && f.dir == nil {
+		err = svr.finalizeUpload(f)
+	}
+	closed := func(err error) error {
+		if err != nil {
+			discardStaged(f)
+		} else if f.dir == nil {
+			svr.addReceipt(handle, f)
 		}
+		if f.dir == nil {
+			svr.uploadClosed(handle, f, err)
+		}
+		return reply(err)
+	}
+	if err == nil && svr.approve != nil && f.dir == nil && !f.discard {
+		svr.holdForApproval(f, func(err error) error {
+			if err == nil {
+				err = svr.placeUpload(f)
+			}
+			return closed(err)
+		})
+		return nil
+	}
+	if err == nil && f.dir == nil {
+		err = svr.placeUpload(f)
+	}
+	return closed(err)
+}
+
+// uploadClosed reports the close of the upload f, which failed with err if
+// that is not nil.
+func (svr *Server) uploadClosed(handle string, f *openFile, err error) {
+	fileName := f.path()
+	written := atomic.LoadInt64(&f.written)
+	d := svr.clock.Now().Sub(f.opened)
+	svr.recordUpload(written, d)
+	sample := f.sampleBytes()
+	svr.emit(FileClosed{
+		Session:    svr.session,
+		Handle:     handle,
+		RemotePath: f.remotePath,
+		Path:       fileName,
+		Written:    written,
+		Duration:   d,
+		Sample:     sample,
+		Digests:    f.sums,
+		Err:        err,
+	})
+	if svr.sampleNotifier != nil && sample != nil {
+		svr.sampleNotifier(UploadSample{
+			Session:    svr.session,
+			RemotePath: f.remotePath,
+			Path:       fileName,
+			Size:       written,
+			Data:       sample,
+		})
+	}
+	if svr.uploadNotifier != nil && !f.discard {
+		svr.uploadNotifier(fileName)
+	}
+}
+
+// finalizeUpload prepares the upload of f, once it has been closed, to be
+// moved into place, giving it its owner and, if it is staged, its file
+// mode, and running the finalize hook.
+func (svr *Server) finalizeUpload(f *openFile) error {
+	if f.discard {
+		return nil
+	}
+	if f.finalPath != "" && svr.fileMode != 0 {
+		if err := os.Chmod(f.Name(), svr.fileMode); err != nil {
+			return err
+		}
+	}
+	if svr.fileOwner != nil {
+		if err := os.Chown(f.Name(), svr.fileOwner.uid, svr.fileOwner.gid); err != nil {
+			return err
+		}
+	}
+	if svr.finalizeHook != nil {
+		return svr.finalizeHook(svr.finalizingUpload(f))
+	}
+	return nil
+}
+
+// placeUpload moves the upload of f into place, if it is staged, once it
+// has been finalized and approved. Under NoOverwrite the move fails if a
+// file has appeared there since f was opened.
+func (svr *Server) placeUpload(f *openFile) error {
+	if f.finalPath == "" {
+		return nil
+	}
+	if !svr.noOverwrite {
+		return os.Rename(f.Name(), f.finalPath)
+	}
+	if err := os.Link(f.Name(), f.finalPath); err != nil {
 		return err
 	}
+	return os.Remove(f.Name())
+}
 
-	return syscall.EBADF
+// writeAt writes b to the file at offset, keeping count of the bytes written.
+func (f *openFile) writeAt(b []byte, offset int64) (n int, written int64, err error) {
+	if f.discard {
+		n = len(b)
+	} else if f.coalesce != nil {
+		n, err = f.coalescedWrite(b, offset)
+	} else {
+		n, err = f.writeThrough(b, offset)
+	}
+	written = atomic.AddInt64(&f.written, int64(n))
+	if f.digests != nil && n > 0 {
+		f.digests.write(b[:n], offset)
+	}
+	if f.sample != nil && n > 0 {
+		f.sampleLock.Lock()
+		f.sample.write(b[:n], offset)
+		f.sampleLock.Unlock()
+	}
+	return n, written, err
+}
+
+// writeThrough writes b to the file at offset with the write backend.
+func (f *openFile) writeThrough(b []byte, offset int64) (n int, err error) {
+	if f.wb != nil {
+		n, err = f.wb.WriteAt(f.File, b, offset)
+	} else {
+		n, err = f.WriteAt(b, offset)
+	}
+	if err == nil && n < len(b) {
+		// A backend must not lose data silently.
+		err = io.ErrShortWrite
+	}
+	return n, err
+}
+
+// sampleBytes returns the sample of the file's content, or nil if sampling
+// is not enabled.
+func (f *openFile) sampleBytes() []byte {
+	if f.sample == nil {
+		return nil
+	}
+	f.sampleLock.Lock()
+	defer f.sampleLock.Unlock()
+	return f.sample.bytes()
 }
 
 func (svr *Server) getHandle(handle string) (*os.File, bool) {
-	svr.openFilesLock.RLock()
-	defer svr.openFilesLock.RUnlock()
-	f, ok := svr.openFiles[handle]
-	return f, ok
+	f, ok := svr.getOpenFile(handle)
+	if !ok {
+		return nil, false
+	}
+	return f.File, true
+}
+
+func (svr *Server) getOpenFile(handle string) (*openFile, bool) {
+	return svr.handles.get(handle)
 }
 
 func (svr *Server) getHandleDirInfo(handle string) (*openDirInfo, bool) {
-	svr.openFilesLock.RLock()
-	defer svr.openFilesLock.RUnlock()
-	di, ok := svr.openDirs[handle]
-	return di, ok
+	f, ok := svr.handles.get(handle)
+	if !ok || f.dir == nil {
+		return nil, false
+	}
+	return f.dir, true
+}
+
+// sendPacket sends m to the client, recording the status code of status
+// responses in the server statistics.
+func (svr *Server) sendPacket(m encoding.BinaryMarshaler) error {
+	if p, ok := m.(sshFxpStatusPacket); ok {
+		svr.recordStatus(p.Code)
+	}
+	svr.debugPacket(false, responseType(m), m)
+	if pa, ok := m.(packetAppender); ok && svr.arenas != nil {
+		if sent, err := svr.sendPacketInArena(pa); sent {
+			return err
+		}
+	}
+	return svr.serverConn.sendPacket(m)
+}
+
+func (svr *Server) sendError(p id, err error) error {
+	pkt := statusFromError(p, err)
+	svr.redactError(p, err, &pkt)
+	return svr.sendPacket(pkt)
+}
+
+func (svr *Server) sendErrorCode(p id, code uint32) error {
+	pkt := sshFxpStatusPacket{
+		ID: p.id(),
+		StatusError: StatusError{
+			Code: code,
+		},
+	}
+	return svr.sendPacket(pkt)
 }
 
 type serverRespondablePacket interface {
@@ -115,10 +383,12 @@ func NewServer(rwc io.ReadWriteCloser, options ...ServerOption) (*Server, error)
 			},
 		},
 		debugStream: ioutil.Discard,
-		pktChan:     make(chan rxPacket, sftpServerWorkerCount),
-		openFiles:   make(map[string]*os.File),
-		openDirs:    make(map[string]*openDirInfo),
+		clock:       systemClock{},
+		workerCount: sftpServerWorkerCount,
+		queueDepth:  -1,
+		handles:     newHandleTable(),
 		maxTxPacket: 1 << 15,
+		stats:       newServerStats(),
 	}
 
 	for _, o := range options {
@@ -127,6 +397,26 @@ func NewServer(rwc io.ReadWriteCloser, options ...ServerOption) (*Server, error)
 		}
 	}
 
+	if s.capture != nil {
+		s.capture.wrap(&s.conn, s.clock, s.debugStream)
+	}
+
+	// Receive buffers hold a write of the client's default 32KiB payload,
+	// or of as much as the server sends in a read, along with its header.
+	rxBufSize := s.maxTxPacket
+	if rxBufSize < 1<<15 {
+		rxBufSize = 1 << 15
+	}
+	rxBufSize += rxPacketOverhead
+	s.rxBufs.New = func() interface{} {
+		b := make([]byte, rxBufSize)
+		return &b
+	}
+
+	if s.receipts != nil && len(s.digests) == 0 {
+		return nil, errors.New("WithReceipts requires WithDigests")
+	}
+
 	if s.uploadPath == "" {
 		s.uploadPath = "/"
 	} else {
@@ -158,6 +448,47 @@ func WithFileSizeLimit(limit int64) ServerOption {
 	}
 }
 
+// WithWorkers sets the number of requests the Server handles concurrently;
+// the default is 1. Requests on the same handle are always handled in order,
+// but with more than one worker the FileNameMapper, hooks and notifiers may
+// be called concurrently, and must be safe for concurrent use.
+func WithWorkers(n int) ServerOption {
+	return func(s *Server) error {
+		if n < 1 {
+			return errors.Errorf("invalid worker count %d", n)
+		}
+		s.workerCount = n
+		return nil
+	}
+}
+
+// WithMaxTxPacket sets the largest amount of data the Server returns for a
+// single read; the default is 32KiB. Larger packets make better use of high
+// latency links, but n may not exceed what clients accept, 256KiB less the
+// packet header.
+func WithMaxTxPacket(n uint32) ServerOption {
+	return func(s *Server) error {
+		if n == 0 || n > maxMsgLength-dataPacketOverhead {
+			return errors.Errorf("max tx packet %d out of range 1 to %d", n, maxMsgLength-dataPacketOverhead)
+		}
+		s.maxTxPacket = n
+		return nil
+	}
+}
+
+// WithResponseBuffer buffers up to size bytes of responses, sending them
+// together once the worker handling them has no more requests waiting. This
+// saves system calls when a client has many small requests in flight.
+func WithResponseBuffer(size int) ServerOption {
+	return func(s *Server) error {
+		if size <= 0 {
+			return errors.Errorf("invalid response buffer size %d", size)
+		}
+		s.conn.bw = bufio.NewWriterSize(s.conn.WriteCloser, size)
+		return nil
+	}
+}
+
 // ReadOnly configures a Server to serve files in read-only mode.
 func ReadOnly() ServerOption {
 	return func(s *Server) error {
@@ -166,6 +497,27 @@ func ReadOnly() ServerOption {
 	}
 }
 
+// WithFileMode sets the permissions of uploaded files to mode, whatever the
+// umask of the process, rather than 0666 less the umask. Files staged by
+// WithStagingDir are given mode as they are moved into place.
+func WithFileMode(mode os.FileMode) ServerOption {
+	return func(s *Server) error {
+		if mode&^os.ModePerm != 0 || mode == 0 {
+			return errors.Errorf("invalid file mode %v", mode)
+		}
+		s.fileMode = mode
+		return nil
+	}
+}
+
+// NoOverwrite refuses uploads to the name of a file which already exists.
+func NoOverwrite() ServerOption {
+	return func(s *Server) error {
+		s.noOverwrite = true
+		return nil
+	}
+}
+
 func UploadPath(path string) ServerOption {
 	return func(s *Server) error {
 		s.uploadPath = path
@@ -187,6 +539,42 @@ func UploadNotifier(f func(string)) ServerOption {
 	}
 }
 
+// LeakedHandle describes a file which was still open when its session ended.
+type LeakedHandle struct {
+	Session    SessionInfo
+	Handle     string
+	RemotePath string // path requested by the client
+	Path       string // path of the file on the server
+	Opened     time.Time
+	Written    int64  // bytes written to the file
+	Sample     []byte // leading bytes of the file, if WithPayloadSampling
+}
+
+// LeakNotifier sets a function called for each uploaded file the client
+// did not close before the session ended. The file has been closed by the
+// time f is called, but UploadNotifier is not called for it.
+func LeakNotifier(f func(LeakedHandle)) ServerOption {
+	return func(s *Server) error {
+		s.leakNotifier = f
+		return nil
+	}
+}
+
+func (svr *Server) reportLeak(handle string, f *openFile) {
+	svr.recordLeak()
+	if svr.leakNotifier != nil {
+		svr.leakNotifier(LeakedHandle{
+			Session:    svr.session,
+			Handle:     handle,
+			RemotePath: f.remotePath,
+			Path:       f.path(),
+			Opened:     f.opened,
+			Written:    atomic.LoadInt64(&f.written),
+			Sample:     f.sampleBytes(),
+		})
+	}
+}
+
 func ReaddirHook(f func() ([]os.FileInfo, error)) ServerOption {
 	return func(s *Server) error {
 		s.readdirHook = f
@@ -194,6 +582,17 @@ func ReaddirHook(f func() ([]os.FileInfo, error)) ServerOption {
 	}
 }
 
+// WithoutLongNames sends each directory entry's name in place of its ls -l
+// style long name, which is costly to format for large listings. Clients
+// which display the long name, such as OpenSSH's sftp for "ls -l", then show
+// only the name.
+func WithoutLongNames() ServerOption {
+	return func(s *Server) error {
+		s.noLongNames = true
+		return nil
+	}
+}
+
 func OpendirHook(f func()) ServerOption {
 	return func(s *Server) error {
 		s.opendirHook = f
@@ -204,8 +603,23 @@ func OpendirHook(f func()) ServerOption {
 type rxPacket struct {
 	pktType  fxp
 	pktBytes []byte
+	buf      *[]byte // receive buffer holding pktBytes, returned to rxBufs
+	stream   *payloadStream
+	budgeted bool // pktBytes is counted against the memory budget
 }
 
+const (
+	// rxPacketOverhead is room in receive buffers for the header of a write
+	// packet, including its handle.
+	rxPacketOverhead = 1024
+
+	// maxMsgLength is the largest packet accepted by the OpenSSH client.
+	maxMsgLength = 256 * 1024
+	// dataPacketOverhead is the length of an SSH_FXP_DATA packet other than
+	// its data: type, id and data length.
+	dataPacketOverhead = 1 + 4 + 4
+)
+
 var allowedPacketTypes = map[fxp]bool{
 	ssh_FXP_INIT:     true,
 	ssh_FXP_OPEN:     true,
@@ -219,100 +633,275 @@ var allowedPacketTypes = map[fxp]bool{
 	ssh_FXP_REALPATH: true,
 }
 
-// Up to N parallel servers
-func (svr *Server) sftpServerWorker() error {
-	for p := range svr.pktChan {
-		var pkt interface {
-			encoding.BinaryUnmarshaler
-			id() uint32
-		}
-		var readonly = true
-		switch p.pktType {
-		case ssh_FXP_INIT:
-			pkt = &sshFxInitPacket{}
-		case ssh_FXP_LSTAT:
-			pkt = &sshFxpLstatPacket{}
-		case ssh_FXP_OPEN:
-			pkt = &sshFxpOpenPacket{}
-			// readonly handled specially below
-		case ssh_FXP_CLOSE:
-			pkt = &sshFxpClosePacket{}
-		case ssh_FXP_READ:
-			pkt = &sshFxpReadPacket{}
-		case ssh_FXP_WRITE:
-			pkt = &sshFxpWritePacket{}
-			readonly = false
-		case ssh_FXP_FSTAT:
-			pkt = &sshFxpFstatPacket{}
-		case ssh_FXP_SETSTAT:
-			pkt = &sshFxpSetstatPacket{}
-			readonly = false
-		case ssh_FXP_FSETSTAT:
-			pkt = &sshFxpFsetstatPacket{}
-			readonly = false
-		case ssh_FXP_OPENDIR:
-			pkt = &sshFxpOpendirPacket{}
-		case ssh_FXP_READDIR:
-			pkt = &sshFxpReaddirPacket{}
-		case ssh_FXP_REMOVE:
-			pkt = &sshFxpRemovePacket{}
-			readonly = false
-		case ssh_FXP_MKDIR:
-			pkt = &sshFxpMkdirPacket{}
-			readonly = false
-		case ssh_FXP_RMDIR:
-			pkt = &sshFxpRmdirPacket{}
-			readonly = false
-		case ssh_FXP_REALPATH:
-			pkt = &sshFxpRealpathPacket{}
-		case ssh_FXP_STAT:
-			pkt = &sshFxpStatPacket{}
-		case ssh_FXP_RENAME:
-			pkt = &sshFxpRenamePacket{}
-			readonly = false
-		case ssh_FXP_READLINK:
-			pkt = &sshFxpReadlinkPacket{}
-		case ssh_FXP_SYMLINK:
-			pkt = &sshFxpSymlinkPacket{}
-			readonly = false
-		case ssh_FXP_EXTENDED:
-			pkt = &sshFxpExtendedPacket{}
-		default:
-			return errors.Errorf("unhandled packet type: %s", p.pktType)
-		}
-		if err := pkt.UnmarshalBinary(p.pktBytes); err != nil {
-			return err
+// sftpServerWorker handles the packets sent on ch until it is closed. After
+// an error it closes the connection and discards the remaining packets.
+func (svr *Server) sftpServerWorker(ch <-chan rxPacket) error {
+	var err error
+	var a *arena
+	if svr.arenas != nil {
+		a = &arena{}
+	}
+	for p := range ch {
+		if err == nil {
+			if a != nil {
+				err = svr.processPacketInArena(p, a)
+			} else {
+				err = svr.processPacket(p)
+			}
+			if err == nil && len(ch) == 0 {
+				// Nothing else is waiting for this worker: send the
+				// buffered responses. Otherwise it will once it has caught
+				// up, whatever other workers are stuck on.
+				err = svr.conn.flush()
+			}
+			if err != nil {
+				svr.conn.Close() // shuts down recvPacket
+			}
 		}
+		svr.donePacket()
+		svr.releasePacket(p)
+		if p.stream != nil {
+			close(p.stream.done)
+		}
+	}
+	return err
+}
+
+// releasePacket returns the memory held by p once it has been handled.
+func (svr *Server) releasePacket(p rxPacket) {
+	svr.touch()
+	atomic.AddInt64(&svr.inFlightBytes, -int64(len(p.pktBytes)))
+	if p.budgeted {
+		svr.memoryBudget.release(int64(len(p.pktBytes)))
+	}
+	if svr.window != nil {
+		<-svr.window
+	}
+	svr.rxBufs.Put(p.buf)
+}
+
+// handlePacketTypes are the requests whose first field after the id is a
+// handle.
+var handlePacketTypes = map[fxp]bool{
+	ssh_FXP_CLOSE:    true,
+	ssh_FXP_READ:     true,
+	ssh_FXP_WRITE:    true,
+	ssh_FXP_FSTAT:    true,
+	ssh_FXP_FSETSTAT: true,
+	ssh_FXP_READDIR:  true,
+}
 
-		if !allowedPacketTypes[p.pktType] {
-			if err := svr.sendErrorCode(pkt, ssh_FX_OP_UNSUPPORTED); err != nil {
-				return errors.Wrap(err, "failed to send op unsupported response")
+// packetWorker returns which of n workers handles p. Requests on a handle
+// always go to the same worker, so that they are handled in the order they
+// were sent; other requests go to the worker after last.
+func packetWorker(p rxPacket, n, last int) int {
+	if handlePacketTypes[p.pktType] && len(p.pktBytes) >= 4 {
+		if handle, _, err := unmarshalStringSafe(p.pktBytes[4:]); err == nil {
+			return int(hashHandle(handle) % uint32(n))
+		}
+	} else if p.pktType == ssh_FXP_EXTENDED && len(p.pktBytes) >= 4 {
+		if req, b, err := unmarshalStringSafe(p.pktBytes[4:]); err == nil && req == "check-file-handle" {
+			if handle, _, err := unmarshalStringSafe(b); err == nil {
+				return int(hashHandle(handle) % uint32(n))
 			}
-			continue
 		}
+	}
+	return (last + 1) % n
+}
 
-		// handle FXP_OPENDIR specially
-		switch pkt := pkt.(type) {
-		case *sshFxpOpenPacket:
-			readonly = pkt.readonly()
-		case *sshFxpExtendedPacket:
-			readonly = pkt.SpecificPacket.readonly()
+// processPacket decodes and responds to a packet received from the client.
+func (svr *Server) processPacket(p rxPacket) error {
+	var pkt interface {
+		encoding.BinaryUnmarshaler
+		id() uint32
+	}
+	var readonly = true
+	switch p.pktType {
+	case ssh_FXP_INIT:
+		pkt = &sshFxInitPacket{}
+	case ssh_FXP_LSTAT:
+		pkt = &sshFxpLstatPacket{}
+	case ssh_FXP_OPEN:
+		pkt = &sshFxpOpenPacket{}
+		// readonly handled specially below
+	case ssh_FXP_CLOSE:
+		pkt = &sshFxpClosePacket{}
+	case ssh_FXP_READ:
+		pkt = &sshFxpReadPacket{}
+	case ssh_FXP_WRITE:
+		pkt = &sshFxpWritePacket{}
+		readonly = false
+	case ssh_FXP_FSTAT:
+		pkt = &sshFxpFstatPacket{}
+	case ssh_FXP_SETSTAT:
+		pkt = &sshFxpSetstatPacket{}
+		readonly = false
+	case ssh_FXP_FSETSTAT:
+		pkt = &sshFxpFsetstatPacket{}
+		readonly = false
+	case ssh_FXP_OPENDIR:
+		pkt = &sshFxpOpendirPacket{}
+	case ssh_FXP_READDIR:
+		pkt = &sshFxpReaddirPacket{}
+	case ssh_FXP_REMOVE:
+		pkt = &sshFxpRemovePacket{}
+		readonly = false
+	case ssh_FXP_MKDIR:
+		pkt = &sshFxpMkdirPacket{}
+		readonly = false
+	case ssh_FXP_RMDIR:
+		pkt = &sshFxpRmdirPacket{}
+		readonly = false
+	case ssh_FXP_REALPATH:
+		pkt = &sshFxpRealpathPacket{}
+	case ssh_FXP_STAT:
+		pkt = &sshFxpStatPacket{}
+	case ssh_FXP_RENAME:
+		pkt = &sshFxpRenamePacket{}
+		readonly = false
+	case ssh_FXP_READLINK:
+		pkt = &sshFxpReadlinkPacket{}
+	case ssh_FXP_SYMLINK:
+		pkt = &sshFxpSymlinkPacket{}
+		readonly = false
+	case ssh_FXP_EXTENDED:
+		pkt = &sshFxpExtendedPacket{}
+	default:
+		return errors.Errorf("unhandled packet type: %s", p.pktType)
+	}
+	if p.stream != nil {
+		// Only the header of the write has been read.
+		wp := pkt.(*sshFxpWritePacket)
+		if _, err := wp.unmarshalHeader(p.pktBytes); err != nil {
+			return err
 		}
+		wp.stream = p.stream.r
+	} else if err := pkt.UnmarshalBinary(p.pktBytes); err != nil {
+		return err
+	}
+	svr.debugPacket(true, p.pktType, pkt)
 
-		// If server is operating read-only and a write operation is requested,
-		// return permission denied
-		if !readonly && svr.readOnly {
-			if err := svr.sendError(pkt, syscall.EPERM); err != nil {
-				return errors.Wrap(err, "failed to send read only packet response")
-			}
-			continue
+	if svr.faults != nil {
+		if err := svr.faults.apply(svr.clock, p.pktType); err != nil {
+			return svr.sendError(pkt, err)
 		}
+	}
 
-		if err := handlePacket(svr, pkt); err != nil {
-			return err
+	// Offsets are int64 from here on: a write ending beyond that is refused
+	// before anything, honeypot or write buffer, takes it for negative.
+	if wp, ok := pkt.(*sshFxpWritePacket); ok && wp.Offset > math.MaxInt64-uint64(wp.Length) {
+		return svr.sendErrorCode(pkt, ssh_FX_FAILURE)
+	}
+
+	if svr.honeypot != nil {
+		return svr.handleHoneypot(p.pktType, pkt)
+	}
+
+	if !allowedPacketTypes[p.pktType] && !svr.symlinkOpAllowed(p.pktType) && !svr.checkFileAllowed(pkt) && !svr.receiptAllowed(pkt) {
+		if err := svr.sendDenied(pkt, p.pktType, svr.requestPath(pkt), ssh_FX_OP_UNSUPPORTED, DeniedUnsupported); err != nil {
+			return errors.Wrap(err, "failed to send op unsupported response")
 		}
+		return nil
+	}
+
+	// handle FXP_OPENDIR specially
+	switch pkt := pkt.(type) {
+	case *sshFxpOpenPacket:
+		readonly = pkt.readonly()
+	case *sshFxpExtendedPacket:
+		readonly = pkt.SpecificPacket.readonly()
+	}
+
+	// If server is operating read-only and a write operation is requested,
+	// return permission denied
+	if !readonly && (svr.readOnly || svr.readOnlyPath(pkt)) {
+		svr.denied(p.pktType, svr.requestPath(pkt), ssh_FX_PERMISSION_DENIED, DeniedReadOnly)
+		if err := svr.sendError(pkt, syscall.EPERM); err != nil {
+			return errors.Wrap(err, "failed to send read only packet response")
+		}
+		return nil
+	}
+
+	if svr.authorizer != nil && p.pktType != ssh_FXP_INIT {
+		if err := svr.authorizer(Operation(p.pktType.String()), svr.authorizationPath(pkt), svr.Session()); err != nil {
+			return svr.sendUnauthorized(pkt, p.pktType, err)
+		}
+	}
+
+	if err := svr.flushBefore(pkt); err != nil {
+		return svr.sendError(pkt, err)
+	}
+	return handlePacket(svr, pkt)
+}
+
+// requestPath returns the path named by a request. For requests on a handle,
+// it is the path the handle was opened with, or the handle itself if it is
+// not open.
+func (svr *Server) requestPath(p interface{}) string {
+	if handle, ok := requestHandle(p); ok {
+		if f, ok := svr.getOpenFile(handle); ok {
+			return f.remotePath
+		}
+		return handle
+	}
+	switch p := p.(type) {
+	case *sshFxpOpenPacket:
+		return p.Path
+	case *sshFxpOpendirPacket:
+		return p.Path
+	case *sshFxpStatPacket:
+		return p.Path
+	case *sshFxpLstatPacket:
+		return p.Path
+	case *sshFxpSetstatPacket:
+		return p.Path
+	case *sshFxpRemovePacket:
+		return p.Filename
+	case *sshFxpMkdirPacket:
+		return p.Path
+	case *sshFxpRmdirPacket:
+		return p.Path
+	case *sshFxpRealpathPacket:
+		return p.Path
+	case *sshFxpRenamePacket:
+		return p.Oldpath
+	case *sshFxpReadlinkPacket:
+		return p.Path
+	case *sshFxpSymlinkPacket:
+		return p.Linkpath
+	case *sshFxpExtendedPacket:
+		if p, ok := p.SpecificPacket.(*sshFxpExtendedPacketStatVFS); ok {
+			return p.Path
+		}
+		return ""
+	default:
+		return ""
+	}
+}
+
+// requestHandle returns the handle named by a request on a handle.
+func requestHandle(p interface{}) (string, bool) {
+	switch p := p.(type) {
+	case *sshFxpClosePacket:
+		return p.Handle, true
+	case *sshFxpReadPacket:
+		return p.Handle, true
+	case *sshFxpWritePacket:
+		return p.Handle, true
+	case *sshFxpFstatPacket:
+		return p.Handle, true
+	case *sshFxpFsetstatPacket:
+		return p.Handle, true
+	case *sshFxpReaddirPacket:
+		return p.Handle, true
+	case *sshFxpExtendedPacket:
+		if p, ok := p.SpecificPacket.(*sshFxpExtendedPacketCheckFileHandle); ok {
+			return p.Handle, true
+		}
+		return "", false
+	default:
+		return "", false
 	}
-	return nil
 }
 
 func (s *Server) isUploadDirOrAncestor(dir string) bool {
@@ -323,32 +912,35 @@ func (s *Server) isUploadDirOrAncestor(dir string) bool {
 }
 
 func handlePacket(s *Server, p interface{}) error {
-	doStat := func(p id, reqPath string) error {
-		reqPath = path.Clean(reqPath)
-		if s.isUploadDirOrAncestor(reqPath) {
+	doStat := func(p id, op fxp, path string) error {
+		reqPath, ok := s.canonicalPath(path)
+		if ok && s.isUploadDirOrAncestor(reqPath) {
 			return s.sendPacket(sshFxpStatResponse{
 				ID: p.id(),
 				info: &fileInfo{
 					name:  reqPath,
 					mode:  os.ModeDir | 0755,
-					mtime: time.Now(),
+					mtime: s.clock.Now(),
 				},
 			})
+		} else if !ok || s.outsideUploadPath(reqPath) {
+			return s.sendDenied(p, op, path, ssh_FX_NO_SUCH_FILE, DeniedOutsideUploadPath)
 		} else {
 			return s.sendError(p, syscall.ENOENT)
 		}
 	}
 	switch p := p.(type) {
 	case *sshFxInitPacket:
+		atomic.StoreUint32(&s.clientVersion, p.Version)
 		return s.sendPacket(sshFxVersionPacket{sftpProtocolVersion, nil})
 	case *sshFxpStatPacket:
-		return doStat(p, p.Path)
+		return doStat(p, ssh_FXP_STAT, p.Path)
 	case *sshFxpLstatPacket:
-		return doStat(p, p.Path)
+		return doStat(p, ssh_FXP_LSTAT, p.Path)
 	case *sshFxpFstatPacket:
 		f, ok := s.getHandle(p.Handle)
 		if !ok {
-			return s.sendError(p, syscall.EBADF)
+			return s.sendError(p, s.handleError(p.Handle))
 		}
 
 		info, err := f.Stat()
@@ -374,12 +966,20 @@ func handlePacket(s *Server, p interface{}) error {
 		err := os.Rename(p.Oldpath, p.Newpath)
 		return s.sendError(p, err)
 	case *sshFxpSymlinkPacket:
-		err := os.Symlink(p.Targetpath, p.Linkpath)
+		linkName, code, reason := s.uploadFile(p.Linkpath)
+		if code != 0 {
+			return s.sendRefusal(p, ssh_FXP_SYMLINK, p.Linkpath, code, reason)
+		}
+		err := os.Symlink(p.Targetpath, linkName)
 		return s.sendError(p, err)
 	case *sshFxpClosePacket:
-		return s.sendError(p, s.closeHandle(p.Handle))
+		return s.closeHandle(p.Handle, func(err error) error { return s.sendError(p, err) })
 	case *sshFxpReadlinkPacket:
-		f, err := os.Readlink(p.Path)
+		linkName, code, reason := s.uploadFile(p.Path)
+		if code != 0 {
+			return s.sendRefusal(p, ssh_FXP_READLINK, p.Path, code, reason)
+		}
+		f, err := os.Readlink(linkName)
 		if err != nil {
 			return s.sendError(p, err)
 		}
@@ -394,11 +994,9 @@ func handlePacket(s *Server, p interface{}) error {
 		})
 
 	case *sshFxpRealpathPacket:
-		var retPath string
-		if p.Path == "" || p.Path[0] != '/' {
-			retPath = path.Clean(s.uploadPath + "/" + p.Path)
-		} else {
-			retPath = path.Clean(p.Path)
+		retPath, ok := s.canonicalPath(p.Path)
+		if !ok {
+			return s.sendError(p, syscall.ENOENT)
 		}
 		return s.sendPacket(sshFxpNamePacket{
 			ID: p.ID,
@@ -409,6 +1007,13 @@ func handlePacket(s *Server, p interface{}) error {
 			}},
 		})
 	case *sshFxpOpendirPacket:
+		if reqPath, ok := s.canonicalPath(p.Path); !ok || !s.isUploadDirOrAncestor(reqPath) {
+			reason := DeniedSubdirectory
+			if !ok || s.outsideUploadPath(reqPath) {
+				reason = DeniedOutsideUploadPath
+			}
+			return s.sendDenied(p, ssh_FXP_OPENDIR, p.Path, ssh_FX_NO_SUCH_PATH, reason)
+		}
 		return sshFxpOpenPacket{
 			ID:     p.ID,
 			Path:   p.Path,
@@ -417,7 +1022,7 @@ func handlePacket(s *Server, p interface{}) error {
 	case *sshFxpReadPacket:
 		f, ok := s.getHandle(p.Handle)
 		if !ok {
-			return s.sendError(p, syscall.EBADF)
+			return s.sendError(p, s.handleError(p.Handle))
 		}
 
 		data := make([]byte, clamp(p.Len, s.maxTxPacket))
@@ -432,15 +1037,35 @@ func handlePacket(s *Server, p interface{}) error {
 		})
 	case *sshFxpWritePacket:
 		var err error
-		f, ok := s.getHandle(p.Handle)
+		f, ok := s.getOpenFile(p.Handle)
 		if !ok {
-			return s.sendError(p, syscall.EBADF)
+			return s.sendError(p, s.handleError(p.Handle))
 		}
 
-		if s.fileSizeLimit > 0 && (int64(p.Offset)+int64(len(p.Data))) > s.fileSizeLimit {
+		if s.fileSizeLimit > 0 && int64(p.Offset)+int64(p.Length) > s.fileSizeLimit {
 			err = syscall.EFBIG
+			s.denied(ssh_FXP_WRITE, f.remotePath, ssh_FX_FAILURE, DeniedFileSize)
+		} else if s.quota != nil && !s.quotaAllows(f, int64(p.Offset)+int64(p.Length)) {
+			err = errQuotaExceeded
+			s.denied(ssh_FXP_WRITE, f.remotePath, ssh_FX_QUOTA_EXCEEDED, DeniedQuota)
 		} else {
-			_, err = f.WriteAt(p.Data, int64(p.Offset))
+			var n int
+			var written int64
+			if p.stream != nil {
+				n, written, err = s.writeFrom(f, p.stream, p.Length, int64(p.Offset))
+			} else {
+				n, written, err = f.writeAt(p.Data, int64(p.Offset))
+			}
+			if n > 0 {
+				s.emit(WriteProgress{
+					Session: s.session,
+					Handle:  p.Handle,
+					Path:    f.path(),
+					Offset:  int64(p.Offset),
+					Length:  n,
+					Written: written,
+				})
+			}
 		}
 		return s.sendError(p, err)
 	case serverRespondablePacket:
@@ -453,36 +1078,129 @@ func handlePacket(s *Server, p interface{}) error {
 
 // Serve serves SFTP connections until the streams stop or the SFTP subsystem
 // is stopped.
+//
+// The goroutines serving the session carry the pprof labels "sftp.user" and
+// "sftp.remote_addr", taken from WithSessionInfo, so that profiles can be
+// broken down by client.
 func (svr *Server) Serve() error {
+	var err error
+	svr.withProfileLabels(func() { err = svr.serve() })
+	return err
+}
+
+func (svr *Server) serve() error {
+	svr.startSession()
+	svr.startWriteBackend()
+	stopIdleTimer := svr.startIdleTimer()
+	stopSessionTimer := svr.startSessionTimer()
+
 	var wg sync.WaitGroup
-	wg.Add(sftpServerWorkerCount)
-	for i := 0; i < sftpServerWorkerCount; i++ {
-		go func() {
+	var workerErr error
+	var workerErrOnce sync.Once
+	workers := make([]chan rxPacket, svr.workerCount)
+	queueDepth := svr.queueDepth
+	if queueDepth < 0 {
+		queueDepth = svr.workerCount
+	}
+	wg.Add(len(workers))
+	for i := range workers {
+		workers[i] = make(chan rxPacket, queueDepth)
+		go func(ch <-chan rxPacket) {
 			defer wg.Done()
-			if err := svr.sftpServerWorker(); err != nil {
-				svr.conn.Close() // shuts down recvPacket
+			if err := svr.sftpServerWorker(ch); err != nil {
+				workerErrOnce.Do(func() { workerErr = err })
 			}
-		}()
+		}(workers[i])
 	}
 
 	var err error
-	var pktType uint8
-	var pktBytes []byte
+	var worker int
+	first := true
 	for {
-		pktType, pktBytes, err = svr.recvPacket()
-		if err != nil {
+		buf := svr.rxBufs.Get().(*[]byte)
+		var p rxPacket
+		p, err = svr.recvServerPacket(*buf)
+		if err != nil || svr.expired() {
+			svr.rxBufs.Put(buf)
 			break
 		}
-		svr.pktChan <- rxPacket{fxp(pktType), pktBytes}
+		p.buf = buf
+		svr.touch()
+		if first {
+			if p.pktType == ssh_FXP_INIT && p.stream == nil {
+				svr.recordInit(p)
+				if err = svr.checkClient(); err != nil {
+					svr.rxBufs.Put(buf)
+					break
+				}
+			}
+			first = false
+		}
+		if svr.requestRate != nil {
+			svr.throttle(p)
+		}
+		atomic.AddInt64(&svr.inFlightBytes, int64(len(p.pktBytes)))
+		if svr.window != nil {
+			svr.window <- struct{}{}
+		}
+		if svr.memoryBudget != nil {
+			var admitted bool
+			if admitted, err = svr.admitPacket(&p); err != nil {
+				break
+			} else if !admitted {
+				continue
+			}
+		}
+		if svr.inlinePacket(p) {
+			atomic.AddInt64(&svr.pendingPackets, 1)
+			err = svr.processInline(p)
+			svr.donePacket()
+			if err != nil {
+				break
+			}
+			continue
+		}
+		worker = packetWorker(p, len(workers), worker)
+		if err = svr.queuePacket(workers[worker], p); err != nil {
+			break
+		}
+		if p.stream != nil {
+			if err = p.stream.finish(); err != nil {
+				break
+			}
+		}
 	}
 
-	close(svr.pktChan) // shuts down sftpServerWorkers
-	wg.Wait()          // wait for all workers to exit
+	for _, ch := range workers {
+		close(ch) // shuts down sftpServerWorkers
+	}
+	wg.Wait()       // wait for all workers to exit
+	svr.held.Wait() // and for the uploads they left held to be decided
+	stopIdleTimer()
+	stopSessionTimer()
+	if atomic.LoadUint32(&svr.idleExpired) != 0 {
+		err = ErrIdleTimeout
+	} else if svr.expired() {
+		svr.conn.Close() // the request which ended the loop is unanswered
+		err = ErrSessionExpired
+	}
 
 	// close any still-open files
-	for handle, file := range svr.openFiles {
+	svr.handles.each(func(handle string, file *openFile) {
 		fmt.Fprintf(svr.debugStream, "sftp server file with handle %q left open: %v\n", handle, file.Name())
+		file.flush()
 		file.Close()
+		discardStaged(file)
+		if file.dir == nil {
+			svr.reportLeak(handle, file)
+		}
+	})
+	svr.stopWriteBackend()
+
+	if workerErr != nil {
+		svr.endSession(workerErr)
+	} else {
+		svr.endSession(err)
 	}
 	return err // error from recvPacket
 }
@@ -525,11 +1243,13 @@ func (p sshFxpOpenPacket) respond(svr *Server) error {
 	// This is upload only, so the file must be opened for writing. Appending
 	// is not supported.
 	var (
-		f       *os.File
-		err     error
-		dirName string
+		f          *os.File
+		err        error
+		dirName    string
+		finalPath  string // if staged
+		quotaLimit = int64(-1)
 	)
-	reqPath := path.Clean(p.Path)
+	reqPath, _ := svr.canonicalPath(p.Path)
 	if svr.isUploadDirOrAncestor(reqPath) && p.readonly() {
 		// Allow open request for upload directory or ancestor.
 		// /dev/null is opened so there's a file there.
@@ -540,43 +1260,66 @@ func (p sshFxpOpenPacket) respond(svr *Server) error {
 		}
 	} else {
 		if !p.hasPflags(ssh_FXF_WRITE) || p.hasPflags(ssh_FXF_APPEND) {
-			return svr.sendErrorCode(p, ssh_FX_OP_UNSUPPORTED)
-		}
-		prefix := svr.uploadPath
-		if prefix != "/" {
-			prefix += "/"
+			return svr.sendDenied(p, ssh_FXP_OPEN, p.Path, ssh_FX_OP_UNSUPPORTED, DeniedOpenMode)
 		}
-		if !strings.HasPrefix(p.Path, prefix) {
-			return svr.sendErrorCode(p, ssh_FX_NO_SUCH_PATH)
+		fileName, code, reason := svr.uploadFile(p.Path)
+		if code != 0 {
+			return svr.sendRefusal(p, ssh_FXP_OPEN, p.Path, code, reason)
 		}
-		fileName := p.Path[len(prefix):]
-		if strings.ContainsRune(fileName, '/') {
-			return svr.sendErrorCode(p, ssh_FX_NO_SUCH_PATH)
+		if svr.quota != nil {
+			if quotaLimit = svr.uploadQuota(fileName); quotaLimit == 0 {
+				return svr.sendDenied(p, ssh_FXP_OPEN, p.Path, ssh_FX_QUOTA_EXCEEDED, DeniedQuota)
+			}
 		}
-		if svr.fileNameMapper != nil {
-			var ok bool
-			var err error
-			fileName, ok, err = svr.fileNameMapper(fileName)
-			if err != nil {
-				return svr.sendErrorCode(p, ssh_FX_FAILURE)
-			} else if !ok {
-				return svr.sendErrorCode(p, ssh_FX_INVALID_FILENAME)
+		if svr.stagingDir != "" {
+			if _, err := os.Lstat(fileName); err == nil && svr.noOverwrite {
+				return svr.sendDenied(p, ssh_FXP_OPEN, p.Path, ssh_FX_FAILURE, DeniedFileExists)
 			}
+			finalPath = fileName
+			f, err = svr.createStagedFile(fileName)
+		} else {
+			f, err = createUploadFile(fileName, svr.symlinkPolicy != SymlinkDenyFollow, svr.noOverwrite)
+			if err == nil && svr.fileMode != 0 {
+				if err = f.Chmod(svr.fileMode); err != nil {
+					f.Close()
+				}
+			}
+		}
+		if pe, ok := err.(*os.PathError); ok && pe.Err == errSymlink {
+			return svr.sendDenied(p, ssh_FXP_OPEN, p.Path, ssh_FX_PERMISSION_DENIED, DeniedSymlink)
+		} else if svr.noOverwrite && os.IsExist(err) {
+			return svr.sendDenied(p, ssh_FXP_OPEN, p.Path, ssh_FX_FAILURE, DeniedFileExists)
 		}
-		f, err = os.Create(fileName)
 	}
 	if err != nil {
 		return svr.sendError(p, err)
 	}
 
-	handle := svr.nextHandle(f, dirName)
+	handle := svr.nextHandle(&openFile{
+		File:       f,
+		remotePath: p.Path,
+		finalPath:  finalPath,
+		quotaLimit: quotaLimit,
+	}, dirName)
+	if dirName == "" {
+		name := f.Name()
+		if finalPath != "" {
+			name = finalPath
+		}
+		svr.emit(FileOpened{
+			Session:    svr.session,
+			Handle:     handle,
+			RemotePath: p.Path,
+			Path:       name,
+		})
+	}
 	return svr.sendPacket(sshFxpHandlePacket{p.ID, handle})
 }
 
 func (p sshFxpReaddirPacket) respond(svr *Server) error {
 	f, ok := svr.getHandle(p.Handle)
 	if !ok {
-		return svr.sendError(p, syscall.EBADF)
+		return svr.sendError(p, svr.handleError(p.Handle))
 	}
 
 	var (
@@ -611,7 +1354,7 @@ func (p sshFxpReaddirPacket) respond(svr *Server) error {
 					&fileInfo{
 						name:  childDirName,
 						mode:  os.ModeDir | 0755,
-						mtime: time.Now(),
+						mtime: svr.clock.Now(),
 					},
 				}
 				dirInfo.read = true
@@ -628,14 +1371,26 @@ func (p sshFxpReaddirPacket) respond(svr *Server) error {
 		return svr.sendError(p, err)
 	}
 
-	ret := sshFxpNamePacket{ID: p.ID}
-	for _, dirent := range dirents {
-		ret.NameAttrs = append(ret.NameAttrs, sshFxpNameAttr{
-			Name:     dirent.Name(),
-			LongName: runLs(dirname, dirent),
-			Attrs:    []interface{}{dirent},
-		})
-	}
+	ret := sshFxpNamePacket{ID: p.ID, NameAttrs: make([]sshFxpNameAttr, len(dirents))}
+	attrs := make([]interface{}, len(dirents))
+	// Formatting long names is the costly part of a huge listing, so like
+	// marshaling the reply it is spread over several goroutines.
+	forEachChunk(len(dirents), nameChunkSize, func(_, lo, hi int) {
+		for i := lo; i < hi; i++ {
+			dirent := dirents[i]
+			attrs[i] = dirent
+			name := dirent.Name()
+			longName := name
+			if !svr.noLongNames {
+				longName = runLs(dirname, dirent)
+			}
+			ret.NameAttrs[i] = sshFxpNameAttr{
+				Name:     name,
+				LongName: longName,
+				Attrs:    attrs[i : i+1 : i+1],
+			}
+		}
+	})
 	return svr.sendPacket(ret)
 }
 
@@ -647,7 +1402,7 @@ func (p sshFxpSetstatPacket) respond(svr *Server) error {
 func (p sshFxpFsetstatPacket) respond(svr *Server) error {
 	f, ok := svr.getHandle(p.Handle)
 	if !ok {
-		return svr.sendError(p, syscall.EBADF)
+		return svr.sendError(p, svr.handleError(p.Handle))
 	}
 
 	// additional unmarshalling is required for each possibility here
@@ -700,6 +1455,10 @@ func translateErrno(errno syscall.Errno) uint32 {
 		return ssh_FX_NO_SUCH_FILE
 	case syscall.EPERM:
 		return ssh_FX_PERMISSION_DENIED
+	case syscall.EDQUOT:
+		return ssh_FX_QUOTA_EXCEEDED
+	case syscall.ENOSPC:
+		return ssh_FX_NO_SPACE_ON_FILESYSTEM
 	}
 
 	return ssh_FX_FAILURE
@@ -727,6 +1486,9 @@ func statusFromError(p id, err error) sshFxpStatusPacket {
 		ret.StatusError.msg = err.Error()
 		if err == io.EOF {
 			ret.StatusError.Code = ssh_FX_EOF
+		} else if statusErr, ok := err.(*StatusError); ok {
+			ret.StatusError.Code = statusErr.Code
+			ret.StatusError.msg = statusErr.msg
 		} else if errno, ok := err.(syscall.Errno); ok {
 			ret.StatusError.Code = translateErrno(errno)
 		} else if pathError, ok := err.(*os.PathError); ok {
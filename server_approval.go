@@ -0,0 +1,102 @@
+package sftp
+
+// Holding uploads for approval
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrApprovalTimeout is the error with which a client's close fails when its
+// upload is neither approved nor rejected in time.
+var ErrApprovalTimeout = errors.New("sftp: upload not approved in time")
+
+// errUploadRejected is the error with which a client's close fails when its
+// upload is rejected without a reason.
+var errUploadRejected = &StatusError{Code: ssh_FX_PERMISSION_DENIED, msg: "upload rejected"}
+
+// A PendingUpload is an upload held by WithApproval until it is approved or
+// rejected.
+type PendingUpload struct {
+	FinalizingUpload
+	once sync.Once
+	done func(error)
+}
+
+// Approve lets the upload be moved into place and the client's close
+// succeed, both of which are done before it returns. It does nothing once
+// the upload has been approved, rejected, or has timed out.
+func (u *PendingUpload) Approve() { u.decide(nil) }
+
+// Reject makes the client's close fail with err, or with
+// SSH_FX_PERMISSION_DENIED if err is nil, and a staged upload be discarded.
+// As with the errors of handling any request, a *StatusError sets the
+// status code the client is sent. It does nothing once the upload has been
+// approved, rejected, or has timed out.
+func (u *PendingUpload) Reject(err error) {
+	if err == nil {
+		err = errUploadRejected
+	}
+	u.decide(err)
+}
+
+func (u *PendingUpload) decide(err error) {
+	u.once.Do(func() { u.done(err) })
+}
+
+// WithApproval holds each upload, once the client has closed it and after
+// the WithFinalizeHook function, until it is approved or rejected, or
+// timeout has passed. f is called with each upload and may call Approve or
+// Reject on it then or later, from any goroutine, once an external check
+// such as a malware scan is done. Meanwhile the client waits for the
+// response to its close, and so learns whether the upload was accepted,
+// while the server goes on handling its other requests.
+// An upload not decided within timeout is rejected with ErrApprovalTimeout.
+//
+// Use WithStagingDir so that uploads are only seen under their final name
+// once approved; uploads written in place are left where they are if
+// rejected.
+func WithApproval(f func(*PendingUpload), timeout time.Duration) ServerOption {
+	return func(s *Server) error {
+		if timeout <= 0 {
+			return errors.Errorf("invalid approval timeout %v", timeout)
+		}
+		s.approve = f
+		s.approveWithin = timeout
+		return nil
+	}
+}
+
+// holdForApproval holds the upload f until it is approved, rejected or has
+// timed out, then calls done with the error it was rejected with, if any,
+// and sends the response done queued. The hold counts as a request being
+// handled, and the session does not end while it lasts.
+func (svr *Server) holdForApproval(f *openFile, done func(error) error) {
+	atomic.AddInt64(&svr.pendingPackets, 1)
+	svr.held.Add(1)
+	u := &PendingUpload{
+		FinalizingUpload: svr.finalizingUpload(f),
+	}
+	var t ClockTimer
+	var timerLock sync.Mutex
+	u.done = func(err error) {
+		timerLock.Lock()
+		t.Stop()
+		timerLock.Unlock()
+		if err = done(err); err == nil {
+			err = svr.conn.flush()
+		}
+		if err != nil {
+			svr.conn.Close() // shuts down recvPacket
+		}
+		svr.donePacket()
+		svr.held.Done()
+	}
+	timerLock.Lock()
+	t = svr.clock.AfterFunc(svr.approveWithin, func() { u.decide(ErrApprovalTimeout) })
+	timerLock.Unlock()
+	svr.approve(u)
+}
@@ -0,0 +1,98 @@
+package sftp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServerApproval(t *testing.T) {
+	staging, rmStaging := stagingDir(t)
+	defer rmStaging()
+	client, _, dir, cleanup := uploadServerPair(t,
+		WithStagingDir(staging),
+		WithApproval(func(u *PendingUpload) {
+			switch filepath.Base(u.Path) {
+			case "approved":
+				go func() {
+					time.Sleep(10 * time.Millisecond)
+					u.Approve()
+				}()
+			case "rejected":
+				u.Reject(&StatusError{Code: ssh_FX_PERMISSION_DENIED, msg: "malware"})
+			}
+		}, 100*time.Millisecond),
+	)
+	defer cleanup()
+
+	upload(t, client, "approved", []byte("contents"))
+	if got, err := ioutil.ReadFile(filepath.Join(dir, "approved")); err != nil || string(got) != "contents" {
+		t.Errorf("want contents, got %q: %v", got, err)
+	}
+	for _, name := range []string{"rejected", "undecided"} {
+		f, err := client.Create(testUploadPath + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte("contents")); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err == nil {
+			t.Errorf("%s: close succeeded", name)
+		}
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s: moved into place: %v", name, err)
+		}
+	}
+	if fis := stagedFiles(t, staging); len(fis) != 0 {
+		t.Errorf("%d staged files left", len(fis))
+	}
+}
+
+func TestServerApprovalFreesWorker(t *testing.T) {
+	pending := make(chan *PendingUpload, 1)
+	client, _, dir, cleanup := uploadServerPair(t,
+		WithApproval(func(u *PendingUpload) {
+			if filepath.Base(u.Path) == "held" {
+				pending <- u
+				return
+			}
+			u.Approve()
+		}, time.Minute),
+	)
+	defer cleanup()
+
+	f, err := client.Create(testUploadPath + "/held")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("contents")); err != nil {
+		t.Fatal(err)
+	}
+	closed := make(chan error, 1)
+	go func() { closed <- f.Close() }()
+	u := <-pending
+
+	// The one worker goes on handling requests while the upload is held.
+	// If it did not, the upload would wait for the held one to be approved.
+	stalled := time.AfterFunc(5*time.Second, u.Approve)
+	upload(t, client, "other", []byte("contents"))
+	if !stalled.Stop() {
+		t.Fatal("upload stalled behind the held one")
+	}
+	select {
+	case err := <-closed:
+		t.Fatalf("held close returned %v", err)
+	default:
+	}
+
+	u.Approve()
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(dir, "held")); err != nil || string(got) != "contents" {
+		t.Errorf("want contents, got %q: %v", got, err)
+	}
+}
@@ -0,0 +1,104 @@
+package sftp
+
+// Per-worker response arenas
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// WithRequestArenas makes each worker encode the responses to the requests it
+// handles into a buffer of its own, reset and reused once each request has
+// been answered, rather than into buffers taken from a shared pool or
+// allocated per response. The buffer grows to fit the largest response the
+// worker has sent and is kept until Serve returns, so this trades memory for
+// less garbage and less contention on busy sessions.
+func WithRequestArenas() ServerOption {
+	return func(s *Server) error {
+		s.arenas = &arenaTable{}
+		return nil
+	}
+}
+
+// An arena holds the temporary buffers of the request a worker is handling.
+// It is locked only so that a client reusing the id of a request still in
+// progress cannot corrupt it.
+type arena struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+// reset makes the arena's buffers available to the next request.
+func (a *arena) reset() {
+	a.mu.Lock()
+	a.buf = a.buf[:0]
+	a.mu.Unlock()
+}
+
+// arenaTable maps the ids of requests being handled to the arena of the
+// worker handling them.
+type arenaTable struct {
+	m sync.Map // of uint32 to *arena
+}
+
+func (t *arenaTable) bind(id uint32, a *arena) { t.m.Store(id, a) }
+func (t *arenaTable) unbind(id uint32)         { t.m.Delete(id) }
+
+func (t *arenaTable) lookup(id uint32) (*arena, bool) {
+	a, ok := t.m.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return a.(*arena), true
+}
+
+// requestID returns the id of p, if it has one.
+func requestID(p rxPacket) (uint32, bool) {
+	if p.pktType == ssh_FXP_INIT || len(p.pktBytes) < 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(p.pktBytes), true
+}
+
+// responseID returns the id of the request m answers, for the responses
+// which can be encoded into an arena.
+func responseID(m packetAppender) uint32 {
+	switch p := m.(type) {
+	case sshFxpStatusPacket:
+		return p.ID
+	case sshFxpHandlePacket:
+		return p.ID
+	case sshFxpNamePacket:
+		return p.ID
+	default:
+		panic("sftp: no id for response")
+	}
+}
+
+// processPacketInArena handles p, encoding its response into a.
+func (svr *Server) processPacketInArena(p rxPacket, a *arena) error {
+	id, ok := requestID(p)
+	if !ok {
+		return svr.processPacket(p)
+	}
+	svr.arenas.bind(id, a)
+	defer func() {
+		svr.arenas.unbind(id)
+		a.reset()
+	}()
+	return svr.processPacket(p)
+}
+
+// sendPacketInArena sends m using the arena of the request it answers. It
+// reports false if there is no such arena.
+func (svr *Server) sendPacketInArena(m packetAppender) (bool, error) {
+	a, ok := svr.arenas.lookup(responseID(m))
+	if !ok {
+		return false, nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var err error
+	a.buf, err = svr.serverConn.sendPacketBuf(a.buf, m)
+	return true, err
+}
@@ -0,0 +1,71 @@
+package sftp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestServerRequestArenas(t *testing.T) {
+	client, _, dir, cleanup := uploadServerPair(t, WithRequestArenas())
+	defer cleanup()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			upload(t, client, fmt.Sprintf("f%d", i), bytes.Repeat([]byte{byte(i)}, 100000))
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < 8; i++ {
+		got, err := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("f%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, bytes.Repeat([]byte{byte(i)}, 100000)) {
+			t.Errorf("f%d: wrong content", i)
+		}
+	}
+
+	// Errors are encoded into the arena too.
+	if _, err := client.Create("/elsewhere/f"); err == nil {
+		t.Error("upload outside the upload path succeeded")
+	} else if se, ok := err.(*StatusError); !ok || se.Code != ssh_FX_NO_SUCH_PATH {
+		t.Errorf("want SSH_FX_NO_SUCH_PATH, got %v", err)
+	}
+}
+
+func TestArenaReuse(t *testing.T) {
+	var svr Server
+	WithRequestArenas()(&svr)
+	a := &arena{}
+	svr.arenas.bind(7, a)
+	pkt := sshFxpStatusPacket{ID: 7, StatusError: StatusError{Code: ssh_FX_OK}}
+	var buf bytes.Buffer
+	svr.conn.WriteCloser = nopWriteCloser{&buf}
+	if sent, err := svr.sendPacketInArena(pkt); !sent || err != nil {
+		t.Fatalf("sent %v: %v", sent, err)
+	}
+	want, _ := pkt.MarshalBinary()
+	if got := buf.Bytes(); !bytes.Equal(got[4:], want) {
+		t.Errorf("want %x, got %x", want, got[4:])
+	}
+	if cap(a.buf) == 0 {
+		t.Error("arena buffer not kept")
+	}
+	svr.arenas.unbind(7)
+	if sent, _ := svr.sendPacketInArena(pkt); sent {
+		t.Error("sent in unbound arena")
+	}
+}
+
+type nopWriteCloser struct {
+	*bytes.Buffer
+}
+
+func (nopWriteCloser) Close() error { return nil }
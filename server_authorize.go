@@ -0,0 +1,81 @@
+package sftp
+
+// Per-operation authorization
+
+// An Operation is a type of request, named as in the SFTP specification,
+// such as "SSH_FXP_OPEN". It is the same as OperationDenied.Op.
+type Operation string
+
+// The operations a client may request.
+const (
+	OpOpen     Operation = "SSH_FXP_OPEN"
+	OpClose    Operation = "SSH_FXP_CLOSE"
+	OpRead     Operation = "SSH_FXP_READ"
+	OpWrite    Operation = "SSH_FXP_WRITE"
+	OpLstat    Operation = "SSH_FXP_LSTAT"
+	OpFstat    Operation = "SSH_FXP_FSTAT"
+	OpSetstat  Operation = "SSH_FXP_SETSTAT"
+	OpFsetstat Operation = "SSH_FXP_FSETSTAT"
+	OpOpendir  Operation = "SSH_FXP_OPENDIR"
+	OpReaddir  Operation = "SSH_FXP_READDIR"
+	OpRemove   Operation = "SSH_FXP_REMOVE"
+	OpMkdir    Operation = "SSH_FXP_MKDIR"
+	OpRmdir    Operation = "SSH_FXP_RMDIR"
+	OpRealpath Operation = "SSH_FXP_REALPATH"
+	OpStat     Operation = "SSH_FXP_STAT"
+	OpRename   Operation = "SSH_FXP_RENAME"
+	OpReadlink Operation = "SSH_FXP_READLINK"
+	OpSymlink  Operation = "SSH_FXP_SYMLINK"
+	OpExtended Operation = "SSH_FXP_EXTENDED"
+)
+
+// WithAuthorizer sets a function consulted before every request is handled,
+// other than SSH_FXP_INIT, so that fine grained policy can live in one
+// place. It is given the operation, the path the request names, and the
+// client's identity. For requests on a handle, the path is the one the
+// handle was opened with; it is "" for requests naming no path or an
+// unknown handle. Paths are canonical: absolute, clean and free of "..".
+//
+// If the function returns an error, the request is refused, with the code
+// of the error if it is a *StatusError and SSH_FX_PERMISSION_DENIED
+// otherwise. Requests the server refuses anyway, such as those of
+// unsupported types or writes to a ReadOnly server, are refused before it
+// is consulted.
+func WithAuthorizer(f func(op Operation, path string, sess SessionInfo) error) ServerOption {
+	return func(s *Server) error {
+		s.authorizer = f
+		return nil
+	}
+}
+
+// authorizationPath returns the canonical path named by a request, for the
+// authorizer.
+func (svr *Server) authorizationPath(pkt interface{}) string {
+	var p string
+	if handle, ok := requestHandle(pkt); ok {
+		f, ok := svr.getOpenFile(handle)
+		if !ok {
+			return ""
+		}
+		p = f.remotePath
+	} else {
+		p = svr.requestPath(pkt)
+	}
+	if p == "" {
+		return ""
+	}
+	c, ok := svr.canonicalPath(p)
+	if !ok {
+		return ""
+	}
+	return c
+}
+
+// sendUnauthorized refuses a request because the authorizer returned err.
+func (svr *Server) sendUnauthorized(pkt id, t fxp, err error) error {
+	code := uint32(ssh_FX_PERMISSION_DENIED)
+	if serr, ok := err.(*StatusError); ok {
+		code = serr.Code
+	}
+	return svr.sendDenied(pkt, t, svr.requestPath(pkt), code, DeniedUnauthorized)
+}
@@ -0,0 +1,77 @@
+package sftp
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestServerAuthorizer(t *testing.T) {
+	type call struct {
+		op   Operation
+		path string
+		user string
+	}
+	var mu sync.Mutex
+	var calls []call
+	denials := make(chan OperationDenied, 2)
+	client, _, _, cleanup := uploadServerPair(t,
+		WithSessionInfo(SessionInfo{User: "partner"}),
+		WithAuthorizer(func(op Operation, path string, sess SessionInfo) error {
+			mu.Lock()
+			calls = append(calls, call{op, path, sess.User})
+			mu.Unlock()
+			switch {
+			case path == testUploadPath+"/secret":
+				return errors.New("not for you")
+			case op == OpStat:
+				return &StatusError{Code: ssh_FX_NO_SUCH_FILE}
+			}
+			return nil
+		}),
+		DenialNotifier(func(e OperationDenied) { denials <- e }),
+	)
+	defer cleanup()
+
+	upload(t, client, "../upload/./f", []byte("data"))
+	if _, err := client.Create(testUploadPath + "/secret"); err == nil {
+		t.Error("unauthorized open succeeded")
+	} else if serr, ok := err.(*StatusError); !ok || serr.Code != ssh_FX_PERMISSION_DENIED {
+		t.Errorf("want permission denied, got %v", err)
+	}
+	if e := <-denials; e.Op != "SSH_FXP_OPEN" || e.Reason != DeniedUnauthorized {
+		t.Errorf("want unauthorized open, got %+v", e)
+	}
+	if _, err := client.Stat(testUploadPath); err == nil {
+		t.Error("unauthorized stat succeeded")
+	} else if !os.IsNotExist(err) {
+		t.Errorf("want no such file, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []call{
+		{OpOpen, testUploadPath + "/f", "partner"},
+		{OpWrite, testUploadPath + "/f", "partner"},
+		{OpClose, testUploadPath + "/f", "partner"},
+		{OpOpen, testUploadPath + "/secret", "partner"},
+		{OpStat, testUploadPath, "partner"},
+	}
+	if len(calls) < len(want) {
+		t.Fatalf("want %d calls, got %+v", len(want), calls)
+	}
+	// The client may also stat the file it wrote.
+	var got []call
+	for _, c := range calls {
+		if c.op != OpFstat {
+			got = append(got, c)
+		}
+	}
+	for i := range want {
+		if i >= len(got) || got[i] != want[i] {
+			t.Errorf("call %d: want %+v, got %+v", i, want[i], got)
+			break
+		}
+	}
+}
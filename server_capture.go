@@ -0,0 +1,345 @@
+package sftp
+
+// Recording sessions, and replaying them
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// captureMagic begins every capture. A capture then holds a record for each
+// packet: a direction byte, captureReceived or captureSent, the time as a
+// uint64 of nanoseconds since the Unix epoch, the length of the packet as a
+// uint32, and the packet, from its type byte on.
+const captureMagic = "SFTPCAP\x01"
+
+const (
+	captureReceived = 'r'
+	captureSent     = 's'
+)
+
+// captureRecordHeader is the length of a record before its packet.
+const captureRecordHeader = 1 + 8 + 4
+
+// A CapturePacket is a packet recorded by WithCapture.
+type CapturePacket struct {
+	// Time is when the packet was received or sent.
+	Time time.Time
+	// Received is true for packets from the client, false for responses.
+	Received bool
+	// Data is the packet, from its type byte on, without the length
+	// which precedes it on the wire.
+	Data []byte
+}
+
+// WithCapture writes every packet received and sent by the Server, with its
+// direction and time, to w, as read by ReadCapture and Replay. Captures
+// hold the data of uploads in full, and should be treated as the files
+// themselves. If writing to w fails, capturing stops, and the session goes
+// on.
+func WithCapture(w io.Writer) ServerOption {
+	return func(s *Server) error {
+		s.capture = &capture{w: w, format: sftpCapture{}}
+		return nil
+	}
+}
+
+// A captureFormat encodes captures.
+type captureFormat interface {
+	// header appends what precedes the first record to b.
+	header(b []byte) []byte
+	// record appends the record of pkt, from its type byte on, to b.
+	record(b []byte, t time.Time, received bool, pkt []byte) []byte
+}
+
+// sftpCapture is the format written by WithCapture and read by ReadCapture.
+type sftpCapture struct{}
+
+func (sftpCapture) header(b []byte) []byte { return append(b, captureMagic...) }
+
+func (sftpCapture) record(b []byte, t time.Time, received bool, pkt []byte) []byte {
+	dir := byte(captureSent)
+	if received {
+		dir = captureReceived
+	}
+	b = append(b, dir)
+	b = marshalUint64(b, uint64(t.UnixNano()))
+	b = marshalUint32(b, uint32(len(pkt)))
+	return append(b, pkt...)
+}
+
+// A capture writes the records of packets.
+type capture struct {
+	clock  Clock
+	debug  io.Writer // the Server's debug stream
+	format captureFormat
+
+	mu      sync.Mutex // serialises records
+	w       io.Writer
+	started bool
+	err     error // if set, why capturing stopped
+}
+
+// wrap makes c record the packets read from and written to conn, at the
+// times told by clock. It is called once the options are applied, so that
+// it sees what they wrap.
+func (c *capture) wrap(conn *conn, clock Clock, debug io.Writer) {
+	c.clock, c.debug = clock, debug
+	w := &captureWriter{WriteCloser: conn.WriteCloser, f: captureFramer{c: c}}
+	conn.Reader = &captureReader{Reader: conn.Reader, f: captureFramer{c: c, received: true}}
+	conn.WriteCloser = w
+	if conn.bw != nil {
+		conn.bw = bufio.NewWriterSize(w, conn.bw.Size())
+	}
+}
+
+// record writes the record of pkt.
+func (c *capture) record(received bool, pkt []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	// Room for the packet and, in either format, what frames it.
+	b := make([]byte, 0, 128+len(pkt))
+	if !c.started {
+		b = c.format.header(b)
+		c.started = true
+	}
+	b = c.format.record(b, c.clock.Now(), received, pkt)
+	if _, err := c.w.Write(b); err != nil {
+		c.err = err
+		fmt.Fprintf(c.debug, "sftp server capture stopped: %v\n", err)
+	}
+}
+
+// A captureFramer splits a stream into packets, which it records.
+type captureFramer struct {
+	c        *capture
+	received bool
+	hdr      [4]byte
+	nhdr     int    // bytes of hdr read
+	left     uint32 // bytes of the packet still to come, once hdr is read
+	pkt      []byte
+}
+
+func (f *captureFramer) feed(b []byte) {
+	for len(b) > 0 {
+		if f.nhdr < len(f.hdr) {
+			n := copy(f.hdr[f.nhdr:], b)
+			f.nhdr += n
+			b = b[n:]
+			if f.nhdr < len(f.hdr) {
+				return
+			}
+			f.left = binary.BigEndian.Uint32(f.hdr[:])
+			f.pkt = f.pkt[:0]
+		}
+		n := len(b)
+		if uint32(n) > f.left {
+			n = int(f.left)
+		}
+		f.pkt = append(f.pkt, b[:n]...)
+		f.left -= uint32(n)
+		b = b[n:]
+		if f.left == 0 {
+			f.c.record(f.received, f.pkt)
+			f.nhdr = 0
+		}
+	}
+}
+
+// A captureReader records the packets read through it. It is only read by
+// one goroutine at a time.
+type captureReader struct {
+	io.Reader
+	f captureFramer
+}
+
+func (r *captureReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.f.feed(b[:n])
+	return n, err
+}
+
+// A captureWriter records the packets written through it.
+type captureWriter struct {
+	io.WriteCloser
+	mu sync.Mutex
+	f  captureFramer
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// Packets are recorded before they are sent, so that the client never
+	// sees a response which is not yet in the capture.
+	w.f.feed(b)
+	return w.WriteCloser.Write(b)
+}
+
+// ReadCapture returns the packets in a capture written by WithCapture. If
+// the capture ends part way through a record, as when the process writing
+// it died, ReadCapture returns the packets before it and
+// io.ErrUnexpectedEOF.
+func ReadCapture(r io.Reader) ([]CapturePacket, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(captureMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if string(magic) != captureMagic {
+		return nil, errors.New("sftp: not a packet capture")
+	}
+	var packets []CapturePacket
+	hdr := make([]byte, captureRecordHeader)
+	for {
+		if _, err := io.ReadFull(br, hdr); err != nil {
+			if err == io.EOF {
+				return packets, nil
+			}
+			return packets, err
+		}
+		if hdr[0] != captureReceived && hdr[0] != captureSent {
+			return packets, errors.Errorf("sftp: bad capture record direction %#x", hdr[0])
+		}
+		nanos, rest := unmarshalUint64(hdr[1:])
+		l, _ := unmarshalUint32(rest)
+		data := make([]byte, l)
+		if _, err := io.ReadFull(br, data); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return packets, err
+		}
+		packets = append(packets, CapturePacket{
+			Time:     time.Unix(0, int64(nanos)),
+			Received: hdr[0] == captureReceived,
+			Data:     data,
+		})
+	}
+}
+
+// replayWait is how long Replay waits for a response which the capture
+// has before the next packet to replay.
+var replayWait = time.Second
+
+// Replay feeds the packets a Server received in the capture r, written by
+// WithCapture, to a new Server with options, and returns the packets that
+// Server sends, for reproducing a session. Each packet is sent once the
+// Server has sent as many responses as the capture has before it, or has
+// not for a second, so that requests arrive in the order, relative to the
+// responses, that they did. A capture cut short is replayed as far as it
+// goes. The error is the one ending the Server's session, if other than
+// the end of the capture.
+func Replay(r io.Reader, options ...ServerOption) ([]CapturePacket, error) {
+	packets, err := ReadCapture(r)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	svr, err := NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{sr, sw}, options...)
+	if err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		err := svr.Serve()
+		sw.Close()
+		sr.Close()
+		done <- err
+	}()
+
+	var mu sync.Mutex
+	cond := sync.NewCond(&mu)
+	var sent []CapturePacket
+	var ended bool
+	go func() {
+		for {
+			data, err := recvCapturePacket(cr)
+			mu.Lock()
+			if err != nil {
+				ended = true
+			} else {
+				sent = append(sent, CapturePacket{Time: time.Now(), Data: data})
+			}
+			cond.Broadcast()
+			mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	// wait waits for n responses, for the end of the session, or for
+	// replayWait to pass without a response.
+	wait := func(n int) {
+		mu.Lock()
+		defer mu.Unlock()
+		for len(sent) < n && !ended {
+			expired := false
+			t := time.AfterFunc(replayWait, func() {
+				mu.Lock()
+				expired = true
+				cond.Broadcast()
+				mu.Unlock()
+			})
+			cond.Wait()
+			t.Stop()
+			if expired {
+				return
+			}
+		}
+	}
+
+	var responses int
+	for _, p := range packets {
+		if !p.Received {
+			responses++
+			continue
+		}
+		wait(responses)
+		b := make([]byte, 0, 4+len(p.Data))
+		b = marshalUint32(b, uint32(len(p.Data)))
+		if _, err := cw.Write(append(b, p.Data...)); err != nil {
+			break
+		}
+	}
+	cw.Close()
+	err = <-done
+	mu.Lock()
+	for !ended {
+		cond.Wait()
+	}
+	mu.Unlock()
+	if err == io.EOF {
+		err = nil
+	}
+	return sent, err
+}
+
+// recvCapturePacket reads a packet from r, returning it from its type byte
+// on.
+func recvCapturePacket(r io.Reader) ([]byte, error) {
+	l, err := recvPacketLength(r)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, l)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,106 @@
+package sftp
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestServerCaptureReplay(t *testing.T) {
+	var buf bytes.Buffer
+	client, _, _, cleanup := uploadServerPair(t, WithCapture(&buf), WithResponseBuffer(4096))
+	upload(t, client, "file", []byte("contents"))
+	cleanup()
+
+	captured, err := ReadCapture(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		received bool
+		typ      fxp
+	}{
+		{true, ssh_FXP_INIT},
+		{false, ssh_FXP_VERSION},
+		{true, ssh_FXP_OPEN},
+		{false, ssh_FXP_HANDLE},
+		{true, ssh_FXP_WRITE},
+		{false, ssh_FXP_STATUS},
+		{true, ssh_FXP_CLOSE},
+		{false, ssh_FXP_STATUS},
+	}
+	if len(captured) != len(want) {
+		t.Fatalf("want %d packets, got %d", len(want), len(captured))
+	}
+	for i, w := range want {
+		p := captured[i]
+		if p.Received != w.received || fxp(p.Data[0]) != w.typ {
+			t.Errorf("packet %d: want %v %s, got %v %s", i, w.received, w.typ, p.Received, fxp(p.Data[0]))
+		}
+		if i > 0 && p.Time.Before(captured[i-1].Time) {
+			t.Errorf("packet %d: time %v before %v", i, p.Time, captured[i-1].Time)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "sftp_replay_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sent, err := Replay(bytes.NewReader(buf.Bytes()),
+		UploadPath(testUploadPath),
+		FileNameMapper(func(name string) (string, bool, error) {
+			return dir + "/" + name, true, nil
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var responses [][]byte
+	for _, p := range captured {
+		if !p.Received {
+			responses = append(responses, p.Data)
+		}
+	}
+	if len(sent) != len(responses) {
+		t.Fatalf("want %d responses, got %d", len(responses), len(sent))
+	}
+	for i, p := range sent {
+		if p.Received || !bytes.Equal(p.Data, responses[i]) {
+			t.Errorf("response %d: want %x, got %x", i, responses[i], p.Data)
+		}
+	}
+	if got, err := ioutil.ReadFile(dir + "/file"); err != nil || string(got) != "contents" {
+		t.Errorf("replayed upload: got %q, %v", got, err)
+	}
+}
+
+func TestReadCaptureTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	client, _, _, cleanup := uploadServerPair(t, WithCapture(&buf))
+	upload(t, client, "file", []byte("contents"))
+	cleanup()
+
+	all, err := ReadCapture(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets, err := ReadCapture(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("want io.ErrUnexpectedEOF, got %v", err)
+	}
+	if len(packets) != len(all)-1 {
+		t.Errorf("want %d packets, got %d", len(all)-1, len(packets))
+	}
+}
+
+func TestReadCaptureNotCapture(t *testing.T) {
+	if _, err := ReadCapture(bytes.NewReader([]byte("not a capture"))); err == nil {
+		t.Error("want error")
+	}
+	if _, err := Replay(bytes.NewReader([]byte("not a capture"))); err == nil {
+		t.Error("Replay: want error")
+	}
+}
@@ -0,0 +1,76 @@
+package sftp
+
+// Refusing unsupported clients
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrClientRefused is returned by Serve when it refuses a client under
+// WithMinSFTPVersion or WithRefusedClients.
+var ErrClientRefused = errors.New("sftp: client refused")
+
+// WithMinSFTPVersion refuses clients whose SSH_FXP_INIT asks for a protocol
+// version below v, rather than serving them with the semantics of that
+// version. Their INIT is answered with SSH_FX_OP_UNSUPPORTED, saying why,
+// reported as DeniedClient, and Serve returns ErrClientRefused.
+func WithMinSFTPVersion(v uint32) ServerOption {
+	return func(s *Server) error {
+		if v < 1 || v > sftpProtocolVersion {
+			return errors.Errorf("invalid minimum SFTP version %d", v)
+		}
+		s.minVersion = v
+		return nil
+	}
+}
+
+// WithRefusedClients refuses clients whose identification string, as given
+// to WithSessionInfo, contains one of patterns, such as "SSH-2.0-BuggyFTP_1."
+// for the releases of a client known to corrupt uploads. They are refused
+// as under WithMinSFTPVersion.
+func WithRefusedClients(patterns ...string) ServerOption {
+	return func(s *Server) error {
+		for _, p := range patterns {
+			if p == "" {
+				return errors.New("empty refused client pattern")
+			}
+		}
+		s.refusedClients = append(s.refusedClients, patterns...)
+		return nil
+	}
+}
+
+// refusal returns why the client should be refused, or "" if it should not.
+func (svr *Server) refusal() string {
+	if v := svr.session.SFTPVersion; v < svr.minVersion {
+		return fmt.Sprintf("SFTP version %d is not supported, at least %d is required", v, svr.minVersion)
+	}
+	for _, p := range svr.refusedClients {
+		if strings.Contains(svr.session.ClientVersion, p) {
+			return fmt.Sprintf("client %q is not supported", svr.session.ClientVersion)
+		}
+	}
+	return ""
+}
+
+// checkClient refuses the client if its INIT, just recorded, or its
+// identification string is not acceptable.
+func (svr *Server) checkClient() error {
+	msg := svr.refusal()
+	if msg == "" {
+		return nil
+	}
+	svr.denied(ssh_FXP_INIT, "", ssh_FX_OP_UNSUPPORTED, DeniedClient)
+	if err := svr.sendPacket(sshFxpStatusPacket{
+		StatusError: StatusError{Code: ssh_FX_OP_UNSUPPORTED, msg: msg},
+	}); err != nil {
+		return err
+	}
+	if err := svr.conn.flush(); err != nil {
+		return err
+	}
+	return ErrClientRefused
+}
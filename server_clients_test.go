@@ -0,0 +1,57 @@
+package sftp
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestServerRefusedClients(t *testing.T) {
+	for _, tt := range []struct {
+		version uint32
+		client  string
+		want    string
+	}{
+		{2, "SSH-2.0-Test", "SFTP version 2"},
+		{3, "SSH-2.0-BuggyFTP_1.2", "SSH-2.0-BuggyFTP_1.2"},
+	} {
+		var in, out bytes.Buffer
+		in.Write(sp(sshFxInitPacket{Version: tt.version}))
+		var denials []OperationDenied
+		svr, err := NewServer(struct {
+			io.Reader
+			io.WriteCloser
+		}{&in, nopWriteCloser{&out}},
+			WithSessionInfo(SessionInfo{ClientVersion: tt.client}),
+			WithMinSFTPVersion(3),
+			WithRefusedClients("BuggyFTP_1."),
+			DenialNotifier(func(e OperationDenied) { denials = append(denials, e) }),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := svr.Serve(); err != ErrClientRefused {
+			t.Errorf("%s: want ErrClientRefused, got %v", tt.client, err)
+		}
+		typ, b, err := recvPacket(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fxp(typ) != ssh_FXP_STATUS {
+			t.Fatalf("%s: want SSH_FXP_STATUS, got %v", tt.client, fxp(typ))
+		}
+		err = unmarshalStatus(0, b)
+		if serr := err.(*StatusError); serr.Code != ssh_FX_OP_UNSUPPORTED || !strings.Contains(serr.msg, tt.want) {
+			t.Errorf("%s: want SSH_FX_OP_UNSUPPORTED about %q, got %v", tt.client, tt.want, err)
+		}
+		if len(denials) != 1 || denials[0].Reason != DeniedClient {
+			t.Errorf("%s: want a client denial, got %+v", tt.client, denials)
+		}
+	}
+
+	// Others are served.
+	client, _, _, cleanup := uploadServerPair(t, WithMinSFTPVersion(3), WithRefusedClients("BuggyFTP_1."))
+	defer cleanup()
+	upload(t, client, "file", []byte("contents"))
+}
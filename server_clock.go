@@ -0,0 +1,46 @@
+package sftp
+
+// Injectable time
+
+import "time"
+
+// A Clock tells a Server the time and runs its timers, so that tests of
+// timeouts, session limits and the times the Server reports need not wait
+// on the system clock. Its methods are called concurrently.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine once d has passed, as
+	// time.AfterFunc does.
+	AfterFunc(d time.Duration, f func()) ClockTimer
+	// Sleep returns once d has passed.
+	Sleep(d time.Duration)
+}
+
+// A ClockTimer is a timer started by Clock.AfterFunc, which a *time.Timer
+// implements.
+type ClockTimer interface {
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// WithClock makes the Server use c instead of the system clock for the
+// times it stamps on directory entries, events and receipts, and for its
+// timeouts, session limits, request rate and fault delays.
+func WithClock(c Clock) ServerOption {
+	return func(s *Server) error {
+		s.clock = c
+		return nil
+	}
+}
+
+// systemClock is the Clock of Servers without WithClock.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return time.AfterFunc(d, f)
+}
+
+func (systemClock) Sleep(d time.Duration) { time.Sleep(d) }
@@ -0,0 +1,187 @@
+package sftp
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock whose time only passes when Advance or Sleep is
+// called. Timers due are run by Advance, in order, in its goroutine.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	c      *fakeClock
+	when   time.Time
+	f      func()
+	active bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{c: c, when: c.now.Add(d), f: f, active: true}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+// Advance moves the time on by d, running the timers which come due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	for {
+		var next *fakeTimer
+		for _, t := range c.timers {
+			if t.active && !t.when.After(end) && (next == nil || t.when.Before(next.when)) {
+				next = t
+			}
+		}
+		if next == nil {
+			break
+		}
+		next.active = false
+		if next.when.After(c.now) {
+			c.now = next.when
+		}
+		c.mu.Unlock()
+		next.f()
+		c.mu.Lock()
+	}
+	c.now = end
+	c.mu.Unlock()
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	active := t.active
+	t.active = false
+	return active
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	active := t.active
+	t.when = t.c.now.Add(d)
+	t.active = true
+	return active
+}
+
+// clockServer starts a Server with clock and options, returning a Client of
+// it and a channel receiving the error Serve returns.
+func clockServer(t *testing.T, clock Clock, options ...ServerOption) (*Server, *Client, <-chan error) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	options = append([]ServerOption{UploadPath(testUploadPath), WithClock(clock)}, options...)
+	server, err := NewServer(pipeEnd{sr, sw}, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		err := server.Serve()
+		sw.Close()
+		done <- err
+	}()
+	client, err := NewClientPipe(cr, cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, client, done
+}
+
+// waitHandled waits until the Server has finished with the requests it has
+// received, which it does after sending their responses.
+func waitHandled(server *Server) {
+	for atomic.LoadInt64(&server.pendingPackets) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestServerClockTimes(t *testing.T) {
+	clock := newFakeClock()
+	server, client, _ := clockServer(t, clock)
+	defer client.Close()
+
+	info, err := client.Stat(testUploadPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.ModTime(); !got.Equal(clock.Now()) {
+		t.Errorf("upload path mtime: got %v, want %v", got, clock.Now())
+	}
+	clock.Advance(time.Hour)
+	if got := server.Status().Uptime; got != time.Hour {
+		t.Errorf("Uptime: got %v, want 1h", got)
+	}
+}
+
+func TestServerClockIdleTimeout(t *testing.T) {
+	clock := newFakeClock()
+	server, client, done := clockServer(t, clock, WithIdleTimeout(time.Minute))
+	defer client.Close()
+
+	clock.Advance(30 * time.Second)
+	if _, err := client.Stat(testUploadPath); err != nil {
+		t.Fatal(err)
+	}
+	waitHandled(server)
+	// The timer fires a minute in, 30s after the Stat, and waits again.
+	clock.Advance(40 * time.Second)
+	select {
+	case err := <-done:
+		t.Fatalf("active session ended: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	clock.Advance(20 * time.Second)
+	select {
+	case err := <-done:
+		if err != ErrIdleTimeout {
+			t.Errorf("want ErrIdleTimeout, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("idle session not ended")
+	}
+}
+
+func TestServerClockSessionDuration(t *testing.T) {
+	clock := newFakeClock()
+	server, client, done := clockServer(t, clock, WithMaxSessionDuration(time.Hour))
+	defer client.Close()
+
+	clock.Advance(time.Hour - time.Second)
+	if _, err := client.Stat(testUploadPath); err != nil {
+		t.Fatal(err)
+	}
+	waitHandled(server)
+	clock.Advance(time.Second)
+	select {
+	case err := <-done:
+		if err != ErrSessionExpired {
+			t.Errorf("want ErrSessionExpired, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("session not ended")
+	}
+}
@@ -0,0 +1,86 @@
+package sftp
+
+// Coalescing of adjacent writes
+
+import "github.com/pkg/errors"
+
+// WithWriteCoalescing makes the server gather writes to a file at
+// contiguous offsets, as pipelining clients send them, into writes of up
+// to window bytes, for filesystems and write backends with a high cost per
+// call. Gathered data is written once a write does not follow on from it
+// or would overflow the window, and before any other request on the
+// handle. An error writing it is returned for the request which caused it
+// to be written, and for CLOSE if that was the last.
+func WithWriteCoalescing(window int) ServerOption {
+	return func(s *Server) error {
+		if window <= 0 {
+			return errors.New("write coalescing window must be positive")
+		}
+		s.coalesceWindow = window
+		return nil
+	}
+}
+
+// A writeBuffer holds data written to a file but not yet written through
+// to it. Requests on a handle are handled in order by one worker, so it
+// needs no lock.
+type writeBuffer struct {
+	window int
+	offset int64  // of buf in the file
+	buf    []byte // allocated on first use
+}
+
+// coalescedWrite adds b, to be written at offset, to the file's buffer,
+// writing through what the buffer held first unless b follows on from it.
+func (f *openFile) coalescedWrite(b []byte, offset int64) (int, error) {
+	c := f.coalesce
+	if len(c.buf) > 0 && offset == c.offset+int64(len(c.buf)) && len(c.buf)+len(b) <= c.window {
+		c.buf = append(c.buf, b...)
+		return len(b), nil
+	}
+	if err := f.flush(); err != nil {
+		return 0, err
+	}
+	if len(b) >= c.window {
+		return f.writeThrough(b, offset)
+	}
+	if c.buf == nil {
+		c.buf = make([]byte, 0, c.window)
+	}
+	// b is in a receive buffer, which is reused once the request is handled.
+	c.offset, c.buf = offset, append(c.buf, b...)
+	return len(b), nil
+}
+
+// flush writes through the data in the file's buffer, if any. The buffer is
+// emptied even if that fails: the error stands for the data.
+func (f *openFile) flush() error {
+	c := f.coalesce
+	if c == nil || len(c.buf) == 0 {
+		return nil
+	}
+	_, err := f.writeThrough(c.buf, c.offset)
+	c.buf = c.buf[:0]
+	return err
+}
+
+// flushBefore writes through the buffered data of the handle a request is
+// on, so that it sees all the writes before it. Writes buffer themselves,
+// and closeHandle flushes before closing.
+func (svr *Server) flushBefore(p interface{}) error {
+	if svr.coalesceWindow == 0 {
+		return nil
+	}
+	switch p.(type) {
+	case *sshFxpWritePacket, *sshFxpClosePacket:
+		return nil
+	}
+	handle, ok := requestHandle(p)
+	if !ok {
+		return nil
+	}
+	if f, ok := svr.getOpenFile(handle); ok {
+		return f.flush()
+	}
+	return nil
+}
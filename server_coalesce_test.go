@@ -0,0 +1,132 @@
+package sftp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestServerWriteCoalescing(t *testing.T) {
+	fs := newMockFS()
+	client, _, dir, cleanup := uploadServerPair(t, withWriteBackend(fs), WithWriteCoalescing(64*1024), WithWorkers(4))
+	defer cleanup()
+
+	// The client writes in chunks of 32 KiB, gathered in pairs.
+	data := bytes.Repeat([]byte("0123456789abcdef"), 256*1024/16)
+	upload(t, client, "file", data)
+	if n := fs.calls("file"); n != 4 {
+		t.Errorf("got %d writes, want 4", n)
+	}
+	if b, err := ioutil.ReadFile(dir + "/file"); err != nil || !bytes.Equal(b, data) {
+		t.Errorf("got %d bytes, %v", len(b), err)
+	}
+}
+
+// coalescingFile returns an open upload, with a write buffer of window
+// bytes, of a new file in a temporary directory.
+func coalescingFile(t *testing.T, window int) *openFile {
+	f, err := ioutil.TempFile("", "sftp_coalesce_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &openFile{File: f, coalesce: &writeBuffer{window: window}}
+}
+
+func TestWriteCoalescingBuffers(t *testing.T) {
+	f := coalescingFile(t, 15)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	for _, w := range []struct {
+		offset int64
+		data   string
+		onDisk string // once written
+	}{
+		{4, "efgh", ""},
+		{8, "ijkl", ""},                         // follows on
+		{0, "abcd", "\x00\x00\x00\x00efghijkl"}, // before the buffer
+		{12, "mnopqrstuvwxyz.", "abcdefghijklmnopqrstuvwxyz."}, // too large to buffer
+	} {
+		if n, _, err := f.writeAt([]byte(w.data), w.offset); err != nil || n != len(w.data) {
+			t.Fatalf("write at %d: got %d, %v", w.offset, n, err)
+		}
+		if b, _ := ioutil.ReadFile(f.Name()); string(b) != w.onDisk {
+			t.Errorf("after write at %d: file holds %q, want %q", w.offset, b, w.onDisk)
+		}
+	}
+	if f.written != 27 {
+		t.Errorf("counted %d bytes written, want 27", f.written)
+	}
+}
+
+func TestWriteCoalescingFlushError(t *testing.T) {
+	f := coalescingFile(t, 16)
+	defer os.Remove(f.Name())
+	f.Close()
+
+	// The write is buffered, so its error is only seen on flushing.
+	if _, _, err := f.writeAt([]byte("abcd"), 0); err != nil {
+		t.Fatalf("buffered write: %v", err)
+	}
+	if err := f.flush(); err == nil {
+		t.Error("flush succeeded")
+	}
+	if err := f.flush(); err != nil {
+		t.Errorf("second flush: %v", err)
+	}
+}
+
+func TestServerWriteCoalescingErrors(t *testing.T) {
+	fs := newMockFS()
+	client, _, _, cleanup := uploadServerPair(t, withWriteBackend(fs), WithWriteCoalescing(1024))
+	defer cleanup()
+
+	// A buffered write fails when it is written through, on close.
+	fs.script("close", mockENOSPC)
+	f, err := client.Create(testUploadPath + "/close")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("contents")); err != nil {
+		t.Fatalf("buffered write: %v", err)
+	}
+	if code := statusCode(t, f.Close()); code != ssh_FX_NO_SPACE_ON_FILESYSTEM {
+		t.Errorf("close: got code %d", code)
+	}
+
+	// Or on the write which does not follow on from it.
+	fs.script("write", mockEIO)
+	f, err = client.Create(testUploadPath + "/write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteAt([]byte("abcd"), 0); err != nil {
+		t.Fatalf("buffered write: %v", err)
+	}
+	if _, err := f.WriteAt([]byte("abcd"), 100); err == nil {
+		t.Error("write succeeded")
+	} else if code := statusCode(t, err); code != ssh_FX_FAILURE {
+		t.Errorf("write: got code %d", code)
+	}
+
+	// A short write of buffered data is an error too.
+	fs.script("short", mockShort(3))
+	f, err = client.Create(testUploadPath + "/short")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("contents")); err != nil {
+		t.Fatalf("buffered write: %v", err)
+	}
+	if code := statusCode(t, f.Close()); code != ssh_FX_FAILURE {
+		t.Errorf("short: got code %d", code)
+	}
+}
+
+func TestWithWriteCoalescingInvalid(t *testing.T) {
+	if _, err := NewServer(nil, WithWriteCoalescing(0)); err == nil {
+		t.Error("want an error for a window of 0")
+	}
+}
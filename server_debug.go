@@ -0,0 +1,84 @@
+package sftp
+
+// Structured debug output
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// A DebugPacket is a packet received or sent by a Server, as passed to a
+// DebugFormatter.
+type DebugPacket struct {
+	// Time is when the packet was received or sent.
+	Time time.Time
+	// Received is true for packets from the client, false for responses.
+	Received bool
+	// Type is the name of the packet type, such as "SSH_FXP_OPEN".
+	Type string
+	// Packet is the decoded packet. It must not be modified or retained
+	// after the formatter returns.
+	Packet interface{}
+}
+
+// A DebugFormatter writes a representation of p to w.
+type DebugFormatter func(w io.Writer, p DebugPacket) error
+
+// WithDebugFormatter passes every packet received and sent by the Server to
+// f, which writes to the stream configured by WithDebug.
+func WithDebugFormatter(f DebugFormatter) ServerOption {
+	return func(s *Server) error {
+		s.debugFormatter = f
+		return nil
+	}
+}
+
+// JSONDebugFormatter is a DebugFormatter which writes each packet as a single
+// line JSON object.
+func JSONDebugFormatter(w io.Writer, p DebugPacket) error {
+	dir := "sent"
+	if p.Received {
+		dir = "received"
+	}
+	return json.NewEncoder(w).Encode(struct {
+		Time   time.Time   `json:"time"`
+		Dir    string      `json:"dir"`
+		Type   string      `json:"type"`
+		Packet interface{} `json:"packet"`
+	}{p.Time, dir, p.Type, p.Packet})
+}
+
+func (svr *Server) debugPacket(received bool, typ fxp, pkt interface{}) {
+	if svr.debugFormatter == nil {
+		return
+	}
+	svr.debugFormatter(svr.debugStream, DebugPacket{
+		Time:     svr.clock.Now(),
+		Received: received,
+		Type:     typ.String(),
+		Packet:   pkt,
+	})
+}
+
+// responseType returns the packet type of a response sent by the server.
+func responseType(m interface{}) fxp {
+	switch m.(type) {
+	case sshFxVersionPacket:
+		return ssh_FXP_VERSION
+	case sshFxpStatusPacket:
+		return ssh_FXP_STATUS
+	case sshFxpHandlePacket:
+		return ssh_FXP_HANDLE
+	case sshFxpDataPacket:
+		return ssh_FXP_DATA
+	case sshFxpNamePacket:
+		return ssh_FXP_NAME
+	case sshFxpStatResponse:
+		return ssh_FXP_ATTRS
+	case *StatVFS, sshFxpCheckFileReply, sshFxpReceiptReply:
+		return ssh_FXP_EXTENDED_REPLY
+	default:
+		return 0
+	}
+}
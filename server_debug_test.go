@@ -0,0 +1,55 @@
+package sftp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestServerJSONDebugFormatter(t *testing.T) {
+	var buf bytes.Buffer
+	client, _, _, cleanup := uploadServerPair(t,
+		WithDebug(&buf),
+		WithDebugFormatter(JSONDebugFormatter),
+	)
+	defer cleanup()
+	upload(t, client, "file", []byte("contents"))
+
+	type line struct {
+		Dir    string                 `json:"dir"`
+		Type   string                 `json:"type"`
+		Packet map[string]interface{} `json:"packet"`
+	}
+	var lines []line
+	sc := bufio.NewScanner(&buf)
+	for sc.Scan() {
+		var l line
+		if err := json.Unmarshal(sc.Bytes(), &l); err != nil {
+			t.Fatalf("%q: %v", sc.Text(), err)
+		}
+		lines = append(lines, l)
+	}
+
+	want := []struct{ dir, typ string }{
+		{"received", "SSH_FXP_INIT"},
+		{"sent", "SSH_FXP_VERSION"},
+		{"received", "SSH_FXP_OPEN"},
+		{"sent", "SSH_FXP_HANDLE"},
+		{"received", "SSH_FXP_WRITE"},
+		{"sent", "SSH_FXP_STATUS"},
+		{"received", "SSH_FXP_CLOSE"},
+		{"sent", "SSH_FXP_STATUS"},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("want %d lines, got %d: %+v", len(want), len(lines), lines)
+	}
+	for i, w := range want {
+		if lines[i].Dir != w.dir || lines[i].Type != w.typ {
+			t.Errorf("line %d: want %s %s, got %s %s", i, w.dir, w.typ, lines[i].Dir, lines[i].Type)
+		}
+	}
+	if path := lines[2].Packet["Path"]; path != testUploadPath+"/file" {
+		t.Errorf("open packet: want Path %q, got %v", testUploadPath+"/file", path)
+	}
+}
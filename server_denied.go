@@ -0,0 +1,99 @@
+package sftp
+
+// Reporting of refused requests
+
+// A DenialReason says why the server refused a request.
+type DenialReason int
+
+const (
+	// DeniedUnsupported is a request of a type the server does not allow.
+	DeniedUnsupported DenialReason = iota + 1
+	// DeniedReadOnly is a write request to a server configured ReadOnly,
+	// or to one of its WithReadOnlyPaths.
+	DeniedReadOnly
+	// DeniedOpenMode is an open which does not write, or which appends.
+	DeniedOpenMode
+	// DeniedOutsideUploadPath is an open, listing or stat of a path not
+	// under UploadPath, other than one of its ancestors.
+	DeniedOutsideUploadPath
+	// DeniedSubdirectory is an open of a path in a subdirectory of
+	// UploadPath, or a listing of a directory beneath it.
+	DeniedSubdirectory
+	// DeniedFileName is an open of a name which is not a valid file name,
+	// or is rejected by the FileNameMapper.
+	DeniedFileName
+	// DeniedFileSize is a write beyond the limit set by WithFileSizeLimit.
+	DeniedFileSize
+	// DeniedOverload is a request refused under OverloadReject.
+	DeniedOverload
+	// DeniedSymlink is an open of a file which is a symbolic link.
+	DeniedSymlink
+	// DeniedUnauthorized is a request refused by the WithAuthorizer
+	// function.
+	DeniedUnauthorized
+	// DeniedFileExists is an open of an existing file under NoOverwrite.
+	DeniedFileExists
+	// DeniedMessageSize is a packet longer than allowed by
+	// WithMaxMessageSize.
+	DeniedMessageSize
+	// DeniedQuota is an open or write refused by the WithQuota Quota.
+	DeniedQuota
+	// DeniedClient is the SSH_FXP_INIT of a client refused under
+	// WithMinSFTPVersion or WithRefusedClients.
+	DeniedClient
+)
+
+var denialReasonNames = map[DenialReason]string{
+	DeniedUnsupported:       "unsupported",
+	DeniedReadOnly:          "read-only",
+	DeniedOpenMode:          "open-mode",
+	DeniedOutsideUploadPath: "outside-upload-path",
+	DeniedSubdirectory:      "subdirectory",
+	DeniedFileName:          "file-name",
+	DeniedFileSize:          "file-size",
+	DeniedOverload:          "overload",
+	DeniedSymlink:           "symlink",
+	DeniedUnauthorized:      "unauthorized",
+	DeniedFileExists:        "file-exists",
+	DeniedMessageSize:       "message-size",
+	DeniedQuota:             "quota",
+	DeniedClient:            "client",
+}
+
+func (r DenialReason) String() string {
+	if name, ok := denialReasonNames[r]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// DenialNotifier sets a function called whenever the server refuses a
+// request, before the refusal is sent to the client.
+func DenialNotifier(f func(OperationDenied)) ServerOption {
+	return func(s *Server) error {
+		s.denyNotifier = f
+		return nil
+	}
+}
+
+// denied reports that a request naming path was refused with code.
+func (svr *Server) denied(op fxp, path string, code uint32, reason DenialReason) {
+	e := OperationDenied{
+		Session: svr.session,
+		Op:      op.String(),
+		Path:    path,
+		Code:    code,
+		Reason:  reason,
+	}
+	if svr.denyNotifier != nil {
+		svr.denyNotifier(e)
+	}
+	svr.emit(e)
+}
+
+// sendDenied reports that a request naming path was refused, and responds to
+// it with code.
+func (svr *Server) sendDenied(p id, op fxp, path string, code uint32, reason DenialReason) error {
+	svr.denied(op, path, code, reason)
+	return svr.sendErrorCode(p, code)
+}
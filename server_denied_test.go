@@ -0,0 +1,138 @@
+package sftp
+
+import (
+	"os"
+	"testing"
+)
+
+func TestServerDenialNotifier(t *testing.T) {
+	var denials []OperationDenied
+	client, _, _, cleanup := uploadServerPair(t,
+		WithFileSizeLimit(4),
+		DenialNotifier(func(e OperationDenied) { denials = append(denials, e) }),
+	)
+	defer cleanup()
+
+	if _, err := client.Create("/elsewhere/file"); err == nil {
+		t.Error("created file outside upload path")
+	}
+	if _, err := client.Create(testUploadPath + "/sub/file"); err == nil {
+		t.Error("created file in subdirectory")
+	}
+	if err := client.Mkdir(testUploadPath + "/sub"); err == nil {
+		t.Error("created directory")
+	}
+	if _, err := client.OpenFile(testUploadPath+"/file", os.O_WRONLY|os.O_APPEND); err == nil {
+		t.Error("opened file for append")
+	}
+	f, err := client.Create(testUploadPath + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("too long")); err == nil {
+		t.Error("wrote beyond file size limit")
+	}
+	f.Close()
+
+	want := []struct {
+		op     string
+		path   string
+		reason DenialReason
+	}{
+		{"SSH_FXP_OPEN", "/elsewhere/file", DeniedOutsideUploadPath},
+		{"SSH_FXP_OPEN", testUploadPath + "/sub/file", DeniedSubdirectory},
+		{"SSH_FXP_MKDIR", testUploadPath + "/sub", DeniedUnsupported},
+		{"SSH_FXP_OPEN", testUploadPath + "/file", DeniedOpenMode},
+		{"SSH_FXP_WRITE", testUploadPath + "/file", DeniedFileSize},
+	}
+	if len(denials) != len(want) {
+		t.Fatalf("want %d denials, got %+v", len(want), denials)
+	}
+	for i, w := range want {
+		if d := denials[i]; d.Op != w.op || d.Path != w.path || d.Reason != w.reason {
+			t.Errorf("denial %d: want %s %s %v, got %s %s %v", i, w.op, w.path, w.reason, d.Op, d.Path, d.Reason)
+		}
+	}
+}
+
+func TestServerDenialAudit(t *testing.T) {
+	var denials []OperationDenied
+	info := SessionInfo{User: "partner", RemoteAddr: "192.0.2.1:2022"}
+	client, _, _, cleanup := uploadServerPair(t,
+		WithSessionInfo(info),
+		DenialNotifier(func(e OperationDenied) { denials = append(denials, e) }),
+	)
+	defer cleanup()
+
+	// Probing outside the upload path is reported; looking at the upload
+	// path and its ancestors is not.
+	for _, p := range []string{"/", testUploadPath, testUploadPath + "/file"} {
+		client.Stat(p)
+	}
+	if _, err := client.Stat("/etc/passwd"); !os.IsNotExist(err) {
+		t.Errorf("stat outside upload path: want not exist, got %v", err)
+	}
+	if _, err := client.Lstat("../etc"); !os.IsNotExist(err) {
+		t.Errorf("lstat outside upload path: want not exist, got %v", err)
+	}
+	if _, err := client.ReadDir("/etc"); err == nil {
+		t.Error("listed directory outside upload path")
+	}
+	if _, err := client.ReadDir(testUploadPath + "/sub"); err == nil {
+		t.Error("listed subdirectory")
+	}
+
+	want := []struct {
+		op     string
+		path   string
+		reason DenialReason
+	}{
+		{"SSH_FXP_STAT", "/etc/passwd", DeniedOutsideUploadPath},
+		{"SSH_FXP_LSTAT", "../etc", DeniedOutsideUploadPath},
+		{"SSH_FXP_OPENDIR", "/etc", DeniedOutsideUploadPath},
+		{"SSH_FXP_OPENDIR", testUploadPath + "/sub", DeniedSubdirectory},
+	}
+	if len(denials) != len(want) {
+		t.Fatalf("want %d denials, got %+v", len(want), denials)
+	}
+	for i, w := range want {
+		d := denials[i]
+		if d.Op != w.op || d.Path != w.path || d.Reason != w.reason {
+			t.Errorf("denial %d: want %s %s %v, got %s %s %v", i, w.op, w.path, w.reason, d.Op, d.Path, d.Reason)
+		}
+		if d.Session.User != info.User || d.Session.RemoteAddr != info.RemoteAddr {
+			t.Errorf("denial %d: want session %+v, got %+v", i, info, d.Session)
+		}
+	}
+}
+
+func TestServerDenialReadOnly(t *testing.T) {
+	var denials []OperationDenied
+	client, _, _, cleanup := uploadServerPair(t,
+		ReadOnly(),
+		WithSessionInfo(SessionInfo{User: "partner"}),
+		DenialNotifier(func(e OperationDenied) { denials = append(denials, e) }),
+	)
+	defer cleanup()
+
+	if _, err := client.Create(testUploadPath + "/file"); err == nil {
+		t.Error("created file on read-only server")
+	}
+	if len(denials) != 1 {
+		t.Fatalf("want 1 denial, got %+v", denials)
+	}
+	d := denials[0]
+	if d.Op != "SSH_FXP_OPEN" || d.Path != testUploadPath+"/file" || d.Reason != DeniedReadOnly ||
+		d.Code != ssh_FX_PERMISSION_DENIED || d.Session.User != "partner" {
+		t.Errorf("got %+v", d)
+	}
+}
+
+func TestDenialReasonString(t *testing.T) {
+	if s := DeniedOutsideUploadPath.String(); s != "outside-upload-path" {
+		t.Errorf("got %q", s)
+	}
+	if s := DenialReason(0).String(); s != "unknown" {
+		t.Errorf("got %q", s)
+	}
+}
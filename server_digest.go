@@ -0,0 +1,190 @@
+package sftp
+
+// Digests of uploaded files
+
+import (
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// A Digest is a hash algorithm used to checksum uploads.
+type Digest struct {
+	// Name identifies the algorithm in events and to clients, such as
+	// "sha256" or "blake3".
+	Name string
+	// New returns a new hash, for instance sha256.New, or the constructor
+	// of a FIPS validated implementation.
+	New func() hash.Hash
+}
+
+const (
+	// minCheckFileBlockSize is the smallest block size allowed in
+	// check-file requests.
+	minCheckFileBlockSize = 256
+	// maxCheckFileHashes is the most hash bytes sent in a check-file
+	// reply, leaving room for its header in a packet clients accept.
+	maxCheckFileHashes = maxMsgLength - 1024
+)
+
+// WithDigests computes a digest of each uploaded file with each of ds. The
+// digests are hashed as the data is written, in a single pass when it is
+// written in order, and are included in the FileClosed event and passed to
+// the WithFinalizeHook function, keyed by Name. Clients can also ask for
+// them during an upload with the check-file-handle extension, which is
+// enabled for ds, in their order of preference if the client has none.
+func WithDigests(ds ...Digest) ServerOption {
+	return func(s *Server) error {
+		seen := make(map[string]bool)
+		for _, d := range ds {
+			if d.Name == "" || strings.ContainsRune(d.Name, ',') || d.New == nil || seen[d.Name] {
+				return errors.Errorf("invalid digest %q", d.Name)
+			}
+			seen[d.Name] = true
+		}
+		s.digests = ds
+		return nil
+	}
+}
+
+// fileDigests hashes the data written to a file. It is only used by the
+// worker handling the requests on the file's handle.
+type fileDigests struct {
+	hashes []hash.Hash
+	next   int64 // offset up to which the data has been hashed
+	stale  bool  // data was written out of order, so must be read back
+}
+
+func (svr *Server) newFileDigests() *fileDigests {
+	if len(svr.digests) == 0 {
+		return nil
+	}
+	fd := &fileDigests{}
+	for _, d := range svr.digests {
+		fd.hashes = append(fd.hashes, d.New())
+	}
+	return fd
+}
+
+// write hashes b, written at offset.
+func (fd *fileDigests) write(b []byte, offset int64) {
+	if fd.stale {
+		return
+	}
+	if offset < 0 || offset != fd.next {
+		fd.stale = true
+		return
+	}
+	for _, h := range fd.hashes {
+		h.Write(b)
+	}
+	fd.next += int64(len(b))
+}
+
+// digestSums returns the digests of f, keyed by name, reading it back if it
+// was not written in order.
+func (svr *Server) digestSums(f *openFile) (map[string][]byte, error) {
+	fd := f.digests
+	if fd.stale {
+		fd = svr.newFileDigests()
+		ws := make([]io.Writer, len(fd.hashes))
+		for i, h := range fd.hashes {
+			ws[i] = h
+		}
+		if _, err := io.Copy(io.MultiWriter(ws...), io.NewSectionReader(f, 0, 1<<62)); err != nil {
+			return nil, err
+		}
+	}
+	sums := make(map[string][]byte)
+	for i, d := range svr.digests {
+		sums[d.Name] = fd.hashes[i].Sum(nil)
+	}
+	return sums, nil
+}
+
+// checkFileAllowed reports whether pkt is a check-file-handle request which
+// the server answers.
+func (svr *Server) checkFileAllowed(pkt interface{}) bool {
+	p, ok := pkt.(*sshFxpExtendedPacket)
+	if !ok || len(svr.digests) == 0 {
+		return false
+	}
+	_, ok = p.SpecificPacket.(*sshFxpExtendedPacketCheckFileHandle)
+	return ok
+}
+
+// checkFileDigest returns the first of the comma separated algorithms which
+// is a configured digest, or the first digest if the list is empty.
+func (svr *Server) checkFileDigest(algorithms string) (Digest, bool) {
+	if algorithms == "" {
+		return svr.digests[0], true
+	}
+	for _, name := range strings.Split(algorithms, ",") {
+		for _, d := range svr.digests {
+			if d.Name == name {
+				return d, true
+			}
+		}
+	}
+	return Digest{}, false
+}
+
+func (p sshFxpExtendedPacketCheckFileHandle) respond(svr *Server) error {
+	f, ok := svr.getOpenFile(p.Handle)
+	if !ok || f.dir != nil {
+		return svr.sendError(p, svr.handleError(p.Handle))
+	}
+	d, ok := svr.checkFileDigest(p.Algorithms)
+	if !ok {
+		return svr.sendPacket(sshFxpStatusPacket{
+			ID: p.ID,
+			StatusError: StatusError{
+				Code: ssh_FX_OP_UNSUPPORTED,
+				msg:  "no supported hash algorithm",
+			},
+		})
+	}
+	if p.BlockSize != 0 && p.BlockSize < minCheckFileBlockSize {
+		return svr.sendErrorCode(p, ssh_FX_INVALID_PARAMETER)
+	}
+	length := int64(p.Length)
+	if p.Length == 0 || p.Length > 1<<62 {
+		length = 1 << 62
+	}
+	r := io.NewSectionReader(f, int64(p.Offset), length)
+	block := int64(p.BlockSize)
+	if block == 0 {
+		block = length
+	}
+	var sums []byte
+	for {
+		h := d.New()
+		n, err := io.CopyN(h, r, block)
+		if err != nil && err != io.EOF {
+			return svr.sendError(p, err)
+		}
+		if n == 0 && len(sums) > 0 {
+			break
+		}
+		if len(sums)+h.Size() > maxCheckFileHashes {
+			return svr.sendPacket(sshFxpStatusPacket{
+				ID: p.ID,
+				StatusError: StatusError{
+					Code: ssh_FX_FAILURE,
+					msg:  "too many blocks",
+				},
+			})
+		}
+		sums = h.Sum(sums)
+		if n < block {
+			break
+		}
+	}
+	return svr.sendPacket(sshFxpCheckFileReply{
+		ID:        p.ID,
+		Algorithm: d.Name,
+		Hashes:    sums,
+	})
+}
@@ -0,0 +1,137 @@
+package sftp
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"io"
+	"testing"
+)
+
+var testDigests = []Digest{
+	{Name: "sha256", New: sha256.New},
+	{Name: "md5", New: md5.New},
+}
+
+func TestServerDigests(t *testing.T) {
+	var ch <-chan Event
+	var finalized map[string][]byte
+	client, _, _, cleanup := uploadServerPair(t,
+		WithDigests(testDigests...),
+		WithFinalizeHook(func(u FinalizingUpload) error {
+			finalized = u.Digests
+			return nil
+		}),
+		subscribeEvents(&ch),
+	)
+	defer cleanup()
+
+	data := bytes.Repeat([]byte("contents"), 1<<13)
+	upload(t, client, "file", data)
+	client.Close()
+
+	sha := sha256.Sum256(data)
+	sum := md5.Sum(data)
+	var closed *FileClosed
+	for e := range ch {
+		if e, ok := e.(FileClosed); ok {
+			closed = &e
+		}
+	}
+	if closed == nil {
+		t.Fatal("no FileClosed event")
+	}
+	for _, got := range []map[string][]byte{closed.Digests, finalized} {
+		if !bytes.Equal(got["sha256"], sha[:]) || !bytes.Equal(got["md5"], sum[:]) {
+			t.Errorf("wrong digests %x", got)
+		}
+	}
+
+	if _, err := NewServer(nopReadWriteCloser{}, WithDigests(testDigests[0], testDigests[0])); err == nil {
+		t.Error("duplicate digest accepted")
+	}
+}
+
+func TestServerDigestsOutOfOrder(t *testing.T) {
+	var ch <-chan Event
+	client, _, _, cleanup := uploadServerPair(t, WithDigests(testDigests...), subscribeEvents(&ch))
+	defer cleanup()
+
+	f, err := client.Create(testUploadPath + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(3, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("tents")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("con")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	sha := sha256.Sum256([]byte("contents"))
+	for e := range ch {
+		if e, ok := e.(FileClosed); ok && !bytes.Equal(e.Digests["sha256"], sha[:]) {
+			t.Errorf("wrong digest %x", e.Digests["sha256"])
+		}
+	}
+}
+
+func TestServerCheckFileHandle(t *testing.T) {
+	client, _, _, cleanup := uploadServerPair(t, WithDigests(testDigests...))
+	defer cleanup()
+
+	f, err := client.Create(testUploadPath + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data := bytes.Repeat([]byte{1}, 1000)
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	typ, b, err := client.sendPacket(sshFxpExtendedPacketCheckFileHandle{
+		ID:         client.nextID(),
+		Handle:     f.handle,
+		Algorithms: "sha1,md5",
+		BlockSize:  512,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != ssh_FXP_EXTENDED_REPLY {
+		t.Fatalf("want SSH_FXP_EXTENDED_REPLY, got %v", fxp(typ))
+	}
+	_, b = unmarshalUint32(b)
+	reply, b := unmarshalString(b)
+	alg, b := unmarshalString(b)
+	first, second := md5.Sum(data[:512]), md5.Sum(data[512:])
+	if reply != "check-file" || alg != "md5" || !bytes.Equal(b, append(first[:], second[:]...)) {
+		t.Errorf("wrong reply %q %q %x", reply, alg, b)
+	}
+
+	typ, b, err = client.sendPacket(sshFxpExtendedPacketCheckFileHandle{
+		ID:         client.nextID(),
+		Handle:     f.handle,
+		Algorithms: "sha1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, b = unmarshalUint32(b); typ != ssh_FXP_STATUS {
+		t.Fatalf("want SSH_FXP_STATUS for unsupported algorithm, got %v", fxp(typ))
+	}
+	if code, _ := unmarshalUint32(b); code != ssh_FX_OP_UNSUPPORTED {
+		t.Errorf("want SSH_FX_OP_UNSUPPORTED, got %d", code)
+	}
+}
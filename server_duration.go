@@ -0,0 +1,60 @@
+package sftp
+
+// Bounding the duration of sessions
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrSessionExpired is returned by Serve when it ends a session which has
+// lasted as long as allowed by WithMaxSessionDuration.
+var ErrSessionExpired = errors.New("sftp: maximum session duration reached")
+
+// WithMaxSessionDuration ends sessions d after Serve is called. The Server
+// stops reading requests, finishes handling those it has received, then
+// closes the connection, as for WithIdleTimeout, and Serve returns
+// ErrSessionExpired. Files the client still has open are closed as if it had
+// gone away.
+func WithMaxSessionDuration(d time.Duration) ServerOption {
+	return func(s *Server) error {
+		if d <= 0 {
+			return errors.Errorf("invalid maximum session duration %v", d)
+		}
+		s.maxDuration = d
+		return nil
+	}
+}
+
+// startSessionTimer starts the timer ending the session. It returns a
+// function stopping it.
+func (svr *Server) startSessionTimer() (stop func()) {
+	if svr.maxDuration == 0 {
+		return func() {}
+	}
+	t := svr.clock.AfterFunc(svr.maxDuration, svr.expireSession)
+	return func() { t.Stop() }
+}
+
+// expireSession ends the session once the requests being handled are done.
+func (svr *Server) expireSession() {
+	atomic.StoreUint32(&svr.sessionExpired, 1)
+	if atomic.LoadInt64(&svr.pendingPackets) == 0 {
+		svr.conn.Close() // shuts down recvPacket
+	}
+}
+
+// expired reports whether the session has lasted as long as allowed.
+func (svr *Server) expired() bool {
+	return atomic.LoadUint32(&svr.sessionExpired) != 0
+}
+
+// donePacket counts a request as handled, ending the session if it was the
+// last one outstanding once expired.
+func (svr *Server) donePacket() {
+	if atomic.AddInt64(&svr.pendingPackets, -1) == 0 && svr.expired() {
+		svr.conn.Close() // shuts down recvPacket
+	}
+}
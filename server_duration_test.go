@@ -0,0 +1,38 @@
+package sftp
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestServerMaxSessionDuration(t *testing.T) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	server, err := NewServer(pipeEnd{sr, sw}, UploadPath(testUploadPath), WithMaxSessionDuration(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- server.Serve() }()
+	client, err := NewClientPipe(cr, cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Requests do not keep the session open.
+	for start := time.Now(); time.Since(start) < 50*time.Millisecond; {
+		if _, err := client.Stat(testUploadPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case err := <-done:
+		if err != ErrSessionExpired {
+			t.Errorf("want ErrSessionExpired, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("session not ended")
+	}
+}
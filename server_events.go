@@ -0,0 +1,135 @@
+package sftp
+
+// Server activity events
+
+import (
+	"time"
+)
+
+// eventBufferSize is the capacity of each channel returned by Server.Events.
+const eventBufferSize = 256
+
+// An Event describes activity on a Server. It is one of SessionStarted,
+// FileOpened, WriteProgress, FileClosed, OperationDenied, RequestFailed or
+// SessionEnded.
+type Event interface {
+	event()
+}
+
+// SessionStarted is sent when Serve is called.
+type SessionStarted struct {
+	Session SessionInfo
+}
+
+// FileOpened is sent when a file is opened for upload.
+type FileOpened struct {
+	Session    SessionInfo
+	Handle     string
+	RemotePath string // path requested by the client
+	Path       string // path of the file on the server
+}
+
+// WriteProgress is sent after data is written to an uploaded file.
+type WriteProgress struct {
+	Session SessionInfo
+	Handle  string
+	Path    string // path of the file on the server
+	Offset  int64  // offset of the write
+	Length  int    // bytes written by this request
+	Written int64  // total bytes written to the file
+}
+
+// FileClosed is sent when an uploaded file is closed by the client.
+type FileClosed struct {
+	Session    SessionInfo
+	Handle     string
+	RemotePath string // path requested by the client
+	Path       string // path of the file on the server
+	Written    int64  // total bytes written to the file
+	Duration   time.Duration
+	Sample     []byte            // leading bytes of the file, if WithPayloadSampling
+	Digests    map[string][]byte // by Digest name, if WithDigests
+	Err        error             // error closing the file, if any
+}
+
+// OperationDenied is sent when the server refuses a request: one of a type
+// it does not support, a write to a ReadOnly server, or a request naming a
+// path outside UploadPath, among others. Along with the Session, as given to
+// WithSessionInfo, it is meant for auditing, to tell clients probing the
+// server from misconfigured ones.
+type OperationDenied struct {
+	Session SessionInfo
+	Op      string // packet type, such as "SSH_FXP_OPEN"
+	Path    string // path or handle named by the request, if any
+	Code    uint32 // SSH_FX_* status code sent to the client
+	Reason  DenialReason
+}
+
+// SessionEnded is sent just before Serve returns. It is the last event
+// sent on any channel, and is never dropped: if a channel's buffer is full,
+// the oldest event in it is dropped to make room.
+type SessionEnded struct {
+	Summary SessionSummary
+}
+
+func (SessionStarted) event()  {}
+func (FileOpened) event()      {}
+func (WriteProgress) event()   {}
+func (FileClosed) event()      {}
+func (OperationDenied) event() {}
+func (SessionEnded) event()    {}
+
+// Events returns a new channel on which the Server's activity is sent. Each
+// call returns a separate channel receiving every event, so that multiple
+// consumers may subscribe independently; to see SessionStarted, call Events
+// before Serve. The channel is closed when Serve returns.
+//
+// Events are never allowed to stall the session: if a channel's buffer is
+// full, events for that channel are dropped until its consumer catches up,
+// apart from SessionEnded.
+func (svr *Server) Events() <-chan Event {
+	ch := make(chan Event, eventBufferSize)
+	svr.eventsLock.Lock()
+	defer svr.eventsLock.Unlock()
+	if svr.eventsClosed {
+		close(ch)
+	} else {
+		svr.eventChans = append(svr.eventChans, ch)
+	}
+	return ch
+}
+
+func (svr *Server) emit(e Event) {
+	svr.eventsLock.Lock()
+	defer svr.eventsLock.Unlock()
+	for _, ch := range svr.eventChans {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// closeEvents sends e, the last event, and closes the event channels. e
+// takes the place of the oldest event in a full channel, as the Server is
+// the only sender.
+func (svr *Server) closeEvents(e Event) {
+	svr.eventsLock.Lock()
+	defer svr.eventsLock.Unlock()
+	for _, ch := range svr.eventChans {
+		for sent := false; !sent; {
+			select {
+			case ch <- e:
+				sent = true
+			default:
+				select {
+				case <-ch:
+				default:
+				}
+			}
+		}
+		close(ch)
+	}
+	svr.eventChans = nil
+	svr.eventsClosed = true
+}
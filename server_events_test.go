@@ -0,0 +1,118 @@
+package sftp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// subscribeEvents is a ServerOption subscribing to the server's events
+// before Serve is called.
+func subscribeEvents(ch *<-chan Event) ServerOption {
+	return func(s *Server) error {
+		*ch = s.Events()
+		return nil
+	}
+}
+
+func eventTypes(t *testing.T, ch <-chan Event) []string {
+	var types []string
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return types
+			}
+			types = append(types, reflect.TypeOf(e).Name())
+		case <-timeout:
+			t.Fatalf("event channel not closed; got %v", types)
+		}
+	}
+}
+
+func TestServerEvents(t *testing.T) {
+	var ch1, ch2 <-chan Event
+	info := SessionInfo{User: "partner", ClientVersion: "SSH-2.0-Test"}
+	client, server, dir, cleanup := uploadServerPair(t,
+		WithSessionInfo(info),
+		subscribeEvents(&ch1),
+		subscribeEvents(&ch2),
+	)
+	defer cleanup()
+
+	upload(t, client, "file", []byte("contents"))
+	if _, err := client.Create("/elsewhere/file"); err == nil {
+		t.Error("Create outside upload path didn't fail")
+	}
+	client.Close()
+
+	want := []string{
+		"SessionStarted",
+		"FileOpened",
+		"WriteProgress",
+		"FileClosed",
+		"OperationDenied",
+		"SessionEnded",
+	}
+	var events []Event
+	for e := range ch1 {
+		events = append(events, e)
+	}
+	var got []string
+	for _, e := range events {
+		got = append(got, reflect.TypeOf(e).Name())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want events %v, got %v", want, got)
+	}
+	if got := eventTypes(t, ch2); !reflect.DeepEqual(got, want) {
+		t.Errorf("second subscriber: want events %v, got %v", want, got)
+	}
+
+	if e := events[1].(FileOpened); e.Path != dir+"/file" || e.RemotePath != testUploadPath+"/file" {
+		t.Errorf("unexpected %+v", e)
+	}
+	if e := events[2].(WriteProgress); e.Length != 8 || e.Written != 8 {
+		t.Errorf("unexpected %+v", e)
+	}
+	if e := events[3].(FileClosed); e.Written != 8 || e.Err != nil {
+		t.Errorf("unexpected %+v", e)
+	}
+	if e := events[4].(OperationDenied); e.Op != "SSH_FXP_OPEN" || e.Path != "/elsewhere/file" || e.Code != ssh_FX_NO_SUCH_PATH {
+		t.Errorf("unexpected %+v", e)
+	} else if e.Session.User != info.User || e.Session.ClientVersion != info.ClientVersion {
+		t.Errorf("OperationDenied: want session %+v, got %+v", info, e.Session)
+	}
+	if e := events[5].(SessionEnded); e.Summary.Stats.Uploads != 1 {
+		t.Errorf("unexpected %+v", e)
+	}
+
+	// Subscribing after the session has ended yields a closed channel.
+	if _, ok := <-server.Events(); ok {
+		t.Error("Events after Serve returned an open channel")
+	}
+}
+
+func TestServerEventsSessionEndedNotDropped(t *testing.T) {
+	svr, err := NewServer(nopReadWriteCloser{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := svr.Events()
+	for i := 0; i < eventBufferSize+10; i++ {
+		svr.emit(OperationDenied{})
+	}
+	svr.endSession(nil)
+
+	var events []Event
+	for e := range ch {
+		events = append(events, e)
+	}
+	if len(events) != eventBufferSize {
+		t.Errorf("got %d events, want %d", len(events), eventBufferSize)
+	}
+	if _, ok := events[len(events)-1].(SessionEnded); !ok {
+		t.Errorf("last event is %T, want SessionEnded", events[len(events)-1])
+	}
+}
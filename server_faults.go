@@ -0,0 +1,83 @@
+package sftp
+
+// Fault injection for testing clients against a misbehaving server
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// A Fault is an artificial delay or failure applied to requests by a Server
+// configured WithFaults.
+type Fault struct {
+	// Ops lists the packet types affected, such as "SSH_FXP_WRITE". If
+	// empty, every request is affected.
+	Ops []string
+	// Probability is the chance, between 0 and 1, that a matching request
+	// is affected.
+	Probability float64
+	// Delay is waited before the request is handled.
+	Delay time.Duration
+	// Err, if not nil, is returned to the client instead of handling the
+	// request. A *StatusError is sent with its own code; other errors are
+	// translated as for real failures.
+	Err error
+}
+
+func (f *Fault) matches(op fxp) bool {
+	if len(f.Ops) == 0 {
+		return true
+	}
+	name := op.String()
+	for _, o := range f.Ops {
+		if o == name {
+			return true
+		}
+	}
+	return false
+}
+
+// WithFaults injects faults into the handling of requests, to exercise the
+// retry and error handling of clients. Each fault is considered
+// independently for every request; delays accumulate, and the first
+// failure applied is returned.
+func WithFaults(faults ...Fault) ServerOption {
+	return func(s *Server) error {
+		s.faults = &faultInjector{
+			faults: faults,
+			rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		}
+		return nil
+	}
+}
+
+type faultInjector struct {
+	faults []Fault
+
+	mu   sync.Mutex // protects rand
+	rand *rand.Rand
+}
+
+// apply delays the handling of a request of type op as configured, on
+// clock, and returns the error to respond with, if any.
+func (fi *faultInjector) apply(clock Clock, op fxp) error {
+	var delay time.Duration
+	var err error
+	fi.mu.Lock()
+	for i := range fi.faults {
+		f := &fi.faults[i]
+		if !f.matches(op) || fi.rand.Float64() >= f.Probability {
+			continue
+		}
+		delay += f.Delay
+		if err == nil {
+			err = f.Err
+		}
+	}
+	fi.mu.Unlock()
+	if delay > 0 {
+		clock.Sleep(delay)
+	}
+	return err
+}
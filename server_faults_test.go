@@ -0,0 +1,48 @@
+package sftp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServerFaults(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	client, _, _, cleanup := uploadServerPair(t, WithFaults(
+		Fault{
+			Ops:         []string{"SSH_FXP_WRITE"},
+			Probability: 1,
+			Err:         &StatusError{Code: ssh_FX_NO_SPACE_ON_FILESYSTEM},
+		},
+		Fault{
+			Ops:         []string{"SSH_FXP_STAT"},
+			Probability: 1,
+			Delay:       delay,
+		},
+		Fault{
+			Ops:         []string{"SSH_FXP_CLOSE"},
+			Probability: 0,
+			Err:         &StatusError{Code: ssh_FX_FAILURE},
+		},
+	))
+	defer cleanup()
+
+	f, err := client.Create(testUploadPath + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Write([]byte("data"))
+	if err, ok := err.(*StatusError); !ok || err.Code != ssh_FX_NO_SPACE_ON_FILESYSTEM {
+		t.Errorf("Write: want SSH_FX_NO_SPACE_ON_FILESYSTEM, got %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+
+	start := time.Now()
+	if _, err := client.Stat(testUploadPath); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < delay {
+		t.Errorf("Stat took %v, want at least %v", d, delay)
+	}
+}
@@ -0,0 +1,34 @@
+package sftp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServerFileMode(t *testing.T) {
+	staging, rmStaging := stagingDir(t)
+	defer rmStaging()
+
+	for _, opts := range [][]ServerOption{
+		{WithFileMode(0660)},
+		{WithFileMode(0660), WithStagingDir(staging)},
+	} {
+		client, _, dir, cleanup := uploadServerPair(t, opts...)
+		upload(t, client, "file", []byte("contents"))
+		fi, err := os.Stat(filepath.Join(dir, "file"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := fi.Mode().Perm(); mode != 0660 {
+			t.Errorf("want mode 0660, got %v", mode)
+		}
+		cleanup()
+	}
+
+	for _, mode := range []os.FileMode{0, os.ModeDir | 0755} {
+		if _, err := NewServer(nopReadWriteCloser{}, WithFileMode(mode)); err == nil {
+			t.Errorf("file mode %v accepted", mode)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package sftp
+
+// Sanitization of uploaded file names
+
+import (
+	"strings"
+	"unicode"
+)
+
+// A FileNamePolicy says what the Server does with uploaded file names
+// containing characters which are unsafe to pass to shell based tools:
+// control characters, including newlines and tabs, and shell
+// metacharacters such as ";", "|", "$" and "`".
+type FileNamePolicy int
+
+const (
+	// FileNamesAsIs accepts such names unchanged. This is the default.
+	FileNamesAsIs FileNamePolicy = iota
+	// FileNamesReject refuses uploads to such names with
+	// SSH_FX_INVALID_FILENAME, reported as DeniedFileName.
+	FileNamesReject
+	// FileNamesStrip removes the unsafe characters from such names. If
+	// nothing usable is left, the upload is refused as under
+	// FileNamesReject.
+	FileNamesStrip
+)
+
+// shellMetacharacters are the characters with a special meaning to POSIX
+// shells, other than space.
+const shellMetacharacters = "|&;<>()$`\\\"'*?[]#~=%!{}"
+
+// WithFileNamePolicy sets what the Server does with uploaded file names
+// containing unsafe characters. The policy applies before the
+// FileNameMapper, which sees the sanitized name.
+func WithFileNamePolicy(p FileNamePolicy) ServerOption {
+	return func(s *Server) error {
+		s.fileNamePolicy = p
+		return nil
+	}
+}
+
+func unsafeFileNameRune(r rune) bool {
+	return unicode.IsControl(r) || strings.ContainsRune(shellMetacharacters, r)
+}
+
+// sanitizeFileName applies the file name policy to name, reporting false if
+// the name is refused.
+func (svr *Server) sanitizeFileName(name string) (string, bool) {
+	switch svr.fileNamePolicy {
+	case FileNamesReject:
+		return name, strings.IndexFunc(name, unsafeFileNameRune) < 0
+	case FileNamesStrip:
+		name = strings.Map(func(r rune) rune {
+			if unsafeFileNameRune(r) {
+				return -1
+			}
+			return r
+		}, name)
+		return name, name != "" && name != "." && name != ".."
+	default:
+		return name, true
+	}
+}
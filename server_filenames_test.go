@@ -0,0 +1,55 @@
+package sftp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		policy FileNamePolicy
+		want   string
+		ok     bool
+	}{
+		{"report.csv", FileNamesReject, "report.csv", true},
+		{"report 2.csv", FileNamesReject, "report 2.csv", true},
+		{"rm -rf;.csv", FileNamesReject, "", false},
+		{"a\nb", FileNamesReject, "", false},
+		{"a\x7fb", FileNamesReject, "", false},
+		{"$(reboot)`x`.csv", FileNamesStrip, "rebootx.csv", true},
+		{"a\r\nb\tc", FileNamesStrip, "abc", true},
+		{"|;&", FileNamesStrip, "", false},
+		{".;.", FileNamesStrip, "", false},
+		{"a;b", FileNamesAsIs, "a;b", true},
+	} {
+		svr := &Server{fileNamePolicy: tt.policy}
+		got, ok := svr.sanitizeFileName(tt.name)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("policy %d, %q: want %q %v, got %q %v", tt.policy, tt.name, tt.want, tt.ok, got, ok)
+		}
+	}
+}
+
+func TestServerFileNamePolicy(t *testing.T) {
+	client, _, dir, cleanup := uploadServerPair(t, WithFileNamePolicy(FileNamesStrip))
+	defer cleanup()
+	upload(t, client, "in;voice\n.csv", []byte("contents"))
+	if _, err := os.Stat(filepath.Join(dir, "invoice.csv")); err != nil {
+		t.Error(err)
+	}
+
+	var denials []OperationDenied
+	client, _, _, cleanup2 := uploadServerPair(t,
+		WithFileNamePolicy(FileNamesReject),
+		DenialNotifier(func(e OperationDenied) { denials = append(denials, e) }),
+	)
+	defer cleanup2()
+	if _, err := client.Create(testUploadPath + "/x$(id)"); err == nil {
+		t.Error("upload to unsafe name succeeded")
+	}
+	if len(denials) != 1 || denials[0].Reason != DeniedFileName {
+		t.Errorf("want file name denial, got %+v", denials)
+	}
+}
@@ -0,0 +1,41 @@
+package sftp
+
+// Hook run on uploads before they are handed over
+
+// A FinalizingUpload describes an uploaded file which the client has closed,
+// before it is made available under its final name.
+type FinalizingUpload struct {
+	Session    SessionInfo
+	RemotePath string // path requested by the client
+	Path       string // final path of the file on the server
+	// File is where the file is now: Path, or its staged file under
+	// WithStagingDir.
+	File string
+	// Digests holds the digests of the file by Digest name, if
+	// WithDigests, for writing to a manifest for instance.
+	Digests map[string][]byte
+}
+
+// WithFinalizeHook sets a function called for each upload once the client
+// has closed it and it has been given its file mode and owner, but before a
+// staged upload is moved into place, so that it can label the file, by
+// setting its security.selinux or user extended attributes for instance,
+// before others see it. If f returns an error, the client's close fails
+// with it, as with the errors of handling any request, and a staged upload
+// is discarded.
+func WithFinalizeHook(f func(FinalizingUpload) error) ServerOption {
+	return func(s *Server) error {
+		s.finalizeHook = f
+		return nil
+	}
+}
+
+func (svr *Server) finalizingUpload(f *openFile) FinalizingUpload {
+	return FinalizingUpload{
+		Session:    svr.session,
+		RemotePath: f.remotePath,
+		Path:       f.path(),
+		File:       f.Name(),
+		Digests:    f.sums,
+	}
+}
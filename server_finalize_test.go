@@ -0,0 +1,58 @@
+package sftp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestServerFinalizeHook(t *testing.T) {
+	staging, rmStaging := stagingDir(t)
+	defer rmStaging()
+	var got []FinalizingUpload
+	var visible bool
+	client, _, dir, cleanup := uploadServerPair(t,
+		WithStagingDir(staging),
+		WithFinalizeHook(func(u FinalizingUpload) error {
+			got = append(got, u)
+			_, err := os.Stat(u.Path)
+			visible = err == nil
+			if filepath.Base(u.Path) == "bad" {
+				return errors.New("labeling failed")
+			}
+			return nil
+		}),
+	)
+	defer cleanup()
+
+	upload(t, client, "good", []byte("contents"))
+	if len(got) != 1 {
+		t.Fatalf("want 1 call, got %d", len(got))
+	}
+	if u := got[0]; u.RemotePath != testUploadPath+"/good" || u.Path != filepath.Join(dir, "good") ||
+		filepath.Dir(u.File) != staging {
+		t.Errorf("got %+v", u)
+	}
+	if visible {
+		t.Error("upload visible before the hook returned")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "good")); err != nil {
+		t.Error(err)
+	}
+
+	f, err := client.Create(testUploadPath + "/bad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err == nil {
+		t.Error("close succeeded despite the hook failing")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bad")); !os.IsNotExist(err) {
+		t.Errorf("upload moved into place despite the hook failing: %v", err)
+	}
+	if fis := stagedFiles(t, staging); len(fis) != 0 {
+		t.Errorf("%d staged files left", len(fis))
+	}
+}
@@ -0,0 +1,177 @@
+package sftp
+
+// Table of open handles
+
+import (
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// handleShards is the number of independently locked parts of a
+// handleTable.
+const handleShards = 16
+
+// A handleID identifies an open file. The low 32 bits are the slot holding
+// the file, and the high 32 bits the generation of the slot, which changes
+// each time it is reused so that a stale handle never names a later file.
+// On the wire it is sent in hexadecimal.
+type handleID uint64
+
+func makeHandleID(slot, gen uint32) handleID {
+	return handleID(gen)<<32 | handleID(slot)
+}
+
+func (h handleID) slot() uint32 { return uint32(h) }
+func (h handleID) gen() uint32  { return uint32(h >> 32) }
+
+func (h handleID) String() string {
+	return strconv.FormatUint(uint64(h), 16)
+}
+
+// parseHandle decodes a handle sent by the client. Only the spelling the
+// server sent is accepted: workers are chosen by the handle as sent, so
+// aliases such as "01" for "1" would let requests on a file overtake each
+// other.
+func parseHandle(handle string) (handleID, bool) {
+	h, err := strconv.ParseUint(handle, 16, 64)
+	if err != nil || handleID(h).String() != handle {
+		return 0, false
+	}
+	return handleID(h), true
+}
+
+// handleTable maps handles to open files. It is split into shards, each with
+// its own lock, so that concurrent requests on different handles rarely
+// contend. Slot n is kept in shard n%handleShards.
+type handleTable struct {
+	next   uint32 // shard of the next handle; accessed atomically
+	shards [handleShards]handleShard
+}
+
+type handleShard struct {
+	sync.RWMutex
+	slots []handleSlot
+	free  []uint32 // indexes into slots of unused slots
+}
+
+type handleSlot struct {
+	gen uint32
+	f   *openFile // nil if unused
+}
+
+func newHandleTable() *handleTable {
+	return &handleTable{}
+}
+
+// hashHandle returns a well mixed hash of handle.
+func hashHandle(handle string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(handle))
+	return h.Sum32()
+}
+
+// lookup returns the shard and slot index in it named by handle.
+func (t *handleTable) lookup(handle string) (*handleShard, int, uint32, bool) {
+	h, ok := parseHandle(handle)
+	if !ok {
+		return nil, 0, 0, false
+	}
+	return &t.shards[h.slot()%handleShards], int(h.slot() / handleShards), h.gen(), true
+}
+
+// add stores f in an unused slot, returning its handle.
+func (t *handleTable) add(f *openFile) string {
+	i := atomic.AddUint32(&t.next, 1) % handleShards
+	sh := &t.shards[i]
+	sh.Lock()
+	defer sh.Unlock()
+	var n int
+	if len(sh.free) > 0 {
+		n = int(sh.free[len(sh.free)-1])
+		sh.free = sh.free[:len(sh.free)-1]
+	} else {
+		n = len(sh.slots)
+		sh.slots = append(sh.slots, handleSlot{})
+	}
+	s := &sh.slots[n]
+	s.gen++
+	s.f = f
+	return makeHandleID(uint32(n)*handleShards+i, s.gen).String()
+}
+
+func (t *handleTable) get(handle string) (*openFile, bool) {
+	sh, n, gen, ok := t.lookup(handle)
+	if !ok {
+		return nil, false
+	}
+	sh.RLock()
+	defer sh.RUnlock()
+	if n >= len(sh.slots) || sh.slots[n].gen != gen || sh.slots[n].f == nil {
+		return nil, false
+	}
+	return sh.slots[n].f, true
+}
+
+// remove deletes handle from the table, returning the file it named.
+func (t *handleTable) remove(handle string) (*openFile, bool) {
+	sh, n, gen, ok := t.lookup(handle)
+	if !ok {
+		return nil, false
+	}
+	sh.Lock()
+	defer sh.Unlock()
+	if n >= len(sh.slots) || sh.slots[n].gen != gen || sh.slots[n].f == nil {
+		return nil, false
+	}
+	f := sh.slots[n].f
+	sh.slots[n].f = nil
+	sh.free = append(sh.free, uint32(n))
+	return f, true
+}
+
+// closed reports whether handle named a file which has since been closed.
+func (t *handleTable) closed(handle string) bool {
+	sh, n, gen, ok := t.lookup(handle)
+	if !ok || gen == 0 {
+		return false
+	}
+	sh.RLock()
+	defer sh.RUnlock()
+	if n >= len(sh.slots) {
+		return false
+	}
+	s := sh.slots[n]
+	return gen < s.gen || (gen == s.gen && s.f == nil)
+}
+
+// errStaleHandle is the error for a request on a handle which has been
+// closed.
+var errStaleHandle = &StatusError{Code: ssh_FX_FAILURE, msg: "stale handle"}
+
+// handleError returns the error for a request on handle, which is not open:
+// errStaleHandle if it has been closed, so that clients reusing handles are
+// told so, and EBADF if it was never opened.
+func (svr *Server) handleError(handle string) error {
+	if svr.handles.closed(handle) {
+		return errStaleHandle
+	}
+	return syscall.EBADF
+}
+
+// each calls fn for every open handle. Each shard is locked in turn, so the
+// handles seen are not necessarily a consistent snapshot of the whole table.
+func (t *handleTable) each(fn func(handle string, f *openFile)) {
+	for i := range t.shards {
+		sh := &t.shards[i]
+		sh.RLock()
+		for n, s := range sh.slots {
+			if s.f != nil {
+				fn(makeHandleID(uint32(n)*handleShards+uint32(i), s.gen).String(), s.f)
+			}
+		}
+		sh.RUnlock()
+	}
+}
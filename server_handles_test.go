@@ -0,0 +1,149 @@
+package sftp
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestHandleTable(t *testing.T) {
+	tab := newHandleTable()
+	files := make(map[string]*openFile)
+	var handles []string
+	for i := 0; i < 100; i++ {
+		f := &openFile{}
+		handle := tab.add(f)
+		if _, dup := files[handle]; dup {
+			t.Fatalf("handle %q issued twice", handle)
+		}
+		files[handle] = f
+		handles = append(handles, handle)
+	}
+	for handle, f := range files {
+		if got, ok := tab.get(handle); !ok || got != f {
+			t.Errorf("get(%q): want %p, got %p %v", handle, f, got, ok)
+		}
+	}
+	stale := handles[7]
+	if got, ok := tab.remove(stale); !ok || got != files[stale] {
+		t.Errorf("remove(%q): got %p %v", stale, got, ok)
+	}
+	if _, ok := tab.remove(stale); ok {
+		t.Errorf("removed %q twice", stale)
+	}
+	if _, ok := tab.get(stale); ok {
+		t.Errorf("%q still open", stale)
+	}
+	n := 0
+	tab.each(func(handle string, f *openFile) {
+		if files[handle] != f {
+			t.Errorf("each: unexpected handle %q", handle)
+		}
+		n++
+	})
+	if n != 99 {
+		t.Errorf("each: want 99 handles, got %d", n)
+	}
+
+	// Reusing the slot must not revive the stale handle.
+	for i := 0; i < handleShards; i++ {
+		tab.add(&openFile{})
+	}
+	if _, ok := tab.get(stale); ok {
+		t.Errorf("stale handle %q names a new file", stale)
+	}
+	if !tab.closed(stale) {
+		t.Errorf("%q not reported closed", stale)
+	}
+	if tab.closed(handles[8]) {
+		t.Errorf("open handle %q reported closed", handles[8])
+	}
+	aliases := []string{"0" + handles[10], "00" + handles[10], "+" + handles[10]}
+	if upper := strings.ToUpper(handles[10]); upper != handles[10] {
+		aliases = append(aliases, upper)
+	}
+	for _, bad := range append([]string{"", "x", "ffffffffffffffff", "-1"}, aliases...) {
+		if _, ok := tab.get(bad); ok {
+			t.Errorf("get(%q) succeeded", bad)
+		}
+		if tab.closed(bad) {
+			t.Errorf("%q, never issued, reported closed", bad)
+		}
+	}
+}
+
+func TestServerStaleHandle(t *testing.T) {
+	client, _, _, cleanup := uploadServerPair(t)
+	defer cleanup()
+
+	f, err := client.Create(testUploadPath + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stale := &File{c: client, s: f.s, path: f.path, handle: f.handle}
+	for name, op := range map[string]func() error{
+		"write": func() error { _, err := stale.Write([]byte("late")); return err },
+		"close": stale.Close,
+	} {
+		err := op()
+		if se, ok := err.(*StatusError); !ok || se.Code != ssh_FX_FAILURE || se.msg != "stale handle" {
+			t.Errorf("%s on closed handle: want stale handle failure, got %v", name, err)
+		}
+	}
+
+	never := &File{c: client, s: f.s, path: f.path, handle: "ffffffff00000000"}
+	if err := never.Close(); err == nil {
+		t.Error("closed handle never issued")
+	} else if se, ok := err.(*StatusError); ok && se.msg == "stale handle" {
+		t.Error("handle never issued reported stale")
+	}
+}
+
+// A handle spelt otherwise than the server sent it names no file, so that
+// requests on a file are all handled by the same worker.
+func TestServerAliasedHandle(t *testing.T) {
+	client, _, dir, cleanup := uploadServerPair(t, WithWorkers(8))
+	defer cleanup()
+
+	f, err := client.Create(testUploadPath + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliased := &File{c: client, s: f.s, path: f.path, handle: "0" + f.handle}
+	if _, err := aliased.Write([]byte("aliased")); err == nil {
+		t.Error("write through an aliased handle succeeded")
+	}
+	if err := aliased.Close(); err == nil {
+		t.Error("close through an aliased handle succeeded")
+	}
+	if _, err := f.Write([]byte("contents")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(dir + "/file"); err != nil || string(b) != "contents" {
+		t.Errorf("got %q, %v", b, err)
+	}
+}
+
+func BenchmarkHandleTableParallel(b *testing.B) {
+	tab := newHandleTable()
+	handles := make([]string, 64)
+	for i := range handles {
+		handles[i] = tab.add(&openFile{})
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			tab.get(handles[i%len(handles)])
+			if i%16 == 0 {
+				tab.remove(tab.add(&openFile{}))
+			}
+			i++
+		}
+	})
+}
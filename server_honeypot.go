@@ -0,0 +1,150 @@
+package sftp
+
+// Decoy server which pretends to accept everything
+
+import (
+	"os"
+	"path"
+	"sync/atomic"
+	"time"
+)
+
+// A HoneypotAction is a request received by a Server in honeypot mode.
+type HoneypotAction struct {
+	Time    time.Time
+	Session SessionInfo
+	Op      Operation
+	// Path is the path named by the request, as sent by the client. For
+	// requests on a handle, it is the path the handle was opened with.
+	Path string
+	// Packet is the decoded request, for logging with %+v or as JSON. It
+	// must not be modified or retained after the function returns.
+	Packet interface{}
+}
+
+// WithHoneypot makes the Server a decoy: every request the client is
+// allowed to send by the protocol appears to succeed, but nothing is
+// changed on the server. Files may be opened anywhere, in any mode, and
+// written without limit; their data is discarded, except for what is
+// captured by WithPayloadSampling, which is the way to keep a bounded
+// sample of it. Directories may be created, removed and renamed, and
+// attributes set, all to no effect. Listings and stats behave as usual;
+// links read as missing, and statvfs describes a made-up filesystem, so
+// that no request reaches the filesystem outside the upload directory.
+//
+// f, which must not be nil, is called with every request before it is
+// handled. Events are sent as usual, naming no path on the server for the
+// files uploaded; UploadNotifier is not called.
+func WithHoneypot(f func(HoneypotAction)) ServerOption {
+	return func(s *Server) error {
+		s.honeypot = f
+		return nil
+	}
+}
+
+// handleHoneypot handles pkt in honeypot mode.
+func (svr *Server) handleHoneypot(typ fxp, pkt interface{}) error {
+	if typ != ssh_FXP_INIT {
+		svr.honeypot(HoneypotAction{
+			Time:    svr.clock.Now(),
+			Session: svr.session,
+			Op:      Operation(typ.String()),
+			Path:    svr.requestPath(pkt),
+			Packet:  pkt,
+		})
+	}
+	switch p := pkt.(type) {
+	case *sshFxpOpenPacket:
+		if reqPath, _ := svr.canonicalPath(p.Path); svr.isUploadDirOrAncestor(reqPath) && p.readonly() {
+			return handlePacket(svr, pkt)
+		}
+		return svr.openDiscarded(p)
+	case *sshFxpReadPacket:
+		if f, ok := svr.getOpenFile(p.Handle); ok && f.discard {
+			return svr.sendErrorCode(p, ssh_FX_EOF)
+		}
+	case *sshFxpWritePacket:
+		f, ok := svr.getOpenFile(p.Handle)
+		if !ok {
+			return svr.sendError(p, svr.handleError(p.Handle))
+		}
+		var n int
+		var written int64
+		var err error
+		if p.stream != nil {
+			n, written, err = svr.writeFrom(f, p.stream, p.Length, int64(p.Offset))
+		} else {
+			n, written, err = f.writeAt(p.Data, int64(p.Offset))
+		}
+		if n > 0 {
+			svr.emit(WriteProgress{
+				Session: svr.session,
+				Handle:  p.Handle,
+				Offset:  int64(p.Offset),
+				Length:  n,
+				Written: written,
+			})
+		}
+		return svr.sendError(p, err)
+	case *sshFxpFstatPacket:
+		if f, ok := svr.getOpenFile(p.Handle); ok && f.discard {
+			return svr.sendPacket(sshFxpStatResponse{
+				ID: p.ID,
+				info: &fileInfo{
+					name:  path.Base(f.remotePath),
+					size:  atomic.LoadInt64(&f.written),
+					mode:  0644,
+					mtime: f.opened,
+				},
+			})
+		}
+	case *sshFxpSetstatPacket, *sshFxpFsetstatPacket, *sshFxpMkdirPacket, *sshFxpRmdirPacket,
+		*sshFxpRemovePacket, *sshFxpRenamePacket, *sshFxpSymlinkPacket:
+		return svr.sendErrorCode(pkt.(id), ssh_FX_OK)
+	case *sshFxpReadlinkPacket:
+		return svr.sendErrorCode(p, ssh_FX_NO_SUCH_FILE)
+	case *sshFxpExtendedPacket:
+		if p, ok := p.SpecificPacket.(*sshFxpExtendedPacketStatVFS); ok {
+			return svr.sendPacket(honeypotStatVFS(p.ID))
+		}
+	}
+	return handlePacket(svr, pkt)
+}
+
+// honeypotStatVFS returns the reply to statvfs in honeypot mode: a large
+// filesystem, mostly free, as befits one which takes everything.
+func honeypotStatVFS(id uint32) *StatVFS {
+	const blocks = 1 << 28 // of 4 KiB, 1 TiB
+	return &StatVFS{
+		ID:      id,
+		Bsize:   4096,
+		Frsize:  4096,
+		Blocks:  blocks,
+		Bfree:   blocks / 8 * 7,
+		Bavail:  blocks / 8 * 7,
+		Files:   blocks / 4,
+		Ffree:   blocks / 4,
+		Favail:  blocks / 4,
+		Namemax: 255,
+	}
+}
+
+// openDiscarded opens a file whose writes are discarded.
+func (svr *Server) openDiscarded(p *sshFxpOpenPacket) error {
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		return svr.sendError(p, err)
+	}
+	of := &openFile{File: f, remotePath: p.Path, opened: svr.clock.Now(), discard: true}
+	if svr.sampleSize > 0 {
+		of.sample = &payloadSample{buf: make([]byte, svr.sampleSize)}
+	}
+	of.digests = svr.newFileDigests()
+	handle := svr.handles.add(of)
+	svr.emit(FileOpened{
+		Session:    svr.session,
+		Handle:     handle,
+		RemotePath: p.Path,
+	})
+	return svr.sendPacket(sshFxpHandlePacket{p.ID, handle})
+}
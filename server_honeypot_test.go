@@ -0,0 +1,137 @@
+package sftp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"math"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestServerHoneypot(t *testing.T) {
+	var mu sync.Mutex
+	var actions []HoneypotAction
+	var samples []UploadSample
+	client, _, dir, cleanup := uploadServerPair(t,
+		ReadOnly(),
+		WithHoneypot(func(a HoneypotAction) {
+			mu.Lock()
+			actions = append(actions, a)
+			mu.Unlock()
+		}),
+		WithPayloadSampling(4, func(s UploadSample) { samples = append(samples, s) }),
+	)
+	defer cleanup()
+
+	f, err := client.OpenFile("/etc/passwd", os.O_RDWR|os.O_APPEND)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(bytes.Repeat([]byte("x"), 100000)); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := f.Stat(); err != nil || fi.Size() != 100000 {
+		t.Errorf("want size 100000, got %v: %v", fi, err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for name, err := range map[string]error{
+		"mkdir":  client.Mkdir("/tmp/x"),
+		"remove": client.Remove("/etc/shadow"),
+		"rename": client.Rename(testUploadPath+"/a", testUploadPath+"/b"),
+		"chmod":  client.Chmod("/bin/sh", 04777),
+	} {
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	client.ReadDir(testUploadPath) // which needs a ReaddirHook to succeed
+
+	// Nothing outside the upload directory is read, even by requests
+	// which do not change anything.
+	if err := os.Symlink("/etc/hostname", dir+"/link"); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := client.ReadLink(testUploadPath + "/link"); err == nil {
+		t.Errorf("readlink: got %q", target)
+	}
+	os.Remove(dir + "/link")
+	if st, err := client.StatVFS("/etc"); err != nil || *st != *honeypotStatVFS(st.ID) {
+		t.Errorf("statvfs: got %+v, %v", st, err)
+	}
+
+	if fis, _ := ioutil.ReadDir(dir); len(fis) != 0 {
+		t.Errorf("honeypot created %d files", len(fis))
+	}
+	if len(samples) != 1 || string(samples[0].Data) != "xxxx" || samples[0].Size != 100000 {
+		t.Errorf("want a sample of the upload, got %+v", samples)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	ops := make(map[Operation]string)
+	for _, a := range actions {
+		if _, ok := ops[a.Op]; !ok {
+			ops[a.Op] = a.Path
+		}
+	}
+	for op, path := range map[Operation]string{
+		OpOpen:     "/etc/passwd",
+		OpWrite:    "/etc/passwd",
+		OpClose:    "/etc/passwd",
+		OpMkdir:    "/tmp/x",
+		OpRemove:   "/etc/shadow",
+		OpRename:   testUploadPath + "/a",
+		OpSetstat:  "/bin/sh",
+		OpOpendir:  testUploadPath,
+		OpReadlink: testUploadPath + "/link",
+	} {
+		if got, ok := ops[op]; !ok || got != path {
+			t.Errorf("%s: want action on %q, got %q %v", op, path, got, ok)
+		}
+	}
+}
+
+func TestServerHugeWriteOffset(t *testing.T) {
+	for name, opts := range map[string][]ServerOption{
+		"honeypot":   {WithHoneypot(func(HoneypotAction) {})},
+		"coalescing": {WithWriteCoalescing(1024), WithDigests(Digest{Name: "sha256", New: sha256.New})},
+	} {
+		opts = append(opts, WithPayloadSampling(16, nil))
+		client, _, _, cleanup := uploadServerPair(t, opts...)
+		f, err := client.Create(testUploadPath + "/file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Offsets of 2^63 and above, and writes ending there, are negative
+		// as an int64.
+		for _, offset := range []uint64{1 << 63, math.MaxUint64, math.MaxInt64 - 1} {
+			id := client.nextID()
+			typ, data, err := client.sendPacket(sshFxpWritePacket{
+				ID:     id,
+				Handle: f.handle,
+				Offset: offset,
+				Length: 4,
+				Data:   []byte("data"),
+			})
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if typ != ssh_FXP_STATUS {
+				t.Fatalf("%s: want SSH_FXP_STATUS, got %v", name, fxp(typ))
+			}
+			if code := statusCode(t, unmarshalStatus(id, data)); code != ssh_FX_FAILURE {
+				t.Errorf("%s: write at %d: got code %d", name, offset, code)
+			}
+		}
+		if _, err := f.Write([]byte("contents")); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if err := f.Close(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		cleanup()
+	}
+}
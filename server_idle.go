@@ -0,0 +1,74 @@
+package sftp
+
+// Ending idle sessions
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrIdleTimeout is returned by Serve when it ends a session which has been
+// idle for longer than allowed by WithIdleTimeout.
+var ErrIdleTimeout = errors.New("sftp: session idle timeout")
+
+// WithIdleTimeout ends sessions in which no request has been received or
+// handled for d, so that a client which has gone away without closing its
+// connection does not keep its files open until TCP gives up on it. The
+// Server closes the connection, which must make a Read from it return, as
+// closing an ssh.Channel or net.Conn does, and Serve returns
+// ErrIdleTimeout.
+func WithIdleTimeout(d time.Duration) ServerOption {
+	return func(s *Server) error {
+		if d <= 0 {
+			return errors.Errorf("invalid idle timeout %v", d)
+		}
+		s.idleTimeout = d
+		return nil
+	}
+}
+
+// touch records activity on the session, for WithIdleTimeout.
+func (svr *Server) touch() {
+	if svr.idleTimeout > 0 {
+		atomic.StoreInt64(&svr.lastActive, svr.clock.Now().UnixNano())
+	}
+}
+
+// startIdleTimer starts ending the session once it is idle. It returns a
+// function stopping the timer.
+func (svr *Server) startIdleTimer() (stop func()) {
+	if svr.idleTimeout == 0 {
+		return func() {}
+	}
+	svr.touch()
+	var mu sync.Mutex
+	var t ClockTimer
+	var stopped bool
+	check := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		idle := svr.clock.Now().Sub(time.Unix(0, atomic.LoadInt64(&svr.lastActive)))
+		switch {
+		case stopped:
+		case atomic.LoadInt64(&svr.pendingPackets) > 0:
+			t.Reset(svr.idleTimeout)
+		case idle < svr.idleTimeout:
+			t.Reset(svr.idleTimeout - idle)
+		default:
+			atomic.StoreUint32(&svr.idleExpired, 1)
+			svr.conn.Close() // shuts down recvPacket
+		}
+	}
+	mu.Lock()
+	t = svr.clock.AfterFunc(svr.idleTimeout, check)
+	mu.Unlock()
+	return func() {
+		mu.Lock()
+		stopped = true
+		t.Stop()
+		mu.Unlock()
+	}
+}
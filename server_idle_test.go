@@ -0,0 +1,61 @@
+package sftp
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// pipeEnd is one end of a connection made of two pipes, which closes both
+// when closed, as an ssh.Channel does.
+type pipeEnd struct {
+	*io.PipeReader
+	*io.PipeWriter
+}
+
+func (p pipeEnd) Close() error {
+	p.PipeReader.Close()
+	return p.PipeWriter.Close()
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	server, err := NewServer(pipeEnd{sr, sw}, UploadPath(testUploadPath), WithIdleTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- server.Serve() }()
+	client, err := NewClientPipe(cr, cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Activity keeps the session open.
+	for i := 0; i < 5; i++ {
+		time.Sleep(20 * time.Millisecond)
+		if _, err := client.Stat(testUploadPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("active session ended: %v", err)
+	default:
+	}
+
+	select {
+	case err := <-done:
+		if err != ErrIdleTimeout {
+			t.Errorf("want ErrIdleTimeout, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("idle session not ended")
+	}
+
+	if _, err := NewServer(nopReadWriteCloser{}, WithIdleTimeout(0)); err == nil {
+		t.Error("zero idle timeout accepted")
+	}
+}
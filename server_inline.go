@@ -0,0 +1,62 @@
+package sftp
+
+// Inline handling of cheap requests
+
+import (
+	"sync/atomic"
+)
+
+// inlinePacket reports whether p is cheap enough to be handled by the
+// receive loop itself, saving the hand off to a worker: REALPATH, and STAT or
+// LSTAT of the upload directory or one of its ancestors, none of which touch
+// the file system, and CLOSE of a directory handle while no other request is
+// outstanding, so that it cannot overtake a READDIR. Closing a file is left
+// to a worker: it may write out, digest and finalize the upload and call
+// hooks. Nothing is handled inline with WithFaults, whose delays must not
+// stall the receive loop.
+func (svr *Server) inlinePacket(p rxPacket) bool {
+	if p.stream != nil || svr.faults != nil || atomic.LoadUint32(&svr.clientVersion) == 0 {
+		return false
+	}
+	switch p.pktType {
+	case ssh_FXP_REALPATH:
+		return true
+	case ssh_FXP_STAT, ssh_FXP_LSTAT:
+		_, b, err := unmarshalUint32Safe(p.pktBytes)
+		if err != nil {
+			return false
+		}
+		reqPath, _, err := unmarshalStringSafe(b)
+		if err != nil {
+			return false
+		}
+		reqPath, ok := svr.canonicalPath(reqPath)
+		return ok && svr.isUploadDirOrAncestor(reqPath)
+	case ssh_FXP_CLOSE:
+		if atomic.LoadInt64(&svr.pendingPackets) != 0 {
+			return false
+		}
+		_, b, err := unmarshalUint32Safe(p.pktBytes)
+		if err != nil {
+			return false
+		}
+		handle, _, err := unmarshalStringSafe(b)
+		if err != nil {
+			return false
+		}
+		f, ok := svr.getOpenFile(handle)
+		return ok && f.dir != nil
+	default:
+		return false
+	}
+}
+
+// processInline handles p in the receive loop, as a worker would. Its
+// response is sent at once, as no worker may be about to flush.
+func (svr *Server) processInline(p rxPacket) error {
+	defer svr.releasePacket(p)
+	if err := svr.processPacket(p); err != nil {
+		return err
+	}
+	return svr.conn.flush()
+}
@@ -0,0 +1,61 @@
+package sftp
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+func TestInlinePacket(t *testing.T) {
+	svr := &Server{uploadPath: testUploadPath, clientVersion: sftpProtocolVersion, handles: newHandleTable()}
+	dir := svr.handles.add(&openFile{dir: &openDirInfo{name: testUploadPath}})
+	file := svr.handles.add(&openFile{})
+	for _, tt := range []struct {
+		p    rxPacket
+		want bool
+	}{
+		{rxPacket{pktType: ssh_FXP_REALPATH, pktBytes: sp(sshFxpRealpathPacket{ID: 1, Path: "."})[5:]}, true},
+		{rxPacket{pktType: ssh_FXP_STAT, pktBytes: sp(sshFxpStatPacket{ID: 2, Path: testUploadPath + "/"})[5:]}, true},
+		{rxPacket{pktType: ssh_FXP_LSTAT, pktBytes: sp(sshFxpLstatPacket{ID: 3, Path: "/"})[5:]}, true},
+		{rxPacket{pktType: ssh_FXP_STAT, pktBytes: sp(sshFxpStatPacket{ID: 4, Path: testUploadPath + "/f"})[5:]}, false},
+		{rxPacket{pktType: ssh_FXP_CLOSE, pktBytes: sp(sshFxpClosePacket{ID: 5, Handle: dir})[5:]}, true},
+		{rxPacket{pktType: ssh_FXP_CLOSE, pktBytes: sp(sshFxpClosePacket{ID: 5, Handle: file})[5:]}, false},
+		{rxPacket{pktType: ssh_FXP_CLOSE, pktBytes: sp(sshFxpClosePacket{ID: 5, Handle: "ff"})[5:]}, false},
+		{rxPacket{pktType: ssh_FXP_WRITE, pktBytes: sp(sshFxpWritePacket{ID: 6, Handle: file, Data: []byte("x")})[5:]}, false},
+	} {
+		if got := svr.inlinePacket(tt.p); got != tt.want {
+			t.Errorf("%s: want %v, got %v", tt.p.pktType, tt.want, got)
+		}
+	}
+
+	// A CLOSE must not overtake requests still waiting for a worker.
+	svr.pendingPackets = 1
+	if svr.inlinePacket(rxPacket{pktType: ssh_FXP_CLOSE, pktBytes: sp(sshFxpClosePacket{ID: 7, Handle: dir})[5:]}) {
+		t.Error("CLOSE handled inline with a request pending")
+	}
+	// Nothing is handled inline before the session is initialized.
+	svr.clientVersion = 0
+	if svr.inlinePacket(rxPacket{pktType: ssh_FXP_REALPATH, pktBytes: sp(sshFxpRealpathPacket{ID: 8, Path: "."})[5:]}) {
+		t.Error("REALPATH handled inline before INIT")
+	}
+}
+
+func TestServerInlineRequests(t *testing.T) {
+	client, _, _, cleanup := uploadServerPair(t, ReaddirHook(func() ([]os.FileInfo, error) {
+		return nil, io.EOF
+	}))
+	defer cleanup()
+
+	for i := 0; i < 3; i++ {
+		if fi, err := client.Stat(testUploadPath); err != nil || !fi.IsDir() {
+			t.Fatalf("stat: %v %v", fi, err)
+		}
+		if wd, err := client.Getwd(); err != nil || wd != testUploadPath {
+			t.Fatalf("getwd: %q %v", wd, err)
+		}
+		upload(t, client, "f", []byte("data"))
+		if _, err := client.ReadDir(testUploadPath); err != nil {
+			t.Fatalf("readdir: %v", err)
+		}
+	}
+}
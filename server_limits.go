@@ -0,0 +1,88 @@
+package sftp
+
+// Limits on simultaneous sessions per client
+
+import (
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrTooManySessions is returned by SessionLimits.Acquire when a client
+// already has as many sessions as it is allowed.
+var ErrTooManySessions = errors.New("too many sessions")
+
+// SessionLimits bounds the number of simultaneous sessions of each source
+// address and of each user. The package does not own the listener, so it is
+// up to the program accepting connections to call Acquire once the client
+// has authenticated, and to close the connection before starting a Server
+// if it fails. It is safe for concurrent use.
+type SessionLimits struct {
+	perAddr, perUser int
+
+	mu     sync.Mutex
+	byAddr map[string]int
+	byUser map[string]int
+}
+
+// NewSessionLimits returns SessionLimits allowing perAddr sessions from each
+// source address, whatever its port, and perUser sessions for each user. A
+// limit of zero or less means no limit.
+func NewSessionLimits(perAddr, perUser int) *SessionLimits {
+	return &SessionLimits{
+		perAddr: perAddr,
+		perUser: perUser,
+		byAddr:  make(map[string]int),
+		byUser:  make(map[string]int),
+	}
+}
+
+// sessionHost returns the source address of info, without its port.
+func sessionHost(info SessionInfo) string {
+	host, _, err := net.SplitHostPort(info.RemoteAddr)
+	if err != nil {
+		return info.RemoteAddr
+	}
+	return host
+}
+
+// Acquire counts a session of the client described by info, whose User and
+// RemoteAddr are used, returning a function to call once the session has
+// ended. If the client is at either of its limits, it returns
+// ErrTooManySessions and counts nothing. Clients with no known address or
+// user are not limited on that count.
+func (l *SessionLimits) Acquire(info SessionInfo) (release func(), err error) {
+	host, user := sessionHost(info), info.User
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if host != "" && l.perAddr > 0 && l.byAddr[host] >= l.perAddr {
+		return nil, errors.Wrapf(ErrTooManySessions, "address %s", host)
+	}
+	if user != "" && l.perUser > 0 && l.byUser[user] >= l.perUser {
+		return nil, errors.Wrapf(ErrTooManySessions, "user %s", user)
+	}
+	if host != "" {
+		l.byAddr[host]++
+	}
+	if user != "" {
+		l.byUser[user]++
+	}
+	var once sync.Once
+	return func() { once.Do(func() { l.release(host, user) }) }, nil
+}
+
+func (l *SessionLimits) release(host, user string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if host != "" {
+		if l.byAddr[host]--; l.byAddr[host] == 0 {
+			delete(l.byAddr, host)
+		}
+	}
+	if user != "" {
+		if l.byUser[user]--; l.byUser[user] == 0 {
+			delete(l.byUser, user)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package sftp
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestSessionLimits(t *testing.T) {
+	l := NewSessionLimits(2, 1)
+	acquire := func(user, addr string) (func(), error) {
+		return l.Acquire(SessionInfo{User: user, RemoteAddr: addr})
+	}
+
+	r1, err := acquire("alice", "192.0.2.1:5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acquire("alice", "192.0.2.2:5000"); errors.Cause(err) != ErrTooManySessions {
+		t.Errorf("second session of user: want ErrTooManySessions, got %v", err)
+	}
+	r2, err := acquire("bob", "192.0.2.1:5001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acquire("carol", "192.0.2.1:5002"); errors.Cause(err) != ErrTooManySessions {
+		t.Errorf("third session of address: want ErrTooManySessions, got %v", err)
+	}
+	// Refused sessions are not counted.
+	if _, err := acquire("carol", "192.0.2.3:5000"); err != nil {
+		t.Errorf("carol: %v", err)
+	}
+
+	r1()
+	r1() // releasing twice counts once
+	r3, err := acquire("alice", "192.0.2.1:5003")
+	if err != nil {
+		t.Fatalf("after release: %v", err)
+	}
+	if _, err := acquire("dave", "192.0.2.1:5004"); errors.Cause(err) != ErrTooManySessions {
+		t.Errorf("want ErrTooManySessions, got %v", err)
+	}
+	r2()
+	r3()
+	if len(l.byAddr) != 1 || len(l.byUser) != 1 {
+		t.Errorf("counts left over: %v %v", l.byAddr, l.byUser)
+	}
+
+	// Unknown identities are not limited.
+	for i := 0; i < 3; i++ {
+		if _, err := acquire("", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
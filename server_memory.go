@@ -0,0 +1,101 @@
+package sftp
+
+// Memory budget shared by Servers
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// A MemoryBudget bounds the memory held by requests which have been received
+// but not yet handled, across every Server sharing it, so that a burst of
+// parallel uploads cannot exhaust the memory of the process. It is safe for
+// concurrent use.
+type MemoryBudget struct {
+	limit int64
+
+	mu   sync.Mutex
+	cond sync.Cond
+	used int64
+}
+
+// NewMemoryBudget returns a MemoryBudget of limit bytes.
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	b := &MemoryBudget{limit: limit}
+	b.cond.L = &b.mu
+	return b
+}
+
+// Used returns the number of bytes of the budget in use.
+func (b *MemoryBudget) Used() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// fits reports whether n more bytes fit in the budget. A request larger than
+// the whole budget fits once nothing else is using it.
+func (b *MemoryBudget) fits(n int64) bool {
+	return b.used == 0 || b.used+n <= b.limit
+}
+
+// acquire takes n bytes from the budget, waiting for them to be released by
+// others if need be.
+func (b *MemoryBudget) acquire(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for !b.fits(n) {
+		b.cond.Wait()
+	}
+	b.used += n
+}
+
+// tryAcquire takes n bytes from the budget if they are available.
+func (b *MemoryBudget) tryAcquire(n int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.fits(n) {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+func (b *MemoryBudget) release(n int64) {
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// WithMemoryBudget counts the requests received by the Server against b.
+// When b is exhausted, the Server stops reading requests until enough of
+// those already received, by it or by other Servers sharing b, have been
+// handled. Under OverloadReject, SSH_FXP_OPEN requests are refused with
+// SSH_FX_FAILURE instead of waiting, so that new uploads are turned away
+// while those in progress continue.
+func WithMemoryBudget(b *MemoryBudget) ServerOption {
+	return func(s *Server) error {
+		if b == nil || b.limit <= 0 {
+			return errors.New("memory budget must be positive")
+		}
+		s.memoryBudget = b
+		return nil
+	}
+}
+
+// admitPacket counts p against the memory budget, waiting for room if need
+// be. If p is instead refused, it reports false.
+func (svr *Server) admitPacket(p *rxPacket) (bool, error) {
+	n := int64(len(p.pktBytes))
+	if svr.overloadPolicy == OverloadReject && p.pktType == ssh_FXP_OPEN {
+		if !svr.memoryBudget.tryAcquire(n) {
+			return false, svr.refusePacket(*p)
+		}
+	} else {
+		svr.memoryBudget.acquire(n)
+	}
+	p.budgeted = true
+	return true, nil
+}
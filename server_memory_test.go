@@ -0,0 +1,85 @@
+package sftp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	b := NewMemoryBudget(100)
+	b.acquire(60)
+	if b.tryAcquire(50) {
+		t.Fatal("budget overcommitted")
+	}
+	acquired := make(chan struct{})
+	go func() {
+		b.acquire(50)
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquire did not wait")
+	case <-time.After(20 * time.Millisecond):
+	}
+	b.release(60)
+	<-acquired
+	if got := b.Used(); got != 50 {
+		t.Errorf("want 50 bytes used, got %d", got)
+	}
+	b.release(50)
+	if !b.tryAcquire(1000) {
+		t.Error("request larger than an unused budget refused")
+	}
+}
+
+func TestServerMemoryBudget(t *testing.T) {
+	budget := NewMemoryBudget(64 * 1024)
+	client1, _, _, cleanup1 := uploadServerPair(t, WithMemoryBudget(budget))
+	defer cleanup1()
+	client2, _, _, cleanup2 := uploadServerPair(t, WithMemoryBudget(budget))
+	defer cleanup2()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		upload(t, client1, "f", bytes.Repeat([]byte("a"), 1<<20))
+	}()
+	upload(t, client2, "f", bytes.Repeat([]byte("b"), 1<<20))
+	<-done
+	// The last responses are sent just before their requests are released.
+	deadline := time.Now().Add(time.Second)
+	for budget.Used() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := budget.Used(); got != 0 {
+		t.Errorf("want budget released, %d bytes still used", got)
+	}
+}
+
+func TestServerMemoryBudgetRejectOpen(t *testing.T) {
+	budget := NewMemoryBudget(1024)
+	denials := make(chan OperationDenied, 1)
+	client, _, _, cleanup := uploadServerPair(t,
+		WithMemoryBudget(budget),
+		WithOverloadPolicy(OverloadReject),
+		DenialNotifier(func(e OperationDenied) { denials <- e }),
+	)
+	defer cleanup()
+
+	budget.acquire(1024)
+	if _, err := client.Create(testUploadPath + "/f"); !isBusy(err) {
+		t.Errorf("want server busy, got %v", err)
+	}
+	if e := <-denials; e.Op != "SSH_FXP_OPEN" || e.Reason != DeniedOverload {
+		t.Errorf("want overload denial of SSH_FXP_OPEN, got %+v", e)
+	}
+	budget.release(1024)
+	upload(t, client, "f", []byte("data"))
+}
+
+func TestWithMemoryBudgetInvalid(t *testing.T) {
+	if _, err := NewServer(nil, WithMemoryBudget(NewMemoryBudget(0))); err == nil {
+		t.Error("no error for empty budget")
+	}
+}
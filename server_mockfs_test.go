@@ -0,0 +1,183 @@
+package sftp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// A mockFS is a writeBackend whose writes to each file follow a script:
+// they fail, stall or come up short on demand, so that tests can take the
+// upload pipeline down its error paths. Writes beyond the script, and to
+// files without one, go through to the file.
+type mockFS struct {
+	mu      sync.Mutex
+	scripts map[string][]mockStep // by base name of the file
+	writes  map[string]int        // calls to WriteAt, by base name
+}
+
+// A mockStep is what one write does.
+type mockStep struct {
+	delay time.Duration // waited before writing
+	short int           // bytes written, if less than asked, before err
+	err   error         // returned, wrapped in an *os.PathError
+}
+
+var (
+	mockOK     = mockStep{short: -1}
+	mockEIO    = mockStep{err: syscall.EIO}
+	mockENOSPC = mockStep{err: syscall.ENOSPC}
+)
+
+func mockSlow(d time.Duration) mockStep { return mockStep{delay: d, short: -1} }
+
+// mockShort writes n bytes, succeeding as a broken backend might.
+func mockShort(n int) mockStep { return mockStep{short: n} }
+
+func newMockFS() *mockFS {
+	return &mockFS{scripts: make(map[string][]mockStep), writes: make(map[string]int)}
+}
+
+// script appends steps to the script of writes to the file named name.
+func (m *mockFS) script(name string, steps ...mockStep) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scripts[name] = append(m.scripts[name], steps...)
+}
+
+// calls returns the number of writes to the file named name.
+func (m *mockFS) calls(name string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.writes[name]
+}
+
+func (m *mockFS) WriteAt(f *os.File, b []byte, offset int64) (int, error) {
+	name := filepath.Base(f.Name())
+	m.mu.Lock()
+	m.writes[name]++
+	step := mockOK
+	if s := m.scripts[name]; len(s) > 0 {
+		step, m.scripts[name] = s[0], s[1:]
+	}
+	m.mu.Unlock()
+
+	time.Sleep(step.delay)
+	if step.short >= 0 && step.short < len(b) {
+		b = b[:step.short]
+	}
+	n, err := f.WriteAt(b, offset)
+	if err == nil && step.err != nil {
+		err = &os.PathError{Op: "write", Path: f.Name(), Err: step.err}
+	}
+	return n, err
+}
+
+func (m *mockFS) Close() error { return nil }
+
+// withWriteBackend makes the Server write uploads through wb.
+func withWriteBackend(wb writeBackend) ServerOption {
+	return func(s *Server) error {
+		s.uringEntries = 0
+		s.writeBackend = wb
+		return nil
+	}
+}
+
+// statusCode returns the code of err, which must be a *StatusError.
+func statusCode(t *testing.T, err error) uint32 {
+	t.Helper()
+	se, ok := err.(*StatusError)
+	if !ok {
+		t.Fatalf("want a *StatusError, got %T %v", err, err)
+	}
+	return se.Code
+}
+
+func TestServerMockFSErrors(t *testing.T) {
+	fs := newMockFS()
+	client, _, dir, cleanup := uploadServerPair(t, withWriteBackend(fs))
+	defer cleanup()
+
+	for _, tt := range []struct {
+		name string
+		step mockStep
+		code uint32
+	}{
+		{"nospace", mockENOSPC, ssh_FX_NO_SPACE_ON_FILESYSTEM},
+		{"eio", mockEIO, ssh_FX_FAILURE},
+		{"quota", mockStep{err: syscall.EDQUOT}, ssh_FX_QUOTA_EXCEEDED},
+		{"partial", mockStep{short: 3, err: syscall.EIO}, ssh_FX_FAILURE},
+		{"short", mockShort(3), ssh_FX_FAILURE},
+	} {
+		fs.script(tt.name, tt.step)
+		f, err := client.Create(testUploadPath + "/" + tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = f.Write([]byte("contents"))
+		if err == nil {
+			t.Errorf("%s: write succeeded", tt.name)
+		} else if code := statusCode(t, err); code != tt.code {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.code)
+		}
+		f.Close()
+	}
+
+	// The data written before a failure stays, and the session goes on.
+	if b, err := ioutil.ReadFile(dir + "/partial"); err != nil || string(b) != "con" {
+		t.Errorf("partial write: got %q, %v", b, err)
+	}
+	upload(t, client, "after", []byte("contents"))
+	if b, err := ioutil.ReadFile(dir + "/after"); err != nil || string(b) != "contents" {
+		t.Errorf("upload after failures: got %q, %v", b, err)
+	}
+}
+
+func TestServerMockFSStreamedWrite(t *testing.T) {
+	fs := newMockFS()
+	client, _, dir, cleanup := uploadServerPair(t, withWriteBackend(fs))
+	defer cleanup()
+	client.maxPacket = 1 << 17 // larger than the server's receive buffers
+
+	// The second chunk of the stream fails: the rest is read and dropped.
+	fs.script("big", mockOK, mockENOSPC)
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1<<13)
+	f, err := client.Create(testUploadPath + "/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(data); err == nil || statusCode(t, err) != ssh_FX_NO_SPACE_ON_FILESYSTEM {
+		t.Errorf("got %v, want SSH_FX_NO_SPACE_ON_FILESYSTEM", err)
+	}
+	f.Close()
+	if n := fs.calls("big"); n != 2 {
+		t.Errorf("got %d writes, want 2", n)
+	}
+
+	upload(t, client, "big", data)
+	if b, err := ioutil.ReadFile(dir + "/big"); err != nil || !bytes.Equal(b, data) {
+		t.Errorf("upload after failure: got %d bytes, %v", len(b), err)
+	}
+}
+
+func TestServerMockFSSlowWrite(t *testing.T) {
+	fs := newMockFS()
+	client, _, dir, cleanup := uploadServerPair(t,
+		withWriteBackend(fs),
+		WithIdleTimeout(50*time.Millisecond),
+	)
+	defer cleanup()
+
+	// A write in progress keeps the session from being idle.
+	fs.script("slow", mockSlow(200*time.Millisecond))
+	upload(t, client, "slow", []byte("contents"))
+	if b, err := ioutil.ReadFile(dir + "/slow"); err != nil || string(b) != "contents" {
+		t.Errorf("got %q, %v", b, err)
+	}
+}
@@ -0,0 +1,59 @@
+package sftp
+
+// Limit on the size of inbound packets
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// minMaxMessageSize is the smallest packet every implementation must accept,
+// according to draft-ietf-secsh-filexfer.
+const minMaxMessageSize = 34000
+
+// WithMaxMessageSize limits the length a client may declare for a packet to
+// n bytes, which must be at least 34000. A client sending a longer packet is
+// refused with SSH_FX_BAD_MESSAGE, reported as DeniedMessageSize, and
+// disconnected without the rest of the packet being read, so that it cannot
+// make the Server allocate or read through large amounts of memory. The
+// default is no limit.
+func WithMaxMessageSize(n uint32) ServerOption {
+	return func(s *Server) error {
+		if n < minMaxMessageSize {
+			return errors.Errorf("max message size %d less than %d", n, minMaxMessageSize)
+		}
+		s.maxMessageSize = n
+		return nil
+	}
+}
+
+// refuseMessage refuses a packet of length l, exceeding the maximum message
+// size, having read its type and id. It returns the error ending the
+// session.
+func (svr *Server) refuseMessage(r io.Reader, l uint32) error {
+	tooLarge := errors.Errorf("packet length %d exceeds limit %d", l, svr.maxMessageSize)
+	b := []byte{0, 0, 0, 0, 0}
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	typ := fxp(b[0])
+	svr.denied(typ, "", ssh_FX_BAD_MESSAGE, DeniedMessageSize)
+	if typ == ssh_FXP_INIT {
+		return tooLarge // which has no id to respond to
+	}
+	id, _ := unmarshalUint32(b[1:])
+	if err := svr.sendPacket(sshFxpStatusPacket{
+		ID: id,
+		StatusError: StatusError{
+			Code: ssh_FX_BAD_MESSAGE,
+			msg:  "message too large",
+		},
+	}); err != nil {
+		return err
+	}
+	if err := svr.conn.flush(); err != nil {
+		return err
+	}
+	return tooLarge
+}
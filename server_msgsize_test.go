@@ -0,0 +1,69 @@
+package sftp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestServerMaxMessageSize(t *testing.T) {
+	client, _, _, cleanup := uploadServerPair(t, WithMaxMessageSize(minMaxMessageSize))
+	defer cleanup()
+	upload(t, client, "file", bytes.Repeat([]byte{1}, 1<<16))
+
+	if _, err := NewServer(nopReadWriteCloser{}, WithMaxMessageSize(1024)); err == nil {
+		t.Error("max message size below the minimum accepted")
+	}
+}
+
+func TestServerMaxMessageSizeExceeded(t *testing.T) {
+	var in bytes.Buffer
+	in.Write(sp(sshFxInitPacket{Version: sftpProtocolVersion}))
+	// A STAT claiming to be 1GiB long, which is never sent.
+	hdr := make([]byte, 4+1+4)
+	binary.BigEndian.PutUint32(hdr, 1<<30)
+	hdr[4] = byte(ssh_FXP_STAT)
+	binary.BigEndian.PutUint32(hdr[5:], 7)
+	in.Write(hdr)
+
+	var out bytes.Buffer
+	var denials []OperationDenied
+	svr, err := NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{&in, nopWriteCloser{&out}},
+		WithMaxMessageSize(minMaxMessageSize),
+		DenialNotifier(func(e OperationDenied) { denials = append(denials, e) }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svr.Serve(); err == nil || !strings.Contains(err.Error(), "exceeds limit") {
+		t.Errorf("want error for packet length, got %v", err)
+	}
+
+	// The VERSION is sent by a worker, so may come second.
+	var status []byte
+	for i := 0; i < 2; i++ {
+		typ, b, err := recvPacket(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fxp(typ) == ssh_FXP_STATUS {
+			status = b
+		}
+	}
+	if status == nil {
+		t.Fatal("no SSH_FXP_STATUS sent")
+	}
+	id, b := unmarshalUint32(status)
+	code, _ := unmarshalUint32(b)
+	if id != 7 || code != ssh_FX_BAD_MESSAGE {
+		t.Errorf("want SSH_FX_BAD_MESSAGE for request 7, got %d for %d", code, id)
+	}
+	if len(denials) != 1 || denials[0].Reason != DeniedMessageSize || denials[0].Op != "SSH_FXP_STAT" {
+		t.Errorf("want a message-size denial of SSH_FXP_STAT, got %+v", denials)
+	}
+}
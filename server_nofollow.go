@@ -0,0 +1,14 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package sftp
+
+import (
+	"syscall"
+)
+
+// oNoFollow makes open fail if the last element of the path is a symbolic
+// link.
+const oNoFollow = syscall.O_NOFOLLOW
+
+var errSymlink = syscall.ELOOP
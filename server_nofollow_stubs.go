@@ -0,0 +1,13 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package sftp
+
+import (
+	"github.com/pkg/errors"
+)
+
+// oNoFollow is not supported here: createUploadFile relies on its Lstat.
+const oNoFollow = 0
+
+var errSymlink = errors.New("too many levels of symbolic links")
@@ -0,0 +1,106 @@
+package sftp
+
+// Request queueing and load shedding
+
+import (
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// An OverloadPolicy says what the Server does with a request when the queue
+// of the worker which would handle it is full.
+type OverloadPolicy int
+
+const (
+	// OverloadBlock stops reading requests until the worker catches up.
+	// This is the default.
+	OverloadBlock OverloadPolicy = iota
+	// OverloadReject refuses the request with SSH_FX_FAILURE, unless it is
+	// part of an upload in progress: writes and closes always wait.
+	OverloadReject
+)
+
+// criticalPacketTypes are the requests which are queued even under
+// OverloadReject.
+var criticalPacketTypes = map[fxp]bool{
+	ssh_FXP_INIT:  true,
+	ssh_FXP_WRITE: true,
+	ssh_FXP_CLOSE: true,
+}
+
+// WithQueueDepth sets the number of requests which may wait for each worker;
+// the default is the number of workers.
+func WithQueueDepth(n int) ServerOption {
+	return func(s *Server) error {
+		if n < 0 {
+			return errors.Errorf("invalid queue depth %d", n)
+		}
+		s.queueDepth = n
+		return nil
+	}
+}
+
+// WithOverloadPolicy sets what the Server does with requests which arrive
+// while their worker's queue is full.
+func WithOverloadPolicy(p OverloadPolicy) ServerOption {
+	return func(s *Server) error {
+		s.overloadPolicy = p
+		return nil
+	}
+}
+
+// WithRequestWindow limits the requests a client may have outstanding, that
+// is, received but not yet answered, to n. When the limit is reached the
+// Server stops reading requests until it has answered one, so that a client
+// pipelining requests without reading the responses is flow controlled
+// rather than queueing without bound. The default is no limit beyond that
+// imposed by the worker queues.
+func WithRequestWindow(n int) ServerOption {
+	return func(s *Server) error {
+		if n < 1 {
+			return errors.Errorf("invalid request window %d", n)
+		}
+		s.window = make(chan struct{}, n)
+		return nil
+	}
+}
+
+// queuePacket sends p to the worker queue ch, or refuses it if the queue is
+// full and the overload policy allows.
+func (svr *Server) queuePacket(ch chan<- rxPacket, p rxPacket) error {
+	atomic.AddInt64(&svr.pendingPackets, 1)
+	if svr.overloadPolicy != OverloadReject || criticalPacketTypes[p.pktType] {
+		ch <- p
+		return nil
+	}
+	select {
+	case ch <- p:
+		return nil
+	default:
+	}
+
+	atomic.AddInt64(&svr.pendingPackets, -1)
+	return svr.refusePacket(p)
+}
+
+// refusePacket answers p with SSH_FX_FAILURE because the server is too busy
+// to handle it, and releases it.
+func (svr *Server) refusePacket(p rxPacket) error {
+	defer svr.releasePacket(p)
+	id, _, err := unmarshalUint32Safe(p.pktBytes)
+	if err != nil {
+		return err
+	}
+	svr.denied(p.pktType, "", ssh_FX_FAILURE, DeniedOverload)
+	if err := svr.sendPacket(sshFxpStatusPacket{
+		ID: id,
+		StatusError: StatusError{
+			Code: ssh_FX_FAILURE,
+			msg:  "server busy",
+		},
+	}); err != nil {
+		return err
+	}
+	return svr.conn.flush()
+}
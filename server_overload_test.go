@@ -0,0 +1,106 @@
+package sftp
+
+import (
+	"testing"
+	"time"
+)
+
+func isBusy(err error) bool {
+	serr, ok := err.(*StatusError)
+	return ok && serr.Code == ssh_FX_FAILURE && serr.msg == "server busy"
+}
+
+func TestServerOverloadReject(t *testing.T) {
+	denials := make(chan OperationDenied, 2)
+	client, _, _, cleanup := uploadServerPair(t,
+		WithWorkers(1),
+		WithQueueDepth(0),
+		WithOverloadPolicy(OverloadReject),
+		WithFaults(Fault{Ops: []string{"SSH_FXP_STAT"}, Probability: 1, Delay: 200 * time.Millisecond}),
+		DenialNotifier(func(e OperationDenied) { denials <- e }),
+	)
+	defer cleanup()
+
+	errs := make(chan error, 2)
+	stat := func() {
+		_, err := client.Stat("/")
+		errs <- err
+	}
+	go stat()
+	time.Sleep(50 * time.Millisecond) // let the first request reach the worker
+	go stat()
+
+	var busy int
+	for i := 0; i < 2; i++ {
+		if isBusy(<-errs) {
+			busy++
+		}
+	}
+	if busy != 1 {
+		t.Errorf("want 1 request refused, got %d", busy)
+	}
+	select {
+	case e := <-denials:
+		if e.Op != "SSH_FXP_STAT" || e.Reason != DeniedOverload {
+			t.Errorf("want overload denial of SSH_FXP_STAT, got %+v", e)
+		}
+	default:
+		t.Error("denial not reported")
+	}
+}
+
+func TestServerOverloadBlock(t *testing.T) {
+	client, _, _, cleanup := uploadServerPair(t,
+		WithWorkers(1),
+		WithQueueDepth(0),
+		WithFaults(Fault{Ops: []string{"SSH_FXP_STAT"}, Probability: 1, Delay: 50 * time.Millisecond}),
+	)
+	defer cleanup()
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := client.Stat("/")
+			errs <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; isBusy(err) {
+			t.Error("request refused")
+		}
+	}
+}
+
+func TestServerRequestWindow(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	client, _, _, cleanup := uploadServerPair(t,
+		WithWorkers(4),
+		WithRequestWindow(2),
+		WithFaults(Fault{Ops: []string{"SSH_FXP_STAT"}, Probability: 1, Delay: delay}),
+	)
+	defer cleanup()
+
+	// With 2 requests answered at a time, 4 take at least two delays.
+	start := time.Now()
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		go func() {
+			_, err := client.Stat("/")
+			errs <- err
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if d := time.Since(start); d < 2*delay {
+		t.Errorf("4 requests answered in %v, want at least %v", d, 2*delay)
+	}
+}
+
+func TestWithRequestWindowInvalid(t *testing.T) {
+	if _, err := NewServer(nil, WithRequestWindow(0)); err == nil {
+		t.Error("no error for empty window")
+	}
+}
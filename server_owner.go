@@ -0,0 +1,27 @@
+package sftp
+
+// Ownership of uploaded files
+
+import (
+	"github.com/pkg/errors"
+)
+
+type fileOwner struct {
+	uid, gid int
+}
+
+// WithFileOwner makes uid and gid the owner and group of each uploaded file
+// once the client has closed it, so that files belong to whoever processes
+// them rather than to the server. Either may be -1 to leave it unchanged.
+// The Server needs the privilege to change ownership, such as CAP_CHOWN;
+// if it fails, the client's close fails. Staged uploads are given their
+// owner before being moved into place.
+func WithFileOwner(uid, gid int) ServerOption {
+	return func(s *Server) error {
+		if uid < -1 || gid < -1 {
+			return errors.Errorf("invalid file owner %d:%d", uid, gid)
+		}
+		s.fileOwner = &fileOwner{uid, gid}
+		return nil
+	}
+}
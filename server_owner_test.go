@@ -0,0 +1,53 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package sftp
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestServerFileOwner(t *testing.T) {
+	// Only root may give files away; anyone may set their own group.
+	uid, gid := -1, os.Getgid()
+	if os.Getuid() == 0 {
+		uid, gid = 65534, 65534
+	}
+	staging, rmStaging := stagingDir(t)
+	defer rmStaging()
+
+	for _, opts := range [][]ServerOption{
+		{WithFileOwner(uid, gid)},
+		{WithFileOwner(uid, gid), WithStagingDir(staging)},
+	} {
+		client, _, dir, cleanup := uploadServerPair(t, opts...)
+		upload(t, client, "file", []byte("contents"))
+		fi, err := os.Stat(filepath.Join(dir, "file"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		if (uid != -1 && int(st.Uid) != uid) || int(st.Gid) != gid {
+			t.Errorf("want owner %d:%d, got %d:%d", uid, gid, st.Uid, st.Gid)
+		}
+		cleanup()
+	}
+}
+
+func TestServerFileOwnerFails(t *testing.T) {
+	if os.Getuid() == 0 {
+		t.Skip("root may chown to anyone")
+	}
+	client, _, _, cleanup := uploadServerPair(t, WithFileOwner(0, 0))
+	defer cleanup()
+	f, err := client.Create(testUploadPath + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err == nil {
+		t.Error("close succeeded without permission to chown")
+	}
+}
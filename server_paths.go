@@ -0,0 +1,126 @@
+package sftp
+
+// Canonicalization of client paths
+//
+// Every path sent by a client passes through canonicalPath before the server
+// acts on it, and every file created for an upload is named by uploadFileName
+// and opened by createUploadFile. Keep it that way: checks made elsewhere on
+// raw client paths are easily fooled by "..", repeated slashes and the like.
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// canonicalPath returns the absolute, clean form of a path sent by the
+// client, with ".." resolved lexically: no path escapes "/". Relative paths
+// are taken relative to the upload directory, which clients see as their
+// working directory. It reports false for a path which no file can have,
+// such as one containing NUL.
+func (svr *Server) canonicalPath(p string) (string, bool) {
+	if strings.IndexByte(p, 0) >= 0 {
+		return "", false
+	}
+	if !strings.HasPrefix(p, "/") {
+		p = svr.uploadPath + "/" + p
+	}
+	return path.Clean(p), true
+}
+
+// uploadFileName returns the name of the file in the upload directory which
+// the client path p names, before mapping by the FileNameMapper. If p names
+// no such file, it returns the status code to refuse it with and why.
+//
+// The name is never empty, ".", or "..", and never contains a separator, so
+// that it cannot be used to reach another directory.
+func (svr *Server) uploadFileName(p string) (string, uint32, DenialReason) {
+	reqPath, ok := svr.canonicalPath(p)
+	if !ok {
+		return "", ssh_FX_INVALID_FILENAME, DeniedFileName
+	}
+	if reqPath == svr.uploadPath {
+		// The upload directory itself.
+		return "", ssh_FX_INVALID_FILENAME, DeniedFileName
+	}
+	if svr.outsideUploadPath(reqPath) {
+		return "", ssh_FX_NO_SUCH_PATH, DeniedOutsideUploadPath
+	}
+	name := reqPath[len(svr.uploadPrefix()):]
+	if strings.ContainsRune(name, '/') {
+		return "", ssh_FX_NO_SUCH_PATH, DeniedSubdirectory
+	}
+	if name == "." || name == ".." ||
+		(filepath.Separator != '/' && strings.ContainsRune(name, filepath.Separator)) {
+		return "", ssh_FX_INVALID_FILENAME, DeniedFileName
+	}
+	return name, 0, 0
+}
+
+// uploadPrefix returns the prefix of the canonical paths of the files in
+// the upload directory.
+func (svr *Server) uploadPrefix() string {
+	if svr.uploadPath == "/" {
+		return "/"
+	}
+	return svr.uploadPath + "/"
+}
+
+// outsideUploadPath reports whether the canonical path reqPath is neither
+// the upload directory nor beneath it.
+func (svr *Server) outsideUploadPath(reqPath string) bool {
+	return reqPath != svr.uploadPath && !strings.HasPrefix(reqPath, svr.uploadPrefix())
+}
+
+// uploadFile returns the path on the server of the upload file which the
+// client path p names: its uploadFileName, sanitized under the file name
+// policy and mapped by the FileNameMapper if there is one. If p names no
+// such file, it returns the status code to refuse it with and, unless the
+// FileNameMapper failed, why.
+func (svr *Server) uploadFile(p string) (string, uint32, DenialReason) {
+	name, code, reason := svr.uploadFileName(p)
+	if reason != 0 {
+		return name, code, reason
+	}
+	name, ok := svr.sanitizeFileName(name)
+	if !ok {
+		return "", ssh_FX_INVALID_FILENAME, DeniedFileName
+	}
+	if svr.fileNameMapper == nil {
+		return name, 0, 0
+	}
+	name, ok, err := svr.fileNameMapper(name)
+	if err != nil {
+		return "", ssh_FX_FAILURE, 0
+	} else if !ok {
+		return "", ssh_FX_INVALID_FILENAME, DeniedFileName
+	}
+	return name, 0, 0
+}
+
+// sendRefusal responds to a request refused by uploadFile.
+func (svr *Server) sendRefusal(p id, op fxp, path string, code uint32, reason DenialReason) error {
+	if reason == 0 {
+		return svr.sendErrorCode(p, code)
+	}
+	return svr.sendDenied(p, op, path, code, reason)
+}
+
+// createUploadFile creates or truncates the file for an upload; if
+// exclusive is set, it fails if the file exists. Unless follow is set, it
+// refuses to follow a symbolic link in place of the file, so that a link
+// planted in the upload directory cannot redirect uploads elsewhere.
+func createUploadFile(name string, follow, exclusive bool) (*os.File, error) {
+	flags := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	if exclusive {
+		flags |= os.O_EXCL
+	}
+	if !follow {
+		if fi, err := os.Lstat(name); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: errSymlink}
+		}
+		flags |= oNoFollow
+	}
+	return os.OpenFile(name, flags, 0666)
+}
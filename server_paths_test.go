@@ -0,0 +1,120 @@
+package sftp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCanonicalPath(t *testing.T) {
+	svr := &Server{uploadPath: testUploadPath}
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{"", testUploadPath},
+		{".", testUploadPath},
+		{"f", testUploadPath + "/f"},
+		{"../..", "/"},
+		{"/..", "/"},
+		{"//upload///f/", testUploadPath + "/f"},
+		{"/upload/./a/../f", testUploadPath + "/f"},
+		{"/../../etc/passwd", "/etc/passwd"},
+	} {
+		if got, ok := svr.canonicalPath(tt.in); !ok || got != tt.want {
+			t.Errorf("canonicalPath(%q): want %q, got %q %v", tt.in, tt.want, got, ok)
+		}
+	}
+	if _, ok := svr.canonicalPath("/upload/f\x00.txt"); ok {
+		t.Error("path containing NUL accepted")
+	}
+}
+
+// traversalTests are attempts to name a file outside the upload directory.
+var traversalTests = []struct {
+	path   string
+	reason DenialReason
+}{
+	{"/upload/../etc/passwd", DeniedOutsideUploadPath},
+	{"/upload/a/../../etc/passwd", DeniedOutsideUploadPath},
+	{"../etc/passwd", DeniedOutsideUploadPath},
+	{"/upload/..", DeniedOutsideUploadPath},
+	{"/uploadx/f", DeniedOutsideUploadPath},
+	{"/etc/passwd", DeniedOutsideUploadPath},
+	{"/upload/sub/f", DeniedSubdirectory},
+	{"/upload/a/./b", DeniedSubdirectory},
+	{"/upload/../upload/sub/f", DeniedSubdirectory},
+	{"/upload/", DeniedFileName},
+	{"/upload/.", DeniedFileName},
+	{"/upload/f\x00/../../etc/passwd", DeniedFileName},
+}
+
+func TestUploadFileName(t *testing.T) {
+	svr := &Server{uploadPath: testUploadPath}
+	for _, tt := range traversalTests {
+		if name, _, reason := svr.uploadFileName(tt.path); reason != tt.reason {
+			t.Errorf("%q: want %v, got %q %v", tt.path, tt.reason, name, reason)
+		}
+	}
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{"/upload/f", "f"},
+		{"f", "f"},
+		{"//upload//f", "f"},
+		{"/upload/../upload/f", "f"},
+		// Percent encoding means nothing in SFTP: these are plain names.
+		{"/upload/..%2f..%2fetc%2fpasswd", "..%2f..%2fetc%2fpasswd"},
+		{"/upload/%2e%2e", "%2e%2e"},
+		{"/upload/...", "..."},
+	} {
+		if name, _, reason := svr.uploadFileName(tt.in); reason != 0 || name != tt.want {
+			t.Errorf("%q: want %q, got %q %v", tt.in, tt.want, name, reason)
+		}
+	}
+}
+
+func TestServerTraversal(t *testing.T) {
+	denials := make(chan OperationDenied, len(traversalTests))
+	client, _, _, cleanup := uploadServerPair(t, DenialNotifier(func(e OperationDenied) { denials <- e }))
+	defer cleanup()
+
+	for _, tt := range traversalTests {
+		if f, err := client.Create(tt.path); err == nil {
+			f.Close()
+			t.Errorf("%q: created", tt.path)
+			continue
+		}
+		if e := <-denials; e.Reason != tt.reason {
+			t.Errorf("%q: want %v, got %v", tt.path, tt.reason, e.Reason)
+		}
+	}
+}
+
+func TestServerSymlinkEscape(t *testing.T) {
+	denials := make(chan OperationDenied, 1)
+	client, _, dir, cleanup := uploadServerPair(t, DenialNotifier(func(e OperationDenied) { denials <- e }))
+	defer cleanup()
+
+	outside, err := ioutil.TempFile("", "sftp_outside_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside.WriteString("precious")
+	outside.Close()
+	defer os.Remove(outside.Name())
+	if err := os.Symlink(outside.Name(), filepath.Join(dir, "link")); err != nil {
+		t.Skip(err)
+	}
+
+	if f, err := client.Create(testUploadPath + "/link"); err == nil {
+		f.Close()
+		t.Error("created a file through a symlink")
+	}
+	if e := <-denials; e.Reason != DeniedSymlink {
+		t.Errorf("want symlink denial, got %v", e.Reason)
+	}
+	if b, _ := ioutil.ReadFile(outside.Name()); string(b) != "precious" {
+		t.Errorf("file outside the upload directory changed to %q", b)
+	}
+}
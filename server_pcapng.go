@@ -0,0 +1,134 @@
+package sftp
+
+// Captures in pcapng, for Wireshark
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// PcapngLinkType is the link type of the interface in the pcapng files
+// written by WithPcapngCapture and WritePcapng: LINKTYPE_USER0, as there is
+// none for SFTP. Each packet in them is an SFTP packet as carried in an SSH
+// channel, from its length on. To dissect them in Wireshark, add an entry
+// for User 0 (DLT=147), with payload protocol sftp, to the DLT_USER table
+// in the protocol preferences.
+const PcapngLinkType = 147
+
+// pcapng block types and options, from draft-ietf-opsawg-pcapng.
+const (
+	pcapngSectionHeader  = 0x0a0d0d0a
+	pcapngInterface      = 0x00000001
+	pcapngEnhancedPacket = 0x00000006
+	pcapngByteOrderMagic = 0x1a2b3c4d
+
+	pcapngOptEnd     = 0
+	pcapngOptIfName  = 2
+	pcapngOptTsresol = 9
+	pcapngOptFlags   = 2
+
+	// Directions of the epb_flags option.
+	pcapngInbound  = 1
+	pcapngOutbound = 2
+)
+
+// WithPcapngCapture is like WithCapture, but writes the capture to w as a
+// pcapng file, which Wireshark and tcpdump read, of packets on an interface
+// of PcapngLinkType. Packets the Server received are marked inbound, and
+// those it sent outbound. Of it and WithCapture, the last given applies.
+func WithPcapngCapture(w io.Writer) ServerOption {
+	return func(s *Server) error {
+		s.capture = &capture{w: w, format: pcapngCapture{}}
+		return nil
+	}
+}
+
+// WritePcapng writes packets, as returned by ReadCapture, to w as a pcapng
+// file like those of WithPcapngCapture, so that captures already recorded
+// can be read by Wireshark.
+func WritePcapng(w io.Writer, packets []CapturePacket) error {
+	var f pcapngCapture
+	b := f.header(nil)
+	for _, p := range packets {
+		b = f.record(b, p.Time, p.Received, p.Data)
+		if len(b) >= 64*1024 {
+			if _, err := w.Write(b); err != nil {
+				return err
+			}
+			b = b[:0]
+		}
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// pcapngCapture is the format written by WithPcapngCapture. It is big
+// endian, as the byte order magic of its section says.
+type pcapngCapture struct{}
+
+func (pcapngCapture) header(b []byte) []byte {
+	start := len(b)
+	b = marshalUint32(b, pcapngSectionHeader)
+	b = marshalUint32(b, 0) // length, filled in by pcapngEndBlock
+	b = marshalUint32(b, pcapngByteOrderMagic)
+	b = append(b, 0, 1, 0, 0)     // version 1.0
+	b = marshalUint64(b, 1<<64-1) // section length unknown
+	b = pcapngEndBlock(b, start)
+
+	start = len(b)
+	b = marshalUint32(b, pcapngInterface)
+	b = marshalUint32(b, 0)
+	b = append(b, PcapngLinkType>>8, PcapngLinkType&0xff, 0, 0)
+	b = marshalUint32(b, 0) // no snap length
+	b = pcapngOption(b, pcapngOptIfName, []byte("sftp"))
+	b = pcapngOption(b, pcapngOptTsresol, []byte{9}) // nanoseconds
+	b = pcapngOption(b, pcapngOptEnd, nil)
+	return pcapngEndBlock(b, start)
+}
+
+func (pcapngCapture) record(b []byte, t time.Time, received bool, pkt []byte) []byte {
+	start := len(b)
+	ns := uint64(t.UnixNano())
+	b = marshalUint32(b, pcapngEnhancedPacket)
+	b = marshalUint32(b, 0)
+	b = marshalUint32(b, 0) // interface
+	b = marshalUint32(b, uint32(ns>>32))
+	b = marshalUint32(b, uint32(ns))
+	b = marshalUint32(b, uint32(4+len(pkt))) // captured length
+	b = marshalUint32(b, uint32(4+len(pkt))) // original length
+	b = marshalUint32(b, uint32(len(pkt)))
+	b = append(b, pkt...)
+	b = pcapngPad(b, start)
+	dir := uint32(pcapngOutbound)
+	if received {
+		dir = pcapngInbound
+	}
+	b = pcapngOption(b, pcapngOptFlags, marshalUint32(nil, dir))
+	b = pcapngOption(b, pcapngOptEnd, nil)
+	return pcapngEndBlock(b, start)
+}
+
+// pcapngOption appends an option of a block to b.
+func pcapngOption(b []byte, code uint16, value []byte) []byte {
+	start := len(b)
+	b = append(b, byte(code>>8), byte(code), byte(len(value)>>8), byte(len(value)))
+	return pcapngPad(append(b, value...), start)
+}
+
+// pcapngPad pads b, which has had a block or option appended from start,
+// to a multiple of 4 bytes.
+func pcapngPad(b []byte, start int) []byte {
+	for (len(b)-start)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// pcapngEndBlock completes the block appended to b from start, whose length it
+// writes at either end.
+func pcapngEndBlock(b []byte, start int) []byte {
+	l := uint32(len(b) - start + 4)
+	binary.BigEndian.PutUint32(b[start+4:], l)
+	return marshalUint32(b, l)
+}
@@ -0,0 +1,131 @@
+package sftp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// readPcapng parses a pcapng file as written by WithPcapngCapture,
+// checking its framing, and returns its packets.
+func readPcapng(t *testing.T, b []byte) []CapturePacket {
+	t.Helper()
+	be := binary.BigEndian
+	var packets []CapturePacket
+	for i := 0; len(b) > 0; i++ {
+		if len(b) < 12 {
+			t.Fatalf("block %d: %d bytes left", i, len(b))
+		}
+		typ, l := be.Uint32(b), be.Uint32(b[4:])
+		if l%4 != 0 || l < 12 || int(l) > len(b) || be.Uint32(b[l-4:]) != l {
+			t.Fatalf("block %d: bad length %d", i, l)
+		}
+		body := b[8 : l-4]
+		b = b[l:]
+		switch {
+		case i == 0:
+			if typ != pcapngSectionHeader || be.Uint32(body) != pcapngByteOrderMagic {
+				t.Fatalf("no section header: %x", body)
+			}
+		case i == 1:
+			if typ != pcapngInterface || be.Uint16(body) != PcapngLinkType {
+				t.Fatalf("no interface of link type %d: %x", PcapngLinkType, body)
+			}
+			// if_name, then if_tsresol of nanoseconds.
+			if opts := body[8:]; !bytes.Equal(opts[8:16], []byte{0, 9, 0, 1, 9, 0, 0, 0}) {
+				t.Errorf("interface options %x", opts)
+			}
+		case typ == pcapngEnhancedPacket:
+			ns := uint64(be.Uint32(body[4:]))<<32 | uint64(be.Uint32(body[8:]))
+			n := be.Uint32(body[12:])
+			if be.Uint32(body[16:]) != n {
+				t.Errorf("block %d: captured %d bytes of %d", i, n, be.Uint32(body[16:]))
+			}
+			data := body[20 : 20+n]
+			if int(be.Uint32(data)) != len(data)-4 {
+				t.Errorf("block %d: packet length %d, want %d", i, be.Uint32(data), len(data)-4)
+			}
+			opts := body[20+(n+3)/4*4:]
+			if be.Uint16(opts) != pcapngOptFlags || be.Uint16(opts[2:]) != 4 {
+				t.Fatalf("block %d: options %x", i, opts)
+			}
+			packets = append(packets, CapturePacket{
+				Time:     time.Unix(0, int64(ns)),
+				Received: be.Uint32(opts[4:]) == pcapngInbound,
+				Data:     append([]byte(nil), data[4:]...),
+			})
+		default:
+			t.Fatalf("block %d: unexpected type %#x", i, typ)
+		}
+	}
+	return packets
+}
+
+func TestServerPcapngCapture(t *testing.T) {
+	clock := newFakeClock()
+	var buf bytes.Buffer
+	client, _, _, cleanup := uploadServerPair(t, WithPcapngCapture(&buf), WithClock(clock))
+	upload(t, client, "file", []byte("odd length"))
+	cleanup()
+
+	packets := readPcapng(t, buf.Bytes())
+	want := []struct {
+		received bool
+		typ      fxp
+	}{
+		{true, ssh_FXP_INIT},
+		{false, ssh_FXP_VERSION},
+		{true, ssh_FXP_OPEN},
+		{false, ssh_FXP_HANDLE},
+		{true, ssh_FXP_WRITE},
+		{false, ssh_FXP_STATUS},
+		{true, ssh_FXP_CLOSE},
+		{false, ssh_FXP_STATUS},
+	}
+	if len(packets) != len(want) {
+		t.Fatalf("want %d packets, got %d", len(want), len(packets))
+	}
+	for i, w := range want {
+		p := packets[i]
+		if p.Received != w.received || fxp(p.Data[0]) != w.typ {
+			t.Errorf("packet %d: want %v %s, got %v %s", i, w.received, w.typ, p.Received, fxp(p.Data[0]))
+		}
+		if !p.Time.Equal(clock.Now()) {
+			t.Errorf("packet %d: time %v, want %v", i, p.Time, clock.Now())
+		}
+	}
+	if !bytes.HasSuffix(packets[4].Data, []byte("odd length")) {
+		t.Errorf("write packet %x", packets[4].Data)
+	}
+}
+
+func TestWritePcapng(t *testing.T) {
+	var capture bytes.Buffer
+	client, _, _, cleanup := uploadServerPair(t, WithCapture(&capture))
+	upload(t, client, "file", []byte("contents"))
+	cleanup()
+	captured, err := ReadCapture(&capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WritePcapng(&buf, captured); err != nil {
+		t.Fatal(err)
+	}
+	got := readPcapng(t, buf.Bytes())
+	if len(got) != len(captured) {
+		t.Fatalf("got %d packets, want %d", len(got), len(captured))
+	}
+	for i := range got {
+		if !got[i].Time.Equal(captured[i].Time) {
+			t.Errorf("packet %d: time %v, want %v", i, got[i].Time, captured[i].Time)
+		}
+		got[i].Time = captured[i].Time
+	}
+	if !reflect.DeepEqual(got, captured) {
+		t.Errorf("got %v, want %v", got, captured)
+	}
+}
@@ -0,0 +1,38 @@
+package sftp
+
+// Profiler labels
+
+import (
+	"context"
+	"runtime/pprof"
+)
+
+// profileLabels returns the pprof labels identifying the session, omitting
+// those which are unknown.
+func (svr *Server) profileLabels() []string {
+	var labels []string
+	if svr.session.User != "" {
+		labels = append(labels, "sftp.user", svr.session.User)
+	}
+	if svr.session.RemoteAddr != "" {
+		labels = append(labels, "sftp.remote_addr", svr.session.RemoteAddr)
+	}
+	return labels
+}
+
+// withProfileLabels calls f with the session's pprof labels, which are
+// inherited by any goroutines f starts. f runs on a goroutine of its own so
+// that the labels of the caller are left alone.
+func (svr *Server) withProfileLabels(f func()) {
+	labels := svr.profileLabels()
+	if len(labels) == 0 {
+		f()
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pprof.Do(context.Background(), pprof.Labels(labels...), func(context.Context) { f() })
+	}()
+	<-done
+}
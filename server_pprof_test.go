@@ -0,0 +1,37 @@
+package sftp
+
+import (
+	"bytes"
+	"runtime/pprof"
+	"testing"
+)
+
+func TestServerProfileLabels(t *testing.T) {
+	profile := make(chan []byte, 1)
+	client, _, _, cleanup := uploadServerPair(t,
+		WithSessionInfo(SessionInfo{User: "alice", RemoteAddr: "192.0.2.1:2222"}),
+		WithSessionHooks(func(SessionInfo) {
+			var buf bytes.Buffer
+			pprof.Lookup("goroutine").WriteTo(&buf, 1)
+			profile <- buf.Bytes()
+		}, nil),
+	)
+	defer cleanup()
+	upload(t, client, "f", []byte("data"))
+
+	want := []byte(`"sftp.remote_addr":"192.0.2.1:2222", "sftp.user":"alice"`)
+	if p := <-profile; !bytes.Contains(p, want) {
+		t.Errorf("goroutine profile has no labels %s:\n%s", want, p)
+	}
+}
+
+func TestProfileLabels(t *testing.T) {
+	svr := &Server{session: SessionInfo{User: "bob"}}
+	if got := svr.profileLabels(); len(got) != 2 || got[0] != "sftp.user" || got[1] != "bob" {
+		t.Errorf("got %q", got)
+	}
+	svr.session.User = ""
+	if got := svr.profileLabels(); len(got) != 0 {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,52 @@
+package sftp
+
+// Permission profiles
+
+import (
+	"io"
+	"os"
+)
+
+// profile returns a ServerOption applying each of opts in turn.
+func profile(opts ...ServerOption) ServerOption {
+	return func(s *Server) error {
+		for _, o := range opts {
+			if err := o(s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// UploadOnly configures a Server for clients to upload files to dir, the
+// usual deployment: they may replace files there but not read them, and
+// uploads never follow symbolic links. Options given after it may relax or
+// tighten it further.
+func UploadOnly(dir string) ServerOption {
+	return profile(
+		UploadPath(dir),
+		WithSymlinkPolicy(SymlinkDenyFollow),
+	)
+}
+
+// DropBox is UploadOnly, except that clients cannot see what is in dir, nor
+// replace a file already there, so that one client cannot learn of or
+// clobber the uploads of another.
+func DropBox(dir string) ServerOption {
+	return profile(
+		UploadOnly(dir),
+		NoOverwrite(),
+		ReaddirHook(func() ([]os.FileInfo, error) { return nil, io.EOF }),
+	)
+}
+
+// ReadOnlyBrowse lets clients navigate to dir and, with a ReaddirHook, list
+// it, but change nothing.
+func ReadOnlyBrowse(dir string) ServerOption {
+	return profile(
+		UploadPath(dir),
+		ReadOnly(),
+		WithSymlinkPolicy(SymlinkDenyFollow),
+	)
+}
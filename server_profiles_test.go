@@ -0,0 +1,67 @@
+package sftp
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestServerUploadOnly(t *testing.T) {
+	client, _, _, cleanup := uploadServerPair(t, UploadOnly(testUploadPath))
+	defer cleanup()
+
+	upload(t, client, "f", []byte("one"))
+	upload(t, client, "f", []byte("two"))
+}
+
+func TestServerDropBox(t *testing.T) {
+	denials := make(chan OperationDenied, 1)
+	client, _, dir, cleanup := uploadServerPair(t,
+		DropBox(testUploadPath),
+		DenialNotifier(func(e OperationDenied) { denials <- e }),
+	)
+	defer cleanup()
+
+	upload(t, client, "f", []byte("one"))
+	if _, err := client.Create(testUploadPath + "/f"); err == nil {
+		t.Error("existing file replaced")
+	}
+	if e := <-denials; e.Reason != DeniedFileExists {
+		t.Errorf("want file-exists denial, got %v", e.Reason)
+	}
+	if fis, err := client.ReadDir(testUploadPath); err != nil || len(fis) != 0 {
+		t.Errorf("listing: got %d entries %v", len(fis), err)
+	}
+	if b, _ := ioutil.ReadFile(dir + "/f"); string(b) != "one" {
+		t.Errorf("file changed to %q", b)
+	}
+}
+
+func TestServerReadOnlyBrowse(t *testing.T) {
+	listed := false
+	client, _, _, cleanup := uploadServerPair(t,
+		ReadOnlyBrowse(testUploadPath),
+		OpendirHook(func() { listed = false }),
+		ReaddirHook(func() ([]os.FileInfo, error) {
+			if listed {
+				return nil, io.EOF
+			}
+			listed = true
+			return []os.FileInfo{&fileInfo{name: "report.csv", size: 10, mode: 0644, mtime: time.Now()}}, nil
+		}),
+	)
+	defer cleanup()
+
+	if _, err := client.Stat(testUploadPath); err != nil {
+		t.Error(err)
+	}
+	fis, err := client.ReadDir(testUploadPath)
+	if err != nil || len(fis) != 1 || fis[0].Name() != "report.csv" {
+		t.Errorf("listing: got %v %v", fis, err)
+	}
+	if _, err := client.Create(testUploadPath + "/f"); err == nil {
+		t.Error("upload allowed")
+	}
+}
@@ -0,0 +1,87 @@
+package sftp
+
+// Storage quotas
+
+import (
+	"path/filepath"
+)
+
+// A Quota reports how much more data may be stored on the Server, for
+// instance under the file system quota of the upload directory.
+type Quota interface {
+	// Remaining returns the number of bytes which may still be stored in
+	// dir, or a negative number if there is no limit.
+	Remaining(dir string) (int64, error)
+}
+
+// WithQuota makes the Server consult q when each upload is opened, and again
+// whenever the file grows beyond what was then available, refusing opens
+// and writes which would exceed it with SSH_FX_QUOTA_EXCEEDED, reported as
+// DeniedQuota, rather than failing part way through a write. If q fails,
+// the request proceeds, and the client is told if the file system then
+// refuses the write. Since uploads in progress share the quota, a write may
+// still find it exhausted; EDQUOT and ENOSPC are always reported as
+// SSH_FX_QUOTA_EXCEEDED and SSH_FX_NO_SPACE_ON_FILESYSTEM.
+func WithQuota(q Quota) ServerOption {
+	return func(s *Server) error {
+		s.quota = q
+		return nil
+	}
+}
+
+// ProjectQuota is a Quota reporting what is left under the hard block limit
+// of the project quota of a directory, as set up with xfs_quota or, on
+// ext4, chattr -p and setquota -P. It needs Linux 5.14 or later, and fails
+// on other systems and on alpha, mips and powerpc.
+type ProjectQuota struct{}
+
+// Remaining implements Quota.
+func (ProjectQuota) Remaining(dir string) (int64, error) {
+	return projectQuotaRemaining(dir)
+}
+
+// errQuotaExceeded is the error for a write which would exceed the quota.
+var errQuotaExceeded = &StatusError{Code: ssh_FX_QUOTA_EXCEEDED, msg: "quota exceeded"}
+
+// quotaRemaining returns the bytes which may be stored in dir, or -1 if
+// there is no limit or it is unknown.
+func (svr *Server) quotaRemaining(dir string) int64 {
+	n, err := svr.quota.Remaining(dir)
+	if err != nil {
+		debug("quota of %s: %v", dir, err)
+		return -1
+	}
+	if n < 0 {
+		return -1
+	}
+	return n
+}
+
+// uploadQuota returns the quota remaining for an upload to name, which is
+// created in the staging directory if there is one.
+func (svr *Server) uploadQuota(name string) int64 {
+	dir := svr.stagingDir
+	if dir == "" {
+		dir = filepath.Dir(name)
+	}
+	return svr.quotaRemaining(dir)
+}
+
+// quotaAllows reports whether f may grow to end bytes under the quota. It is
+// only called by the worker handling the requests on f.
+func (svr *Server) quotaAllows(f *openFile, end int64) bool {
+	if f.quotaLimit < 0 || end <= f.quotaLimit {
+		return true
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return true
+	}
+	n := svr.quotaRemaining(filepath.Dir(f.Name()))
+	if n < 0 {
+		f.quotaLimit = -1
+		return true
+	}
+	f.quotaLimit = fi.Size() + n
+	return end <= f.quotaLimit
+}
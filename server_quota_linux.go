@@ -0,0 +1,61 @@
+//go:build linux && (386 || amd64 || arm || arm64 || loong64 || riscv64 || s390x)
+// +build linux
+// +build 386 amd64 arm arm64 loong64 riscv64 s390x
+
+package sftp
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Project quota system calls and constants, from linux/quota.h and
+// linux/fs.h, as numbered on the architectures this file is built for:
+// alpha and mips number quotactl_fd otherwise, and they and powerpc encode
+// ioctls otherwise. Elsewhere ProjectQuota is not supported.
+const (
+	sysQuotactlFd   = 443
+	qGetQuota       = 0x800007
+	prjQuota        = 2
+	qifDqblkSize    = 1024
+	fsIocFSGetXattr = 0x801c581f
+	qcmdGetPrjQuota = qGetQuota<<8 | prjQuota
+)
+
+type fsxattr struct {
+	xflags, extsize, nextents, projid, cowextsize uint32
+	pad                                           [8]byte
+}
+
+type ifDqblk struct {
+	bhardlimit, bsoftlimit, curspace, ihardlimit, isoftlimit, curinodes, btime, itime uint64
+	valid                                                                             uint32
+}
+
+func projectQuotaRemaining(dir string) (int64, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var attr fsxattr
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), fsIocFSGetXattr, uintptr(unsafe.Pointer(&attr))); errno != 0 {
+		return 0, os.NewSyscallError("ioctl", errno)
+	}
+	var q ifDqblk
+	if _, _, errno := syscall.Syscall6(sysQuotactlFd, f.Fd(), qcmdGetPrjQuota, uintptr(attr.projid), uintptr(unsafe.Pointer(&q)), 0, 0); errno != 0 {
+		if errno == syscall.ESRCH {
+			return -1, nil // quotas are not enabled
+		}
+		return 0, os.NewSyscallError("quotactl_fd", errno)
+	}
+	if q.bhardlimit == 0 {
+		return -1, nil
+	}
+	n := int64(q.bhardlimit*qifDqblkSize) - int64(q.curspace)
+	if n < 0 {
+		n = 0
+	}
+	return n, nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux || !(386 || amd64 || arm || arm64 || loong64 || riscv64 || s390x)
+// +build !linux !386,!amd64,!arm,!arm64,!loong64,!riscv64,!s390x
+
+package sftp
+
+import (
+	"github.com/pkg/errors"
+)
+
+func projectQuotaRemaining(dir string) (int64, error) {
+	return 0, errors.New("project quotas are not supported on this system")
+}
@@ -0,0 +1,75 @@
+package sftp
+
+import (
+	"bytes"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// fixedQuota is a Quota of limit bytes less those used.
+type fixedQuota struct {
+	mu    sync.Mutex
+	limit int64
+	used  int64
+	calls int
+}
+
+func (q *fixedQuota) Remaining(dir string) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.calls++
+	return q.limit - q.used, nil
+}
+
+func TestServerQuota(t *testing.T) {
+	q := &fixedQuota{limit: 100000}
+	var denials []OperationDenied
+	client, _, _, cleanup := uploadServerPair(t,
+		WithQuota(q),
+		DenialNotifier(func(e OperationDenied) { denials = append(denials, e) }),
+	)
+	defer cleanup()
+
+	upload(t, client, "small", bytes.Repeat([]byte{1}, 90000))
+	if q.calls != 1 {
+		t.Errorf("quota consulted %d times for an upload within it", q.calls)
+	}
+
+	q.used = 90000
+	f, err := client.Create(testUploadPath + "/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Write(bytes.Repeat([]byte{1}, 20000))
+	if se, ok := err.(*StatusError); !ok || se.Code != ssh_FX_QUOTA_EXCEEDED {
+		t.Errorf("want SSH_FX_QUOTA_EXCEEDED, got %v", err)
+	}
+	f.Close()
+
+	q.used = q.limit
+	_, err = client.Create(testUploadPath + "/more")
+	if se, ok := err.(*StatusError); !ok || se.Code != ssh_FX_QUOTA_EXCEEDED {
+		t.Errorf("open: want SSH_FX_QUOTA_EXCEEDED, got %v", err)
+	}
+	if len(denials) != 2 || denials[0].Reason != DeniedQuota || denials[0].Op != "SSH_FXP_WRITE" ||
+		denials[1].Reason != DeniedQuota || denials[1].Op != "SSH_FXP_OPEN" {
+		t.Errorf("want quota denials of write and open, got %+v", denials)
+	}
+}
+
+func TestTranslateErrnoSpace(t *testing.T) {
+	if code := translateErrno(syscall.EDQUOT); code != ssh_FX_QUOTA_EXCEEDED {
+		t.Errorf("EDQUOT: got %d", code)
+	}
+	if code := translateErrno(syscall.ENOSPC); code != ssh_FX_NO_SPACE_ON_FILESYSTEM {
+		t.Errorf("ENOSPC: got %d", code)
+	}
+}
+
+func TestProjectQuota(t *testing.T) {
+	// Most test machines have no project quotas; only check that asking
+	// does no harm.
+	n, err := ProjectQuota{}.Remaining(".")
+	t.Logf("project quota of .: %d, %v", n, err)
+}
@@ -0,0 +1,60 @@
+package sftp
+
+// Request rate limiting
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// requestRate spaces out the requests of a session, allowing bursts of up to
+// burst requests. It is only used by the receive loop.
+type requestRate struct {
+	interval time.Duration // between requests at the sustained rate
+	burst    int
+	tat      time.Time // when the next request would arrive at that rate
+}
+
+// WithRequestRate limits the client to perSecond requests a second, with
+// bursts of up to burst requests. Beyond that the Server stops reading
+// requests until the rate has dropped, so that a client polling STAT or
+// READDIR in a tight loop cannot take more than its share of the process.
+// SSH_FXP_WRITE requests are not counted, so that uploads are limited only
+// by how fast data can be written.
+func WithRequestRate(perSecond float64, burst int) ServerOption {
+	return func(s *Server) error {
+		if perSecond <= 0 || burst < 1 {
+			return errors.Errorf("invalid request rate %v, burst %d", perSecond, burst)
+		}
+		s.requestRate = &requestRate{
+			interval: time.Duration(float64(time.Second) / perSecond),
+			burst:    burst,
+		}
+		return nil
+	}
+}
+
+// delay returns how long to wait before handling a request received at now,
+// and counts it.
+func (r *requestRate) delay(now time.Time) time.Duration {
+	if r.tat.Before(now) {
+		r.tat = now
+	}
+	var d time.Duration
+	if allowed := r.tat.Add(-time.Duration(r.burst-1) * r.interval); now.Before(allowed) {
+		d = allowed.Sub(now)
+	}
+	r.tat = r.tat.Add(r.interval)
+	return d
+}
+
+// throttle waits until p may be handled under the request rate.
+func (svr *Server) throttle(p rxPacket) {
+	if p.pktType == ssh_FXP_INIT || p.pktType == ssh_FXP_WRITE {
+		return
+	}
+	if d := svr.requestRate.delay(svr.clock.Now()); d > 0 {
+		svr.clock.Sleep(d)
+	}
+}
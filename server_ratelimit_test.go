@@ -0,0 +1,50 @@
+package sftp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestRequestRateDelay(t *testing.T) {
+	r := &requestRate{interval: 100 * time.Millisecond, burst: 3}
+	now := time.Unix(1000, 0)
+	for i, want := range []time.Duration{0, 0, 0, 100 * time.Millisecond, 200 * time.Millisecond} {
+		if got := r.delay(now); got != want {
+			t.Errorf("request %d: want delay %v, got %v", i, want, got)
+		}
+	}
+	// Idle time lets the burst build up again, but no further.
+	now = now.Add(time.Minute)
+	for i := 0; i < 3; i++ {
+		if got := r.delay(now); got != 0 {
+			t.Errorf("after idle, request %d: want no delay, got %v", i, got)
+		}
+	}
+	if got := r.delay(now); got != 100*time.Millisecond {
+		t.Errorf("after burst: want delay 100ms, got %v", got)
+	}
+}
+
+func TestServerRequestRate(t *testing.T) {
+	client, _, _, cleanup := uploadServerPair(t, WithRequestRate(50, 1))
+	defer cleanup()
+
+	// Writes are not counted: an upload of many packets is not slowed down
+	// beyond its open and close.
+	start := time.Now()
+	upload(t, client, "file", bytes.Repeat([]byte{1}, 100*32768))
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("upload took %v", d)
+	}
+
+	start = time.Now()
+	for i := 0; i < 10; i++ {
+		if _, err := client.Stat(testUploadPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("10 requests at 50/s took only %v", d)
+	}
+}
@@ -0,0 +1,56 @@
+package sftp
+
+// Read-only parts of the file system
+
+import (
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// WithReadOnlyPaths refuses requests which would write to the given paths
+// or anything beneath them, as a ReadOnly server refuses all writes, while
+// allowing them elsewhere. This lets a client browse an archive, say, while
+// uploading to UploadPath. The prefixes must be absolute.
+func WithReadOnlyPaths(prefixes ...string) ServerOption {
+	return func(s *Server) error {
+		for _, p := range prefixes {
+			if !path.IsAbs(p) || strings.IndexByte(p, 0) >= 0 {
+				return errors.Errorf("invalid read-only path %q", p)
+			}
+			s.readOnlyPaths = append(s.readOnlyPaths, path.Clean(p))
+		}
+		return nil
+	}
+}
+
+// readOnlyPath reports whether the write request pkt names a path under one
+// of the read-only paths.
+func (svr *Server) readOnlyPath(pkt interface{}) bool {
+	if len(svr.readOnlyPaths) == 0 {
+		return false
+	}
+	paths := []string{svr.authorizationPath(pkt)}
+	switch p := pkt.(type) {
+	case *sshFxpRenamePacket:
+		paths = append(paths, p.Newpath)
+	case *sshFxpSymlinkPacket:
+		paths = append(paths, p.Linkpath)
+	}
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		c, ok := svr.canonicalPath(p)
+		if !ok {
+			continue
+		}
+		for _, prefix := range svr.readOnlyPaths {
+			if c == prefix || prefix == "/" || strings.HasPrefix(c, prefix+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,29 @@
+package sftp
+
+import (
+	"testing"
+)
+
+func TestServerReadOnlyPaths(t *testing.T) {
+	var denials []OperationDenied
+	client, _, _, cleanup := uploadServerPair(t,
+		WithReadOnlyPaths("/archive", testUploadPath+"/frozen"),
+		DenialNotifier(func(e OperationDenied) { denials = append(denials, e) }),
+	)
+	defer cleanup()
+
+	upload(t, client, "file", []byte("contents"))
+	if _, err := client.Create(testUploadPath + "/frozen"); err == nil {
+		t.Error("created file under a read-only path")
+	}
+	if _, err := client.Create(testUploadPath + "/../upload/./frozen"); err == nil {
+		t.Error("created file under a read-only path, by another name")
+	}
+	if len(denials) != 2 || denials[0].Reason != DeniedReadOnly {
+		t.Errorf("want 2 read-only denials, got %+v", denials)
+	}
+
+	if _, err := NewServer(nopReadWriteCloser{}, WithReadOnlyPaths("archive")); err == nil {
+		t.Error("relative read-only path accepted")
+	}
+}
@@ -0,0 +1,93 @@
+package sftp
+
+// Signed receipts for uploads
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// maxReceipts is the number of receipts a session keeps for the client to
+// fetch, the oldest being dropped beyond that.
+const maxReceipts = 1024
+
+// WithReceipts lets clients fetch a Receipt for each upload, once closed,
+// with File.Receipt: an acknowledgement naming the file, its size and its
+// digest, using the first of WithDigests, which must be given too, signed
+// with key by HMAC-SHA256. A session keeps the receipts of its last 1024
+// uploads.
+func WithReceipts(key []byte) ServerOption {
+	return func(s *Server) error {
+		if len(key) == 0 {
+			return errors.New("empty receipt key")
+		}
+		s.receipts = &receiptStore{
+			key:      key,
+			receipts: make(map[string]*Receipt),
+		}
+		return nil
+	}
+}
+
+// receiptStore holds the receipts of a session's uploads, by handle.
+type receiptStore struct {
+	key      []byte
+	mu       sync.Mutex
+	receipts map[string]*Receipt
+	order    []string // handles, oldest first
+}
+
+// add signs and keeps the receipt of the upload closed with handle.
+func (rs *receiptStore) add(handle string, r *Receipt) {
+	r.sign(rs.key)
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if len(rs.order) == maxReceipts {
+		delete(rs.receipts, rs.order[0])
+		rs.order = rs.order[1:]
+	}
+	rs.receipts[handle] = r
+	rs.order = append(rs.order, handle)
+}
+
+func (rs *receiptStore) get(handle string) *Receipt {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.receipts[handle]
+}
+
+// addReceipt records the receipt of the upload f, just closed with handle.
+func (svr *Server) addReceipt(handle string, f *openFile) {
+	if svr.receipts == nil || f.discard || f.sums == nil {
+		return
+	}
+	d := svr.digests[0]
+	svr.receipts.add(handle, &Receipt{
+		Name:      f.remotePath,
+		Size:      atomic.LoadInt64(&f.written),
+		Algorithm: d.Name,
+		Digest:    f.sums[d.Name],
+		Time:      svr.clock.Now(),
+	})
+}
+
+// receiptAllowed reports whether pkt is a receipt request which the server
+// answers.
+func (svr *Server) receiptAllowed(pkt interface{}) bool {
+	p, ok := pkt.(*sshFxpExtendedPacket)
+	if !ok || svr.receipts == nil {
+		return false
+	}
+	_, ok = p.SpecificPacket.(*sshFxpExtendedPacketReceipt)
+	return ok
+}
+
+func (p sshFxpExtendedPacketReceipt) respond(svr *Server) error {
+	r := svr.receipts.get(p.Handle)
+	if r == nil {
+		return svr.sendError(p, &StatusError{Code: ssh_FX_NO_SUCH_FILE, msg: "no receipt for handle"})
+	}
+	return svr.sendPacket(sshFxpReceiptReply{ID: p.ID, Receipt: r})
+}
@@ -0,0 +1,47 @@
+package sftp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"testing"
+)
+
+func TestServerReceipts(t *testing.T) {
+	key := []byte("receipt key")
+	client, _, _, cleanup := uploadServerPair(t, WithDigests(testDigests...), WithReceipts(key))
+	defer cleanup()
+
+	f, err := client.Create(testUploadPath + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("contents")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Receipt(); !os.IsNotExist(err) {
+		t.Errorf("want no receipt before close, got %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := f.Receipt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("contents"))
+	if r.Name != testUploadPath+"/file" || r.Size != 8 || r.Algorithm != "sha256" || !bytes.Equal(r.Digest, sum[:]) {
+		t.Errorf("wrong receipt %+v", r)
+	}
+	if !r.Verify(key) {
+		t.Error("receipt not verified")
+	}
+	r.Size++
+	if r.Verify(key) {
+		t.Error("altered receipt verified")
+	}
+
+	if _, err := NewServer(nopReadWriteCloser{}, WithReceipts(key)); err == nil {
+		t.Error("receipts without digests accepted")
+	}
+}
@@ -0,0 +1,126 @@
+package sftp
+
+// Redaction of server paths from error messages
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+)
+
+// An ErrorMessages policy says what the Server tells clients about the errors
+// it gets from the file system, whose messages name paths on the server.
+type ErrorMessages int
+
+const (
+	// ErrorMessagesAsIs sends the messages unchanged. This is the default.
+	ErrorMessagesAsIs ErrorMessages = iota
+	// ErrorMessagesRelative names the paths as the client sent them, or
+	// by their base name when the request named none. Errors other than
+	// those of the os package are sent as under ErrorMessagesGeneric.
+	ErrorMessagesRelative
+	// ErrorMessagesGeneric sends a message describing the status code,
+	// such as "no such file", and nothing else.
+	ErrorMessagesGeneric
+)
+
+// WithErrorMessages sets what the Server tells clients about the errors
+// it gets, so as not to disclose the layout of its file system. Unless m is
+// ErrorMessagesAsIs, a RequestFailed event carries each error in full.
+func WithErrorMessages(m ErrorMessages) ServerOption {
+	return func(s *Server) error {
+		s.errorMessages = m
+		return nil
+	}
+}
+
+// RequestFailed is sent when a request fails with an error whose message
+// the client is not sent in full, because of WithErrorMessages.
+type RequestFailed struct {
+	Session SessionInfo
+	Path    string // path or handle named by the request, if any
+	Code    uint32 // SSH_FX_* status code sent to the client
+	Message string // message sent to the client
+	Err     error
+}
+
+func (RequestFailed) event() {}
+
+var genericMessages = map[uint32]string{
+	ssh_FX_NO_SUCH_FILE:           "no such file",
+	ssh_FX_PERMISSION_DENIED:      "permission denied",
+	ssh_FX_NO_SUCH_PATH:           "no such path",
+	ssh_FX_FILE_ALREADY_EXISTS:    "file already exists",
+	ssh_FX_NO_SPACE_ON_FILESYSTEM: "no space on file system",
+	ssh_FX_QUOTA_EXCEEDED:         "quota exceeded",
+	ssh_FX_DIR_NOT_EMPTY:          "directory not empty",
+	ssh_FX_NOT_A_DIRECTORY:        "not a directory",
+	ssh_FX_INVALID_FILENAME:       "invalid file name",
+	ssh_FX_FILE_IS_A_DIRECTORY:    "file is a directory",
+}
+
+// redactError rewrites the message of pkt, the status sent for err in reply
+// to p, according to the ErrorMessages policy.
+func (svr *Server) redactError(p id, err error, pkt *sshFxpStatusPacket) {
+	if svr.errorMessages == ErrorMessagesAsIs || pkt.Code == ssh_FX_OK || pkt.Code == ssh_FX_EOF {
+		return
+	}
+	if _, ok := err.(*StatusError); ok {
+		return
+	}
+	reqPath := svr.requestPath(requestPacket(p))
+	msg := genericMessages[pkt.Code]
+	if msg == "" {
+		msg = "failure"
+	}
+	if svr.errorMessages == ErrorMessagesRelative {
+		switch err := err.(type) {
+		case syscall.Errno:
+			msg = err.Error()
+		case *os.PathError:
+			msg = err.Op + " " + clientPath(reqPath, err.Path) + ": " + err.Err.Error()
+		case *os.LinkError:
+			oldPath, newPath := reqPath, ""
+			if r, ok := p.(*sshFxpRenamePacket); ok {
+				newPath = r.Newpath
+			}
+			msg = err.Op + " " + clientPath(oldPath, err.Old) + " " + clientPath(newPath, err.New) + ": " + err.Err.Error()
+		}
+	}
+	pkt.msg = msg
+	svr.emit(RequestFailed{
+		Session: svr.session,
+		Path:    reqPath,
+		Code:    pkt.Code,
+		Message: msg,
+		Err:     err,
+	})
+}
+
+// clientPath returns reqPath, the path named by the client, or the base
+// name of name, its path on the server, if the client named none.
+func clientPath(reqPath, name string) string {
+	if reqPath != "" {
+		return reqPath
+	}
+	return path.Base(filepath.ToSlash(name))
+}
+
+// requestPacket returns p as it is passed to requestPath, which takes the
+// packets handled by respond methods by pointer.
+func requestPacket(p id) interface{} {
+	switch p := p.(type) {
+	case sshFxpOpenPacket:
+		return &p
+	case sshFxpReaddirPacket:
+		return &p
+	case sshFxpSetstatPacket:
+		return &p
+	case sshFxpFsetstatPacket:
+		return &p
+	case sshFxpExtendedPacketCheckFileHandle:
+		return &sshFxpExtendedPacket{SpecificPacket: &p}
+	}
+	return p
+}
@@ -0,0 +1,55 @@
+package sftp
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestServerErrorMessages(t *testing.T) {
+	for _, tt := range []struct {
+		messages ErrorMessages
+		want     string
+	}{
+		{ErrorMessagesRelative, "open " + testUploadPath + "/file: "},
+		{ErrorMessagesGeneric, `"no such file"`},
+	} {
+		var ch <-chan Event
+		missing := "/nonexistent/sftp_redact_test"
+		client, _, _, cleanup := uploadServerPair(t,
+			WithErrorMessages(tt.messages),
+			FileNameMapper(func(name string) (string, bool, error) {
+				return missing + "/" + name, true, nil
+			}),
+			subscribeEvents(&ch),
+		)
+
+		id := client.nextID()
+		typ, data, err := client.sendPacket(sshFxpOpenPacket{
+			ID:     id,
+			Path:   testUploadPath + "/file",
+			Pflags: flags(os.O_WRONLY | os.O_CREATE),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != ssh_FXP_STATUS {
+			t.Fatalf("want SSH_FXP_STATUS, got %v", fxp(typ))
+		}
+		err = unmarshalStatus(id, data)
+		if strings.Contains(err.Error(), missing) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%d: want error containing %q, got %v", tt.messages, tt.want, err)
+		}
+		client.Close()
+		var failed []RequestFailed
+		for e := range ch {
+			if e, ok := e.(RequestFailed); ok {
+				failed = append(failed, e)
+			}
+		}
+		if len(failed) != 1 || !os.IsNotExist(failed[0].Err) || failed[0].Path != testUploadPath+"/file" {
+			t.Errorf("%d: want RequestFailed with the full error, got %+v", tt.messages, failed)
+		}
+		cleanup()
+	}
+}
@@ -0,0 +1,44 @@
+package sftp
+
+// Sampling of uploaded content
+
+// An UploadSample holds the beginning of an uploaded file.
+type UploadSample struct {
+	Session    SessionInfo
+	RemotePath string // path requested by the client
+	Path       string // path of the file on the server
+	Size       int64  // bytes written to the file
+	Data       []byte // up to the configured number of leading bytes
+}
+
+// WithPayloadSampling captures the first n bytes of every uploaded file.
+// When the file is closed, the sample is included in the FileClosed event
+// and passed to f, if not nil.
+func WithPayloadSampling(n int, f func(UploadSample)) ServerOption {
+	return func(s *Server) error {
+		s.sampleSize = n
+		s.sampleNotifier = f
+		return nil
+	}
+}
+
+// payloadSample accumulates the leading bytes of a file from writes at
+// arbitrary offsets.
+type payloadSample struct {
+	buf []byte
+	n   int // length of the sample; bytes below n not written are zero
+}
+
+func (ps *payloadSample) write(b []byte, offset int64) {
+	if offset < 0 || offset >= int64(len(ps.buf)) {
+		return
+	}
+	end := int(offset) + copy(ps.buf[offset:], b)
+	if end > ps.n {
+		ps.n = end
+	}
+}
+
+func (ps *payloadSample) bytes() []byte {
+	return ps.buf[:ps.n]
+}
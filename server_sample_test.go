@@ -0,0 +1,49 @@
+package sftp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPayloadSample(t *testing.T) {
+	ps := payloadSample{buf: make([]byte, 8)}
+	ps.write([]byte("world"), 6)
+	if got := ps.bytes(); !bytes.Equal(got, []byte("\x00\x00\x00\x00\x00\x00wo")) {
+		t.Errorf("got %q", got)
+	}
+	ps.write([]byte("hello "), 0)
+	ps.write([]byte("ignored"), 8)
+	if got := ps.bytes(); !bytes.Equal(got, []byte("hello wo")) {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestServerPayloadSampling(t *testing.T) {
+	samples := make(chan UploadSample, 2)
+	client, _, dir, cleanup := uploadServerPair(t,
+		WithPayloadSampling(4, func(s UploadSample) { samples <- s }),
+	)
+	defer cleanup()
+
+	upload(t, client, "long", []byte("%PDF-1.4 ..."))
+	upload(t, client, "short", []byte("ab"))
+
+	for _, want := range []struct {
+		path string
+		data string
+		size int64
+	}{
+		{dir + "/long", "%PDF", 12},
+		{dir + "/short", "ab", 2},
+	} {
+		select {
+		case s := <-samples:
+			if s.Path != want.path || string(s.Data) != want.data || s.Size != want.size {
+				t.Errorf("want %s %q (%d bytes), got %s %q (%d bytes)", want.path, want.data, want.size, s.Path, s.Data, s.Size)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("sample not reported")
+		}
+	}
+}
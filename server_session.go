@@ -0,0 +1,129 @@
+package sftp
+
+// Session identity and lifecycle hooks
+
+import (
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SessionInfo describes the client side of an SFTP session.
+type SessionInfo struct {
+	// User is the name the client authenticated as, if known.
+	User string
+	// RemoteAddr is the network address of the client, if known.
+	RemoteAddr string
+	// ClientVersion is the identification string of the client software,
+	// such as "SSH-2.0-OpenSSH_7.4", if known.
+	ClientVersion string
+	// SFTPVersion and SFTPExtensions are the protocol version and the
+	// extensions, by name, sent by the client in its SSH_FXP_INIT, which
+	// tell client implementations apart. They are set once the INIT is
+	// received, so are zero in SessionStarted and the start hook.
+	SFTPVersion    uint32
+	SFTPExtensions map[string]string
+	// Started is the time Serve was called.
+	Started time.Time
+}
+
+// SessionSummary describes a completed SFTP session.
+type SessionSummary struct {
+	SessionInfo
+	// Ended is the time Serve returned.
+	Ended time.Time
+	// Stats holds the activity of the session.
+	Stats ServerStats
+	// Errors is the number of status responses reporting a failure, that
+	// is, with a code other than SSH_FX_OK or SSH_FX_EOF.
+	Errors uint64
+	// Err is the reason the session ended. It is io.EOF if the client
+	// closed the connection.
+	Err error
+}
+
+// WithSessionInfo supplies the identity of the client, typically taken from
+// the ssh.ConnMetadata of the connection. The Started field is ignored and
+// set by Serve.
+func WithSessionInfo(info SessionInfo) ServerOption {
+	return func(s *Server) error {
+		s.session = info
+		return nil
+	}
+}
+
+// SessionInfoFromConn returns the identity of the client of an SSH
+// connection, including its identification string, for WithSessionInfo.
+func SessionInfoFromConn(c ssh.ConnMetadata) SessionInfo {
+	return SessionInfo{
+		User:          c.User(),
+		RemoteAddr:    c.RemoteAddr().String(),
+		ClientVersion: string(c.ClientVersion()),
+	}
+}
+
+// WithSessionHooks registers functions called when Serve starts and just
+// before it returns. Either may be nil.
+func WithSessionHooks(onStart func(SessionInfo), onEnd func(SessionSummary)) ServerOption {
+	return func(s *Server) error {
+		s.sessionStartHook = onStart
+		s.sessionEndHook = onEnd
+		return nil
+	}
+}
+
+// Session returns the identity of the client, as given to WithSessionInfo
+// and completed by its SSH_FXP_INIT.
+// Hooks which need to know which client a request comes from may call it.
+func (svr *Server) Session() SessionInfo {
+	svr.sessionLock.Lock()
+	defer svr.sessionLock.Unlock()
+	return svr.session
+}
+
+func (svr *Server) startSession() {
+	svr.sessionLock.Lock()
+	svr.session.Started = svr.clock.Now()
+	svr.sessionLock.Unlock()
+	if svr.sessionStartHook != nil {
+		svr.sessionStartHook(svr.session)
+	}
+	svr.emit(SessionStarted{Session: svr.session})
+}
+
+// recordInit adds what the client says of itself in the SSH_FXP_INIT p to
+// the session. It is called by the receive loop before any request is
+// handed to a worker, so that workers see the session as it then is.
+func (svr *Server) recordInit(p rxPacket) {
+	var init sshFxInitPacket
+	if err := init.UnmarshalBinary(p.pktBytes); err != nil {
+		return
+	}
+	svr.sessionLock.Lock()
+	defer svr.sessionLock.Unlock()
+	svr.session.SFTPVersion = init.Version
+	if len(init.Extensions) > 0 {
+		svr.session.SFTPExtensions = make(map[string]string)
+		for _, e := range init.Extensions {
+			svr.session.SFTPExtensions[e.Name] = e.Data
+		}
+	}
+}
+
+func (svr *Server) endSession(err error) {
+	summary := SessionSummary{
+		SessionInfo: svr.session,
+		Ended:       svr.clock.Now(),
+		Stats:       svr.Stats(),
+		Err:         err,
+	}
+	for code, n := range summary.Stats.Responses {
+		if code != ssh_FX_OK && code != ssh_FX_EOF {
+			summary.Errors += n
+		}
+	}
+	if svr.sessionEndHook != nil {
+		svr.sessionEndHook(summary)
+	}
+	svr.closeEvents(SessionEnded{Summary: summary})
+}
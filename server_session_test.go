@@ -0,0 +1,100 @@
+package sftp
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestServerSessionHooks(t *testing.T) {
+	info := SessionInfo{User: "partner", RemoteAddr: "192.0.2.1:2022"}
+	started := make(chan SessionInfo, 1)
+	ended := make(chan SessionSummary, 1)
+	client, _, _, cleanup := uploadServerPair(t,
+		WithSessionInfo(info),
+		WithSessionHooks(
+			func(si SessionInfo) { started <- si },
+			func(ss SessionSummary) { ended <- ss },
+		),
+	)
+	defer cleanup()
+
+	select {
+	case si := <-started:
+		if si.User != info.User || si.RemoteAddr != info.RemoteAddr {
+			t.Errorf("onStart: want %+v, got %+v", info, si)
+		}
+		if si.Started.IsZero() {
+			t.Error("onStart: Started not set")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("onStart not called")
+	}
+
+	upload(t, client, "file", []byte("contents"))
+	if err := client.Mkdir(testUploadPath + "/dir"); err == nil {
+		t.Error("Mkdir didn't fail")
+	}
+	client.Close()
+
+	select {
+	case ss := <-ended:
+		if ss.User != info.User {
+			t.Errorf("onEnd: want user %q, got %q", info.User, ss.User)
+		}
+		if ss.Ended.Before(ss.Started) {
+			t.Errorf("onEnd: ended %v before start %v", ss.Ended, ss.Started)
+		}
+		if ss.Stats.Uploads != 1 || ss.Stats.UploadBytes != 8 {
+			t.Errorf("onEnd: want 1 upload of 8 bytes, got %d of %d", ss.Stats.Uploads, ss.Stats.UploadBytes)
+		}
+		if ss.Errors != 1 {
+			t.Errorf("onEnd: want 1 error, got %d", ss.Errors)
+		}
+		if ss.Err != io.EOF {
+			t.Errorf("onEnd: want io.EOF, got %v", ss.Err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("onEnd not called")
+	}
+}
+
+func TestServerSessionInit(t *testing.T) {
+	var in, out bytes.Buffer
+	in.Write(sp(sshFxInitPacket{
+		Version:    sftpProtocolVersion,
+		Extensions: []extensionPair{{"posix-rename@openssh.com", "1"}},
+	}))
+	svr, err := NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{&in, nopWriteCloser{&out}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr.Serve()
+	si := svr.Session()
+	if si.SFTPVersion != sftpProtocolVersion || si.SFTPExtensions["posix-rename@openssh.com"] != "1" {
+		t.Errorf("INIT not recorded: %+v", si)
+	}
+}
+
+// connMetadata is an ssh.ConnMetadata for a made up client.
+type connMetadata struct{ ssh.ConnMetadata }
+
+func (connMetadata) User() string          { return "partner" }
+func (connMetadata) ClientVersion() []byte { return []byte("SSH-2.0-Test") }
+func (connMetadata) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 2022}
+}
+
+func TestSessionInfoFromConn(t *testing.T) {
+	si := SessionInfoFromConn(connMetadata{})
+	if si.User != "partner" || si.RemoteAddr != "192.0.2.1:2022" || si.ClientVersion != "SSH-2.0-Test" {
+		t.Errorf("wrong session info %+v", si)
+	}
+}
@@ -0,0 +1,96 @@
+package sftp
+
+// Socket tuning
+
+import (
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SocketOptions tune the socket underlying a Server whose connection is a
+// net.Conn, which matters for throughput on high latency links. Zero fields
+// leave the socket's setting unchanged.
+type SocketOptions struct {
+	// Nagle enables Nagle's algorithm, that is, clears TCP_NODELAY. By
+	// default Go sets TCP_NODELAY on TCP connections.
+	Nagle bool
+	// ReadBuffer and WriteBuffer set the sizes of the kernel's receive and
+	// send buffers, SO_RCVBUF and SO_SNDBUF.
+	ReadBuffer  int
+	WriteBuffer int
+	// KeepAlive enables TCP keepalives with the given period. A negative
+	// value disables them.
+	KeepAlive time.Duration
+}
+
+// WithSocketOptions applies opts to the connection given to NewServer, which
+// must be a net.Conn supporting them, such as a *net.TCPConn. A Server
+// behind SSH is given a channel instead; use Apply on the SSH connection.
+func WithSocketOptions(opts SocketOptions) ServerOption {
+	return func(s *Server) error {
+		c, ok := s.conn.WriteCloser.(net.Conn)
+		if !ok {
+			return errors.Errorf("socket options need a net.Conn, not %T", s.conn.WriteCloser)
+		}
+		return setSocketOptions(c, opts)
+	}
+}
+
+// Apply applies opts to c, for connections which reach the Server through
+// another protocol, such as the net.Conn of an SSH connection.
+func (opts SocketOptions) Apply(c net.Conn) error {
+	return setSocketOptions(c, opts)
+}
+
+func setSocketOptions(c net.Conn, opts SocketOptions) error {
+	unsupported := func(what string) error {
+		return errors.Errorf("%T does not support setting %s", c, what)
+	}
+	if opts.Nagle {
+		sc, ok := c.(interface{ SetNoDelay(bool) error })
+		if !ok {
+			return unsupported("TCP_NODELAY")
+		}
+		if err := sc.SetNoDelay(false); err != nil {
+			return errors.Wrap(err, "failed to clear TCP_NODELAY")
+		}
+	}
+	if opts.ReadBuffer > 0 {
+		sc, ok := c.(interface{ SetReadBuffer(int) error })
+		if !ok {
+			return unsupported("the read buffer size")
+		}
+		if err := sc.SetReadBuffer(opts.ReadBuffer); err != nil {
+			return errors.Wrap(err, "failed to set read buffer size")
+		}
+	}
+	if opts.WriteBuffer > 0 {
+		sc, ok := c.(interface{ SetWriteBuffer(int) error })
+		if !ok {
+			return unsupported("the write buffer size")
+		}
+		if err := sc.SetWriteBuffer(opts.WriteBuffer); err != nil {
+			return errors.Wrap(err, "failed to set write buffer size")
+		}
+	}
+	if opts.KeepAlive != 0 {
+		sc, ok := c.(interface {
+			SetKeepAlive(bool) error
+			SetKeepAlivePeriod(time.Duration) error
+		})
+		if !ok {
+			return unsupported("keepalives")
+		}
+		if err := sc.SetKeepAlive(opts.KeepAlive > 0); err != nil {
+			return errors.Wrap(err, "failed to set keepalive")
+		}
+		if opts.KeepAlive > 0 {
+			if err := sc.SetKeepAlivePeriod(opts.KeepAlive); err != nil {
+				return errors.Wrap(err, "failed to set keepalive period")
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package sftp
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWithSocketOptions(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	opts := SocketOptions{Nagle: true, ReadBuffer: 1 << 20, WriteBuffer: 1 << 20, KeepAlive: 30 * time.Second}
+	if _, err := NewServer(c, WithSocketOptions(opts)); err != nil {
+		t.Error(err)
+	}
+	if _, err := NewServer(c, WithSocketOptions(SocketOptions{KeepAlive: -1})); err != nil {
+		t.Error(err)
+	}
+	if err := opts.Apply(c); err != nil {
+		t.Errorf("apply: %v", err)
+	}
+
+	// A pipe supports none of them.
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+	if _, err := NewServer(p1, WithSocketOptions(SocketOptions{ReadBuffer: 1 << 20})); err == nil {
+		t.Error("no error setting the buffer size of a pipe")
+	}
+	if _, err := NewServer(p1, WithSocketOptions(SocketOptions{})); err != nil {
+		t.Errorf("no options: %v", err)
+	}
+}
+
+func TestWithSocketOptionsNotConn(t *testing.T) {
+	if _, err := NewServer(nopReadWriteCloser{}, WithSocketOptions(SocketOptions{})); err == nil {
+		t.Error("no error for a connection which is not a net.Conn")
+	}
+}
+
+type nopReadWriteCloser struct{}
+
+func (nopReadWriteCloser) Read([]byte) (int, error)    { return 0, nil }
+func (nopReadWriteCloser) Write(b []byte) (int, error) { return len(b), nil }
+func (nopReadWriteCloser) Close() error                { return nil }
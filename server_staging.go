@@ -0,0 +1,50 @@
+package sftp
+
+// Atomic uploads through a staging directory
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// WithStagingDir writes each upload to a new file in dir, moving it into
+// place only once the client has closed it, so that other processes never
+// see a partial upload under its final name. Staged files are created with
+// mode 0600 and a name with an unpredictable suffix, so that other users
+// cannot guess, read or plant them. dir must be on the same file system as
+// the files uploaded. Uploads the client leaves open are removed when Serve
+// returns.
+//
+// Events, notifiers and Status name the final path of each upload, not that
+// of its staged file.
+func WithStagingDir(dir string) ServerOption {
+	return func(s *Server) error {
+		s.stagingDir = dir
+		return nil
+	}
+}
+
+// path returns the path of the file on the server, once uploaded, or ""
+// if its data is discarded.
+func (f *openFile) path() string {
+	if f.discard {
+		return ""
+	}
+	if f.finalPath != "" {
+		return f.finalPath
+	}
+	return f.Name()
+}
+
+// createStagedFile creates the staged file of an upload to name.
+func (svr *Server) createStagedFile(name string) (*os.File, error) {
+	return ioutil.TempFile(svr.stagingDir, "."+filepath.Base(name)+".*")
+}
+
+// discardStaged removes the staged file f, if it is one.
+func discardStaged(f *openFile) {
+	if f.finalPath != "" {
+		os.Remove(f.Name())
+	}
+}
@@ -0,0 +1,128 @@
+package sftp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func stagingDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "sftp_staging_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// stagedFiles returns the files in dir.
+func stagedFiles(t *testing.T, dir string) []os.FileInfo {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fis
+}
+
+func TestServerStagingDir(t *testing.T) {
+	staging, rmStaging := stagingDir(t)
+	defer rmStaging()
+	var closed []string
+	client, _, dir, cleanup := uploadServerPair(t,
+		WithStagingDir(staging),
+		UploadNotifier(func(name string) { closed = append(closed, name) }),
+	)
+	defer cleanup()
+
+	f, err := client.Create(testUploadPath + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("contents")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "file")); !os.IsNotExist(err) {
+		t.Errorf("partial upload visible under its final name: %v", err)
+	}
+	fis := stagedFiles(t, staging)
+	if len(fis) != 1 {
+		t.Fatalf("want 1 staged file, got %d", len(fis))
+	}
+	if mode := fis[0].Mode().Perm(); mode != 0600 {
+		t.Errorf("staged file mode %v", mode)
+	}
+	if fis[0].Name() == ".file" {
+		t.Error("staged file name is predictable")
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := ioutil.ReadFile(filepath.Join(dir, "file")); err != nil || string(got) != "contents" {
+		t.Errorf("want contents, got %q: %v", got, err)
+	}
+	if fis := stagedFiles(t, staging); len(fis) != 0 {
+		t.Errorf("%d staged files left", len(fis))
+	}
+	if len(closed) != 1 || closed[0] != filepath.Join(dir, "file") {
+		t.Errorf("want notification of final path, got %q", closed)
+	}
+}
+
+func TestServerStagingDirNoOverwrite(t *testing.T) {
+	staging, rmStaging := stagingDir(t)
+	defer rmStaging()
+	client, _, dir, cleanup := uploadServerPair(t, WithStagingDir(staging), NoOverwrite())
+	defer cleanup()
+
+	upload(t, client, "file", []byte("first"))
+	if _, err := client.Create(testUploadPath + "/file"); err == nil {
+		t.Error("overwrote existing file")
+	}
+
+	// A file appearing during the upload is not replaced.
+	f, err := client.Create(testUploadPath + "/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "other"), []byte("theirs"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err == nil {
+		t.Error("close replaced file created during upload")
+	}
+	if got, _ := ioutil.ReadFile(filepath.Join(dir, "other")); string(got) != "theirs" {
+		t.Errorf("want theirs, got %q", got)
+	}
+	if fis := stagedFiles(t, staging); len(fis) != 0 {
+		t.Errorf("%d staged files left", len(fis))
+	}
+}
+
+func TestServerStagingDirLeftOpen(t *testing.T) {
+	staging, rmStaging := stagingDir(t)
+	defer rmStaging()
+	ended := make(chan struct{})
+	client, _, dir, cleanup := uploadServerPair(t,
+		WithStagingDir(staging),
+		WithSessionHooks(nil, func(SessionSummary) { close(ended) }),
+	)
+	defer cleanup()
+
+	f, err := client.Create(testUploadPath + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("partial")); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	<-ended
+
+	if fis := stagedFiles(t, staging); len(fis) != 0 {
+		t.Errorf("%d staged files left", len(fis))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "file")); !os.IsNotExist(err) {
+		t.Errorf("abandoned upload moved into place: %v", err)
+	}
+}
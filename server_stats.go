@@ -0,0 +1,228 @@
+package sftp
+
+// Server statistics
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	// uploadSizeBounds are the upper bounds, in bytes, of the upload size
+	// histogram buckets: 1KiB to 16GiB in powers of 4.
+	uploadSizeBounds = []float64{
+		1 << 10, 1 << 12, 1 << 14, 1 << 16, 1 << 18, 1 << 20, 1 << 22,
+		1 << 24, 1 << 26, 1 << 28, 1 << 30, 1 << 32, 1 << 34,
+	}
+
+	// uploadRateBounds are the upper bounds, in bytes per second, of the
+	// upload rate histogram buckets: 1KiB/s to 1GiB/s in powers of 4.
+	uploadRateBounds = []float64{
+		1 << 10, 1 << 12, 1 << 14, 1 << 16, 1 << 18, 1 << 20, 1 << 22,
+		1 << 24, 1 << 26, 1 << 28, 1 << 30,
+	}
+
+	// uploadDurationBounds are the upper bounds, in seconds, of the upload
+	// duration histogram buckets.
+	uploadDurationBounds = []float64{
+		0.01, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600,
+	}
+)
+
+// A Histogram is a distribution of observed values over a fixed set of
+// buckets.
+type Histogram struct {
+	// Bounds holds the inclusive upper bound of each bucket, in increasing
+	// order. Values above the last bound are counted in an extra, unbounded
+	// bucket.
+	Bounds []float64
+	// Counts holds the number of observations in each bucket. It has
+	// len(Bounds)+1 entries.
+	Counts []uint64
+	// Count is the total number of observations.
+	Count uint64
+	// Sum is the sum of all observed values.
+	Sum float64
+}
+
+func newHistogram(bounds []float64) Histogram {
+	return Histogram{
+		Bounds: bounds,
+		Counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+func (h *Histogram) observe(v float64) {
+	i := 0
+	for i < len(h.Bounds) && v > h.Bounds[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Count++
+	h.Sum += v
+}
+
+func (h Histogram) clone() Histogram {
+	h.Counts = append([]uint64(nil), h.Counts...)
+	return h
+}
+
+// ServerStats is a snapshot of the activity of a Server.
+type ServerStats struct {
+	// Uploads is the number of uploaded files which have been closed.
+	Uploads uint64
+	// UploadBytes is the total number of bytes written to uploaded files.
+	UploadBytes uint64
+	// UploadSizes is the distribution of completed upload sizes, in bytes.
+	UploadSizes Histogram
+	// UploadRates is the distribution of completed upload byte rates, in
+	// bytes per second.
+	UploadRates Histogram
+	// UploadDurations is the distribution of the time between opening and
+	// closing an uploaded file, in seconds.
+	UploadDurations Histogram
+	// LeakedHandles is the number of uploaded files which were still open
+	// when their session ended.
+	LeakedHandles uint64
+	// Responses is the number of status responses sent, keyed by SSH_FX_*
+	// status code. Successful operations are counted under SSH_FX_OK (0).
+	Responses map[uint32]uint64
+}
+
+// serverStats accumulates ServerStats. It is safe for concurrent use.
+type serverStats struct {
+	mu sync.Mutex
+	s  ServerStats
+}
+
+func newServerStats() *serverStats {
+	return &serverStats{
+		s: ServerStats{
+			UploadSizes:     newHistogram(uploadSizeBounds),
+			UploadRates:     newHistogram(uploadRateBounds),
+			UploadDurations: newHistogram(uploadDurationBounds),
+			Responses:       make(map[uint32]uint64),
+		},
+	}
+}
+
+func (st *serverStats) recordUpload(size int64, d time.Duration) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.s.Uploads++
+	st.s.UploadBytes += uint64(size)
+	st.s.UploadSizes.observe(float64(size))
+	st.s.UploadDurations.observe(d.Seconds())
+	if d > 0 {
+		st.s.UploadRates.observe(float64(size) / d.Seconds())
+	}
+}
+
+func (st *serverStats) recordStatus(code uint32) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.s.Responses[code]++
+}
+
+func (st *serverStats) recordLeak() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.s.LeakedHandles++
+}
+
+func (st *serverStats) snapshot() ServerStats {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s := st.s
+	s.UploadSizes = s.UploadSizes.clone()
+	s.UploadRates = s.UploadRates.clone()
+	s.UploadDurations = s.UploadDurations.clone()
+	s.Responses = make(map[uint32]uint64, len(st.s.Responses))
+	for code, n := range st.s.Responses {
+		s.Responses[code] = n
+	}
+	return s
+}
+
+// A StatsCollector aggregates the statistics of any number of Servers, such
+// as all sessions handled by a process, both in total and for each user. It
+// is safe for concurrent use.
+type StatsCollector struct {
+	st *serverStats
+
+	mu    sync.Mutex
+	users map[string]*serverStats
+}
+
+// NewStatsCollector returns an empty StatsCollector.
+func NewStatsCollector() *StatsCollector {
+	return &StatsCollector{
+		st:    newServerStats(),
+		users: make(map[string]*serverStats),
+	}
+}
+
+// Stats returns a snapshot of the statistics collected so far.
+func (c *StatsCollector) Stats() ServerStats {
+	return c.st.snapshot()
+}
+
+// StatsByUser returns a snapshot of the statistics collected so far, keyed
+// by SessionInfo.User. Sessions without a known user are keyed by "".
+func (c *StatsCollector) StatsByUser() map[string]ServerStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := make(map[string]ServerStats, len(c.users))
+	for user, st := range c.users {
+		m[user] = st.snapshot()
+	}
+	return m
+}
+
+func (c *StatsCollector) user(name string) *serverStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st, ok := c.users[name]
+	if !ok {
+		st = newServerStats()
+		c.users[name] = st
+	}
+	return st
+}
+
+// WithStatsCollector adds the activity of the Server to c, in addition to the
+// per-session statistics returned by Server.Stats.
+func WithStatsCollector(c *StatsCollector) ServerOption {
+	return func(s *Server) error {
+		s.sharedStats = c
+		return nil
+	}
+}
+
+// Stats returns a snapshot of the activity of the Server so far. It may be
+// called concurrently with Serve.
+func (svr *Server) Stats() ServerStats {
+	return svr.stats.snapshot()
+}
+
+// eachStats calls f with the statistics of the session and, if collected,
+// the process-wide and per-user statistics.
+func (svr *Server) eachStats(f func(st *serverStats)) {
+	f(svr.stats)
+	if svr.sharedStats != nil {
+		f(svr.sharedStats.st)
+		f(svr.sharedStats.user(svr.session.User))
+	}
+}
+
+func (svr *Server) recordUpload(size int64, d time.Duration) {
+	svr.eachStats(func(st *serverStats) { st.recordUpload(size, d) })
+}
+
+func (svr *Server) recordStatus(code uint32) {
+	svr.eachStats(func(st *serverStats) { st.recordStatus(code) })
+}
+
+func (svr *Server) recordLeak() {
+	svr.eachStats(func(st *serverStats) { st.recordLeak() })
+}
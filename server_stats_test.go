@@ -0,0 +1,123 @@
+package sftp
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestHistogramObserve(t *testing.T) {
+	h := newHistogram([]float64{1, 10, 100})
+	for _, v := range []float64{0, 1, 2, 10, 50, 1000} {
+		h.observe(v)
+	}
+	if want := []uint64{2, 2, 1, 1}; !reflect.DeepEqual(h.Counts, want) {
+		t.Errorf("Counts: want %v, got %v", want, h.Counts)
+	}
+	if h.Count != 6 {
+		t.Errorf("Count: want 6, got %d", h.Count)
+	}
+	if h.Sum != 1063 {
+		t.Errorf("Sum: want 1063, got %v", h.Sum)
+	}
+}
+
+func TestServerUploadStats(t *testing.T) {
+	client, server, _, cleanup := uploadServerPair(t, ReaddirHook(func() ([]os.FileInfo, error) {
+		return nil, io.EOF
+	}))
+	defer cleanup()
+
+	upload(t, client, "small", make([]byte, 100))
+	upload(t, client, "large", make([]byte, 100000))
+
+	// Directory handles are not uploads.
+	if _, err := client.ReadDir(testUploadPath); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := server.Stats()
+	if stats.Uploads != 2 {
+		t.Errorf("Uploads: want 2, got %d", stats.Uploads)
+	}
+	if stats.UploadBytes != 100100 {
+		t.Errorf("UploadBytes: want 100100, got %d", stats.UploadBytes)
+	}
+	if want := []uint64{1, 0, 0, 0, 1}; !reflect.DeepEqual(stats.UploadSizes.Counts[:5], want) {
+		t.Errorf("UploadSizes.Counts: want prefix %v, got %v", want, stats.UploadSizes.Counts)
+	}
+	if stats.UploadDurations.Count != 2 {
+		t.Errorf("UploadDurations.Count: want 2, got %d", stats.UploadDurations.Count)
+	}
+
+	// Snapshots must not alias the live counters.
+	stats.UploadSizes.Counts[0] = 42
+	if server.Stats().UploadSizes.Counts[0] != 1 {
+		t.Error("snapshot aliases server stats")
+	}
+}
+
+func TestServerResponseStats(t *testing.T) {
+	collector := NewStatsCollector()
+	for i := 0; i < 2; i++ {
+		client, server, _, cleanup := uploadServerPair(t, WithStatsCollector(collector))
+		upload(t, client, "ok", []byte("data"))                     // OPEN, WRITE, CLOSE
+		if _, err := client.Create("/elsewhere/file"); err == nil { // NO_SUCH_PATH
+			t.Error("Create outside upload path didn't fail")
+		}
+		if err := client.Mkdir(testUploadPath + "/dir"); err == nil { // OP_UNSUPPORTED
+			t.Error("Mkdir didn't fail")
+		}
+
+		stats := server.Stats()
+		want := map[uint32]uint64{
+			ssh_FX_OK:             2,
+			ssh_FX_NO_SUCH_PATH:   1,
+			ssh_FX_OP_UNSUPPORTED: 1,
+		}
+		if !reflect.DeepEqual(stats.Responses, want) {
+			t.Errorf("session %d: Responses: want %v, got %v", i, want, stats.Responses)
+		}
+		cleanup()
+	}
+
+	stats := collector.Stats()
+	want := map[uint32]uint64{
+		ssh_FX_OK:             4,
+		ssh_FX_NO_SUCH_PATH:   2,
+		ssh_FX_OP_UNSUPPORTED: 2,
+	}
+	if !reflect.DeepEqual(stats.Responses, want) {
+		t.Errorf("collector: Responses: want %v, got %v", want, stats.Responses)
+	}
+	if stats.Uploads != 2 {
+		t.Errorf("collector: Uploads: want 2, got %d", stats.Uploads)
+	}
+}
+
+func TestStatsCollectorByUser(t *testing.T) {
+	collector := NewStatsCollector()
+	for _, user := range []string{"alice", "bob", "alice"} {
+		client, _, _, cleanup := uploadServerPair(t,
+			WithStatsCollector(collector),
+			WithSessionInfo(SessionInfo{User: user}),
+		)
+		upload(t, client, "file", []byte(user))
+		cleanup()
+	}
+
+	byUser := collector.StatsByUser()
+	if len(byUser) != 2 {
+		t.Fatalf("want 2 users, got %v", byUser)
+	}
+	if n := byUser["alice"].Uploads; n != 2 {
+		t.Errorf("alice: want 2 uploads, got %d", n)
+	}
+	if n := byUser["bob"].UploadBytes; n != 3 {
+		t.Errorf("bob: want 3 bytes, got %d", n)
+	}
+	if n := collector.Stats().Uploads; n != 3 {
+		t.Errorf("total: want 3 uploads, got %d", n)
+	}
+}
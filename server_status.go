@@ -0,0 +1,69 @@
+package sftp
+
+// Server status snapshots
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// ServerStatus is a snapshot of the state of a Server, suitable for
+// reporting on a health endpoint.
+type ServerStatus struct {
+	Session SessionInfo
+	// Uptime is the time since Serve was called.
+	Uptime time.Duration
+	// Version is the negotiated protocol version, or 0 if the client has
+	// not yet sent SSH_FXP_INIT.
+	Version uint32
+	// OpenHandles lists the handles currently open, oldest first.
+	OpenHandles []HandleStatus
+	// InFlightBytes is the size of the requests which have been received
+	// but not yet answered.
+	InFlightBytes int64
+}
+
+// HandleStatus describes an open handle.
+type HandleStatus struct {
+	Handle     string
+	RemotePath string // path requested by the client
+	Path       string // path of the file on the server
+	Dir        bool
+	Age        time.Duration
+	Written    int64 // bytes written to the file
+}
+
+// Status returns a snapshot of the state of the Server. It may be called
+// concurrently with Serve.
+func (svr *Server) Status() ServerStatus {
+	now := svr.clock.Now()
+	svr.sessionLock.Lock()
+	st := ServerStatus{Session: svr.session}
+	svr.sessionLock.Unlock()
+	if !st.Session.Started.IsZero() {
+		st.Uptime = now.Sub(st.Session.Started)
+	}
+	if v := atomic.LoadUint32(&svr.clientVersion); v != 0 {
+		st.Version = v
+		if st.Version > sftpProtocolVersion {
+			st.Version = sftpProtocolVersion
+		}
+	}
+	st.InFlightBytes = atomic.LoadInt64(&svr.inFlightBytes)
+
+	svr.handles.each(func(handle string, f *openFile) {
+		st.OpenHandles = append(st.OpenHandles, HandleStatus{
+			Handle:     handle,
+			RemotePath: f.remotePath,
+			Path:       f.path(),
+			Dir:        f.dir != nil,
+			Age:        now.Sub(f.opened),
+			Written:    atomic.LoadInt64(&f.written),
+		})
+	})
+	sort.Slice(st.OpenHandles, func(i, j int) bool {
+		return st.OpenHandles[i].Age > st.OpenHandles[j].Age
+	})
+	return st
+}
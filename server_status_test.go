@@ -0,0 +1,53 @@
+package sftp
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestServerStatus(t *testing.T) {
+	client, server, dir, cleanup := uploadServerPair(t)
+	defer cleanup()
+
+	f, err := client.Create(testUploadPath + "/open")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("12345")); err != nil {
+		t.Fatal(err)
+	}
+
+	st := server.Status()
+	if st.Version != sftpProtocolVersion {
+		t.Errorf("Version: want %d, got %d", sftpProtocolVersion, st.Version)
+	}
+	if st.Uptime <= 0 {
+		t.Errorf("Uptime: want > 0, got %v", st.Uptime)
+	}
+	// The last request may still be finishing after its response was sent.
+	for i := 0; st.InFlightBytes != 0 && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		st = server.Status()
+	}
+	if st.InFlightBytes != 0 {
+		t.Errorf("InFlightBytes: want 0, got %d", st.InFlightBytes)
+	}
+	if len(st.OpenHandles) != 1 {
+		t.Fatalf("OpenHandles: want 1, got %+v", st.OpenHandles)
+	}
+	h := st.OpenHandles[0]
+	if h.RemotePath != testUploadPath+"/open" || h.Path != dir+"/open" || h.Dir || h.Written != 5 {
+		t.Errorf("unexpected %+v", h)
+	}
+	if _, err := json.Marshal(st); err != nil {
+		t.Errorf("status not serializable: %v", err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if st := server.Status(); len(st.OpenHandles) != 0 {
+		t.Errorf("OpenHandles after close: want none, got %+v", st.OpenHandles)
+	}
+}
@@ -0,0 +1,98 @@
+package sftp
+
+// Streaming of writes too large for a receive buffer
+
+import (
+	"io"
+	"io/ioutil"
+)
+
+// A payloadStream is the data of a write packet which did not fit in a
+// receive buffer. It is read from the connection by the worker handling the
+// write, while the receive loop waits.
+type payloadStream struct {
+	r    *io.LimitedReader
+	done chan struct{} // closed by the worker once it has finished
+}
+
+// recvServerPacket reads the next packet into buf. A write too large for buf
+// is read only as far as its data, which is left in the returned stream.
+func (svr *Server) recvServerPacket(buf []byte) (rxPacket, error) {
+	r := svr.conn.Reader
+	l, err := recvPacketLength(r)
+	if err != nil {
+		return rxPacket{}, err
+	}
+	if svr.maxMessageSize != 0 && l > svr.maxMessageSize {
+		return rxPacket{}, svr.refuseMessage(r, l)
+	}
+	// type, id, handle length, offset and data length
+	const fixedLen = 1 + 4 + 4 + 8 + 4
+	if l <= uint32(cap(buf)) || l < fixedLen || cap(buf) < fixedLen {
+		typ, b, err := recvPacketBody(r, l, buf)
+		return rxPacket{pktType: fxp(typ), pktBytes: b}, err
+	}
+
+	b := buf[:1+4+4]
+	if _, err := io.ReadFull(r, b); err != nil {
+		return rxPacket{}, err
+	}
+	handleLen, _ := unmarshalUint32(b[5:])
+	hdrLen := uint64(fixedLen) + uint64(handleLen)
+	if fxp(b[0]) != ssh_FXP_WRITE || hdrLen > uint64(cap(buf)) || hdrLen > uint64(l) {
+		// Not worth streaming: read the rest of the packet as usual.
+		whole := make([]byte, l)
+		copy(whole, b)
+		if _, err := io.ReadFull(r, whole[len(b):]); err != nil {
+			return rxPacket{}, err
+		}
+		return rxPacket{pktType: fxp(whole[0]), pktBytes: whole[1:]}, nil
+	}
+
+	b = buf[:hdrLen]
+	if _, err := io.ReadFull(r, b[1+4+4:]); err != nil {
+		return rxPacket{}, err
+	}
+	return rxPacket{
+		pktType:  ssh_FXP_WRITE,
+		pktBytes: b[1:],
+		stream: &payloadStream{
+			r:    &io.LimitedReader{R: r, N: int64(l) - int64(hdrLen)},
+			done: make(chan struct{}),
+		},
+	}, nil
+}
+
+// finish waits for the worker to be done with the stream, and discards any
+// data it did not read.
+func (ps *payloadStream) finish() error {
+	<-ps.done
+	_, err := io.Copy(ioutil.Discard, ps.r)
+	return err
+}
+
+// writeFrom writes length bytes read from r to f at offset, a receive buffer
+// at a time.
+func (svr *Server) writeFrom(f *openFile, r io.Reader, length uint32, offset int64) (n int, written int64, err error) {
+	buf := svr.rxBufs.Get().(*[]byte)
+	defer svr.rxBufs.Put(buf)
+	for n < int(length) {
+		chunk := *buf
+		if len(chunk) > int(length)-n {
+			chunk = chunk[:int(length)-n]
+		}
+		if _, err = io.ReadFull(r, chunk); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, written, err
+		}
+		var m int
+		m, written, err = f.writeAt(chunk, offset+int64(n))
+		n += m
+		if err != nil {
+			return n, written, err
+		}
+	}
+	return n, written, nil
+}
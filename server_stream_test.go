@@ -0,0 +1,55 @@
+package sftp
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestServerStreamedWrite(t *testing.T) {
+	sampled := make(chan UploadSample, 1)
+	client, _, dir, cleanup := uploadServerPair(t,
+		WithFileSizeLimit(1<<20),
+		WithPayloadSampling(4, func(s UploadSample) { sampled <- s }),
+	)
+	defer cleanup()
+	client.maxPacket = 1 << 17 // larger than the server's receive buffers
+
+	data := make([]byte, 3<<17+5)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	upload(t, client, "big", data)
+	b, err := ioutil.ReadFile(dir + "/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Error("content differs")
+	}
+	if s := <-sampled; !bytes.Equal(s.Data, data[:4]) {
+		t.Errorf("want sample %x, got %x", data[:4], s.Data)
+	}
+
+	// A refused write leaves its data unread; the session must carry on.
+	f, err := client.Create(testUploadPath + "/huge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(1<<20, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(data[:1<<17]); err == nil {
+		t.Error("wrote beyond file size limit")
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(data[:1<<17]); err != nil {
+		t.Error(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Error(err)
+	}
+}
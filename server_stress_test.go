@@ -0,0 +1,160 @@
+package sftp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestServerStress has goroutines holding hundreds of handles open on one
+// Server between them, interleaving writes, stats and closes across them in
+// random order, so that -race sees the workers and the handle table under
+// load. Each file is written in chunks, out of order, and checked at the end.
+func TestServerStress(t *testing.T) {
+	goroutines, handles, chunks := 8, 32, 8
+	if testing.Short() {
+		goroutines, handles, chunks = 4, 8, 4
+	}
+	for _, workers := range []int{1, 8, 64} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			stress(t, workers, goroutines, handles, chunks)
+		})
+	}
+}
+
+const stressChunk = 1000
+
+// stressData returns chunk c of the file of handle h of goroutine g.
+func stressData(g, h, c int) []byte {
+	b := bytes.Repeat([]byte(fmt.Sprintf("%d.%d.%d;", g, h, c)), stressChunk)
+	return b[:stressChunk]
+}
+
+func stress(t *testing.T, workers, goroutines, handles, chunks int) {
+	client, server, dir, cleanup := uploadServerPair(t, WithWorkers(workers))
+	defer cleanup()
+
+	seed := time.Now().UnixNano()
+	defer func() {
+		if t.Failed() {
+			t.Logf("seed %d", seed)
+		}
+	}()
+	var (
+		opened sync.WaitGroup
+		done   sync.WaitGroup
+		start  = make(chan struct{})
+		errs   = make(chan error, goroutines)
+	)
+	opened.Add(goroutines)
+	done.Add(goroutines)
+	for g := 0; g < goroutines; g++ {
+		go func(g int) {
+			defer done.Done()
+			errs <- stressGoroutine(client, rand.New(rand.NewSource(seed+int64(g))), &opened, start, g, handles, chunks)
+		}(g)
+	}
+
+	// Every handle is open before any is written.
+	opened.Wait()
+	if n := len(server.Status().OpenHandles); n != goroutines*handles {
+		t.Errorf("got %d open handles, want %d", n, goroutines*handles)
+	}
+	close(start)
+	done.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if t.Failed() {
+		return
+	}
+
+	if n := len(server.Status().OpenHandles); n != 0 {
+		t.Errorf("%d handles left open", n)
+	}
+	for g := 0; g < goroutines; g++ {
+		for h := 0; h < handles; h++ {
+			var want []byte
+			for c := 0; c < chunks; c++ {
+				want = append(want, stressData(g, h, c)...)
+			}
+			got, err := ioutil.ReadFile(fmt.Sprintf("%s/%d.%d", dir, g, h))
+			if err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(got, want) {
+				t.Fatalf("file %d.%d: got %d bytes, not as written", g, h, len(got))
+			}
+		}
+	}
+}
+
+// stressGoroutine opens handles files, marks opened done and waits for
+// start, then writes their chunks in random order, with stats and other
+// opens and closes between them, and closes each after its last chunk.
+func stressGoroutine(client *Client, rnd *rand.Rand, opened *sync.WaitGroup, start <-chan struct{}, g, handles, chunks int) error {
+	files := make([]*File, handles)
+	var err error
+	for h := range files {
+		files[h], err = client.Create(fmt.Sprintf("%s/%d.%d", testUploadPath, g, h))
+		if err != nil {
+			opened.Done()
+			return err
+		}
+	}
+	opened.Done()
+	<-start
+
+	// One entry per chunk to be written, shuffled.
+	var todo []int
+	for h := range files {
+		for c := 0; c < chunks; c++ {
+			todo = append(todo, h)
+		}
+	}
+	rnd.Shuffle(len(todo), func(i, j int) { todo[i], todo[j] = todo[j], todo[i] })
+	written := make([]int, handles)
+	for _, h := range todo {
+		c := written[h]
+		// Write the chunks of a file out of order, the last one first.
+		c = (c + chunks - 1) % chunks
+		if _, err := files[h].WriteAt(stressData(g, h, c), int64(c*stressChunk)); err != nil {
+			return fmt.Errorf("write %d.%d chunk %d: %v", g, h, c, err)
+		}
+		written[h]++
+
+		switch rnd.Intn(4) {
+		case 0:
+			if _, err := client.Stat(testUploadPath); err != nil {
+				return fmt.Errorf("stat: %v", err)
+			}
+		case 1:
+			// This Server only reveals the upload path.
+			if _, err := client.Lstat(files[h].Name()); !os.IsNotExist(err) {
+				return fmt.Errorf("lstat %d.%d: %v", g, h, err)
+			}
+		case 2:
+			// Churn the handle table: open and close another file.
+			f, err := client.Create(fmt.Sprintf("%s/%d.%d.tmp", testUploadPath, g, h))
+			if err != nil {
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		}
+		if written[h] == chunks {
+			if err := files[h].Close(); err != nil {
+				return fmt.Errorf("close %d.%d: %v", g, h, err)
+			}
+		}
+	}
+	return nil
+}
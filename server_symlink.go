@@ -0,0 +1,36 @@
+package sftp
+
+// Symbolic link policy
+
+// A SymlinkPolicy says what the Server allows clients to do with symbolic
+// links in the upload directory.
+type SymlinkPolicy int
+
+const (
+	// SymlinkDenyFollow refuses SSH_FXP_SYMLINK and SSH_FXP_READLINK, and
+	// refuses uploads to a name which is a symbolic link. This is the
+	// default.
+	SymlinkDenyFollow SymlinkPolicy = iota
+	// SymlinkDenyCreate refuses SSH_FXP_SYMLINK and SSH_FXP_READLINK, but
+	// uploads to a name which is a symbolic link, placed there by the
+	// server's operator, write to the file it points to.
+	SymlinkDenyCreate
+	// SymlinkAllow lets clients create and read symbolic links in the
+	// upload directory, and uploads follow them. Since a link may point
+	// anywhere, this lets clients write to any file the server can.
+	SymlinkAllow
+)
+
+// WithSymlinkPolicy sets what clients may do with symbolic links.
+func WithSymlinkPolicy(p SymlinkPolicy) ServerOption {
+	return func(s *Server) error {
+		s.symlinkPolicy = p
+		return nil
+	}
+}
+
+// symlinkOpAllowed reports whether the symlink policy allows requests of
+// type t, which are otherwise refused as unsupported.
+func (svr *Server) symlinkOpAllowed(t fxp) bool {
+	return svr.symlinkPolicy == SymlinkAllow && (t == ssh_FXP_SYMLINK || t == ssh_FXP_READLINK)
+}
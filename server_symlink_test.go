@@ -0,0 +1,56 @@
+package sftp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServerSymlinkDenyFollow(t *testing.T) {
+	client, _, _, cleanup := uploadServerPair(t)
+	defer cleanup()
+
+	if err := client.Symlink("/etc/passwd", testUploadPath+"/l"); err == nil {
+		t.Error("symlink created")
+	}
+	if _, err := client.ReadLink(testUploadPath + "/l"); err == nil {
+		t.Error("symlink read")
+	}
+}
+
+func TestServerSymlinkDenyCreate(t *testing.T) {
+	client, _, dir, cleanup := uploadServerPair(t, WithSymlinkPolicy(SymlinkDenyCreate))
+	defer cleanup()
+
+	target := filepath.Join(dir, "target")
+	if err := os.Symlink(target, filepath.Join(dir, "link")); err != nil {
+		t.Skip(err)
+	}
+	upload(t, client, "link", []byte("data"))
+	if b, err := ioutil.ReadFile(target); err != nil || string(b) != "data" {
+		t.Errorf("upload through link: got %q %v", b, err)
+	}
+	if err := client.Symlink("target", testUploadPath+"/l"); err == nil {
+		t.Error("symlink created")
+	}
+}
+
+func TestServerSymlinkAllow(t *testing.T) {
+	client, _, dir, cleanup := uploadServerPair(t, WithSymlinkPolicy(SymlinkAllow))
+	defer cleanup()
+
+	if err := client.Symlink("target", testUploadPath+"/link"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.Readlink(filepath.Join(dir, "link")); err != nil || got != "target" {
+		t.Errorf("link points to %q %v", got, err)
+	}
+	if got, err := client.ReadLink(testUploadPath + "/link"); err != nil || got != "target" {
+		t.Errorf("ReadLink: got %q %v", got, err)
+	}
+	// Links are still confined to the upload directory.
+	if err := client.Symlink("target", "/elsewhere/link"); err == nil {
+		t.Error("symlink created outside the upload directory")
+	}
+}
@@ -1,8 +1,16 @@
 package sftp
 
 import (
+	"bytes"
+	"encoding"
 	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func clientServerPair(t *testing.T) (*Client, *Server) {
@@ -23,6 +31,65 @@ func clientServerPair(t *testing.T) (*Client, *Server) {
 	return client, server
 }
 
+const testUploadPath = "/upload"
+
+// uploadServerPair returns a client connected to a server accepting uploads
+// under testUploadPath, which are stored in a temporary directory. The
+// returned cleanup function closes the client and removes the directory.
+func uploadServerPair(t *testing.T, options ...ServerOption) (*Client, *Server, string, func()) {
+	dir, err := ioutil.TempDir("", "sftp_server_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapper := func(name string) (string, bool, error) {
+		return dir + "/" + name, true, nil
+	}
+	options = append([]ServerOption{
+		UploadPath(testUploadPath),
+		FileNameMapper(mapper),
+	}, options...)
+
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	server, err := NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{sr, sw}, options...)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	go func() {
+		server.Serve()
+		sw.Close()
+	}()
+	client, err := NewClientPipe(cr, cw)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("%+v\n", err)
+	}
+	return client, server, dir, func() {
+		cw.Close()
+		sw.Close()
+		client.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// upload creates name under testUploadPath and writes data to it.
+func upload(t *testing.T, client *Client, name string, data []byte) {
+	f, err := client.Create(testUploadPath + "/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 type sshFxpTestBadExtendedPacket struct {
 	ID        uint32
 	Extension string
@@ -62,3 +129,268 @@ func TestInvalidExtendedPacket(t *testing.T) {
 	}
 
 }
+
+func TestServerLeakNotifier(t *testing.T) {
+	leaks := make(chan LeakedHandle, 2)
+	ended := make(chan SessionSummary, 1)
+	client, _, dir, cleanup := uploadServerPair(t,
+		LeakNotifier(func(l LeakedHandle) { leaks <- l }),
+		WithSessionHooks(nil, func(ss SessionSummary) { ended <- ss }),
+	)
+	defer cleanup()
+
+	upload(t, client, "closed", []byte("closed"))
+	f, err := client.Create(testUploadPath + "/leaked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("leaked!")); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	select {
+	case l := <-leaks:
+		if l.RemotePath != testUploadPath+"/leaked" {
+			t.Errorf("RemotePath: want %q, got %q", testUploadPath+"/leaked", l.RemotePath)
+		}
+		if l.Path != dir+"/leaked" {
+			t.Errorf("Path: want %q, got %q", dir+"/leaked", l.Path)
+		}
+		if l.Written != 7 {
+			t.Errorf("Written: want 7, got %d", l.Written)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("leak not reported")
+	}
+	select {
+	case ss := <-ended:
+		if ss.Stats.LeakedHandles != 1 {
+			t.Errorf("LeakedHandles: want 1, got %d", ss.Stats.LeakedHandles)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("session end not reported")
+	}
+	if len(leaks) != 0 {
+		t.Errorf("unexpected leak report %+v", <-leaks)
+	}
+}
+
+func TestPacketWorker(t *testing.T) {
+	write := func(handle string) rxPacket {
+		return rxPacket{pktType: ssh_FXP_WRITE, pktBytes: sp(sshFxpWritePacket{ID: 1, Handle: handle, Data: []byte("x")})[5:]}
+	}
+	closeHandle := func(handle string) rxPacket {
+		return rxPacket{pktType: ssh_FXP_CLOSE, pktBytes: sp(sshFxpClosePacket{ID: 2, Handle: handle})[5:]}
+	}
+	for _, handle := range []string{"1", "2", "17"} {
+		w := packetWorker(write(handle), 8, 0)
+		if got := packetWorker(closeHandle(handle), 8, 5); got != w {
+			t.Errorf("handle %s: write on worker %d, close on worker %d", handle, w, got)
+		}
+	}
+	stat := rxPacket{pktType: ssh_FXP_STAT, pktBytes: sp(sshFxpStatPacket{ID: 3, Path: "/"})[5:]}
+	if got := packetWorker(stat, 8, 7); got != 0 {
+		t.Errorf("want stat on worker 0, got %d", got)
+	}
+}
+
+func TestServerConcurrentUploads(t *testing.T) {
+	client, _, dir, cleanup := uploadServerPair(t, WithWorkers(4))
+	defer cleanup()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			f, err := client.Create(testUploadPath + "/" + name)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := f.Write(bytes.Repeat([]byte(name), 50000)); err != nil {
+				t.Error(err)
+			}
+			if err := f.Close(); err != nil {
+				t.Error(err)
+			}
+		}(strconv.Itoa(i))
+	}
+	wg.Wait()
+
+	for i := 0; i < 8; i++ {
+		name := strconv.Itoa(i)
+		b, err := ioutil.ReadFile(dir + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, bytes.Repeat([]byte(name), 50000)) {
+			t.Errorf("%s: content differs", name)
+		}
+	}
+}
+
+func TestWithWorkersInvalid(t *testing.T) {
+	if _, err := NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{}, WithWorkers(0)); err == nil {
+		t.Error("want error for zero workers")
+	}
+}
+
+func TestServerResponseBuffer(t *testing.T) {
+	client, _, dir, cleanup := uploadServerPair(t, WithResponseBuffer(4096))
+	defer cleanup()
+
+	data := bytes.Repeat([]byte("buffered"), 20000)
+	upload(t, client, "file", data)
+	b, err := ioutil.ReadFile(dir + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Error("content differs")
+	}
+}
+
+// stallingClock is the system clock, except that Sleep waits until release
+// is closed.
+type stallingClock struct {
+	systemClock
+	release chan struct{}
+}
+
+func (c stallingClock) Sleep(time.Duration) { <-c.release }
+
+// A worker stuck on a request, with another waiting behind it, must not
+// hold up the buffered responses of other workers.
+func TestServerResponseBufferStalledWorker(t *testing.T) {
+	clock := stallingClock{release: make(chan struct{})}
+	stall := Fault{Ops: []string{"SSH_FXP_FSTAT"}, Probability: 1, Delay: time.Minute}
+	client, server, _, cleanup := uploadServerPair(t, WithWorkers(2), WithResponseBuffer(4096),
+		WithFaults(stall), WithClock(clock))
+	defer cleanup()
+
+	// Open files until one is handled by the other worker than the first.
+	slow, err := client.Create(testUploadPath + "/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+	var fast *File
+	for i := 0; fast == nil; i++ {
+		f, err := client.Create(testUploadPath + "/fast" + strconv.Itoa(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if hashHandle(f.handle)%2 != hashHandle(slow.handle)%2 {
+			fast = f
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slow.Stat()
+		}()
+	}
+	defer wg.Wait()
+	defer close(clock.release)
+	for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt64(&server.pendingPackets) < 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("requests on the slow handle not received")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := fast.Write([]byte("contents"))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("write held up by the stalled worker")
+	}
+}
+
+func TestWithMaxTxPacket(t *testing.T) {
+	rw := struct {
+		io.Reader
+		io.WriteCloser
+	}{}
+	for _, n := range []uint32{0, maxMsgLength} {
+		if _, err := NewServer(rw, WithMaxTxPacket(n)); err == nil {
+			t.Errorf("want error for max tx packet %d", n)
+		}
+	}
+	s, err := NewServer(rw, WithMaxTxPacket(1<<17))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.maxTxPacket != 1<<17 {
+		t.Errorf("want max tx packet %d, got %d", 1<<17, s.maxTxPacket)
+	}
+	if n := len(*s.rxBufs.Get().(*[]byte)); n < 1<<17+rxPacketOverhead {
+		t.Errorf("receive buffer of %d bytes too small", n)
+	}
+}
+
+func TestServerWithoutLongNames(t *testing.T) {
+	fi, err := os.Stat(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []os.FileInfo{fi}
+	for _, omit := range []bool{false, true} {
+		options := []ServerOption{
+			UploadPath(testUploadPath),
+			ReaddirHook(func() ([]os.FileInfo, error) { return entries, nil }),
+		}
+		if omit {
+			options = append(options, WithoutLongNames())
+		}
+		svr, err := NewServer(struct {
+			io.Reader
+			io.WriteCloser
+		}{}, options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sent interface{}
+		svr.conn.sendPacketTest = func(w io.Writer, m encoding.BinaryMarshaler) error {
+			sent = m
+			return nil
+		}
+		f, err := os.Open(os.DevNull)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handle := svr.nextHandle(&openFile{File: f, remotePath: testUploadPath}, testUploadPath)
+		if err := (sshFxpReaddirPacket{ID: 1, Handle: handle}).respond(svr); err != nil {
+			t.Fatal(err)
+		}
+		svr.closeHandle(handle, func(error) error { return nil })
+
+		name, ok := sent.(sshFxpNamePacket)
+		if !ok || len(name.NameAttrs) != 1 {
+			t.Fatalf("want one entry, got %#v", sent)
+		}
+		want := runLs("", fi)
+		if omit {
+			want = fi.Name()
+		}
+		if long := name.NameAttrs[0].LongName; long != want {
+			t.Errorf("omit %v: want long name %q, got %q", omit, want, long)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package sftp
+
+// io_uring write backend
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// A writeBackend writes uploaded data to files in place of os.File.WriteAt.
+type writeBackend interface {
+	WriteAt(f *os.File, b []byte, offset int64) (int, error)
+	Close() error
+}
+
+// WithIOUring makes the server write uploaded data through an io_uring of
+// the given number of entries, which bounds the writes in progress at once,
+// rather than with a pwrite system call per request. This helps hosts
+// receiving many concurrent uploads onto fast storage. Where io_uring is not
+// available, including on systems other than Linux, the server silently
+// falls back to ordinary writes.
+func WithIOUring(entries uint32) ServerOption {
+	return func(s *Server) error {
+		if entries == 0 {
+			return errors.New("io_uring entries must be positive")
+		}
+		s.uringEntries = entries
+		return nil
+	}
+}
+
+// startWriteBackend sets up the write backend chosen by the options, if any.
+func (svr *Server) startWriteBackend() {
+	if svr.uringEntries == 0 {
+		return
+	}
+	wb, err := newURing(svr.uringEntries)
+	if err != nil {
+		fmt.Fprintf(svr.debugStream, "sftp server falling back to ordinary writes: %v\n", err)
+		return
+	}
+	svr.writeBackend = wb
+}
+
+func (svr *Server) stopWriteBackend() {
+	if svr.writeBackend != nil {
+		svr.writeBackend.Close()
+		svr.writeBackend = nil
+	}
+}
@@ -0,0 +1,298 @@
+//go:build linux && (386 || amd64 || arm || arm64 || loong64 || ppc64 || ppc64le || riscv64 || s390x)
+// +build linux
+// +build 386 amd64 arm arm64 loong64 ppc64 ppc64le riscv64 s390x
+
+package sftp
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// io_uring system calls and constants, from linux/io_uring.h. The system
+// call numbers are those of the architectures this file is built for; alpha
+// and mips number them otherwise, and fall back to ordinary writes.
+const (
+	sysIOURingSetup = 425
+	sysIOURingEnter = 426
+
+	ioringOffSQRing = 0
+	ioringOffCQRing = 0x8000000
+	ioringOffSQEs   = 0x10000000
+
+	ioringOpNop    = 0
+	ioringOpWritev = 2
+
+	ioringEnterGetEvents = 1 << 0
+)
+
+type ioSQRingOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type ioCQRingOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type ioURingParams struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFd uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  ioSQRingOffsets
+	cqOff                                                                  ioCQRingOffsets
+}
+
+type ioURingSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	rwFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	pad         [2]uint64
+}
+
+type ioURingCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// uringClose is the user data of the request which stops the reaper.
+const uringClose = ^uint64(0)
+
+// uringPollInterval is how often the reaper looks for completions if it
+// cannot wait for them.
+const uringPollInterval = time.Millisecond
+
+// uring submits writes through an io_uring. Any number of goroutines may
+// write at once, up to the number of entries of the ring; a reaper goroutine
+// passes each completion to the goroutine waiting for it.
+type uring struct {
+	fd           int
+	sqRing       []byte
+	cqRing       []byte
+	sqeMem       []byte
+	sqes         []ioURingSQE
+	sqHead       *uint32
+	sqTail       *uint32
+	sqMask       uint32
+	sqArray      []uint32
+	cqHead       *uint32
+	cqTail       *uint32
+	cqMask       uint32
+	cqes         []ioURingCQE
+	submitLock   sync.Mutex
+	slots        []uringSlot
+	free         chan uint32 // indexes of unused slots
+	reaperClosed chan struct{}
+}
+
+// A uringSlot holds a write in progress.
+type uringSlot struct {
+	iov  syscall.Iovec
+	done chan int32 // result of the write
+}
+
+func newURing(entries uint32) (writeBackend, error) {
+	var p ioURingParams
+	fd, _, errno := syscall.Syscall(sysIOURingSetup, uintptr(entries), uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, os.NewSyscallError("io_uring_setup", errno)
+	}
+	r := &uring{fd: int(fd), reaperClosed: make(chan struct{})}
+	if err := r.mmap(&p); err != nil {
+		r.unmap()
+		syscall.Close(r.fd)
+		return nil, err
+	}
+	r.slots = make([]uringSlot, p.sqEntries)
+	r.free = make(chan uint32, p.sqEntries)
+	for i := range r.slots {
+		r.slots[i].done = make(chan int32, 1)
+		r.free <- uint32(i)
+	}
+	go r.reap()
+	return r, nil
+}
+
+func (r *uring) mmap(p *ioURingParams) error {
+	var err error
+	mmap := func(off int64, size uint32) []byte {
+		if err != nil {
+			return nil
+		}
+		var b []byte
+		b, err = syscall.Mmap(r.fd, off, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+		if err != nil {
+			err = os.NewSyscallError("mmap", err)
+		}
+		return b
+	}
+	r.sqRing = mmap(ioringOffSQRing, p.sqOff.array+p.sqEntries*4)
+	r.cqRing = mmap(ioringOffCQRing, p.cqOff.cqes+p.cqEntries*uint32(unsafe.Sizeof(ioURingCQE{})))
+	r.sqeMem = mmap(ioringOffSQEs, p.sqEntries*uint32(unsafe.Sizeof(ioURingSQE{})))
+	if err != nil {
+		return err
+	}
+	r.sqHead = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.head]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.tail]))
+	r.sqMask = *(*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.ringMask]))
+	r.sqArray = (*[1 << 20]uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.array]))[:p.sqEntries:p.sqEntries]
+	r.sqes = (*[1 << 20]ioURingSQE)(unsafe.Pointer(&r.sqeMem[0]))[:p.sqEntries:p.sqEntries]
+	r.cqHead = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.tail]))
+	r.cqMask = *(*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.ringMask]))
+	r.cqes = (*[1 << 20]ioURingCQE)(unsafe.Pointer(&r.cqRing[p.cqOff.cqes]))[:p.cqEntries:p.cqEntries]
+	return nil
+}
+
+func (r *uring) unmap() {
+	for _, b := range [][]byte{r.sqRing, r.cqRing, r.sqeMem} {
+		if b != nil {
+			syscall.Munmap(b)
+		}
+	}
+}
+
+// submit queues sqe and tells the kernel about it. If it returns nil, the
+// kernel has taken sqe and will complete it; otherwise sqe has been
+// withdrawn, and the kernel will never see it.
+func (r *uring) submit(sqe ioURingSQE) error {
+	r.submitLock.Lock()
+	defer r.submitLock.Unlock()
+	tail := atomic.LoadUint32(r.sqTail)
+	i := tail & r.sqMask
+	r.sqes[i] = sqe
+	r.sqArray[i] = i
+	atomic.StoreUint32(r.sqTail, tail+1)
+	for {
+		_, _, errno := syscall.Syscall6(sysIOURingEnter, uintptr(r.fd), 1, 0, 0, 0, 0)
+		switch {
+		case errno == syscall.EINTR:
+			continue
+		case errno == 0 || atomic.LoadUint32(r.sqHead) != tail:
+			return nil
+		default:
+			// The kernel only takes entries while submit holds the
+			// lock, so none can follow sqe.
+			atomic.StoreUint32(r.sqTail, tail)
+			return os.NewSyscallError("io_uring_enter", errno)
+		}
+	}
+}
+
+// reap passes completions to their writers until the ring is closed. The
+// kernel may be reading the data of writes in progress, so their writers
+// must not return before their completions: if the reaper cannot wait for
+// completions, it polls for them instead.
+func (r *uring) reap() {
+	defer close(r.reaperClosed)
+	for {
+		_, _, errno := syscall.Syscall6(sysIOURingEnter, uintptr(r.fd), 0, 1, ioringEnterGetEvents, 0, 0)
+		switch errno {
+		case 0, syscall.EINTR, syscall.EAGAIN:
+		default:
+			time.Sleep(uringPollInterval)
+		}
+		head := atomic.LoadUint32(r.cqHead)
+		tail := atomic.LoadUint32(r.cqTail)
+		closed := false
+		for ; head != tail; head++ {
+			cqe := r.cqes[head&r.cqMask]
+			if cqe.userData == uringClose {
+				closed = true
+				continue
+			}
+			r.slots[cqe.userData].done <- cqe.res
+		}
+		atomic.StoreUint32(r.cqHead, head)
+		if closed {
+			return
+		}
+	}
+}
+
+// writeAt writes b to fd at offset, returning the number of bytes written.
+// Like os.File.WriteAt, it only returns a short count along with an error.
+func (r *uring) writeAt(fd int32, b []byte, offset int64) (int, error) {
+	slot := <-r.free
+	defer func() { r.free <- slot }()
+	s := &r.slots[slot]
+	n := 0
+	for n < len(b) {
+		s.iov.Base = &b[n]
+		s.iov.SetLen(len(b) - n)
+		err := r.submit(ioURingSQE{
+			opcode:   ioringOpWritev,
+			fd:       fd,
+			off:      uint64(offset) + uint64(n),
+			addr:     uint64(uintptr(unsafe.Pointer(&s.iov))),
+			len:      1,
+			userData: uint64(slot),
+		})
+		if err != nil {
+			return n, err
+		}
+		res := <-s.done
+		if res < 0 {
+			return n, syscall.Errno(-res)
+		}
+		if res == 0 {
+			return n, io.ErrShortWrite
+		}
+		n += int(res)
+	}
+	runtime.KeepAlive(b)
+	return n, nil
+}
+
+func (r *uring) WriteAt(f *os.File, b []byte, offset int64) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	cerr := rc.Control(func(fd uintptr) {
+		n, err = r.writeAt(int32(fd), b, offset)
+	})
+	if cerr != nil {
+		return 0, cerr
+	}
+	if err != nil {
+		err = &os.PathError{Op: "write", Path: f.Name(), Err: err}
+	}
+	return n, err
+}
+
+// Close stops the reaper and releases the ring. No writes may be in
+// progress.
+func (r *uring) Close() error {
+	select {
+	case <-r.reaperClosed:
+	default:
+		if err := r.submit(ioURingSQE{opcode: ioringOpNop, userData: uringClose}); err != nil {
+			// The reaper may still be using the ring.
+			return err
+		}
+		<-r.reaperClosed
+	}
+	r.unmap()
+	return syscall.Close(r.fd)
+}
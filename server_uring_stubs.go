@@ -0,0 +1,12 @@
+//go:build !linux || !(386 || amd64 || arm || arm64 || loong64 || ppc64 || ppc64le || riscv64 || s390x)
+// +build !linux !386,!amd64,!arm,!arm64,!loong64,!ppc64,!ppc64le,!riscv64,!s390x
+
+package sftp
+
+import (
+	"syscall"
+)
+
+func newURing(entries uint32) (writeBackend, error) {
+	return nil, syscall.ENOSYS
+}
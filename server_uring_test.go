@@ -0,0 +1,81 @@
+package sftp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestURingWriteAt(t *testing.T) {
+	wb, err := newURing(8)
+	if err != nil {
+		t.Skipf("io_uring not available: %v", err)
+	}
+	f, err := ioutil.TempFile("", "sftp_uring_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	// More concurrent writes than entries in the ring.
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			b := bytes.Repeat([]byte{byte('a' + i%26)}, 4096)
+			if n, err := wb.WriteAt(f, b, int64(i)*4096); n != len(b) || err != nil {
+				t.Errorf("write %d: %d bytes, %v", i, n, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err := wb.Close(); err != nil {
+		t.Error(err)
+	}
+
+	got, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 32; i++ {
+		if !bytes.Equal(got[i*4096:(i+1)*4096], bytes.Repeat([]byte{byte('a' + i%26)}, 4096)) {
+			t.Errorf("block %d: wrong content", i)
+		}
+	}
+}
+
+func TestServerIOUring(t *testing.T) {
+	client, _, dir, cleanup := uploadServerPair(t, WithIOUring(16))
+	defer cleanup()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			upload(t, client, fmt.Sprintf("f%d", i), bytes.Repeat([]byte{byte(i)}, 300000))
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < 4; i++ {
+		got, err := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("f%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, bytes.Repeat([]byte{byte(i)}, 300000)) {
+			t.Errorf("f%d: wrong content", i)
+		}
+	}
+}
+
+func TestWithIOUringInvalid(t *testing.T) {
+	if _, err := NewServer(nil, WithIOUring(0)); err == nil {
+		t.Error("no error for zero entries")
+	}
+}
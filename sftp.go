@@ -4,6 +4,7 @@ package sftp
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/pkg/errors"
 )
@@ -207,6 +208,19 @@ func (u *unexpectedVersionErr) Error() string {
 	return fmt.Sprintf("sftp: unexpected server version: want %v, got %v", u.want, u.got)
 }
 
+// Errors for the status codes of failed requests, for use with errors.Is.
+// A *StatusError is ErrNotExist, ErrPermission or ErrOpUnsupported if its
+// code is SSH_FX_NO_SUCH_FILE, SSH_FX_PERMISSION_DENIED or
+// SSH_FX_OP_UNSUPPORTED. ErrNotExist and ErrPermission are os.ErrNotExist
+// and os.ErrPermission, so that errors.Is(err, fs.ErrNotExist) works as
+// well. As before, a Client returns SSH_FX_NO_SUCH_FILE as os.ErrNotExist
+// itself.
+var (
+	ErrNotExist      = os.ErrNotExist
+	ErrPermission    = os.ErrPermission
+	ErrOpUnsupported = errors.New("sftp: operation unsupported")
+)
+
 // A StatusError is returned when an SFTP operation fails, and provides
 // additional information about the failure.
 type StatusError struct {
@@ -215,3 +229,20 @@ type StatusError struct {
 }
 
 func (s *StatusError) Error() string { return fmt.Sprintf("sftp: %q (%v)", s.msg, fx(s.Code)) }
+
+// Message returns the message the server gave with the status.
+func (s *StatusError) Message() string { return s.msg }
+
+// Is reports whether the status is that of target, one of ErrNotExist,
+// ErrPermission and ErrOpUnsupported, for errors.Is.
+func (s *StatusError) Is(target error) bool {
+	switch target {
+	case ErrNotExist:
+		return s.Code == ssh_FX_NO_SUCH_FILE
+	case ErrPermission:
+		return s.Code == ssh_FX_PERMISSION_DENIED
+	case ErrOpUnsupported:
+		return s.Code == ssh_FX_OP_UNSUPPORTED
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+// Package sftpbench provides benchmarks of an sftp Server and Client
+// connected in process, so that the performance of releases can be
+// compared. Each benchmark takes the ServerOptions to test with, in addition
+// to those it needs itself.
+//
+// A benchmark in another package runs one with:
+//
+//	func BenchmarkUpload(b *testing.B) { sftpbench.Upload(b, 1<<20) }
+package sftpbench
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/retailnext/sftp"
+)
+
+// uploadPath is where the benchmark servers accept uploads.
+const uploadPath = "/upload"
+
+// pair returns a client connected to a server storing uploads in a
+// temporary directory, and a function which shuts them down.
+func pair(b *testing.B, options ...sftp.ServerOption) (*sftp.Client, func()) {
+	dir, err := ioutil.TempDir("", "sftpbench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	options = append([]sftp.ServerOption{
+		sftp.UploadPath(uploadPath),
+		sftp.FileNameMapper(func(name string) (string, bool, error) {
+			return dir + "/" + name, true, nil
+		}),
+	}, options...)
+
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{sr, sw}, options...)
+	if err != nil {
+		os.RemoveAll(dir)
+		b.Fatal(err)
+	}
+	go func() {
+		server.Serve()
+		sw.Close()
+	}()
+	client, err := sftp.NewClientPipe(cr, cw)
+	if err != nil {
+		os.RemoveAll(dir)
+		b.Fatal(err)
+	}
+	return client, func() {
+		client.Close()
+		sw.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func upload(b *testing.B, client *sftp.Client, name string, data []byte) {
+	f, err := client.Create(uploadPath + "/" + name)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := f.Write(data); err != nil {
+		b.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// Upload benchmarks the throughput of uploading a file of size bytes.
+func Upload(b *testing.B, size int, options ...sftp.ServerOption) {
+	client, cleanup := pair(b, options...)
+	defer cleanup()
+	data := make([]byte, size)
+
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		upload(b, client, "file", data)
+	}
+}
+
+// SmallFiles benchmarks uploading n files of size bytes each, so that the
+// cost of opening and closing files dominates.
+func SmallFiles(b *testing.B, n, size int, options ...sftp.ServerOption) {
+	client, cleanup := pair(b, options...)
+	defer cleanup()
+	data := make([]byte, size)
+
+	b.SetBytes(int64(n * size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < n; j++ {
+			upload(b, client, fmt.Sprintf("file%d", j), data)
+		}
+	}
+}
+
+// Readdir benchmarks listing an upload directory of n entries, as returned
+// by a ReaddirHook.
+func Readdir(b *testing.B, n int, options ...sftp.ServerOption) {
+	entries := make([]os.FileInfo, n)
+	mtime := time.Now()
+	for i := range entries {
+		entries[i] = fileInfo{name: fmt.Sprintf("file%06d", i), size: int64(i), mtime: mtime}
+	}
+	// The hook is called until it returns io.EOF, once per listing.
+	var calls uint32
+	hook := func() ([]os.FileInfo, error) {
+		if atomic.AddUint32(&calls, 1)%2 == 0 {
+			return nil, io.EOF
+		}
+		return entries, nil
+	}
+	client, cleanup := pair(b, append([]sftp.ServerOption{sftp.ReaddirHook(hook)}, options...)...)
+	defer cleanup()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fis, err := client.ReadDir(uploadPath)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(fis) != n {
+			b.Fatalf("want %d entries, got %d", n, len(fis))
+		}
+	}
+}
+
+type fileInfo struct {
+	name  string
+	size  int64
+	mtime time.Time
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) Mode() os.FileMode  { return 0644 }
+func (fi fileInfo) ModTime() time.Time { return fi.mtime }
+func (fi fileInfo) IsDir() bool        { return false }
+func (fi fileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,23 @@
+package sftpbench
+
+import (
+	"testing"
+
+	"github.com/retailnext/sftp"
+)
+
+func BenchmarkUpload1M(b *testing.B)   { Upload(b, 1<<20) }
+func BenchmarkUpload64M(b *testing.B)  { Upload(b, 64<<20) }
+func BenchmarkSmallFiles(b *testing.B) { SmallFiles(b, 100, 1<<10) }
+func BenchmarkReaddir1k(b *testing.B)  { Readdir(b, 1000) }
+func BenchmarkReaddir100k(b *testing.B) {
+	Readdir(b, 100000)
+}
+
+func BenchmarkUpload1MEightWorkers(b *testing.B) {
+	Upload(b, 1<<20, sftp.WithWorkers(8))
+}
+
+func BenchmarkUpload1MResponseBuffer(b *testing.B) {
+	Upload(b, 1<<20, sftp.WithResponseBuffer(1<<16))
+}
@@ -0,0 +1,351 @@
+package sftptest
+
+// Interoperation with OpenSSH
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/retailnext/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// SFTPBinary and SSHDBinary are the OpenSSH programs run by RunSFTP and
+// NewSSHD, looked up in $PATH, and /usr/sbin for sshd, if not absolute.
+var (
+	SFTPBinary = "sftp"
+	SSHDBinary = "sshd"
+)
+
+// lookPath returns the absolute path of the program name, skipping the
+// test if it is not installed.
+func lookPath(t testing.TB, name string, dirs ...string) string {
+	t.Helper()
+	if path, err := exec.LookPath(name); err == nil {
+		if path, err := filepath.Abs(path); err == nil {
+			return path
+		}
+	}
+	for _, dir := range dirs {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && info.Mode()&0111 != 0 {
+			return path
+		}
+	}
+	t.Skipf("%s not installed", name)
+	return ""
+}
+
+// newSigner returns a new ECDSA key, and its PEM encoding.
+func newSigner(t testing.TB) (ssh.Signer, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+// An SSHServer is a Server, storing uploads in a temporary directory,
+// behind an SSH server on the loopback interface, for clients such as
+// OpenSSH's sftp which only speak SFTP over SSH. Clients need not
+// authenticate.
+type SSHServer struct {
+	Addr string // host:port of the SSH server
+	Dir  string // where the Servers store the files uploaded to UploadPath
+
+	t        testing.TB
+	listener net.Listener
+	config   *ssh.ServerConfig
+	options  []sftp.ServerOption
+	wg       sync.WaitGroup
+}
+
+// NewSSHServer returns an SSHServer whose sessions are served by Servers
+// with options, in addition to those of NewPair.
+func NewSSHServer(t testing.TB, options ...sftp.ServerOption) *SSHServer {
+	dir, err := ioutil.TempDir("", "sftptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	s := &SSHServer{
+		Addr:     l.Addr().String(),
+		Dir:      dir,
+		t:        t,
+		listener: l,
+		config:   &ssh.ServerConfig{NoClientAuth: true},
+		options:  append(serverOptions(dir), options...),
+	}
+	signer, _ := newSigner(t)
+	s.config.AddHostKey(signer)
+	s.wg.Add(1)
+	go s.accept()
+	return s
+}
+
+func (s *SSHServer) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// serveConn serves the sftp subsystem on each session of the SSH
+// connection conn.
+func (s *SSHServer) serveConn(conn net.Conn) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "only sessions are accepted")
+			continue
+		}
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			for req := range reqs {
+				ok := req.Type == "subsystem" && len(req.Payload) >= 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				svr, err := sftp.NewServer(ch, s.options...)
+				if err != nil {
+					s.t.Errorf("sftptest: %v", err)
+					ch.Close()
+					return
+				}
+				status := uint32(0)
+				if err := svr.Serve(); err != nil && err != io.EOF {
+					status = 1
+				}
+				ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+				ch.Close()
+			}
+		}()
+	}
+}
+
+// Close stops the SSHServer, waits for its sessions to end and removes Dir.
+func (s *SSHServer) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+	os.RemoveAll(s.Dir)
+}
+
+// AssertFile fails the test unless a Server stored the upload to name,
+// relative to UploadPath, with the contents want.
+func (s *SSHServer) AssertFile(name string, want []byte) {
+	s.t.Helper()
+	assertFile(s.t, s.Dir, name, want)
+}
+
+// RunSFTP runs OpenSSH's sftp, connected to the SSHServer, with the batch
+// script of commands, returning what it prints. It skips the test if sftp
+// is not installed. The error is sftp's, with what it printed on stderr.
+//
+//	out, err := s.RunSFTP("cd /upload\nput report.csv\n")
+func (s *SSHServer) RunSFTP(script string) (string, error) {
+	s.t.Helper()
+	bin := lookPath(s.t, SFTPBinary)
+	host, port, _ := net.SplitHostPort(s.Addr)
+	cmd := exec.Command(bin,
+		"-b", "-",
+		"-F", os.DevNull, // ignore the user's configuration
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "UserKnownHostsFile="+os.DevNull,
+		"-o", "BatchMode=yes",
+		"-o", "LogLevel=ERROR",
+		"-P", port,
+		"sftptest@"+host,
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader(script)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), fmt.Errorf("%s: %v: %s", SFTPBinary, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return stdout.String(), nil
+}
+
+// An SSHD is an OpenSSH sshd, run as the current user on the loopback
+// interface with its internal-sftp subsystem, and a Client connected to
+// it, for testing the Client against OpenSSH's server.
+type SSHD struct {
+	Client *sftp.Client
+	Dir    string // an empty directory, for the files of the test
+
+	conf    string // sshd's configuration and keys
+	cmd     *exec.Cmd
+	conn    *ssh.Client
+	stderr  bytes.Buffer
+	exited  chan struct{}
+	waitErr error
+}
+
+// sshdTimeout is how long NewSSHD waits for sshd to accept connections.
+var sshdTimeout = 10 * time.Second
+
+// NewSSHD starts sshd and returns an SSHD with a Client with options. It
+// skips the test if sshd is not installed.
+func NewSSHD(t testing.TB, options ...func(*sftp.Client) error) *SSHD {
+	t.Helper()
+	bin := lookPath(t, SSHDBinary, "/usr/sbin", "/usr/local/sbin")
+	u, err := user.Current()
+	if err != nil {
+		t.Skipf("no current user: %v", err)
+	}
+	conf, err := ioutil.TempDir("", "sftptest-sshd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &SSHD{conf: conf, Dir: filepath.Join(conf, "files"), exited: make(chan struct{})}
+	ok := false
+	defer func() {
+		if !ok {
+			d.Close()
+		}
+	}()
+
+	_, hostKey := newSigner(t)
+	clientKey, _ := newSigner(t)
+	port, err := freePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := strings.Join([]string{
+		"ListenAddress 127.0.0.1:" + port,
+		"HostKey " + filepath.Join(conf, "host_key"),
+		"AuthorizedKeysFile " + filepath.Join(conf, "authorized_keys"),
+		"AllowUsers " + u.Username,
+		"PidFile none",
+		"StrictModes no",
+		"UsePAM no",
+		"PasswordAuthentication no",
+		"Subsystem sftp internal-sftp",
+		"LogLevel ERROR",
+		"",
+	}, "\n")
+	for name, data := range map[string][]byte{
+		"sshd_config":     []byte(config),
+		"host_key":        hostKey,
+		"authorized_keys": ssh.MarshalAuthorizedKey(clientKey.PublicKey()),
+	} {
+		if err := ioutil.WriteFile(filepath.Join(conf, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(d.Dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	d.cmd = exec.Command(bin, "-D", "-e", "-f", filepath.Join(conf, "sshd_config"))
+	d.cmd.Stderr = &d.stderr
+	if err := d.cmd.Start(); err != nil {
+		t.Skipf("cannot start sshd: %v", err)
+	}
+	go func() {
+		d.waitErr = d.cmd.Wait()
+		close(d.exited)
+	}()
+
+	cc := &ssh.ClientConfig{
+		User:            u.Username,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(clientKey)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         sshdTimeout,
+	}
+	deadline := time.Now().Add(sshdTimeout)
+	for {
+		d.conn, err = ssh.Dial("tcp", "127.0.0.1:"+port, cc)
+		if err == nil {
+			break
+		}
+		select {
+		case <-d.exited:
+			// sshd refuses to run in some sandboxes, and as users without
+			// a home directory: that is no fault of the test.
+			t.Skipf("sshd exited: %v: %s", d.waitErr, bytes.TrimSpace(d.stderr.Bytes()))
+		case <-time.After(50 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("connecting to sshd: %v: %s", err, bytes.TrimSpace(d.stderr.Bytes()))
+		}
+	}
+	if d.Client, err = sftp.NewClient(d.conn, options...); err != nil {
+		t.Fatal(err)
+	}
+	ok = true
+	return d
+}
+
+// freePort returns a port which was free on the loopback interface.
+func freePort() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	return port, err
+}
+
+// Close closes the Client, stops sshd and removes Dir.
+func (d *SSHD) Close() {
+	if d.Client != nil {
+		d.Client.Close()
+	}
+	if d.conn != nil {
+		d.conn.Close()
+	}
+	if d.cmd != nil && d.cmd.Process != nil {
+		d.cmd.Process.Kill()
+		<-d.exited
+	}
+	os.RemoveAll(d.conf)
+}
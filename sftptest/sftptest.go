@@ -0,0 +1,197 @@
+// Package sftptest provides an sftp Server and Client connected in process,
+// so that code which uploads over SFTP, or which handles the Server's
+// events, can be tested without an SSH server.
+//
+// A test in another package uses one with:
+//
+//	p := sftptest.NewPair(t, sftp.WithWorkers(4))
+//	defer p.Close()
+//	p.Upload("report.csv", data)
+//	p.AssertFile("report.csv", data)
+//
+// For interoperation with OpenSSH, an SSHServer runs OpenSSH's sftp against
+// Servers, and an SSHD connects a Client to OpenSSH's sshd. Tests using
+// them are skipped where OpenSSH is not installed.
+package sftptest
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/retailnext/sftp"
+)
+
+// UploadPath is where the Servers of Pairs accept uploads.
+const UploadPath = "/upload"
+
+// EventTimeout is how long WaitEvent waits for an event.
+var EventTimeout = 5 * time.Second
+
+// A Pair is a Server storing uploads in a temporary directory and a Client
+// connected to it over a net.Pipe.
+type Pair struct {
+	Client *sftp.Client
+	Server *sftp.Server
+	Dir    string // where the Server stores the files uploaded to UploadPath
+
+	t     testing.TB
+	serve chan error // the error ending the session
+
+	mu     sync.Mutex
+	cond   *sync.Cond // signalled when events or ended change
+	events []sftp.Event
+	ended  bool // all events have been received
+}
+
+// NewPair returns a Pair of a Server with options, in addition to
+// sftp.UploadPath(UploadPath) and a FileNameMapper storing uploads in Dir,
+// and a Client. It fails t if either cannot be created.
+func NewPair(t testing.TB, options ...sftp.ServerOption) *Pair {
+	dir, err := ioutil.TempDir("", "sftptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options = append(serverOptions(dir), options...)
+
+	cconn, sconn := net.Pipe()
+	server, err := sftp.NewServer(sconn, options...)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	p := &Pair{Server: server, Dir: dir, t: t, serve: make(chan error, 1)}
+	p.cond = sync.NewCond(&p.mu)
+	go p.collect(server.Events())
+	go func() {
+		err := server.Serve()
+		sconn.Close()
+		p.serve <- err
+	}()
+	p.Client, err = sftp.NewClientPipe(cconn, cconn)
+	if err != nil {
+		sconn.Close()
+		<-p.serve
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return p
+}
+
+// serverOptions returns the options of Servers storing uploads in dir.
+func serverOptions(dir string) []sftp.ServerOption {
+	return []sftp.ServerOption{
+		sftp.UploadPath(UploadPath),
+		sftp.FileNameMapper(func(name string) (string, bool, error) {
+			return filepath.Join(dir, filepath.FromSlash(name)), true, nil
+		}),
+	}
+}
+
+// collect records the events sent on ch until it is closed.
+func (p *Pair) collect(ch <-chan sftp.Event) {
+	for e := range ch {
+		p.mu.Lock()
+		p.events = append(p.events, e)
+		p.cond.Broadcast()
+		p.mu.Unlock()
+	}
+	p.mu.Lock()
+	p.ended = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
+
+// Close closes the Client, waits for the Server's session to end and
+// removes Dir. It returns the error which ended the session, if other than
+// the Client closing it.
+func (p *Pair) Close() error {
+	p.Client.Close()
+	err := <-p.serve
+	p.mu.Lock()
+	for !p.ended {
+		p.cond.Wait()
+	}
+	p.mu.Unlock()
+	os.RemoveAll(p.Dir)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// Upload uploads data to name, relative to UploadPath, failing the test if
+// it cannot.
+func (p *Pair) Upload(name string, data []byte) {
+	p.t.Helper()
+	f, err := p.Client.Create(UploadPath + "/" + name)
+	if err != nil {
+		p.t.Fatalf("upload %s: %v", name, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		p.t.Fatalf("upload %s: %v", name, err)
+	}
+	if err := f.Close(); err != nil {
+		p.t.Fatalf("upload %s: %v", name, err)
+	}
+}
+
+// AssertFile fails the test unless the Server stored the upload to name,
+// relative to UploadPath, with the contents want.
+func (p *Pair) AssertFile(name string, want []byte) {
+	p.t.Helper()
+	assertFile(p.t, p.Dir, name, want)
+}
+
+func assertFile(t testing.TB, dir, name string, want []byte) {
+	t.Helper()
+	got, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		t.Errorf("%s: %v", name, err)
+	} else if !bytes.Equal(got, want) {
+		t.Errorf("%s: got %d bytes %.64q, want %d bytes %.64q", name, len(got), got, len(want), want)
+	}
+}
+
+// Events returns the events the Server has sent so far, or all of them
+// once Close has returned.
+func (p *Pair) Events() []sftp.Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]sftp.Event(nil), p.events...)
+}
+
+// WaitEvent returns the first event, already sent or sent within
+// EventTimeout, for which match returns true. It fails the test if there
+// is none.
+func (p *Pair) WaitEvent(match func(sftp.Event) bool) sftp.Event {
+	p.t.Helper()
+	timeout := time.AfterFunc(EventTimeout, func() {
+		p.mu.Lock()
+		p.cond.Broadcast()
+		p.mu.Unlock()
+	})
+	defer timeout.Stop()
+	deadline := time.Now().Add(EventTimeout)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := 0; ; {
+		for ; i < len(p.events); i++ {
+			if match(p.events[i]) {
+				return p.events[i]
+			}
+		}
+		if p.ended || !time.Now().Before(deadline) {
+			p.t.Fatalf("no matching event in %d events", len(p.events))
+			return nil
+		}
+		p.cond.Wait()
+	}
+}
@@ -0,0 +1,130 @@
+package sftptest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/retailnext/sftp"
+)
+
+// recorder records the failures of a test instead of failing it.
+type recorder struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func TestPair(t *testing.T) {
+	p := NewPair(t)
+	p.Upload("file", []byte("contents"))
+	p.AssertFile("file", []byte("contents"))
+
+	r := &recorder{TB: t}
+	p.t = r
+	p.AssertFile("file", []byte("other"))
+	p.AssertFile("missing", nil)
+	p.t = t
+	if len(r.failures) != 2 {
+		t.Errorf("want 2 failures, got %q", r.failures)
+	}
+
+	e := p.WaitEvent(func(e sftp.Event) bool {
+		_, ok := e.(sftp.FileClosed)
+		return ok
+	})
+	if e := e.(sftp.FileClosed); e.RemotePath != UploadPath+"/file" || e.Written != 8 {
+		t.Errorf("got %+v", e)
+	}
+
+	dir := p.Dir
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	events := p.Events()
+	if _, ok := events[len(events)-1].(sftp.SessionEnded); !ok {
+		t.Errorf("last event %T, want SessionEnded", events[len(events)-1])
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Dir not removed: %v", err)
+	}
+}
+
+func TestPairOptions(t *testing.T) {
+	p := NewPair(t, sftp.ReadOnly())
+	defer p.Close()
+
+	if _, err := p.Client.Create(UploadPath + "/file"); err == nil {
+		t.Fatal("upload to read-only server")
+	}
+	p.WaitEvent(func(e sftp.Event) bool {
+		_, ok := e.(sftp.OperationDenied)
+		return ok
+	})
+}
+
+func TestSSHServerOpenSSH(t *testing.T) {
+	s := NewSSHServer(t)
+	defer s.Close()
+	local, err := ioutil.TempDir("", "sftptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(local)
+	data := bytes.Repeat([]byte("0123456789"), 100000)
+	if err := ioutil.WriteFile(filepath.Join(local, "file"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	script := "cd " + UploadPath + "\nput " + filepath.Join(local, "file") + " copy\n"
+	if out, err := s.RunSFTP(script); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	s.AssertFile("copy", data)
+
+	// Outside UploadPath, the Server refuses, and sftp fails.
+	if _, err := s.RunSFTP("put " + filepath.Join(local, "file") + " /etc/file\n"); err == nil {
+		t.Error("upload outside UploadPath succeeded")
+	}
+}
+
+func TestSSHDClient(t *testing.T) {
+	d := NewSSHD(t)
+	defer d.Close()
+	data := bytes.Repeat([]byte("0123456789"), 100000)
+	name := filepath.Join(d.Dir, "file")
+	f, err := d.Client.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadFile(name); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("got %d bytes, %v", len(got), err)
+	}
+
+	f, err = d.Client.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, err := ioutil.ReadAll(f)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("read %d bytes, %v", len(got), err)
+	}
+	if err := d.Client.Remove(name); err != nil {
+		t.Error(err)
+	}
+}
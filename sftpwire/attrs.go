@@ -0,0 +1,85 @@
+package sftpwire
+
+// Flags of Attrs.Flags, saying which attributes are present.
+const (
+	AttrSize        = 0x00000001
+	AttrUIDGID      = 0x00000002
+	AttrPermissions = 0x00000004
+	AttrACModTime   = 0x00000008
+	AttrExtended    = 0x80000000
+)
+
+// An Extension is a name and data pair, as found in Init, Version and
+// Attrs.
+type Extension struct {
+	Name string
+	Data string
+}
+
+// Attrs are the attributes of a file. Only those Flags says are present
+// are encoded or decoded.
+type Attrs struct {
+	Flags       uint32
+	Size        uint64
+	UID, GID    uint32
+	Permissions uint32
+	Atime       uint32 // seconds since the Unix epoch
+	Mtime       uint32
+	Extended    []Extension
+}
+
+func (a *Attrs) append(b []byte) []byte {
+	b = appendUint32(b, a.Flags)
+	if a.Flags&AttrSize != 0 {
+		b = appendUint64(b, a.Size)
+	}
+	if a.Flags&AttrUIDGID != 0 {
+		b = appendUint32(b, a.UID)
+		b = appendUint32(b, a.GID)
+	}
+	if a.Flags&AttrPermissions != 0 {
+		b = appendUint32(b, a.Permissions)
+	}
+	if a.Flags&AttrACModTime != 0 {
+		b = appendUint32(b, a.Atime)
+		b = appendUint32(b, a.Mtime)
+	}
+	if a.Flags&AttrExtended != 0 {
+		b = appendUint32(b, uint32(len(a.Extended)))
+		for _, e := range a.Extended {
+			b = appendString(b, e.Name)
+			b = appendString(b, e.Data)
+		}
+	}
+	return b
+}
+
+func (a *Attrs) decode(d *decoder) {
+	*a = Attrs{Flags: d.uint32()}
+	if a.Flags&AttrSize != 0 {
+		a.Size = d.uint64()
+	}
+	if a.Flags&AttrUIDGID != 0 {
+		a.UID = d.uint32()
+		a.GID = d.uint32()
+	}
+	if a.Flags&AttrPermissions != 0 {
+		a.Permissions = d.uint32()
+	}
+	if a.Flags&AttrACModTime != 0 {
+		a.Atime = d.uint32()
+		a.Mtime = d.uint32()
+	}
+	if a.Flags&AttrExtended != 0 {
+		n := d.uint32()
+		// Each extension is at least 8 bytes: don't trust n further.
+		if uint64(n)*8 > uint64(len(d.b)) {
+			d.err = ErrShortPacket
+			return
+		}
+		a.Extended = make([]Extension, n)
+		for i := range a.Extended {
+			a.Extended[i] = Extension{d.string(), d.string()}
+		}
+	}
+}
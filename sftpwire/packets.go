@@ -0,0 +1,654 @@
+package sftpwire
+
+// Init is the SSH_FXP_INIT packet with which a client starts a session.
+type Init struct {
+	Version    uint32
+	Extensions []Extension
+}
+
+func (p *Init) Type() uint8 { return TypeInit }
+
+func (p *Init) MarshalBinary() ([]byte, error) {
+	return marshalVersion(TypeInit, p.Version, p.Extensions), nil
+}
+
+func (p *Init) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeInit)
+	p.Version, p.Extensions = unmarshalVersion(d)
+	return d.err
+}
+
+// Version is the server's SSH_FXP_VERSION reply to Init.
+type Version struct {
+	Version    uint32
+	Extensions []Extension
+}
+
+func (p *Version) Type() uint8 { return TypeVersion }
+
+func (p *Version) MarshalBinary() ([]byte, error) {
+	return marshalVersion(TypeVersion, p.Version, p.Extensions), nil
+}
+
+func (p *Version) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeVersion)
+	p.Version, p.Extensions = unmarshalVersion(d)
+	return d.err
+}
+
+func marshalVersion(t uint8, version uint32, exts []Extension) []byte {
+	l := 1 + 4
+	for _, e := range exts {
+		l += 4 + len(e.Name) + 4 + len(e.Data)
+	}
+	b := append(make([]byte, 0, l), t)
+	b = appendUint32(b, version)
+	for _, e := range exts {
+		b = appendString(b, e.Name)
+		b = appendString(b, e.Data)
+	}
+	return b
+}
+
+func unmarshalVersion(d *decoder) (uint32, []Extension) {
+	version := d.uint32()
+	var exts []Extension
+	for d.err == nil && len(d.b) > 0 {
+		exts = append(exts, Extension{d.string(), d.string()})
+	}
+	return version, exts
+}
+
+func marshalIDString(t uint8, id uint32, s string) []byte {
+	b := append(make([]byte, 0, 1+4+4+len(s)), t)
+	b = appendUint32(b, id)
+	return appendString(b, s)
+}
+
+// Close is an SSH_FXP_CLOSE request, closing Handle.
+type Close struct {
+	ID     uint32
+	Handle string
+}
+
+func (p *Close) Type() uint8      { return TypeClose }
+func (p *Close) PacketID() uint32 { return p.ID }
+
+func (p *Close) MarshalBinary() ([]byte, error) {
+	return marshalIDString(TypeClose, p.ID, p.Handle), nil
+}
+
+func (p *Close) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeClose)
+	*p = Close{ID: d.uint32(), Handle: d.string()}
+	return d.err
+}
+
+// Lstat is an SSH_FXP_LSTAT request for the attributes of Path, not
+// following a final symbolic link.
+type Lstat struct {
+	ID   uint32
+	Path string
+}
+
+func (p *Lstat) Type() uint8      { return TypeLstat }
+func (p *Lstat) PacketID() uint32 { return p.ID }
+
+func (p *Lstat) MarshalBinary() ([]byte, error) {
+	return marshalIDString(TypeLstat, p.ID, p.Path), nil
+}
+
+func (p *Lstat) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeLstat)
+	*p = Lstat{ID: d.uint32(), Path: d.string()}
+	return d.err
+}
+
+// Fstat is an SSH_FXP_FSTAT request for the attributes of the file open
+// as Handle.
+type Fstat struct {
+	ID     uint32
+	Handle string
+}
+
+func (p *Fstat) Type() uint8      { return TypeFstat }
+func (p *Fstat) PacketID() uint32 { return p.ID }
+
+func (p *Fstat) MarshalBinary() ([]byte, error) {
+	return marshalIDString(TypeFstat, p.ID, p.Handle), nil
+}
+
+func (p *Fstat) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeFstat)
+	*p = Fstat{ID: d.uint32(), Handle: d.string()}
+	return d.err
+}
+
+// Opendir is an SSH_FXP_OPENDIR request, opening the directory Path for
+// Readdir.
+type Opendir struct {
+	ID   uint32
+	Path string
+}
+
+func (p *Opendir) Type() uint8      { return TypeOpendir }
+func (p *Opendir) PacketID() uint32 { return p.ID }
+
+func (p *Opendir) MarshalBinary() ([]byte, error) {
+	return marshalIDString(TypeOpendir, p.ID, p.Path), nil
+}
+
+func (p *Opendir) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeOpendir)
+	*p = Opendir{ID: d.uint32(), Path: d.string()}
+	return d.err
+}
+
+// Readdir is an SSH_FXP_READDIR request for the next entries of the
+// directory open as Handle.
+type Readdir struct {
+	ID     uint32
+	Handle string
+}
+
+func (p *Readdir) Type() uint8      { return TypeReaddir }
+func (p *Readdir) PacketID() uint32 { return p.ID }
+
+func (p *Readdir) MarshalBinary() ([]byte, error) {
+	return marshalIDString(TypeReaddir, p.ID, p.Handle), nil
+}
+
+func (p *Readdir) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeReaddir)
+	*p = Readdir{ID: d.uint32(), Handle: d.string()}
+	return d.err
+}
+
+// Remove is an SSH_FXP_REMOVE request, removing the file Filename.
+type Remove struct {
+	ID       uint32
+	Filename string
+}
+
+func (p *Remove) Type() uint8      { return TypeRemove }
+func (p *Remove) PacketID() uint32 { return p.ID }
+
+func (p *Remove) MarshalBinary() ([]byte, error) {
+	return marshalIDString(TypeRemove, p.ID, p.Filename), nil
+}
+
+func (p *Remove) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeRemove)
+	*p = Remove{ID: d.uint32(), Filename: d.string()}
+	return d.err
+}
+
+// Rmdir is an SSH_FXP_RMDIR request, removing the directory Path.
+type Rmdir struct {
+	ID   uint32
+	Path string
+}
+
+func (p *Rmdir) Type() uint8      { return TypeRmdir }
+func (p *Rmdir) PacketID() uint32 { return p.ID }
+
+func (p *Rmdir) MarshalBinary() ([]byte, error) {
+	return marshalIDString(TypeRmdir, p.ID, p.Path), nil
+}
+
+func (p *Rmdir) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeRmdir)
+	*p = Rmdir{ID: d.uint32(), Path: d.string()}
+	return d.err
+}
+
+// Realpath is an SSH_FXP_REALPATH request for the canonical form of
+// Path.
+type Realpath struct {
+	ID   uint32
+	Path string
+}
+
+func (p *Realpath) Type() uint8      { return TypeRealpath }
+func (p *Realpath) PacketID() uint32 { return p.ID }
+
+func (p *Realpath) MarshalBinary() ([]byte, error) {
+	return marshalIDString(TypeRealpath, p.ID, p.Path), nil
+}
+
+func (p *Realpath) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeRealpath)
+	*p = Realpath{ID: d.uint32(), Path: d.string()}
+	return d.err
+}
+
+// Stat is an SSH_FXP_STAT request for the attributes of Path.
+type Stat struct {
+	ID   uint32
+	Path string
+}
+
+func (p *Stat) Type() uint8      { return TypeStat }
+func (p *Stat) PacketID() uint32 { return p.ID }
+
+func (p *Stat) MarshalBinary() ([]byte, error) {
+	return marshalIDString(TypeStat, p.ID, p.Path), nil
+}
+
+func (p *Stat) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeStat)
+	*p = Stat{ID: d.uint32(), Path: d.string()}
+	return d.err
+}
+
+// Readlink is an SSH_FXP_READLINK request for the target of the
+// symbolic link Path.
+type Readlink struct {
+	ID   uint32
+	Path string
+}
+
+func (p *Readlink) Type() uint8      { return TypeReadlink }
+func (p *Readlink) PacketID() uint32 { return p.ID }
+
+func (p *Readlink) MarshalBinary() ([]byte, error) {
+	return marshalIDString(TypeReadlink, p.ID, p.Path), nil
+}
+
+func (p *Readlink) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeReadlink)
+	*p = Readlink{ID: d.uint32(), Path: d.string()}
+	return d.err
+}
+
+// Handle is an SSH_FXP_HANDLE response, naming the file or directory
+// opened.
+type Handle struct {
+	ID     uint32
+	Handle string
+}
+
+func (p *Handle) Type() uint8      { return TypeHandle }
+func (p *Handle) PacketID() uint32 { return p.ID }
+
+func (p *Handle) MarshalBinary() ([]byte, error) {
+	return marshalIDString(TypeHandle, p.ID, p.Handle), nil
+}
+
+func (p *Handle) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeHandle)
+	*p = Handle{ID: d.uint32(), Handle: d.string()}
+	return d.err
+}
+
+// Open is an SSH_FXP_OPEN request, opening the file Path with the Open
+// flags Pflags, and Attrs for the file if it is created.
+type Open struct {
+	ID     uint32
+	Path   string
+	Pflags uint32
+	Attrs  Attrs
+}
+
+func (p *Open) Type() uint8      { return TypeOpen }
+func (p *Open) PacketID() uint32 { return p.ID }
+
+func (p *Open) MarshalBinary() ([]byte, error) {
+	b := append(make([]byte, 0, 1+4+4+len(p.Path)+4+4), TypeOpen)
+	b = appendUint32(b, p.ID)
+	b = appendString(b, p.Path)
+	b = appendUint32(b, p.Pflags)
+	return p.Attrs.append(b), nil
+}
+
+func (p *Open) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeOpen)
+	*p = Open{ID: d.uint32(), Path: d.string(), Pflags: d.uint32()}
+	p.Attrs.decode(d)
+	return d.err
+}
+
+// Read is an SSH_FXP_READ request for up to Len bytes at Offset of the file
+// open as Handle.
+type Read struct {
+	ID     uint32
+	Handle string
+	Offset uint64
+	Len    uint32
+}
+
+func (p *Read) Type() uint8      { return TypeRead }
+func (p *Read) PacketID() uint32 { return p.ID }
+
+func (p *Read) MarshalBinary() ([]byte, error) {
+	b := append(make([]byte, 0, 1+4+4+len(p.Handle)+8+4), TypeRead)
+	b = appendUint32(b, p.ID)
+	b = appendString(b, p.Handle)
+	b = appendUint64(b, p.Offset)
+	return appendUint32(b, p.Len), nil
+}
+
+func (p *Read) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeRead)
+	*p = Read{ID: d.uint32(), Handle: d.string(), Offset: d.uint64(), Len: d.uint32()}
+	return d.err
+}
+
+// Write is an SSH_FXP_WRITE request, writing Data at Offset of the file
+// open as Handle.
+type Write struct {
+	ID     uint32
+	Handle string
+	Offset uint64
+	Data   []byte
+}
+
+func (p *Write) Type() uint8      { return TypeWrite }
+func (p *Write) PacketID() uint32 { return p.ID }
+
+func (p *Write) MarshalBinary() ([]byte, error) {
+	b := append(make([]byte, 0, 1+4+4+len(p.Handle)+8+4+len(p.Data)), TypeWrite)
+	b = appendUint32(b, p.ID)
+	b = appendString(b, p.Handle)
+	b = appendUint64(b, p.Offset)
+	return append(appendUint32(b, uint32(len(p.Data))), p.Data...), nil
+}
+
+func (p *Write) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeWrite)
+	*p = Write{ID: d.uint32(), Handle: d.string(), Offset: d.uint64(), Data: d.bytes()}
+	return d.err
+}
+
+// Setstat is an SSH_FXP_SETSTAT request, setting the attributes of Path.
+type Setstat struct {
+	ID    uint32
+	Path  string
+	Attrs Attrs
+}
+
+func (p *Setstat) Type() uint8      { return TypeSetstat }
+func (p *Setstat) PacketID() uint32 { return p.ID }
+
+func (p *Setstat) MarshalBinary() ([]byte, error) {
+	return p.Attrs.append(marshalIDString(TypeSetstat, p.ID, p.Path)), nil
+}
+
+func (p *Setstat) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeSetstat)
+	*p = Setstat{ID: d.uint32(), Path: d.string()}
+	p.Attrs.decode(d)
+	return d.err
+}
+
+// Fsetstat is an SSH_FXP_FSETSTAT request, setting the attributes of the
+// file open as Handle.
+type Fsetstat struct {
+	ID     uint32
+	Handle string
+	Attrs  Attrs
+}
+
+func (p *Fsetstat) Type() uint8      { return TypeFsetstat }
+func (p *Fsetstat) PacketID() uint32 { return p.ID }
+
+func (p *Fsetstat) MarshalBinary() ([]byte, error) {
+	return p.Attrs.append(marshalIDString(TypeFsetstat, p.ID, p.Handle)), nil
+}
+
+func (p *Fsetstat) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeFsetstat)
+	*p = Fsetstat{ID: d.uint32(), Handle: d.string()}
+	p.Attrs.decode(d)
+	return d.err
+}
+
+// Mkdir is an SSH_FXP_MKDIR request, making the directory Path with Attrs.
+type Mkdir struct {
+	ID    uint32
+	Path  string
+	Attrs Attrs
+}
+
+func (p *Mkdir) Type() uint8      { return TypeMkdir }
+func (p *Mkdir) PacketID() uint32 { return p.ID }
+
+func (p *Mkdir) MarshalBinary() ([]byte, error) {
+	return p.Attrs.append(marshalIDString(TypeMkdir, p.ID, p.Path)), nil
+}
+
+func (p *Mkdir) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeMkdir)
+	*p = Mkdir{ID: d.uint32(), Path: d.string()}
+	p.Attrs.decode(d)
+	return d.err
+}
+
+// Rename is an SSH_FXP_RENAME request, renaming Oldpath to Newpath.
+type Rename struct {
+	ID      uint32
+	Oldpath string
+	Newpath string
+}
+
+func (p *Rename) Type() uint8      { return TypeRename }
+func (p *Rename) PacketID() uint32 { return p.ID }
+
+func (p *Rename) MarshalBinary() ([]byte, error) {
+	return appendString(marshalIDString(TypeRename, p.ID, p.Oldpath), p.Newpath), nil
+}
+
+func (p *Rename) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeRename)
+	*p = Rename{ID: d.uint32(), Oldpath: d.string(), Newpath: d.string()}
+	return d.err
+}
+
+// Symlink is an SSH_FXP_SYMLINK request, making Linkpath a symbolic link to
+// Targetpath. The fields are in the order of the draft; OpenSSH's server
+// reads them the other way round.
+type Symlink struct {
+	ID         uint32
+	Targetpath string
+	Linkpath   string
+}
+
+func (p *Symlink) Type() uint8      { return TypeSymlink }
+func (p *Symlink) PacketID() uint32 { return p.ID }
+
+func (p *Symlink) MarshalBinary() ([]byte, error) {
+	return appendString(marshalIDString(TypeSymlink, p.ID, p.Targetpath), p.Linkpath), nil
+}
+
+func (p *Symlink) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeSymlink)
+	*p = Symlink{ID: d.uint32(), Targetpath: d.string(), Linkpath: d.string()}
+	return d.err
+}
+
+// Status is an SSH_FXP_STATUS response: the result of requests which return
+// nothing else, or the error of those which do.
+type Status struct {
+	ID      uint32
+	Code    uint32
+	Message string
+	Lang    string
+}
+
+func (p *Status) Type() uint8      { return TypeStatus }
+func (p *Status) PacketID() uint32 { return p.ID }
+
+func (p *Status) MarshalBinary() ([]byte, error) {
+	b := append(make([]byte, 0, 1+4+4+4+len(p.Message)+4+len(p.Lang)), TypeStatus)
+	b = appendUint32(b, p.ID)
+	b = appendUint32(b, p.Code)
+	b = appendString(b, p.Message)
+	return appendString(b, p.Lang), nil
+}
+
+// UnmarshalBinary accepts a status without a message and language, as
+// servers of version 2 and earlier send.
+func (p *Status) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeStatus)
+	*p = Status{ID: d.uint32(), Code: d.uint32()}
+	if d.err == nil && len(d.b) > 0 {
+		p.Message, p.Lang = d.string(), d.string()
+	}
+	return d.err
+}
+
+// Data is an SSH_FXP_DATA response, the data read by a Read.
+type Data struct {
+	ID   uint32
+	Data []byte
+}
+
+func (p *Data) Type() uint8      { return TypeData }
+func (p *Data) PacketID() uint32 { return p.ID }
+
+func (p *Data) MarshalBinary() ([]byte, error) {
+	b := append(make([]byte, 0, 1+4+4+len(p.Data)), TypeData)
+	b = appendUint32(b, p.ID)
+	return append(appendUint32(b, uint32(len(p.Data))), p.Data...), nil
+}
+
+func (p *Data) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeData)
+	*p = Data{ID: d.uint32(), Data: d.bytes()}
+	return d.err
+}
+
+// A NameEntry is a file named in a Name response. Longname is the line ls
+// -l would print for it.
+type NameEntry struct {
+	Filename string
+	Longname string
+	Attrs    Attrs
+}
+
+// Name is an SSH_FXP_NAME response, the entries of a Readdir or the path
+// of a Realpath or Readlink.
+type Name struct {
+	ID      uint32
+	Entries []NameEntry
+}
+
+func (p *Name) Type() uint8      { return TypeName }
+func (p *Name) PacketID() uint32 { return p.ID }
+
+func (p *Name) MarshalBinary() ([]byte, error) {
+	b := append(make([]byte, 0, 1+4+4+len(p.Entries)*64), TypeName)
+	b = appendUint32(b, p.ID)
+	b = appendUint32(b, uint32(len(p.Entries)))
+	for i := range p.Entries {
+		e := &p.Entries[i]
+		b = appendString(b, e.Filename)
+		b = appendString(b, e.Longname)
+		b = e.Attrs.append(b)
+	}
+	return b, nil
+}
+
+func (p *Name) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeName)
+	*p = Name{ID: d.uint32()}
+	n := d.uint32()
+	// Each entry is at least 12 bytes: don't trust n further.
+	if d.err == nil && uint64(n)*12 > uint64(len(d.b)) {
+		return ErrShortPacket
+	}
+	p.Entries = make([]NameEntry, n)
+	for i := range p.Entries {
+		e := &p.Entries[i]
+		e.Filename, e.Longname = d.string(), d.string()
+		e.Attrs.decode(d)
+	}
+	return d.err
+}
+
+// AttrsPacket is an SSH_FXP_ATTRS response, the attributes of a file.
+type AttrsPacket struct {
+	ID    uint32
+	Attrs Attrs
+}
+
+func (p *AttrsPacket) Type() uint8      { return TypeAttrs }
+func (p *AttrsPacket) PacketID() uint32 { return p.ID }
+
+func (p *AttrsPacket) MarshalBinary() ([]byte, error) {
+	b := append(make([]byte, 0, 1+4+4+8+4*5), TypeAttrs)
+	return p.Attrs.append(appendUint32(b, p.ID)), nil
+}
+
+func (p *AttrsPacket) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeAttrs)
+	*p = AttrsPacket{ID: d.uint32()}
+	p.Attrs.decode(d)
+	return d.err
+}
+
+// Extended is an SSH_FXP_EXTENDED request. Data is the encoding of the
+// fields particular to Request, such as the path of a
+// "statvfs@openssh.com".
+type Extended struct {
+	ID      uint32
+	Request string
+	Data    []byte
+}
+
+func (p *Extended) Type() uint8      { return TypeExtended }
+func (p *Extended) PacketID() uint32 { return p.ID }
+
+func (p *Extended) MarshalBinary() ([]byte, error) {
+	return append(marshalIDString(TypeExtended, p.ID, p.Request), p.Data...), nil
+}
+
+func (p *Extended) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeExtended)
+	*p = Extended{ID: d.uint32(), Request: d.string()}
+	p.Data = d.rest()
+	return d.err
+}
+
+// ExtendedReply is an SSH_FXP_EXTENDED_REPLY response to an Extended
+// request, whose Data is particular to the request.
+type ExtendedReply struct {
+	ID   uint32
+	Data []byte
+}
+
+func (p *ExtendedReply) Type() uint8      { return TypeExtendedReply }
+func (p *ExtendedReply) PacketID() uint32 { return p.ID }
+
+func (p *ExtendedReply) MarshalBinary() ([]byte, error) {
+	b := append(make([]byte, 0, 1+4+len(p.Data)), TypeExtendedReply)
+	return append(appendUint32(b, p.ID), p.Data...), nil
+}
+
+func (p *ExtendedReply) UnmarshalBinary(b []byte) error {
+	d := newDecoder(b, TypeExtendedReply)
+	*p = ExtendedReply{ID: d.uint32()}
+	p.Data = d.rest()
+	return d.err
+}
+
+// Unknown is a packet of a type this package does not know, kept whole so
+// that it can be passed on.
+type Unknown struct {
+	PacketType uint8
+	Payload    []byte // what follows the type byte
+}
+
+func (p *Unknown) Type() uint8 { return p.PacketType }
+
+func (p *Unknown) MarshalBinary() ([]byte, error) {
+	return append([]byte{p.PacketType}, p.Payload...), nil
+}
+
+func (p *Unknown) UnmarshalBinary(b []byte) error {
+	if len(b) == 0 {
+		return ErrShortPacket
+	}
+	*p = Unknown{PacketType: b[0], Payload: append([]byte(nil), b[1:]...)}
+	return nil
+}
@@ -0,0 +1,322 @@
+// Package sftpwire encodes and decodes the packets of version 3 of the SSH
+// File Transfer Protocol, for proxies, protocol analyzers and servers which
+// need the codec but not the sftp package's Client or Server.
+//
+// A packet on the wire is a uint32 length followed by that many bytes, the
+// first of which is the packet type. ReadPacket and WritePacket deal in
+// whole packets; Decode and the MarshalBinary and UnmarshalBinary methods
+// of the packet types deal in the bytes after the length.
+//
+//	for {
+//		p, err := sftpwire.ReadPacket(r)
+//		if err != nil {
+//			return err
+//		}
+//		if w, ok := p.(*sftpwire.Write); ok {
+//			log.Printf("write of %d bytes at %d", len(w.Data), w.Offset)
+//		}
+//		if err := sftpwire.WritePacket(upstream, p); err != nil {
+//			return err
+//		}
+//	}
+//
+// The sftp package does not use this codec: its Client and Server have
+// their own, and the two must be kept in lockstep. The sftp package's
+// TestWireEncodings checks that every packet the sftp package encodes,
+// extended requests and replies included, is encoded alike by this package
+// and decodes with it to the same packet, and fails on a type of packet it
+// has no case for. A change to either codec needs a case there.
+package sftpwire
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Packet types.
+const (
+	TypeInit          = 1
+	TypeVersion       = 2
+	TypeOpen          = 3
+	TypeClose         = 4
+	TypeRead          = 5
+	TypeWrite         = 6
+	TypeLstat         = 7
+	TypeFstat         = 8
+	TypeSetstat       = 9
+	TypeFsetstat      = 10
+	TypeOpendir       = 11
+	TypeReaddir       = 12
+	TypeRemove        = 13
+	TypeMkdir         = 14
+	TypeRmdir         = 15
+	TypeRealpath      = 16
+	TypeStat          = 17
+	TypeRename        = 18
+	TypeReadlink      = 19
+	TypeSymlink       = 20
+	TypeStatus        = 101
+	TypeHandle        = 102
+	TypeData          = 103
+	TypeName          = 104
+	TypeAttrs         = 105
+	TypeExtended      = 200
+	TypeExtendedReply = 201
+)
+
+// Status codes. Those after StatusOpUnsupported are from later drafts of
+// the protocol, and are sent by some servers regardless.
+const (
+	StatusOK                  = 0
+	StatusEOF                 = 1
+	StatusNoSuchFile          = 2
+	StatusPermissionDenied    = 3
+	StatusFailure             = 4
+	StatusBadMessage          = 5
+	StatusNoConnection        = 6
+	StatusConnectionLost      = 7
+	StatusOpUnsupported       = 8
+	StatusInvalidHandle       = 9
+	StatusNoSuchPath          = 10
+	StatusFileAlreadyExists   = 11
+	StatusWriteProtect        = 12
+	StatusNoMedia             = 13
+	StatusNoSpaceOnFilesystem = 14
+	StatusQuotaExceeded       = 15
+)
+
+// Flags of Open.Pflags.
+const (
+	OpenRead   = 0x00000001
+	OpenWrite  = 0x00000002
+	OpenAppend = 0x00000004
+	OpenCreate = 0x00000008
+	OpenTrunc  = 0x00000010
+	OpenExcl   = 0x00000020
+)
+
+// MaxPacketLength is the largest packet ReadPacket accepts, the length
+// OpenSSH allows.
+const MaxPacketLength = 256 * 1024
+
+var (
+	// ErrShortPacket is returned when a packet ends before its fields do.
+	ErrShortPacket = errors.New("sftpwire: packet too short")
+	// ErrPacketLength is returned by ReadPacket for packets longer than
+	// MaxPacketLength, or empty.
+	ErrPacketLength = errors.New("sftpwire: bad packet length")
+)
+
+// A Packet is an SFTP packet. MarshalBinary returns its encoding, from the
+// type byte on; UnmarshalBinary decodes one, which must be of its type.
+type Packet interface {
+	Type() uint8
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+}
+
+// An IDPacket is a packet carrying a request ID, which is every packet but
+// Init, Version and Unknown: a response has the ID of its request.
+type IDPacket interface {
+	Packet
+	PacketID() uint32
+}
+
+// newPacket returns a new packet of type t, or nil if t is unknown.
+func newPacket(t uint8) Packet {
+	switch t {
+	case TypeInit:
+		return &Init{}
+	case TypeVersion:
+		return &Version{}
+	case TypeOpen:
+		return &Open{}
+	case TypeClose:
+		return &Close{}
+	case TypeRead:
+		return &Read{}
+	case TypeWrite:
+		return &Write{}
+	case TypeLstat:
+		return &Lstat{}
+	case TypeFstat:
+		return &Fstat{}
+	case TypeSetstat:
+		return &Setstat{}
+	case TypeFsetstat:
+		return &Fsetstat{}
+	case TypeOpendir:
+		return &Opendir{}
+	case TypeReaddir:
+		return &Readdir{}
+	case TypeRemove:
+		return &Remove{}
+	case TypeMkdir:
+		return &Mkdir{}
+	case TypeRmdir:
+		return &Rmdir{}
+	case TypeRealpath:
+		return &Realpath{}
+	case TypeStat:
+		return &Stat{}
+	case TypeRename:
+		return &Rename{}
+	case TypeReadlink:
+		return &Readlink{}
+	case TypeSymlink:
+		return &Symlink{}
+	case TypeStatus:
+		return &Status{}
+	case TypeHandle:
+		return &Handle{}
+	case TypeData:
+		return &Data{}
+	case TypeName:
+		return &Name{}
+	case TypeAttrs:
+		return &AttrsPacket{}
+	case TypeExtended:
+		return &Extended{}
+	case TypeExtendedReply:
+		return &ExtendedReply{}
+	}
+	return nil
+}
+
+// Decode decodes the packet b, from its type byte on. A packet of a type
+// unknown to this package is returned as an *Unknown. The packet does not
+// alias b.
+func Decode(b []byte) (Packet, error) {
+	if len(b) == 0 {
+		return nil, ErrShortPacket
+	}
+	p := newPacket(b[0])
+	if p == nil {
+		p = &Unknown{}
+	}
+	if err := p.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ReadRaw reads a packet from r, returning it from its type byte on.
+func ReadRaw(r io.Reader) ([]byte, error) {
+	var l [4]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	n := (&decoder{b: l[:]}).uint32()
+	if n == 0 || n > MaxPacketLength {
+		return nil, ErrPacketLength
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// ReadPacket reads and decodes a packet from r.
+func ReadPacket(r io.Reader) (Packet, error) {
+	b, err := ReadRaw(r)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(b)
+}
+
+// WritePacket writes p to w, preceded by its length, in a single Write.
+func WritePacket(w io.Writer, p Packet) error {
+	b, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 0, 4+len(b))
+	buf = append(appendUint32(buf, uint32(len(b))), b...)
+	_, err = w.Write(buf)
+	return err
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
+
+func appendString(b []byte, v string) []byte {
+	return append(appendUint32(b, uint32(len(v))), v...)
+}
+
+// A decoder reads the fields of a packet in turn. Once one is missing, it
+// records ErrShortPacket, and returns zero values from then on.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+// newDecoder returns a decoder of b, a packet of type t, positioned after
+// its type byte.
+func newDecoder(b []byte, t uint8) *decoder {
+	if len(b) == 0 {
+		return &decoder{err: ErrShortPacket}
+	}
+	if b[0] != t {
+		return &decoder{err: fmt.Errorf("sftpwire: packet type %d, want %d", b[0], t)}
+	}
+	return &decoder{b: b[1:]}
+}
+
+func (d *decoder) uint32() uint32 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.b) < 4 {
+		d.err = ErrShortPacket
+		return 0
+	}
+	v := uint32(d.b[0])<<24 | uint32(d.b[1])<<16 | uint32(d.b[2])<<8 | uint32(d.b[3])
+	d.b = d.b[4:]
+	return v
+}
+
+func (d *decoder) uint64() uint64 {
+	h := d.uint32()
+	return uint64(h)<<32 | uint64(d.uint32())
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uint32()
+	if d.err != nil {
+		return nil
+	}
+	if uint64(n) > uint64(len(d.b)) {
+		d.err = ErrShortPacket
+		return nil
+	}
+	v := make([]byte, n)
+	copy(v, d.b)
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+// rest returns a copy of the bytes not yet decoded.
+func (d *decoder) rest() []byte {
+	if d.err != nil || len(d.b) == 0 {
+		return nil
+	}
+	v := append([]byte(nil), d.b...)
+	d.b = nil
+	return v
+}
@@ -0,0 +1,159 @@
+package sftpwire
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+var attrs = Attrs{
+	Flags:       AttrSize | AttrUIDGID | AttrPermissions | AttrACModTime | AttrExtended,
+	Size:        1 << 40,
+	UID:         1000,
+	GID:         100,
+	Permissions: 0100644,
+	Atime:       1000000000,
+	Mtime:       1000000001,
+	Extended:    []Extension{{"a@example.com", "1"}, {"b@example.com", ""}},
+}
+
+var packets = []Packet{
+	&Init{Version: 3},
+	&Init{Version: 3, Extensions: []Extension{{"x@example.com", "1"}}},
+	&Version{Version: 3, Extensions: []Extension{{"posix-rename@openssh.com", "1"}, {"statvfs@openssh.com", "2"}}},
+	&Open{ID: 1, Path: "/upload/f", Pflags: OpenWrite | OpenCreate | OpenTrunc},
+	&Open{ID: 1, Path: "/upload/f", Pflags: OpenWrite | OpenCreate, Attrs: Attrs{Flags: AttrPermissions, Permissions: 0600}},
+	&Close{ID: 2, Handle: "h"},
+	&Read{ID: 3, Handle: "h", Offset: 1 << 33, Len: 32768},
+	&Write{ID: 4, Handle: "h", Offset: 5, Data: []byte("data")},
+	&Write{ID: 4, Handle: "h", Offset: 5, Data: []byte{}},
+	&Lstat{ID: 5, Path: "/l"},
+	&Fstat{ID: 6, Handle: "h"},
+	&Setstat{ID: 7, Path: "/s", Attrs: attrs},
+	&Fsetstat{ID: 8, Handle: "h", Attrs: Attrs{Flags: AttrSize, Size: 10}},
+	&Opendir{ID: 9, Path: "/d"},
+	&Readdir{ID: 10, Handle: "h"},
+	&Remove{ID: 11, Filename: "/r"},
+	&Mkdir{ID: 12, Path: "/m"},
+	&Rmdir{ID: 13, Path: "/m"},
+	&Realpath{ID: 14, Path: "."},
+	&Stat{ID: 15, Path: "/s"},
+	&Rename{ID: 16, Oldpath: "/a", Newpath: "/b"},
+	&Readlink{ID: 17, Path: "/l"},
+	&Symlink{ID: 18, Targetpath: "/t", Linkpath: "/l"},
+	&Status{ID: 19, Code: StatusNoSuchFile, Message: "no such file", Lang: "en"},
+	&Handle{ID: 20, Handle: "0123"},
+	&Data{ID: 21, Data: []byte("data")},
+	&Name{ID: 22, Entries: []NameEntry{}},
+	&Name{ID: 22, Entries: []NameEntry{{"a", "-rw-r--r-- a", attrs}, {"b", "b", Attrs{}}}},
+	&AttrsPacket{ID: 23, Attrs: attrs},
+	&Extended{ID: 24, Request: "statvfs@openssh.com", Data: []byte("\x00\x00\x00\x01/")},
+	&ExtendedReply{ID: 25, Data: []byte("reply")},
+	&Unknown{PacketType: 99, Payload: []byte("?")},
+}
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	for _, p := range packets {
+		if err := WritePacket(&buf, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range packets {
+		got, err := ReadPacket(&buf)
+		if err != nil {
+			t.Fatalf("%T: %v", want, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %#v, want %#v", got, want)
+		}
+		if id, ok := want.(IDPacket); ok && got.(IDPacket).PacketID() != id.PacketID() {
+			t.Errorf("%T: got ID %d", want, got.(IDPacket).PacketID())
+		}
+	}
+	if _, err := ReadPacket(&buf); err != io.EOF {
+		t.Errorf("at end: got %v, want io.EOF", err)
+	}
+}
+
+func TestKnownEncodings(t *testing.T) {
+	for _, tt := range []struct {
+		p    Packet
+		want string
+	}{
+		{&Init{Version: 3}, "\x01\x00\x00\x00\x03"},
+		{&Close{ID: 1, Handle: "h"}, "\x04\x00\x00\x00\x01\x00\x00\x00\x01h"},
+		{&Write{ID: 1, Handle: "h", Offset: 2, Data: []byte("d")},
+			"\x06\x00\x00\x00\x01\x00\x00\x00\x01h\x00\x00\x00\x00\x00\x00\x00\x02\x00\x00\x00\x01d"},
+		{&Status{ID: 1, Code: StatusEOF}, "\x65\x00\x00\x00\x01\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00"},
+		{&AttrsPacket{ID: 1, Attrs: Attrs{Flags: AttrSize, Size: 3}},
+			"\x69\x00\x00\x00\x01\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x03"},
+	} {
+		b, err := tt.p.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("%T: got %q, want %q", tt.p, b, tt.want)
+		}
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	// Every prefix either decodes or fails cleanly.
+	for _, p := range packets {
+		b, _ := p.MarshalBinary()
+		for i := 0; i < len(b); i++ {
+			Decode(b[:i])
+		}
+	}
+	for _, p := range []Packet{
+		&Open{ID: 1, Path: "/upload/f", Attrs: attrs},
+		&Write{ID: 4, Handle: "h", Data: []byte("data")},
+		&Name{ID: 22, Entries: []NameEntry{{"a", "a", attrs}}},
+		&Symlink{ID: 1, Targetpath: "/t", Linkpath: "/l"},
+	} {
+		b, _ := p.MarshalBinary()
+		if _, err := Decode(b[:len(b)-1]); err != ErrShortPacket {
+			t.Errorf("%T less a byte: got %v, want ErrShortPacket", p, err)
+		}
+	}
+	// A count larger than the packet could hold is not allocated.
+	b := []byte("\x68\x00\x00\x00\x01\xff\xff\xff\xff")
+	if _, err := Decode(b); err != ErrShortPacket {
+		t.Errorf("huge Name count: got %v, want ErrShortPacket", err)
+	}
+}
+
+func TestStatusWithoutMessage(t *testing.T) {
+	p, err := Decode([]byte("\x65\x00\x00\x00\x07\x00\x00\x00\x04"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&Status{ID: 7, Code: StatusFailure}); !reflect.DeepEqual(p, want) {
+		t.Errorf("got %#v, want %#v", p, want)
+	}
+}
+
+func TestWrongType(t *testing.T) {
+	b, _ := (&Stat{ID: 1, Path: "/"}).MarshalBinary()
+	var l Lstat
+	if err := l.UnmarshalBinary(b); err == nil {
+		t.Error("Lstat decoded a Stat")
+	}
+}
+
+func TestReadRawLength(t *testing.T) {
+	for _, b := range []string{
+		"\x00\x00\x00\x00",
+		"\x00\x04\x00\x01",
+	} {
+		if _, err := ReadRaw(bytes.NewReader([]byte(b))); err != ErrPacketLength {
+			t.Errorf("length %q: got %v, want ErrPacketLength", b, err)
+		}
+	}
+	if _, err := ReadRaw(bytes.NewReader([]byte("\x00\x00\x00\x05\x01"))); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated: got %v, want io.ErrUnexpectedEOF", err)
+	}
+}